@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/infrastructure/clamav"
+	"github.com/delaram/GoTastic/internal/infrastructure/mysql"
+	"github.com/delaram/GoTastic/internal/infrastructure/storage"
+	"github.com/delaram/GoTastic/internal/usecase"
+)
+
+func runFiles(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected gc, backfill or scan")
+	}
+
+	switch args[0] {
+	case "gc":
+		return filesGC(ctx, a, args[1:])
+	case "backfill":
+		return filesBackfill(ctx, a, args[1:])
+	case "scan":
+		return filesScan(ctx, a, args[1:])
+	default:
+		return fmt.Errorf("unknown files subcommand %q", args[0])
+	}
+}
+
+// filesGC runs one collection. Unlike the scheduled job it defaults to a dry
+// run; -delete actually removes the files listed.
+func filesGC(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("files gc", flag.ContinueOnError)
+	grace := fs.Duration("grace", a.cfg.FileGC.GracePeriod, "keep files modified more recently than this")
+	del := fs.Bool("delete", false, "delete the orphaned files instead of only listing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fileRepo, err := storage.New(a.cfg.Storage, a.cfg.S3)
+	if err != nil {
+		return err
+	}
+	uc := usecase.NewFileGCUseCase(a.logger, fileRepo,
+		mysql.NewFileMetadataRepository(a.engine, a.logger),
+		mysql.NewFileReferenceRepository(a.engine, a.logger))
+	report, err := uc.Collect(ctx, *grace, !*del)
+	if report == nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSIZE\tMODIFIED")
+	for _, f := range report.Orphans {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", f.ID, f.Size, f.ModTime.Format(time.RFC3339))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if report.DryRun {
+		fmt.Printf("dry run: %d of %d files orphaned (%d bytes), %d unconfirmed uploads expired; rerun with -delete to remove them\n", len(report.Orphans), report.Scanned, report.Bytes, report.Unconfirmed)
+	} else {
+		fmt.Printf("deleted %d of %d orphaned files (%d bytes) and %d unconfirmed uploads, %d failed\n", report.Deleted, len(report.Orphans), report.Bytes, report.Unconfirmed, report.Failed)
+	}
+	return err
+}
+
+// filesBackfill hashes files stored before uploads were deduplicated and
+// merges copies of the same content. Like gc it defaults to a dry run; -apply
+// writes the metadata, repoints todos and deletes the copies.
+func filesBackfill(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("files backfill", flag.ContinueOnError)
+	apply := fs.Bool("apply", false, "index and merge the files instead of only counting them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fileRepo, err := storage.New(a.cfg.Storage, a.cfg.S3)
+	if err != nil {
+		return err
+	}
+	policy, err := usecase.NewFilePolicy(a.cfg.Files)
+	if err != nil {
+		return err
+	}
+	uc := usecase.NewFileDedupUseCase(a.logger, fileRepo,
+		mysql.NewFileMetadataRepository(a.engine, a.logger),
+		mysql.NewFileReferenceRepository(a.engine, a.logger)).
+		WithPolicy(policy).
+		WithThumbnails(mysql.NewThumbnailRepository(a.engine, a.logger))
+	report, err := uc.Backfill(ctx, !*apply)
+	if report == nil {
+		return err
+	}
+
+	if report.DryRun {
+		fmt.Printf("dry run: %d of %d files to index, %d copies to merge (%d bytes); rerun with -apply\n",
+			report.Indexed, report.Scanned, report.Merged, report.Reclaimed)
+	} else {
+		fmt.Printf("indexed %d of %d files, merged %d copies (%d bytes), %d failed\n",
+			report.Indexed, report.Scanned, report.Merged, report.Reclaimed, report.Failed)
+	}
+	return err
+}
+
+// filesScan rescans the files left quarantined as pending, e.g. because
+// clamd was down when they were uploaded.
+func filesScan(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("files scan", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if a.cfg.Scanner.ClamAVAddress == "" {
+		return errors.New("no scanner configured; set scanner.clamav_address")
+	}
+
+	fileRepo, err := storage.New(a.cfg.Storage, a.cfg.S3)
+	if err != nil {
+		return err
+	}
+	scanner := clamav.NewScanner(a.cfg.Scanner.ClamAVAddress, a.cfg.Scanner.Timeout)
+	if err := scanner.Ping(ctx); err != nil {
+		return err
+	}
+	uc := usecase.NewFileUseCase(a.logger, fileRepo, mysql.NewFileMetadataRepository(a.engine, a.logger)).
+		WithEvents(mysql.NewOutboxRepo(a.engine, a.logger)).
+		WithScanner(scanner)
+	clean, infected, err := uc.ScanPending(ctx)
+	fmt.Printf("released %d clean files, %d infected\n", clean, infected)
+	return err
+}
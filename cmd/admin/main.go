@@ -0,0 +1,89 @@
+// Command admin is the operator CLI for GoTastic. It talks to the same MySQL
+// and Redis as the API, using the same configuration.
+//
+//	admin outbox list [-limit N] [-offset N]
+//	admin outbox inspect <id>
+//	admin outbox requeue <id>...
+//	admin outbox purge <id>...
+//	admin todos resync [-batch N] [-stream NAME]
+//	admin files gc [-grace D] [-delete]
+//	admin files backfill [-apply]
+//	admin files scan
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	beeinfra "github.com/delaram/GoTastic/internal/infrastructure/beeorm"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/delaram/GoTastic/pkg/logger"
+
+	"git.ice.global/packages/beeorm/v4"
+)
+
+type app struct {
+	cfg    *config.Config
+	engine *beeorm.Engine
+	logger logger.Logger
+}
+
+type command func(ctx context.Context, a *app, args []string) error
+
+var commands = map[string]command{
+	"outbox": runOutbox,
+	"todos":  runTodos,
+	"files":  runFiles,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+	engine, err := beeinfra.NewEngine(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "init engine: %v\n", err)
+		os.Exit(1)
+	}
+	a := &app{
+		cfg:    cfg,
+		engine: engine,
+		logger: logger.New(logger.Config{Level: "warn", TimeFormat: time.RFC3339, Pretty: true}),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd(ctx, a, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: admin <command> [arguments]
+
+commands:
+  outbox list|inspect|requeue|purge   manage dead-lettered outbox events
+  todos resync                        republish every todo as a todo.snapshot event
+  files gc                            list, or with -delete remove, files no todo refers to
+  files backfill                      count, or with -apply merge, copies of the same file
+  files scan                          rescan files quarantined as pending
+`)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/infrastructure/mysql"
+	"github.com/delaram/GoTastic/internal/usecase"
+)
+
+func runOutbox(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected list, inspect, requeue or purge")
+	}
+	uc := usecase.NewOutboxAdminUseCase(a.logger, mysql.NewOutboxRepo(a.engine, a.logger))
+
+	switch args[0] {
+	case "list":
+		return outboxList(ctx, uc, args[1:])
+	case "inspect":
+		return outboxInspect(ctx, uc, args[1:])
+	case "requeue":
+		return forEachID(args[1:], func(id uint64) error {
+			if err := uc.RequeueDeadLetter(ctx, id); err != nil {
+				return err
+			}
+			fmt.Printf("requeued %d\n", id)
+			return nil
+		})
+	case "purge":
+		return forEachID(args[1:], func(id uint64) error {
+			if err := uc.PurgeDeadLetter(ctx, id); err != nil {
+				return err
+			}
+			fmt.Printf("purged %d\n", id)
+			return nil
+		})
+	default:
+		return fmt.Errorf("unknown outbox subcommand %q", args[0])
+	}
+}
+
+func outboxList(ctx context.Context, uc *usecase.OutboxAdminUseCase, args []string) error {
+	fs := flag.NewFlagSet("outbox list", flag.ContinueOnError)
+	limit := fs.Int("limit", 20, "rows per page (max 100)")
+	offset := fs.Int("offset", 0, "rows to skip")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rows, total, err := uc.ListDeadLetters(ctx, *limit, *offset)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEVENT\tAGGREGATE\tATTEMPTS\tDEAD AT\tLAST ERROR")
+	for _, row := range rows {
+		deadAt, lastErr := "", ""
+		if row.DeadAt != nil {
+			deadAt = row.DeadAt.Format(time.RFC3339)
+		}
+		if row.LastError != nil {
+			lastErr = *row.LastError
+			if len(lastErr) > 60 {
+				lastErr = lastErr[:57] + "..."
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s/%s\t%d\t%s\t%s\n", row.ID, row.EventType, row.AggregateType, row.AggregateID, row.Attempts, deadAt, lastErr)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d of %d dead-lettered events\n", len(rows), total)
+	return nil
+}
+
+func outboxInspect(ctx context.Context, uc *usecase.OutboxAdminUseCase, args []string) error {
+	if len(args) != 1 {
+		return errors.New("inspect takes exactly one id")
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %q", args[0])
+	}
+
+	dl, err := uc.GetDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	type attempt struct {
+		Attempt  int       `json:"attempt"`
+		Worker   string    `json:"worker"`
+		Error    string    `json:"error"`
+		FailedAt time.Time `json:"failed_at"`
+	}
+	msg := dl.Message
+	view := struct {
+		ID            uint64          `json:"id"`
+		AggregateType string          `json:"aggregate_type"`
+		AggregateID   string          `json:"aggregate_id"`
+		EventType     string          `json:"event_type"`
+		Payload       json.RawMessage `json:"payload"`
+		Headers       *string         `json:"headers,omitempty"`
+		Attempts      int             `json:"attempts"`
+		LastError     *string         `json:"last_error,omitempty"`
+		DeadAt        *time.Time      `json:"dead_at,omitempty"`
+		History       []attempt       `json:"attempt_history"`
+	}{
+		ID:            msg.ID,
+		AggregateType: msg.AggregateType,
+		AggregateID:   msg.AggregateID,
+		EventType:     msg.EventType,
+		Payload:       json.RawMessage(msg.Payload),
+		Headers:       msg.Headers,
+		Attempts:      msg.Attempts,
+		LastError:     msg.LastError,
+		DeadAt:        msg.DeadAt,
+		History:       make([]attempt, len(dl.Attempts)),
+	}
+	for i, a := range dl.Attempts {
+		view.History[i] = attempt{Attempt: a.Attempt, Worker: a.Worker, Error: a.Error, FailedAt: a.FailedAt}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(view)
+}
+
+func forEachID(args []string, fn func(id uint64) error) error {
+	if len(args) == 0 {
+		return errors.New("expected at least one id")
+	}
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid id %q", arg)
+		}
+		if err := fn(id); err != nil {
+			return fmt.Errorf("%d: %w", id, err)
+		}
+	}
+	return nil
+}
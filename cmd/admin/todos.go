@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/delaram/GoTastic/internal/infrastructure/broker"
+	"github.com/delaram/GoTastic/internal/infrastructure/mysql"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/redis/go-redis/v9"
+)
+
+func runTodos(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected resync")
+	}
+
+	switch args[0] {
+	case "resync":
+		return todosResync(ctx, a, args[1:])
+	default:
+		return fmt.Errorf("unknown todos subcommand %q", args[0])
+	}
+}
+
+func todosResync(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("todos resync", flag.ContinueOnError)
+	batch := fs.Int("batch", 500, "todos read per query (max 1000)")
+	stream := fs.String("stream", a.cfg.Stream.Name, "stream to publish snapshots to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     net.JoinHostPort(a.cfg.Redis.Host, a.cfg.Redis.Port),
+		Password: a.cfg.Redis.Password,
+		DB:       a.cfg.Redis.DB,
+	})
+	defer rdb.Close()
+
+	b, err := broker.New(a.cfg.Broker, rdb, repository.StreamRetention{MaxLen: a.cfg.Stream.MaxLen, MaxAge: a.cfg.Stream.MaxAge})
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	uc := usecase.NewTodoSnapshotUseCase(a.logger, mysql.NewTodoRepository(a.engine, a.logger), b, *stream)
+	n, err := uc.Resync(ctx, *batch)
+	fmt.Printf("published %d todo.snapshot events to %s\n", n, *stream)
+	return err
+}
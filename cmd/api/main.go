@@ -1,3 +1,189 @@
-version https://git-lfs.github.com/spec/v1
-oid sha256:68585970511580de69fb6bf8a36cc38f3488fa77f71b2d45dc588c0706352980
-size 11969
+// Command api serves the GoTastic REST and GraphQL APIs, and runs the
+// background workers next to them. The server and the workers stop together
+// on SIGINT or SIGTERM.
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/delivery/graphql"
+	httpdelivery "github.com/delaram/GoTastic/internal/delivery/http"
+	beeinfra "github.com/delaram/GoTastic/internal/infrastructure/beeorm"
+	"github.com/delaram/GoTastic/internal/infrastructure/broker"
+	"github.com/delaram/GoTastic/internal/infrastructure/clamav"
+	"github.com/delaram/GoTastic/internal/infrastructure/mysql"
+	redisinfra "github.com/delaram/GoTastic/internal/infrastructure/redis"
+	"github.com/delaram/GoTastic/internal/infrastructure/storage"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/delaram/GoTastic/internal/worker"
+	"github.com/delaram/GoTastic/pkg/cache"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/delaram/GoTastic/pkg/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// thumbnailGroup is the consumer group the thumbnail worker reads the event
+// stream through.
+const thumbnailGroup = "thumbnails"
+
+// shutdownTimeout bounds how long in-flight requests get to finish.
+const shutdownTimeout = 15 * time.Second
+
+func main() {
+	log := logger.New(logger.Config{Level: "info", TimeFormat: time.RFC3339})
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load config", err)
+	}
+	engine, err := beeinfra.NewEngine(cfg)
+	if err != nil {
+		log.Fatal("Failed to init database engine", err)
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     net.JoinHostPort(cfg.Redis.Host, cfg.Redis.Port),
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	defer rdb.Close()
+
+	fileRepo, err := storage.New(cfg.Storage, cfg.S3)
+	if err != nil {
+		log.Fatal("Failed to init file storage", err)
+	}
+	retention := repository.StreamRetention{MaxLen: cfg.Stream.MaxLen, MaxAge: cfg.Stream.MaxAge}
+	eventBroker, err := broker.New(cfg.Broker, rdb, retention)
+	if err != nil {
+		log.Fatal("Failed to init message broker", err)
+	}
+	defer eventBroker.Close()
+
+	todoRepo := mysql.NewTodoRepository(engine, log)
+	outboxRepo := mysql.NewOutboxRepo(engine, log)
+	metadata := mysql.NewFileMetadataRepository(engine, log)
+	refs := mysql.NewFileReferenceRepository(engine, log)
+	thumbs := mysql.NewThumbnailRepository(engine, log)
+	attachmentRepo := mysql.NewAttachmentRepository(engine, log)
+
+	codec, err := cache.CodecByName(cfg.Cache.Codec)
+	if err != nil {
+		log.Fatal("Failed to pick cache codec", err)
+	}
+	l1, err := cache.NewTiered(repository.NewRedisCacheRepository(log, rdb), cache.TieredOptions{
+		Size: cfg.Cache.L1Size,
+		TTL:  cfg.Cache.L1TTL,
+		Bus:  repository.NewRedisInvalidationBus(log, rdb, cfg.Cache.InvalidationChannel),
+	})
+	if err != nil {
+		log.Fatal("Failed to init L1 cache", err)
+	}
+
+	policy, err := usecase.NewFilePolicy(cfg.Files)
+	if err != nil {
+		log.Fatal("Invalid file policy", err)
+	}
+	files := usecase.NewFileUseCase(log, fileRepo, metadata).
+		WithPolicy(policy).
+		WithReferences(refs).
+		WithEvents(outboxRepo).
+		WithThumbnails(thumbs)
+	scanning := cfg.Scanner.ClamAVAddress != ""
+	if scanning {
+		files.WithScanner(clamav.NewScanner(cfg.Scanner.ClamAVAddress, cfg.Scanner.Timeout))
+	}
+	todos := usecase.NewTodoUseCase(log, todoRepo, fileRepo, l1, outboxRepo).
+		WithCache(codec, cfg.Cache.TTL, cfg.Cache.StaleTTL).
+		WithQuarantine(metadata, scanning).
+		WithAttachments(attachmentRepo)
+	attachments := usecase.NewAttachmentUseCase(log, todoRepo, fileRepo, attachmentRepo).
+		WithQuarantine(metadata, scanning)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	run := func(name string, fn func(ctx context.Context) error) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := fn(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Error(name+" stopped", err)
+			}
+		}()
+	}
+
+	run("Outbox dispatcher", func(ctx context.Context) error {
+		worker.NewOutboxDispatcher(outboxRepo, eventBroker, cfg.Stream.Name).Run(ctx)
+		return nil
+	})
+	// Entries consumers give up on are parked next to the stream, and kept
+	// for as long as the stream's own.
+	deadStream := worker.DeadStreamOf(cfg.Stream.Name)
+	run("Stream trim job", func(ctx context.Context) error {
+		worker.NewStreamTrimJob(log, redisinfra.NewStreamTrimmer(rdb), cfg.Stream, cfg.Stream.Name, deadStream).Run(ctx)
+		return nil
+	})
+	run("File GC job", func(ctx context.Context) error {
+		worker.NewFileGCJob(log, usecase.NewFileGCUseCase(log, fileRepo, metadata, refs), cfg.FileGC).Run(ctx)
+		return nil
+	})
+	run("L1 cache invalidation", l1.Run)
+	// The thumbnail worker reads file events back from the Redis stream;
+	// other brokers deliver them elsewhere.
+	if cfg.Broker.Driver == "" || cfg.Broker.Driver == broker.DriverRedis {
+		consumer := worker.NewStreamConsumer(log, rdb, worker.StreamConsumerConfig{
+			Stream:        cfg.Stream.Name,
+			Group:         thumbnailGroup,
+			DeadStream:    deadStream,
+			DeadRetention: retention,
+		})
+		// Files quarantined on upload are thumbnailed once file.scanned
+		// releases them.
+		thumbnails := worker.NewThumbnailHandler(log, usecase.NewThumbnailUseCase(log, fileRepo, metadata, thumbs, cfg.Thumbnails))
+		consumer.Handle(repository.EventFileUploaded, thumbnails)
+		consumer.Handle(repository.EventFileScanned, thumbnails)
+		run("Thumbnail consumer", consumer.Run)
+	} else {
+		log.Info("Thumbnails are only generated with the redis broker; consumer not started")
+	}
+
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.Logger(), middleware.Recovery(), middleware.CORS())
+	httpdelivery.NewHandler(log, todos, files, attachments).RegisterRoutes(router)
+	httpdelivery.NewAdminHandler(log, usecase.NewOutboxAdminUseCase(log, outboxRepo)).RegisterRoutes(router)
+	graphql.RegisterGinGraphQL(router, todos, files, attachments)
+
+	server := &http.Server{
+		Addr:         ":" + cfg.Server.Port,
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	go func() {
+		log.Info("API listening on %s", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("Server failed", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error("Failed to shut down cleanly", err)
+	}
+	workers.Wait()
+}
@@ -1,41 +1,190 @@
 package graphql
 
 import (
-	"time"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/delaram/GoTastic/internal/delivery/graphql/model"
 	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
-func toModelTodoPtr(t *domain.TodoItem) *model.Todo {
+func toModelTodoPtr(t *domain.TodoItem) *model.TodoItem {
 	if t == nil {
 		return nil
 	}
 
-	var due time.Time
-	if t.DueDate != nil {
-		due = *t.DueDate
-	}
-
 	var filePtr *string
 	if t.FileID != nil && *t.FileID != "" {
 		filePtr = t.FileID
 	}
 
-	return &model.Todo{
+	return &model.TodoItem{
 		ID:          t.UUID,
 		Description: t.Description,
-		DueDate:     due,
+		DueDate:     t.DueDate,
 		FileID:      filePtr,
+		Status:      toModelStatus(t.CurrentStatus()),
+		CompletedAt: t.CompletedAt,
 		CreatedAt:   t.CreatedAt,
 		UpdatedAt:   t.UpdatedAt,
 	}
 }
 
-func toModelTodosPtr(in []*domain.TodoItem) []*model.Todo {
-	out := make([]*model.Todo, 0, len(in))
+func toModelTodosPtr(in []*domain.TodoItem) []*model.TodoItem {
+	out := make([]*model.TodoItem, 0, len(in))
 	for _, it := range in {
 		out = append(out, toModelTodoPtr(it))
 	}
 	return out
 }
+
+func toModelFile(f *domain.File) *model.File {
+	return &model.File{
+		ID:          f.FileID,
+		Filename:    f.Filename,
+		ContentType: f.ContentType,
+		Size:        int(f.Size),
+		Sha256:      f.SHA256,
+		UploadedBy:  f.UploadedBy,
+		CreatedAt:   f.CreatedAt,
+		ScanStatus:  toModelScanStatus(f.ScanStatus),
+	}
+}
+
+// toModelScanStatus is nil for files stored without scanning.
+func toModelScanStatus(s string) *model.FileScanStatus {
+	if s == "" {
+		return nil
+	}
+	status := model.FileScanStatus(strings.ToUpper(s))
+	return &status
+}
+
+// toDomainFile is as much of the file as resolving its fields needs: its id
+// and scan status.
+func toDomainFile(f *model.File) *domain.File {
+	file := &domain.File{FileID: f.ID}
+	if f.ScanStatus != nil {
+		file.ScanStatus = strings.ToLower(string(*f.ScanStatus))
+	}
+	return file
+}
+
+func toModelFiles(in []*domain.File) []*model.File {
+	out := make([]*model.File, 0, len(in))
+	for _, f := range in {
+		out = append(out, toModelFile(f))
+	}
+	return out
+}
+
+func toModelThumbnails(fileID string, in []*domain.FileThumbnail) []*model.Thumbnail {
+	out := make([]*model.Thumbnail, 0, len(in))
+	for _, t := range in {
+		out = append(out, &model.Thumbnail{
+			Size:        t.Size,
+			Width:       t.Width,
+			Height:      t.Height,
+			ContentType: t.ContentType,
+			URL:         usecase.ThumbnailPath(fileID, t.Size),
+		})
+	}
+	return out
+}
+
+func toModelAttachment(a *domain.TodoAttachment) *model.Attachment {
+	return &model.Attachment{
+		FileID:     a.FileID,
+		Position:   a.Position,
+		AttachedAt: a.CreatedAt,
+	}
+}
+
+func toModelAttachments(in []*domain.TodoAttachment) []*model.Attachment {
+	out := make([]*model.Attachment, 0, len(in))
+	for _, a := range in {
+		out = append(out, toModelAttachment(a))
+	}
+	return out
+}
+
+func toModelPresignedURL(u *repository.PresignedURL) *model.PresignedURL {
+	headers := make([]*model.Header, 0, len(u.Headers))
+	for name, value := range u.Headers {
+		headers = append(headers, &model.Header{Name: name, Value: value})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return &model.PresignedURL{
+		URL:       u.URL,
+		Method:    u.Method,
+		Headers:   headers,
+		ExpiresAt: u.ExpiresAt,
+	}
+}
+
+// fileByID resolves a file's metadata; a file without any (or one that is
+// gone) resolves to null rather than an error.
+func (r *Resolver) fileByID(ctx context.Context, id string) (*model.File, error) {
+	f, err := r.FileUC.GetFile(ctx, id)
+	if errors.Is(err, usecase.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toModelFile(f), nil
+}
+
+// GraphQL enum values are the upper-cased domain statuses (IN_PROGRESS <-> in_progress).
+func toModelStatus(s domain.TodoStatus) model.TodoStatus {
+	return model.TodoStatus(strings.ToUpper(string(s)))
+}
+
+func toDomainStatus(s model.TodoStatus) domain.TodoStatus {
+	return domain.TodoStatus(strings.ToLower(string(s)))
+}
+
+// uploadError turns file errors into GraphQL errors carrying the HTTP status
+// the REST API answers with, so clients can branch on it.
+func uploadError(ctx context.Context, err error) error {
+	var code string
+	var status int
+	switch {
+	case errors.Is(err, usecase.ErrInvalidFileType):
+		code, status = "UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType
+	case errors.Is(err, usecase.ErrFileTooLarge):
+		code, status = "PAYLOAD_TOO_LARGE", http.StatusRequestEntityTooLarge
+	case errors.Is(err, usecase.ErrInvalidFileSize):
+		code, status = "BAD_USER_INPUT", http.StatusBadRequest
+	case errors.Is(err, usecase.ErrFileNotFound):
+		code, status = "NOT_FOUND", http.StatusNotFound
+	case errors.Is(err, usecase.ErrPresignUnsupported):
+		code, status = "NOT_IMPLEMENTED", http.StatusNotImplemented
+	case errors.Is(err, usecase.ErrFileInUse):
+		code, status = "CONFLICT", http.StatusConflict
+	case errors.Is(err, usecase.ErrUploadUnconfirmed):
+		code, status = "UPLOAD_UNCONFIRMED", http.StatusConflict
+	case errors.Is(err, usecase.ErrFileQuarantined):
+		code, status = "FILE_QUARANTINED", http.StatusLocked
+	case errors.Is(err, usecase.ErrFileInfected):
+		code, status = "FILE_INFECTED", http.StatusUnprocessableEntity
+	default:
+		return err
+	}
+	return &gqlerror.Error{
+		Err:     err,
+		Message: err.Error(),
+		Path:    graphql.GetPath(ctx),
+		Extensions: map[string]interface{}{
+			"code":   code,
+			"status": status,
+		},
+	}
+}
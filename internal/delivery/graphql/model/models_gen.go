@@ -10,6 +10,40 @@ import (
 	"time"
 )
 
+type Attachment struct {
+	FileID     string    `json:"fileId"`
+	File       *File     `json:"file,omitempty"`
+	Position   int       `json:"position"`
+	AttachedAt time.Time `json:"attachedAt"`
+}
+
+type File struct {
+	ID          string          `json:"id"`
+	Filename    string          `json:"filename"`
+	ContentType string          `json:"contentType"`
+	Size        int             `json:"size"`
+	Sha256      string          `json:"sha256"`
+	UploadedBy  *string         `json:"uploadedBy,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	ScanStatus  *FileScanStatus `json:"scanStatus,omitempty"`
+	Thumbnails  []*Thumbnail    `json:"thumbnails"`
+}
+
+type FileFilter struct {
+	UploadedBy  *string `json:"uploadedBy,omitempty"`
+	ContentType *string `json:"contentType,omitempty"`
+}
+
+type FilePage struct {
+	Total int     `json:"total"`
+	Items []*File `json:"items"`
+}
+
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
 type Mutation struct {
 }
 
@@ -18,28 +52,53 @@ type PageInput struct {
 	Offset int `json:"offset"`
 }
 
+type PresignedURL struct {
+	URL       string    `json:"url"`
+	Method    string    `json:"method"`
+	Headers   []*Header `json:"headers"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type PresignedUpload struct {
+	FileID string        `json:"fileId"`
+	Upload *PresignedURL `json:"upload"`
+}
+
 type Query struct {
 }
 
-type Todo struct {
-	ID          string    `json:"id"`
-	Description string    `json:"description"`
-	DueDate     time.Time `json:"dueDate"`
-	FileID      *string   `json:"fileId,omitempty"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+type Thumbnail struct {
+	Size        int    `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"contentType"`
+	URL         string `json:"url"`
 }
 
 type TodoFilter struct {
-	Q       *string    `json:"q,omitempty"`
-	DueFrom *time.Time `json:"dueFrom,omitempty"`
-	DueTo   *time.Time `json:"dueTo,omitempty"`
-	HasFile *bool      `json:"hasFile,omitempty"`
+	Q       *string      `json:"q,omitempty"`
+	DueFrom *time.Time   `json:"dueFrom,omitempty"`
+	DueTo   *time.Time   `json:"dueTo,omitempty"`
+	HasFile *bool        `json:"hasFile,omitempty"`
+	Status  []TodoStatus `json:"status,omitempty"`
+}
+
+type TodoItem struct {
+	ID          string        `json:"id"`
+	Description string        `json:"description"`
+	DueDate     *time.Time    `json:"dueDate,omitempty"`
+	FileID      *string       `json:"fileId,omitempty"`
+	File        *File         `json:"file,omitempty"`
+	Attachments []*Attachment `json:"attachments"`
+	Status      TodoStatus    `json:"status"`
+	CompletedAt *time.Time    `json:"completedAt,omitempty"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
 }
 
 type TodoPage struct {
-	Total int     `json:"total"`
-	Items []*Todo `json:"items"`
+	Total int         `json:"total"`
+	Items []*TodoItem `json:"items"`
 }
 
 type TodoSort struct {
@@ -47,6 +106,65 @@ type TodoSort struct {
 	Direction SortDirection `json:"direction"`
 }
 
+type FileScanStatus string
+
+const (
+	FileScanStatusUnconfirmed FileScanStatus = "UNCONFIRMED"
+	FileScanStatusPending     FileScanStatus = "PENDING"
+	FileScanStatusClean       FileScanStatus = "CLEAN"
+	FileScanStatusInfected    FileScanStatus = "INFECTED"
+)
+
+var AllFileScanStatus = []FileScanStatus{
+	FileScanStatusUnconfirmed,
+	FileScanStatusPending,
+	FileScanStatusClean,
+	FileScanStatusInfected,
+}
+
+func (e FileScanStatus) IsValid() bool {
+	switch e {
+	case FileScanStatusUnconfirmed, FileScanStatusPending, FileScanStatusClean, FileScanStatusInfected:
+		return true
+	}
+	return false
+}
+
+func (e FileScanStatus) String() string {
+	return string(e)
+}
+
+func (e *FileScanStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = FileScanStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid FileScanStatus", str)
+	}
+	return nil
+}
+
+func (e FileScanStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *FileScanStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e FileScanStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
 type SortDirection string
 
 const (
@@ -160,3 +278,64 @@ func (e TodoSortField) MarshalJSON() ([]byte, error) {
 	e.MarshalGQL(&buf)
 	return buf.Bytes(), nil
 }
+
+type TodoStatus string
+
+const (
+	TodoStatusOpen       TodoStatus = "OPEN"
+	TodoStatusInProgress TodoStatus = "IN_PROGRESS"
+	TodoStatusBlocked    TodoStatus = "BLOCKED"
+	TodoStatusDone       TodoStatus = "DONE"
+	TodoStatusCancelled  TodoStatus = "CANCELLED"
+)
+
+var AllTodoStatus = []TodoStatus{
+	TodoStatusOpen,
+	TodoStatusInProgress,
+	TodoStatusBlocked,
+	TodoStatusDone,
+	TodoStatusCancelled,
+}
+
+func (e TodoStatus) IsValid() bool {
+	switch e {
+	case TodoStatusOpen, TodoStatusInProgress, TodoStatusBlocked, TodoStatusDone, TodoStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (e TodoStatus) String() string {
+	return string(e)
+}
+
+func (e *TodoStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TodoStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TodoStatus", str)
+	}
+	return nil
+}
+
+func (e TodoStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *TodoStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e TodoStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
@@ -3,6 +3,7 @@ package graphql
 import "github.com/delaram/GoTastic/internal/usecase"
 
 type Resolver struct {
-	TodoUC *usecase.TodoUseCase
-	FileUC *usecase.FileUseCase
+	TodoUC       *usecase.TodoUseCase
+	FileUC       *usecase.FileUseCase
+	AttachmentUC *usecase.AttachmentUseCase
 }
@@ -13,21 +13,35 @@ import (
 	"github.com/delaram/GoTastic/internal/domain"
 )
 
+// File is the resolver for the file field.
+func (r *attachmentResolver) File(ctx context.Context, obj *model.Attachment) (*model.File, error) {
+	return r.fileByID(ctx, obj.FileID)
+}
+
+// Thumbnails is the resolver for the thumbnails field.
+func (r *fileResolver) Thumbnails(ctx context.Context, obj *model.File) ([]*model.Thumbnail, error) {
+	thumbs, err := r.FileUC.Thumbnails(ctx, []*domain.File{toDomainFile(obj)})
+	if err != nil {
+		return nil, err
+	}
+	return toModelThumbnails(obj.ID, thumbs[obj.ID]), nil
+}
+
 // CreateTodo is the resolver for the createTodo field.
-func (r *mutationResolver) CreateTodo(ctx context.Context, description string, dueDate time.Time, fileID *string) (*model.Todo, error) {
+func (r *mutationResolver) CreateTodo(ctx context.Context, description string, dueDate time.Time, fileID *string) (*model.TodoItem, error) {
 	var fid string
 	if fileID != nil {
 		fid = *fileID
 	}
 	todo, err := r.TodoUC.CreateTodoItem(ctx, description, dueDate, fid)
 	if err != nil {
-		return nil, err
+		return nil, uploadError(ctx, err)
 	}
 	return toModelTodoPtr(todo), nil
 }
 
 // UpdateTodo is the resolver for the updateTodo field.
-func (r *mutationResolver) UpdateTodo(ctx context.Context, id string, description string, dueDate time.Time, fileID *string) (*model.Todo, error) {
+func (r *mutationResolver) UpdateTodo(ctx context.Context, id string, description string, dueDate time.Time, fileID *string) (*model.TodoItem, error) {
 	var fid *string
 	if fileID != nil && *fileID != "" {
 		fid = fileID
@@ -42,7 +56,7 @@ func (r *mutationResolver) UpdateTodo(ctx context.Context, id string, descriptio
 	}
 
 	if err := r.TodoUC.UpdateTodoItem(ctx, t); err != nil {
-		return nil, err
+		return nil, uploadError(ctx, err)
 	}
 
 	after, err := r.TodoUC.GetTodoItem(ctx, id)
@@ -60,15 +74,72 @@ func (r *mutationResolver) DeleteTodo(ctx context.Context, id string) (bool, err
 	return true, nil
 }
 
+// SetTodoStatus is the resolver for the setTodoStatus field.
+func (r *mutationResolver) SetTodoStatus(ctx context.Context, id string, status model.TodoStatus) (*model.TodoItem, error) {
+	todo, err := r.TodoUC.ChangeTodoStatus(ctx, id, toDomainStatus(status))
+	if err != nil {
+		return nil, err
+	}
+	return toModelTodoPtr(todo), nil
+}
+
+// AttachFile is the resolver for the attachFile field.
+func (r *mutationResolver) AttachFile(ctx context.Context, todoID string, fileID string) (*model.Attachment, error) {
+	attachment, err := r.AttachmentUC.AttachFile(ctx, todoID, fileID)
+	if err != nil {
+		return nil, uploadError(ctx, err)
+	}
+	return toModelAttachment(attachment), nil
+}
+
+// DetachFile is the resolver for the detachFile field.
+func (r *mutationResolver) DetachFile(ctx context.Context, todoID string, fileID string) (bool, error) {
+	if err := r.AttachmentUC.DetachFile(ctx, todoID, fileID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReorderAttachments is the resolver for the reorderAttachments field.
+func (r *mutationResolver) ReorderAttachments(ctx context.Context, todoID string, fileIds []string) ([]*model.Attachment, error) {
+	attachments, err := r.AttachmentUC.ReorderAttachments(ctx, todoID, fileIds)
+	if err != nil {
+		return nil, err
+	}
+	return toModelAttachments(attachments), nil
+}
+
 // UploadFile is the resolver for the uploadFile field.
 func (r *mutationResolver) UploadFile(ctx context.Context, file graphql.Upload) (string, error) {
-	return r.FileUC.UploadFile(ctx, file.File, file.Filename)
+	id, err := r.FileUC.UploadFile(ctx, file.File, file.Filename)
+	if err != nil {
+		return "", uploadError(ctx, err)
+	}
+	return id, nil
+}
+
+// RequestFileUpload is the resolver for the requestFileUpload field.
+func (r *mutationResolver) RequestFileUpload(ctx context.Context, filename string, size int) (*model.PresignedUpload, error) {
+	fileID, url, err := r.FileUC.RequestUpload(ctx, filename, int64(size))
+	if err != nil {
+		return nil, uploadError(ctx, err)
+	}
+	return &model.PresignedUpload{FileID: fileID, Upload: toModelPresignedURL(url)}, nil
+}
+
+// ConfirmFileUpload is the resolver for the confirmFileUpload field.
+func (r *mutationResolver) ConfirmFileUpload(ctx context.Context, id string, filename string) (*model.File, error) {
+	file, err := r.FileUC.ConfirmUpload(ctx, id, filename)
+	if err != nil {
+		return nil, uploadError(ctx, err)
+	}
+	return toModelFile(file), nil
 }
 
 // DeleteFile is the resolver for the deleteFile field.
 func (r *mutationResolver) DeleteFile(ctx context.Context, id string) (bool, error) {
 	if err := r.FileUC.DeleteFile(ctx, id); err != nil {
-		return false, err
+		return false, uploadError(ctx, err)
 	}
 	return true, nil
 }
@@ -95,6 +166,9 @@ func (r *queryResolver) Todos(ctx context.Context, page model.PageInput, filter
 		df.DueFrom = filter.DueFrom
 		df.DueTo = filter.DueTo
 		df.HasFile = filter.HasFile
+		for _, st := range filter.Status {
+			df.Status = append(df.Status, toDomainStatus(st))
+		}
 	}
 
 	ds := domain.TodoSort{Field: domain.SortUpdatedAt, Direction: domain.SortDesc}
@@ -128,7 +202,7 @@ func (r *queryResolver) Todos(ctx context.Context, page model.PageInput, filter
 }
 
 // Todo is the resolver for the todo field.
-func (r *queryResolver) Todo(ctx context.Context, id string) (*model.Todo, error) {
+func (r *queryResolver) Todo(ctx context.Context, id string) (*model.TodoItem, error) {
 	item, err := r.TodoUC.GetTodoItem(ctx, id)
 	if err != nil {
 		return nil, err
@@ -136,11 +210,73 @@ func (r *queryResolver) Todo(ctx context.Context, id string) (*model.Todo, error
 	return toModelTodoPtr(item), nil
 }
 
+// Files is the resolver for the files field.
+func (r *queryResolver) Files(ctx context.Context, page model.PageInput, filter *model.FileFilter) (*model.FilePage, error) {
+	var df domain.FileFilter
+	if filter != nil {
+		df.UploadedBy = filter.UploadedBy
+		df.ContentType = filter.ContentType
+	}
+
+	files, total, err := r.FileUC.ListFiles(ctx, df, page.Limit, page.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.FilePage{
+		Total: int(total),
+		Items: toModelFiles(files),
+	}, nil
+}
+
+// File is the resolver for the file field.
+func (r *queryResolver) File(ctx context.Context, id string) (*model.File, error) {
+	return r.fileByID(ctx, id)
+}
+
+// FileDownloadURL is the resolver for the fileDownloadUrl field.
+func (r *queryResolver) FileDownloadURL(ctx context.Context, id string) (*model.PresignedURL, error) {
+	url, err := r.FileUC.DownloadURL(ctx, id)
+	if err != nil {
+		return nil, uploadError(ctx, err)
+	}
+	return toModelPresignedURL(url), nil
+}
+
+// File is the resolver for the file field.
+func (r *todoItemResolver) File(ctx context.Context, obj *model.TodoItem) (*model.File, error) {
+	if obj.FileID == nil {
+		return nil, nil
+	}
+	return r.fileByID(ctx, *obj.FileID)
+}
+
+// Attachments is the resolver for the attachments field.
+func (r *todoItemResolver) Attachments(ctx context.Context, obj *model.TodoItem) ([]*model.Attachment, error) {
+	attachments, err := r.AttachmentUC.ListAttachments(ctx, obj.ID)
+	if err != nil {
+		return nil, err
+	}
+	return toModelAttachments(attachments), nil
+}
+
+// Attachment returns AttachmentResolver implementation.
+func (r *Resolver) Attachment() AttachmentResolver { return &attachmentResolver{r} }
+
+// File returns FileResolver implementation.
+func (r *Resolver) File() FileResolver { return &fileResolver{r} }
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
+// TodoItem returns TodoItemResolver implementation.
+func (r *Resolver) TodoItem() TodoItemResolver { return &todoItemResolver{r} }
+
+type attachmentResolver struct{ *Resolver }
+type fileResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type todoItemResolver struct{ *Resolver }
@@ -11,13 +11,18 @@ import (
 
 //go:generate go run github.com/99designs/gqlgen generate --config internal/delivery/graphql/gqlgen.yml --verbose
 
-func NewHandlers(todoUC *usecase.TodoUseCase, fileUC *usecase.FileUseCase) (playgroundH http.Handler, gqlH http.Handler) {
-	es := NewExecutableSchema(Config{Resolvers: &Resolver{TodoUC: todoUC, FileUC: fileUC}})
+func NewHandlers(todoUC *usecase.TodoUseCase, fileUC *usecase.FileUseCase, attachmentUC *usecase.AttachmentUseCase) (playgroundH http.Handler, gqlH http.Handler) {
+	es := NewExecutableSchema(Config{Resolvers: &Resolver{TodoUC: todoUC, FileUC: fileUC, AttachmentUC: attachmentUC}})
 	return playground.Handler("GraphQL Playground", "/graphql/query"), handler.NewDefaultServer(es)
 }
 
-func RegisterGinGraphQL(r *gin.Engine, todoUC *usecase.TodoUseCase, fileUC *usecase.FileUseCase) {
-	pg, gql := NewHandlers(todoUC, fileUC)
+// UploaderHeader names who is making the request; files uploaded through
+// the API are attributed to them. Same header as the REST API.
+const UploaderHeader = "X-User-ID"
+
+func RegisterGinGraphQL(r *gin.Engine, todoUC *usecase.TodoUseCase, fileUC *usecase.FileUseCase, attachmentUC *usecase.AttachmentUseCase) {
+	pg, gql := NewHandlers(todoUC, fileUC, attachmentUC)
+	gql = withUploader(gql)
 	g := r.Group("/graphql")
 	{
 		// Playground
@@ -30,3 +35,10 @@ func RegisterGinGraphQL(r *gin.Engine, todoUC *usecase.TodoUseCase, fileUC *usec
 		g.POST("/query", gin.WrapH(gql))
 	}
 }
+
+func withUploader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := usecase.ContextWithUploader(req.Context(), req.Header.Get(UploaderHeader))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
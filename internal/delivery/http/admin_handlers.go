@@ -0,0 +1,152 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler serves the operator endpoints. It is kept apart from Handler
+// so it can be mounted behind its own auth or on an internal listener.
+type AdminHandler struct {
+	logger      logger.Logger
+	outboxAdmin *usecase.OutboxAdminUseCase
+}
+
+func NewAdminHandler(logger logger.Logger, outboxAdmin *usecase.OutboxAdminUseCase) *AdminHandler {
+	return &AdminHandler{
+		logger:      logger,
+		outboxAdmin: outboxAdmin,
+	}
+}
+
+func (h *AdminHandler) RegisterRoutes(r gin.IRouter) {
+	admin := r.Group("/api/v1/admin")
+	{
+		dead := admin.Group("/outbox/dead")
+		{
+			dead.GET("/", h.ListDeadLetters)
+			dead.GET("/:id", h.GetDeadLetter)
+			dead.POST("/:id/requeue", h.RequeueDeadLetter)
+			dead.DELETE("/:id", h.PurgeDeadLetter)
+		}
+	}
+}
+
+func (h *AdminHandler) ListDeadLetters(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	rows, total, err := h.outboxAdmin.ListDeadLetters(c.Request.Context(), limit, offset)
+	if err != nil {
+		h.logger.Error("Failed to list dead letters", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list dead letters"})
+		return
+	}
+
+	response := make([]gin.H, len(rows))
+	for i, row := range rows {
+		response[i] = outboxJSON(row)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"events": response,
+		"total":  total,
+	})
+}
+
+func (h *AdminHandler) GetDeadLetter(c *gin.Context) {
+	id, ok := outboxIDParam(c)
+	if !ok {
+		return
+	}
+
+	dl, err := h.outboxAdmin.GetDeadLetter(c.Request.Context(), id)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+			return
+		}
+		h.logger.Error("Failed to get dead letter", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dead letter"})
+		return
+	}
+
+	attempts := make([]gin.H, len(dl.Attempts))
+	for i, a := range dl.Attempts {
+		attempts[i] = gin.H{
+			"attempt":   a.Attempt,
+			"worker":    a.Worker,
+			"error":     a.Error,
+			"failed_at": a.FailedAt,
+		}
+	}
+	response := outboxJSON(dl.Message)
+	response["attempt_history"] = attempts
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AdminHandler) RequeueDeadLetter(c *gin.Context) {
+	id, ok := outboxIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.outboxAdmin.RequeueDeadLetter(c.Request.Context(), id); err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue dead letter"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AdminHandler) PurgeDeadLetter(c *gin.Context) {
+	id, ok := outboxIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.outboxAdmin.PurgeDeadLetter(c.Request.Context(), id); err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge dead letter"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func outboxIDParam(c *gin.Context) (uint64, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid outbox id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func outboxJSON(row *domain.Outbox) gin.H {
+	var headers json.RawMessage
+	if row.Headers != nil {
+		headers = json.RawMessage(*row.Headers)
+	}
+	return gin.H{
+		"id":             row.ID,
+		"aggregate_type": row.AggregateType,
+		"aggregate_id":   row.AggregateID,
+		"event_type":     row.EventType,
+		"payload":        json.RawMessage(row.Payload),
+		"headers":        headers,
+		"status":         row.Status,
+		"attempts":       row.Attempts,
+		"last_error":     row.LastError,
+		"dead_at":        row.DeadAt,
+	}
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupAdminRouter() (*gin.Engine, *usecase.MockDeadLetterRepository) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockDeadLetters := new(usecase.MockDeadLetterRepository)
+	handler := NewAdminHandler(log, usecase.NewOutboxAdminUseCase(log, mockDeadLetters))
+
+	r := gin.New()
+	handler.RegisterRoutes(r)
+	return r, mockDeadLetters
+}
+
+func TestHandleListDeadLetters(t *testing.T) {
+	r, mockDeadLetters := setupAdminRouter()
+
+	rows := []*domain.Outbox{{ID: 5, EventType: repository.EventTodoCreated, Payload: []byte(`{"id":"x"}`), Status: domain.OutboxStatusDead}}
+	mockDeadLetters.On("ListDead", mock.Anything, 10, 0).Return(rows, int64(1), nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/admin/outbox/dead/?limit=10", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Events []map[string]interface{} `json:"events"`
+		Total  int64                    `json:"total"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, int64(1), response.Total)
+	assert.Equal(t, "x", response.Events[0]["payload"].(map[string]interface{})["id"])
+}
+
+func TestHandleGetDeadLetter(t *testing.T) {
+	r, mockDeadLetters := setupAdminRouter()
+
+	lastErr := "boom"
+	mockDeadLetters.On("GetDead", mock.Anything, uint64(5)).Return(&domain.Outbox{ID: 5, Payload: []byte(`{}`), Status: domain.OutboxStatusDead, LastError: &lastErr}, nil)
+	mockDeadLetters.On("ListAttempts", mock.Anything, uint64(5)).Return([]*domain.OutboxAttempt{{OutboxID: 5, Attempt: 1, Error: lastErr}}, nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/admin/outbox/dead/5", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, lastErr, response["last_error"])
+	assert.Len(t, response["attempt_history"], 1)
+}
+
+func TestHandleRequeueDeadLetter(t *testing.T) {
+	r, mockDeadLetters := setupAdminRouter()
+
+	mockDeadLetters.On("Requeue", mock.Anything, uint64(5)).Return(nil)
+	mockDeadLetters.On("Requeue", mock.Anything, uint64(6)).Return(repository.ErrNotFound)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/admin/outbox/dead/5/requeue", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/admin/outbox/dead/6/requeue", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlePurgeDeadLetter(t *testing.T) {
+	r, mockDeadLetters := setupAdminRouter()
+
+	mockDeadLetters.On("Purge", mock.Anything, uint64(5)).Return(nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/admin/outbox/dead/5", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/admin/outbox/dead/abc", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -1,8 +1,11 @@
 package http
 
 import (
-	"io"
+	"errors"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/delaram/GoTastic/internal/domain"
@@ -14,16 +17,18 @@ import (
 )
 
 type Handler struct {
-	logger      logger.Logger
-	todoUseCase *usecase.TodoUseCase
-	fileUseCase *usecase.FileUseCase
+	logger            logger.Logger
+	todoUseCase       *usecase.TodoUseCase
+	fileUseCase       *usecase.FileUseCase
+	attachmentUseCase *usecase.AttachmentUseCase
 }
 
-func NewHandler(logger logger.Logger, todoUseCase *usecase.TodoUseCase, fileUseCase *usecase.FileUseCase) *Handler {
+func NewHandler(logger logger.Logger, todoUseCase *usecase.TodoUseCase, fileUseCase *usecase.FileUseCase, attachmentUseCase *usecase.AttachmentUseCase) *Handler {
 	return &Handler{
-		logger:      logger,
-		todoUseCase: todoUseCase,
-		fileUseCase: fileUseCase,
+		logger:            logger,
+		todoUseCase:       todoUseCase,
+		fileUseCase:       fileUseCase,
+		attachmentUseCase: attachmentUseCase,
 	}
 }
 
@@ -42,11 +47,23 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 			todos.GET("/:id", h.GetTodoItem)
 			todos.PUT("/:id", h.UpdateTodoItem)
 			todos.DELETE("/:id", h.DeleteTodoItem)
+			todos.PATCH("/:id/status", h.ChangeTodoStatus)
+			todos.GET("/:id/attachments", h.ListAttachments)
+			todos.POST("/:id/attachments", h.AttachFile)
+			todos.PUT("/:id/attachments", h.ReorderAttachments)
+			todos.DELETE("/:id/attachments/:fileId", h.DetachFile)
 		}
 		files := api.Group("/files")
 		{
+			files.GET("/", h.ListFiles)
 			files.POST("/", h.UploadFile)
 			files.GET("/:id", h.DownloadFile)
+			files.HEAD("/:id", h.DownloadFile)
+			files.GET("/:id/metadata", h.GetFile)
+			files.GET("/:id/thumbnails/:size", h.DownloadThumbnail)
+			files.POST("/uploads", h.RequestUpload)
+			files.POST("/:id/confirm", h.ConfirmUpload)
+			files.GET("/:id/url", h.DownloadURL)
 			files.DELETE("/:id", h.DeleteFile)
 		}
 	}
@@ -66,12 +83,14 @@ func (h *Handler) ListTodoItems(c *gin.Context) {
 	response := make([]gin.H, len(todos))
 	for i, todo := range todos {
 		response[i] = gin.H{
-			"id":          todo.ID,
-			"description": todo.Description,
-			"due_date":    todo.DueDate,
-			"file_id":     todo.FileID,
-			"created_at":  todo.CreatedAt,
-			"updated_at":  todo.UpdatedAt,
+			"id":           todo.ID,
+			"description":  todo.Description,
+			"due_date":     todo.DueDate,
+			"file_id":      todo.FileID,
+			"status":       todo.CurrentStatus(),
+			"completed_at": todo.CompletedAt,
+			"created_at":   todo.CreatedAt,
+			"updated_at":   todo.UpdatedAt,
 		}
 	}
 
@@ -119,6 +138,9 @@ func (h *Handler) CreateTodoItem(c *gin.Context) {
 			})
 			return
 		}
+		if scanError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to create todo item",
 			"details": err.Error(),
@@ -127,12 +149,14 @@ func (h *Handler) CreateTodoItem(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"id":          todo.ID,
-		"description": todo.Description,
-		"due_date":    todo.DueDate,
-		"file_id":     todo.FileID,
-		"created_at":  todo.CreatedAt,
-		"updated_at":  todo.UpdatedAt,
+		"id":           todo.ID,
+		"description":  todo.Description,
+		"due_date":     todo.DueDate,
+		"file_id":      todo.FileID,
+		"status":       todo.CurrentStatus(),
+		"completed_at": todo.CompletedAt,
+		"created_at":   todo.CreatedAt,
+		"updated_at":   todo.UpdatedAt,
 	})
 }
 
@@ -193,12 +217,59 @@ func (h *Handler) UpdateTodoItem(c *gin.Context) {
 
 	if err := h.todoUseCase.UpdateTodoItem(c.Request.Context(), todo); err != nil {
 		h.logger.Error("update todo item", err)
+		if scanError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update todo item"})
 		return
 	}
 	c.Status(http.StatusNoContent)
 }
 
+func (h *Handler) ChangeTodoStatus(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid todo id format"})
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("decode request body", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	status, err := domain.ParseTodoStatus(req.Status)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	todo, err := h.todoUseCase.ChangeTodoStatus(c.Request.Context(), id, status)
+	if err != nil {
+		switch err {
+		case repository.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Todo item not found"})
+		case domain.ErrInvalidStatusTransition:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("change todo status", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change todo status"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":           todo.ID,
+		"status":       todo.CurrentStatus(),
+		"completed_at": todo.CompletedAt,
+		"updated_at":   todo.UpdatedAt,
+	})
+}
+
 func (h *Handler) DeleteTodoItem(c *gin.Context) {
 	id := c.Param("id")
 	if err := h.todoUseCase.DeleteTodoItem(c.Request.Context(), id); err != nil {
@@ -209,40 +280,390 @@ func (h *Handler) DeleteTodoItem(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+func (h *Handler) ListAttachments(c *gin.Context) {
+	attachments, err := h.attachmentUseCase.ListAttachments(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.attachmentError(c, "list attachments", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attachments": attachmentsJSON(attachments)})
+}
+
+func (h *Handler) AttachFile(c *gin.Context) {
+	var req struct {
+		FileID string `json:"file_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	attachment, err := h.attachmentUseCase.AttachFile(c.Request.Context(), c.Param("id"), req.FileID)
+	if err != nil {
+		h.attachmentError(c, "attach file", err)
+		return
+	}
+	c.JSON(http.StatusCreated, attachmentJSON(attachment))
+}
+
+func (h *Handler) ReorderAttachments(c *gin.Context) {
+	var req struct {
+		FileIDs []string `json:"file_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	attachments, err := h.attachmentUseCase.ReorderAttachments(c.Request.Context(), c.Param("id"), req.FileIDs)
+	if err != nil {
+		h.attachmentError(c, "reorder attachments", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attachments": attachmentsJSON(attachments)})
+}
+
+func (h *Handler) DetachFile(c *gin.Context) {
+	if err := h.attachmentUseCase.DetachFile(c.Request.Context(), c.Param("id"), c.Param("fileId")); err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		h.attachmentError(c, "detach file", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) attachmentError(c *gin.Context, action string, err error) {
+	switch err {
+	case repository.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Todo item not found"})
+	case usecase.ErrFileNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	case usecase.ErrAlreadyAttached:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case usecase.ErrUploadUnconfirmed:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case usecase.ErrFileQuarantined:
+		c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+	case usecase.ErrFileInfected:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case usecase.ErrInvalidAttachmentOrder:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Failed to "+action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+	}
+}
+
+func attachmentJSON(a *domain.TodoAttachment) gin.H {
+	return gin.H{
+		"file_id":     a.FileID,
+		"position":    a.Position,
+		"attached_at": a.CreatedAt,
+	}
+}
+
+func attachmentsJSON(attachments []*domain.TodoAttachment) []gin.H {
+	response := make([]gin.H, len(attachments))
+	for i, a := range attachments {
+		response[i] = attachmentJSON(a)
+	}
+	return response
+}
+
+// maxUploadOverhead is what a multipart request may carry on top of the file
+// itself: boundaries, part headers and small form fields.
+const maxUploadOverhead = 1 << 20
+
+// UploaderHeader names who is uploading a file. It is set by the gateway in
+// front of the API and recorded with the file's metadata.
+const UploaderHeader = "X-User-ID"
+
+// UploadFile streams the "file" part of a multipart request straight to the
+// file use case instead of letting the form parser spool it first.
 func (h *Handler) UploadFile(c *gin.Context) {
-	file, header, err := c.Request.FormFile("file")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.fileUseCase.MaxUploadSize()+maxUploadOverhead)
+	part, err := filePart(c.Request, "file")
 	if err != nil {
 		h.logger.Error("Failed to get file from request", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
 		return
 	}
-	defer file.Close()
-	fileID, err := h.fileUseCase.UploadFile(c.Request.Context(), file, header.Filename)
+	defer part.Close()
+	ctx := usecase.ContextWithUploader(c.Request.Context(), c.GetHeader(UploaderHeader))
+	file, err := h.fileUseCase.StoreFile(ctx, part, part.FileName())
 	if err != nil {
-		h.logger.Error("Failed to upload file", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, usecase.ErrInvalidFileType):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		case errors.Is(err, usecase.ErrFileTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		case errors.As(err, &tooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+		case errors.Is(err, usecase.ErrFileInfected):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to upload file", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+		}
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"file_id": fileID})
+	resp := gin.H{"file_id": file.ID, "sha256": file.SHA256}
+	if file.ScanStatus != "" {
+		resp["scan_status"] = file.ScanStatus
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// filePart returns the first part of r's multipart body named field,
+// skipping any before it.
+func filePart(r *http.Request, field string) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
 }
 
+// DownloadFile serves the file under its original name. http.ServeContent
+// answers Range, If-Range and conditional requests from the ETag and
+// modification time storage reports.
 func (h *Handler) DownloadFile(c *gin.Context) {
-	id := c.Param("id")
-	reader, err := h.fileUseCase.DownloadFile(c.Request.Context(), id)
+	file, err := h.fileUseCase.OpenFile(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.fileError(c, "download file", err)
+		return
+	}
+	defer file.Close()
+
+	header := c.Writer.Header()
+	if file.ContentType != "" {
+		header.Set("Content-Type", file.ContentType)
+	}
+	if etag := file.ETag(); etag != "" {
+		header.Set("ETag", etag)
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+	http.ServeContent(c.Writer, c.Request, file.Filename, file.ModTime(), file)
+}
+
+// DownloadThumbnail serves one of an image's thumbnails inline, for pages to
+// embed.
+func (h *Handler) DownloadThumbnail(c *gin.Context) {
+	size, err := strconv.Atoi(c.Param("size"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	file, err := h.fileUseCase.OpenThumbnail(c.Request.Context(), c.Param("id"), size)
+	if err != nil {
+		h.fileError(c, "download thumbnail", err)
+		return
+	}
+	defer file.Close()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", file.ContentType)
+	if etag := file.ETag(); etag != "" {
+		header.Set("ETag", etag)
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": file.Filename}))
+	http.ServeContent(c.Writer, c.Request, file.Filename, file.ModTime(), file)
+}
+
+func (h *Handler) ListFiles(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	var filter domain.FileFilter
+	if v := c.Query("uploaded_by"); v != "" {
+		filter.UploadedBy = &v
+	}
+	if v := c.Query("content_type"); v != "" {
+		filter.ContentType = &v
+	}
+
+	files, total, err := h.fileUseCase.ListFiles(c.Request.Context(), filter, limit, offset)
+	if err != nil {
+		h.logger.Error("Failed to list files", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list files"})
+		return
+	}
+	thumbs, err := h.fileUseCase.Thumbnails(c.Request.Context(), files)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list files"})
+		return
+	}
+
+	response := make([]gin.H, len(files))
+	for i, file := range files {
+		response[i] = fileJSON(file, thumbs[file.FileID])
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"files": response,
+		"total": total,
+	})
+}
+
+func (h *Handler) GetFile(c *gin.Context) {
+	file, err := h.fileUseCase.GetFile(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == usecase.ErrFileNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		h.logger.Error("Failed to get file", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file"})
+		return
+	}
+	thumbs, err := h.fileUseCase.Thumbnails(c.Request.Context(), []*domain.File{file})
 	if err != nil {
-		h.logger.Error("Failed to download file", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download file"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file"})
 		return
 	}
-	defer reader.Close()
-	io.Copy(c.Writer, reader)
+	c.JSON(http.StatusOK, fileJSON(file, thumbs[file.FileID]))
+}
+
+func fileJSON(file *domain.File, thumbs []*domain.FileThumbnail) gin.H {
+	thumbnails := make([]gin.H, len(thumbs))
+	for i, t := range thumbs {
+		thumbnails[i] = gin.H{
+			"size":         t.Size,
+			"width":        t.Width,
+			"height":       t.Height,
+			"content_type": t.ContentType,
+			"url":          usecase.ThumbnailPath(file.FileID, t.Size),
+		}
+	}
+	return gin.H{
+		"id":           file.FileID,
+		"filename":     file.Filename,
+		"content_type": file.ContentType,
+		"size":         file.Size,
+		"sha256":       file.SHA256,
+		"uploaded_by":  file.UploadedBy,
+		"created_at":   file.CreatedAt,
+		"scan_status":  file.ScanStatus,
+		"thumbnails":   thumbnails,
+	}
+}
+
+// RequestUpload returns a presigned URL the client uploads the file to
+// directly, after which it calls ConfirmUpload.
+func (h *Handler) RequestUpload(c *gin.Context) {
+	var req struct {
+		Filename string `json:"filename" binding:"required"`
+		Size     int64  `json:"size" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	fileID, url, err := h.fileUseCase.RequestUpload(c.Request.Context(), req.Filename, req.Size)
+	if err != nil {
+		h.fileError(c, "request upload", err)
+		return
+	}
+	response := presignedJSON(url)
+	response["file_id"] = fileID
+	c.JSON(http.StatusCreated, response)
+}
+
+func (h *Handler) ConfirmUpload(c *gin.Context) {
+	var req struct {
+		Filename string `json:"filename" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	ctx := usecase.ContextWithUploader(c.Request.Context(), c.GetHeader(UploaderHeader))
+	file, err := h.fileUseCase.ConfirmUpload(ctx, c.Param("id"), req.Filename)
+	if err != nil {
+		h.fileError(c, "confirm upload", err)
+		return
+	}
+	// Thumbnails are generated in the background; GetFile lists them.
+	c.JSON(http.StatusCreated, fileJSON(file, nil))
+}
+
+// DownloadURL returns a presigned URL the client downloads the file from
+// directly.
+func (h *Handler) DownloadURL(c *gin.Context) {
+	url, err := h.fileUseCase.DownloadURL(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.fileError(c, "presign download", err)
+		return
+	}
+	c.JSON(http.StatusOK, presignedJSON(url))
+}
+
+func (h *Handler) fileError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrFileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	case errors.Is(err, usecase.ErrInvalidFileType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrInvalidFileSize):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrPresignUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrFileInUse), errors.Is(err, usecase.ErrUploadUnconfirmed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrFileQuarantined):
+		c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrFileInfected):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Failed to "+action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+	}
+}
+
+// scanError answers for a file refused over its malware scan, or because its
+// upload was never confirmed, and reports whether it did.
+func scanError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, usecase.ErrUploadUnconfirmed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrFileQuarantined):
+		c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrFileInfected):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+func presignedJSON(url *repository.PresignedURL) gin.H {
+	return gin.H{
+		"url":        url.URL,
+		"method":     url.Method,
+		"headers":    url.Headers,
+		"expires_at": url.ExpiresAt,
+	}
 }
 
 func (h *Handler) DeleteFile(c *gin.Context) {
 	id := c.Param("id")
 	if err := h.fileUseCase.DeleteFile(c.Request.Context(), id); err != nil {
-		h.logger.Error("Failed to delete file", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		h.fileError(c, "delete file", err)
 		return
 	}
 	c.Status(http.StatusNoContent)
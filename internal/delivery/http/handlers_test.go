@@ -3,13 +3,17 @@ package http
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/repository/scantest"
 	"github.com/delaram/GoTastic/internal/usecase"
 	"github.com/delaram/GoTastic/pkg/logger"
 	"github.com/gin-gonic/gin"
@@ -18,7 +22,7 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
-func setupTestHandler() (*Handler, *usecase.MockTodoRepository, *usecase.MockFileRepository, *usecase.MockCacheRepository, *usecase.MockStreamPublisher) {
+func setupTestHandler() (*Handler, *usecase.MockTodoRepository, *usecase.MockFileRepository, *usecase.MockCacheRepository, *usecase.MockOutboxRepository) {
 	log := logger.New(logger.Config{
 		Level:      "info",
 		TimeFormat: time.RFC3339,
@@ -27,18 +31,34 @@ func setupTestHandler() (*Handler, *usecase.MockTodoRepository, *usecase.MockFil
 	mockTodoRepo := new(usecase.MockTodoRepository)
 	mockFileRepo := new(usecase.MockFileRepository)
 	mockCacheRepo := new(usecase.MockCacheRepository)
-	mockStreamPublisher := new(usecase.MockStreamPublisher)
+	mockOutboxRepo := new(usecase.MockOutboxRepository)
 
-	todoUseCase := usecase.NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
-	fileUseCase := usecase.NewFileUseCase(log, mockFileRepo)
+	todoUseCase := usecase.NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
+	fileUseCase := usecase.NewFileUseCase(log, mockFileRepo, new(usecase.MockFileMetadataRepository))
+	attachmentUseCase := usecase.NewAttachmentUseCase(log, mockTodoRepo, mockFileRepo, new(usecase.MockAttachmentRepository))
 
-	handler := NewHandler(log, todoUseCase, fileUseCase)
-	return handler, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher
+	handler := NewHandler(log, todoUseCase, fileUseCase, attachmentUseCase)
+	return handler, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo
 }
 
-func TestHandleFileUpload(t *testing.T) {
-	handler, _, mockFileRepo, _, _ := setupTestHandler()
+func setupFileTestHandler() (*Handler, *usecase.MockFileRepository, *usecase.MockFileMetadataRepository) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(usecase.MockFileRepository)
+	mockMetadata := new(usecase.MockFileMetadataRepository)
+
+	todoUseCase := usecase.NewTodoUseCase(log, new(usecase.MockTodoRepository), mockFileRepo, new(usecase.MockCacheRepository), new(usecase.MockOutboxRepository))
+	fileUseCase := usecase.NewFileUseCase(log, mockFileRepo, mockMetadata)
+	attachmentUseCase := usecase.NewAttachmentUseCase(log, new(usecase.MockTodoRepository), mockFileRepo, new(usecase.MockAttachmentRepository))
+
+	return NewHandler(log, todoUseCase, fileUseCase, attachmentUseCase), mockFileRepo, mockMetadata
+}
 
+func TestHandleFileUpload(t *testing.T) {
+	handler, mockFileRepo, mockMetadata := setupFileTestHandler()
 
 	body := new(bytes.Buffer)
 	writer := multipart.NewWriter(body)
@@ -47,29 +67,32 @@ func TestHandleFileUpload(t *testing.T) {
 	part.Write([]byte("test content"))
 	writer.Close()
 
-
 	req := httptest.NewRequest("POST", "/upload", body)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set(UploaderHeader, "alice")
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	c.Request = req
 
-
 	mockFileRepo.On("Upload", mock.Anything, mock.Anything, "test.txt").Return("test-file-id", nil)
-
+	mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+	mockMetadata.On("Create", mock.Anything, mock.MatchedBy(func(f *domain.File) bool {
+		return f.FileID == "test-file-id" && f.Filename == "test.txt" && *f.UploadedBy == "alice"
+	})).Return(nil)
 
 	handler.UploadFile(c)
 
-
 	assert.Equal(t, http.StatusCreated, w.Code)
 	var response map[string]string
 	err = json.NewDecoder(w.Body).Decode(&response)
 	assert.NoError(t, err)
 	assert.Equal(t, "test-file-id", response["file_id"])
+	mockMetadata.AssertExpectations(t)
 }
 
 func TestHandleCreateTodo(t *testing.T) {
-	handler, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher := setupTestHandler()
+	handler, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo := setupTestHandler()
+	mockTx := new(usecase.MockTx)
 
 	reqBody := map[string]interface{}{
 		"description": "Test todo",
@@ -82,127 +105,129 @@ func TestHandleCreateTodo(t *testing.T) {
 	c, _ := gin.CreateTestContext(w)
 	c.Request = req
 
-
 	mockFileRepo.On("Exists", mock.Anything, "test-file-id").Return(true, nil)
-	mockTodoRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todos").Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, mock.AnythingOfType("*domain.TodoItem")).Return(nil)
-
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("CreateTx", mock.Anything, mockTx, mock.AnythingOfType("*domain.TodoItem")).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.AnythingOfType("repository.OutboxMessage")).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
 
 	handler.CreateTodoItem(c)
 
-
 	assert.Equal(t, http.StatusCreated, w.Code)
-	var response domain.TodoItem
+	var response map[string]interface{}
 	err := json.NewDecoder(w.Body).Decode(&response)
 	assert.NoError(t, err)
-	assert.Equal(t, reqBody["description"], response.Description)
-	assert.Equal(t, reqBody["file_id"], response.FileID)
+	assert.Equal(t, reqBody["description"], response["description"])
+	assert.Equal(t, reqBody["file_id"], response["file_id"])
+	assert.Equal(t, string(domain.TodoStatusOpen), response["status"])
 }
 
 func TestHandleGetTodo(t *testing.T) {
-	handler, mockTodoRepo, _, mockCacheRepo, mockStreamPublisher := setupTestHandler()
-
+	handler, mockTodoRepo, _, mockCacheRepo, _ := setupTestHandler()
 
 	id := uuid.New().String()
+	dueDate := time.Now().Add(24 * time.Hour)
+	fileID := "test-file-id"
 	expectedTodo := &domain.TodoItem{
-		ID:          uuid.MustParse(id),
+		ID:          1,
+		UUID:        id,
 		Description: "Test todo",
-		DueDate:     time.Now().Add(24 * time.Hour),
-		FileID:      "test-file-id",
+		DueDate:     &dueDate,
+		FileID:      &fileID,
 	}
 
-
 	req := httptest.NewRequest("GET", "/todo/"+id, nil)
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	c.Request = req
 	c.Params = []gin.Param{{Key: "id", Value: id}}
 
-
-	cacheKey := "todo:" + id
+	cacheKey := "todo:v1:json:" + id
 	mockCacheRepo.On("Get", mock.Anything, cacheKey).Return(nil, nil)
 	mockTodoRepo.On("GetByID", mock.Anything, id).Return(expectedTodo, nil)
-	mockCacheRepo.On("Set", mock.Anything, cacheKey, expectedTodo, time.Hour).Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, expectedTodo).Return(nil)
-
+	mockCacheRepo.On("Set", mock.Anything, cacheKey, mock.Anything, time.Hour).Return(nil)
 
 	handler.GetTodoItem(c)
 
-
 	assert.Equal(t, http.StatusOK, w.Code)
 	var response domain.TodoItem
 	err := json.NewDecoder(w.Body).Decode(&response)
 	assert.NoError(t, err)
-	assert.Equal(t, expectedTodo.ID, response.ID)
+	assert.Equal(t, expectedTodo.UUID, response.UUID)
 	assert.Equal(t, expectedTodo.Description, response.Description)
 	assert.Equal(t, expectedTodo.FileID, response.FileID)
 }
 
 func TestHandleListTodos(t *testing.T) {
-	handler, mockTodoRepo, _, mockCacheRepo, mockStreamPublisher := setupTestHandler()
-
+	handler, mockTodoRepo, _, mockCacheRepo, _ := setupTestHandler()
 
+	due1 := time.Now().Add(24 * time.Hour)
+	due2 := time.Now().Add(48 * time.Hour)
+	file1 := "file-1"
+	file2 := "file-2"
 	expectedTodos := []*domain.TodoItem{
 		{
-			ID:          uuid.New(),
+			ID:          1,
+			UUID:        uuid.NewString(),
 			Description: "Todo 1",
-			DueDate:     time.Now().Add(24 * time.Hour),
-			FileID:      "file-1",
+			DueDate:     &due1,
+			FileID:      &file1,
 		},
 		{
-			ID:          uuid.New(),
+			ID:          2,
+			UUID:        uuid.NewString(),
 			Description: "Todo 2",
-			DueDate:     time.Now().Add(48 * time.Hour),
-			FileID:      "file-2",
+			DueDate:     &due2,
+			FileID:      &file2,
 		},
 	}
 
-
 	req := httptest.NewRequest("GET", "/todo", nil)
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	c.Request = req
 
-
-	mockCacheRepo.On("Get", mock.Anything, "todos").Return(nil, nil)
+	mockCacheRepo.On("Get", mock.Anything, "todos:v1:json:all").Return(nil, nil)
 	mockTodoRepo.On("List", mock.Anything).Return(expectedTodos, nil)
-	mockCacheRepo.On("Set", mock.Anything, "todos", expectedTodos, time.Hour).Return(nil)
-	mockStreamPublisher.On("PublishTodoItems", mock.Anything, mock.AnythingOfType("[]*domain.TodoItem")).Return(nil)
-
+	mockCacheRepo.On("Set", mock.Anything, "todos:v1:json:all", mock.Anything, time.Hour).Return(nil)
+	mockCacheRepo.On("Tag", mock.Anything, "todos:v1:json:all", time.Hour, []string{"todos"}).Return(nil)
 
 	handler.ListTodoItems(c)
 
-
 	assert.Equal(t, http.StatusOK, w.Code)
 	var response struct {
-		Todos []domain.TodoItem `json:"todos"`
+		Todos []map[string]interface{} `json:"todos"`
 	}
 	err := json.NewDecoder(w.Body).Decode(&response)
 	assert.NoError(t, err)
 	assert.Len(t, response.Todos, 2)
+	assert.Equal(t, string(domain.TodoStatusOpen), response.Todos[0]["status"])
 }
 
 func TestHandleUpdateTodo(t *testing.T) {
-	handler, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher := setupTestHandler()
+	handler, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo := setupTestHandler()
+	mockTx := new(usecase.MockTx)
 
 	id := uuid.New().String()
-	todo := domain.TodoItem{
-		ID:          uuid.MustParse(id),
-		Description: "Updated todo",
-		DueDate:     time.Now().Add(24 * time.Hour),
-		FileID:      "updated-file",
-	}
-
-	body, _ := json.Marshal(todo)
+	body, _ := json.Marshal(map[string]interface{}{
+		"description": "Updated todo",
+		"dueDate":     time.Now().Add(24 * time.Hour),
+		"fileId":      "updated-file",
+	})
 
 	mockFileRepo.On("Exists", mock.Anything, "updated-file").Return(true, nil)
-	mockTodoRepo.On("Update", mock.Anything, mock.MatchedBy(func(t *domain.TodoItem) bool {
-		return t.ID == todo.ID && t.Description == todo.Description
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(&domain.TodoItem{ID: 1, UUID: id, Description: "Old todo"}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateTx", mock.Anything, mockTx, mock.MatchedBy(func(t *domain.TodoItem) bool {
+		return t.UUID == id && t.Description == "Updated todo"
 	})).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todo:"+id).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todos").Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, mock.AnythingOfType("*domain.TodoItem")).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.AnythingOfType("repository.OutboxMessage")).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("Delete", mock.Anything, "todo:v1:json:"+id).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
 
 	r := gin.Default()
 	r.PUT("/todo/:id", handler.UpdateTodoItem)
@@ -215,14 +240,19 @@ func TestHandleUpdateTodo(t *testing.T) {
 }
 
 func TestHandleDeleteTodo(t *testing.T) {
-	handler, mockTodoRepo, _, mockCacheRepo, mockStreamPublisher := setupTestHandler()
+	handler, mockTodoRepo, _, mockCacheRepo, mockOutboxRepo := setupTestHandler()
+	mockTx := new(usecase.MockTx)
 
 	id := uuid.New().String()
 
-	mockTodoRepo.On("Delete", mock.Anything, id).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todo:"+id).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todos").Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, mock.AnythingOfType("*domain.TodoItem")).Return(nil)
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(&domain.TodoItem{ID: 1, UUID: id}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("DeleteTx", mock.Anything, mockTx, id).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.AnythingOfType("repository.OutboxMessage")).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("Delete", mock.Anything, "todo:v1:json:"+id).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
 
 	r := gin.Default()
 	r.DELETE("/todo/:id", handler.DeleteTodoItem)
@@ -233,3 +263,390 @@ func TestHandleDeleteTodo(t *testing.T) {
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
 }
+
+func TestHandleChangeTodoStatus(t *testing.T) {
+	handler, mockTodoRepo, _, mockCacheRepo, mockOutboxRepo := setupTestHandler()
+	mockTx := new(usecase.MockTx)
+
+	id := uuid.New().String()
+
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(&domain.TodoItem{ID: 1, UUID: id, Status: string(domain.TodoStatusOpen)}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateStatusTx", mock.Anything, mockTx, mock.AnythingOfType("*domain.TodoItem"), domain.TodoStatusOpen).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.AnythingOfType("repository.OutboxMessage")).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("Delete", mock.Anything, "todo:v1:json:"+id).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
+
+	r := gin.Default()
+	r.PATCH("/todo/:id/status", handler.ChangeTodoStatus)
+
+	req := httptest.NewRequest("PATCH", "/todo/"+id+"/status", bytes.NewBufferString(`{"status":"done"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, string(domain.TodoStatusDone), response["status"])
+	assert.NotNil(t, response["completed_at"])
+}
+
+func TestHandleChangeTodoStatusConflict(t *testing.T) {
+	handler, mockTodoRepo, _, _, _ := setupTestHandler()
+
+	id := uuid.New().String()
+
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(&domain.TodoItem{ID: 1, UUID: id, Status: string(domain.TodoStatusCancelled)}, nil)
+
+	r := gin.Default()
+	r.PATCH("/todo/:id/status", handler.ChangeTodoStatus)
+
+	req := httptest.NewRequest("PATCH", "/todo/"+id+"/status", bytes.NewBufferString(`{"status":"done"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestHandleChangeTodoStatusBadRequest(t *testing.T) {
+	handler, _, _, _, _ := setupTestHandler()
+
+	r := gin.Default()
+	r.PATCH("/todo/:id/status", handler.ChangeTodoStatus)
+
+	req := httptest.NewRequest("PATCH", "/todo/"+uuid.New().String()+"/status", bytes.NewBufferString(`{"status":"archived"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleChangeTodoStatusNotFound(t *testing.T) {
+	handler, mockTodoRepo, _, _, _ := setupTestHandler()
+
+	id := uuid.New().String()
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(nil, repository.ErrNotFound)
+
+	r := gin.Default()
+	r.PATCH("/todo/:id/status", handler.ChangeTodoStatus)
+
+	req := httptest.NewRequest("PATCH", "/todo/"+id+"/status", bytes.NewBufferString(`{"status":"done"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleFileUploadRejectsOversizedFiles(t *testing.T) {
+	handler, _, mockFileRepo, _, _ := setupTestHandler()
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "big.txt")
+	assert.NoError(t, err)
+	part.Write(bytes.Repeat([]byte("a"), usecase.MaxFileSize+1))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	handler.UploadFile(c)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	mockFileRepo.AssertNotCalled(t, "Upload", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleFileUploadRejectsUnsupportedTypes(t *testing.T) {
+	handler, _, mockFileRepo, _, _ := setupTestHandler()
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "invoice.pdf")
+	assert.NoError(t, err)
+	part.Write([]byte("MZ\x90\x00 not really a pdf"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	handler.UploadFile(c)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	mockFileRepo.AssertNotCalled(t, "Upload", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleListAndGetFiles(t *testing.T) {
+	handler, _, mockMetadata := setupFileTestHandler()
+	r := gin.New()
+	handler.RegisterRoutes(r)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	file := &domain.File{FileID: "a.pdf", Filename: "report.pdf", ContentType: "application/pdf", Size: 42, CreatedAt: created}
+	contentType := "application/pdf"
+
+	mockMetadata.On("List", mock.Anything, domain.FileFilter{ContentType: &contentType}, 10, 0).Return([]*domain.File{file}, int64(1), nil)
+	mockMetadata.On("GetByFileID", mock.Anything, "a.pdf").Return(file, nil)
+	mockMetadata.On("GetByFileID", mock.Anything, "missing.pdf").Return(nil, repository.ErrNotFound)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/?limit=10&content_type=application/pdf", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Files []map[string]any `json:"files"`
+		Total int64            `json:"total"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Equal(t, int64(1), list.Total)
+	assert.Equal(t, "report.pdf", list.Files[0]["filename"])
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/a.pdf/metadata", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got map[string]any
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.Equal(t, "a.pdf", got["id"])
+	assert.Equal(t, float64(42), got["size"])
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/missing.pdf/metadata", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleAttachments(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(usecase.MockTodoRepository)
+	mockFileRepo := new(usecase.MockFileRepository)
+	mockAttachments := new(usecase.MockAttachmentRepository)
+	attachmentUseCase := usecase.NewAttachmentUseCase(log, mockTodoRepo, mockFileRepo, mockAttachments)
+	handler := NewHandler(log, nil, nil, attachmentUseCase)
+	r := gin.New()
+	handler.RegisterRoutes(r)
+
+	id := uuid.New().String()
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(&domain.TodoItem{UUID: id}, nil)
+	mockTodoRepo.On("GetByID", mock.Anything, mock.Anything).Return(nil, repository.ErrNotFound)
+	mockFileRepo.On("Exists", mock.Anything, "b.png").Return(true, nil)
+	mockAttachments.On("Attach", mock.Anything, mock.Anything).Return(repository.ErrDuplicate)
+	mockAttachments.On("List", mock.Anything, id).Return([]*domain.TodoAttachment{
+		{TodoUUID: id, FileID: "a.pdf", Position: 0},
+		{TodoUUID: id, FileID: "b.png", Position: 1},
+	}, nil)
+	mockAttachments.On("Detach", mock.Anything, id, "c.txt").Return(repository.ErrNotFound)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/todos/"+id+"/attachments", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Attachments []map[string]any `json:"attachments"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Len(t, list.Attachments, 2)
+	assert.Equal(t, "b.png", list.Attachments[1]["file_id"])
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/todos/"+uuid.New().String()+"/attachments", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/todos/"+id+"/attachments", bytes.NewBufferString(`{"file_id":"b.png"}`)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/todos/"+id+"/attachments", bytes.NewBufferString(`{"file_ids":["b.png"]}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/todos/"+id+"/attachments/c.txt", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleDirectUpload(t *testing.T) {
+	handler, mockFileRepo, mockMetadata := setupFileTestHandler()
+	r := gin.New()
+	handler.RegisterRoutes(r)
+
+	// The mock repository cannot presign.
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/files/uploads", bytes.NewBufferString(`{"filename":"a.pdf","size":10}`)))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	mockMetadata.On("GetByFileID", mock.Anything, "id.txt").Return(nil, repository.ErrNotFound)
+	mockFileRepo.On("Exists", mock.Anything, "id.txt").Return(false, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/files/id.txt/confirm", bytes.NewBufferString(`{"filename":"notes.txt"}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/files/id.txt/confirm", bytes.NewBufferString(`{"filename":"notes.exe"}`)))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+// storedFile is file content as a repository would return it.
+type storedFile struct {
+	*bytes.Reader
+}
+
+func newStoredFile(data string) *storedFile { return &storedFile{bytes.NewReader([]byte(data))} }
+
+func (f *storedFile) ETag() string       { return `"v1"` }
+func (f *storedFile) ModTime() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+func (f *storedFile) Close() error       { return nil }
+
+func TestHandleDownloadFile(t *testing.T) {
+	handler, mockFileRepo, mockMetadata := setupFileTestHandler()
+	r := gin.New()
+	handler.RegisterRoutes(r)
+
+	mockMetadata.On("GetByFileID", mock.Anything, "a.pdf").Return(&domain.File{FileID: "a.pdf", Filename: "report.pdf", ContentType: "application/pdf"}, nil)
+	// One fresh reader per request below.
+	for i := 0; i < 3; i++ {
+		mockFileRepo.On("Open", mock.Anything, "a.pdf").Return(newStoredFile("%PDF-1.4 body"), nil).Once()
+	}
+	mockFileRepo.On("Open", mock.Anything, "missing.pdf").Return(nil, repository.ErrNotFound)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/a.pdf", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "%PDF-1.4 body", w.Body.String())
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=report.pdf`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+
+	req := httptest.NewRequest("GET", "/api/v1/files/a.pdf", nil)
+	req.Header.Set("Range", "bytes=9-")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "body", w.Body.String())
+	assert.Equal(t, "bytes 9-12/13", w.Header().Get("Content-Range"))
+
+	req = httptest.NewRequest("GET", "/api/v1/files/a.pdf", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/missing.pdf", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockFileRepo.AssertExpectations(t)
+}
+
+func TestHandleDeleteFileInUse(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(usecase.MockFileRepository)
+	mockMetadata := new(usecase.MockFileMetadataRepository)
+	mockRefs := new(usecase.MockFileReferenceRepository)
+	fileUseCase := usecase.NewFileUseCase(log, mockFileRepo, mockMetadata).WithReferences(mockRefs)
+	handler := NewHandler(log, nil, fileUseCase, nil)
+	r := gin.New()
+	handler.RegisterRoutes(r)
+
+	mockFileRepo.On("Exists", mock.Anything, "a.pdf").Return(true, nil)
+	mockFileRepo.On("Exists", mock.Anything, "missing.pdf").Return(false, nil)
+	mockMetadata.On("Release", mock.Anything, "a.pdf").Return(0, nil)
+	mockRefs.On("Referenced", mock.Anything, []string{"a.pdf"}).Return(map[string]bool{"a.pdf": true}, nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/files/a.pdf", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/files/missing.pdf", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockFileRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestHandleThumbnails(t *testing.T) {
+	handler, mockFileRepo, mockMetadata := setupFileTestHandler()
+	thumbs := new(usecase.MockThumbnailRepository)
+	handler.fileUseCase.WithThumbnails(thumbs)
+	r := gin.New()
+	handler.RegisterRoutes(r)
+	file := &domain.File{FileID: "a.png", Filename: "photo.png", ContentType: "image/png", Size: 42}
+	thumb := &domain.FileThumbnail{FileID: "a.png", Size: 128, ThumbnailID: "a-128.png", ContentType: "image/png", Width: 128, Height: 64}
+
+	mockMetadata.On("GetByFileID", mock.Anything, "a.png").Return(file, nil)
+	thumbs.On("List", mock.Anything, []string{"a.png"}).Return(map[string][]*domain.FileThumbnail{"a.png": {thumb}}, nil)
+	mockFileRepo.On("Open", mock.Anything, "a-128.png").Return(newStoredFile("png bytes"), nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/a.png/metadata", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got struct {
+		Thumbnails []map[string]any `json:"thumbnails"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	if assert.Len(t, got.Thumbnails, 1) {
+		assert.Equal(t, float64(128), got.Thumbnails[0]["size"])
+		assert.Equal(t, float64(64), got.Thumbnails[0]["height"])
+		assert.Equal(t, "/api/v1/files/a.png/thumbnails/128", got.Thumbnails[0]["url"])
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/a.png/thumbnails/128", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, "png bytes", w.Body.String())
+
+	for _, size := range []string{"512", "big"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/a.png/thumbnails/"+size, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleQuarantinedFiles(t *testing.T) {
+	handler, mockFileRepo, mockMetadata := setupFileTestHandler()
+	handler.fileUseCase.WithScanner(scantest.New())
+	r := gin.New()
+	handler.RegisterRoutes(r)
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "eicar.txt")
+	assert.NoError(t, err)
+	part.Write([]byte(scantest.EICAR))
+	writer.Close()
+	mockFileRepo.On("Upload", mock.Anything, mock.Anything, "eicar.txt").Return("eicar-id.txt", nil)
+	mockFileRepo.On("Download", mock.Anything, "eicar-id.txt").Return(io.NopCloser(strings.NewReader(scantest.EICAR)), nil)
+	mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+	mockMetadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+	mockMetadata.On("SetScanStatus", mock.Anything, "eicar-id.txt", domain.FileScanInfected, mock.Anything).Return(nil)
+
+	req := httptest.NewRequest("POST", "/api/v1/files/", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), scantest.EICARSignature)
+
+	mockMetadata.On("GetByFileID", mock.Anything, "pending.pdf").Return(&domain.File{FileID: "pending.pdf", ScanStatus: string(domain.FileScanPending)}, nil)
+	mockMetadata.On("GetByFileID", mock.Anything, "eicar-id.txt").Return(&domain.File{FileID: "eicar-id.txt", ScanStatus: string(domain.FileScanInfected)}, nil)
+	mockFileRepo.On("Open", mock.Anything, mock.Anything).Return(newStoredFile("body"), nil)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/pending.pdf", nil))
+	assert.Equal(t, http.StatusLocked, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/eicar-id.txt", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -0,0 +1,18 @@
+package domain
+
+import (
+	"time"
+
+	"git.ice.global/packages/beeorm/v4"
+)
+
+// TodoAttachment attaches a file to a todo. A todo's attachments are listed
+// in Position order; positions only need to be ordered, not contiguous.
+type TodoAttachment struct {
+	beeorm.ORM `orm:"table=TodoAttachment"`
+	ID         uint64    `orm:"pk;auto_increment"`
+	TodoUUID   string    `orm:"size(36);unique=TodoFile"`
+	FileID     string    `orm:"size(255);unique=TodoFile:2;index"`
+	Position   int       `orm:"default(0)"`
+	CreatedAt  time.Time `orm:"type(datetime);default(now())"`
+}
@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"time"
+
+	"git.ice.global/packages/beeorm/v4"
+)
+
+// File is the metadata recorded for an uploaded file. FileID is the id the
+// content is stored under in the file repository, and what TodoItem.FileID
+// refers to. Uploads of content already stored resolve to the existing File;
+// RefCount is how many uploads did. ScanStatus is empty for files stored
+// without a malware scanner, and unconfirmed for presigned uploads whose
+// content has not been checked yet.
+type File struct {
+	beeorm.ORM    `orm:"table=File"`
+	ID            uint64 `orm:"pk;auto_increment"`
+	FileID        string `orm:"size(255);unique;index"`
+	Filename      string `orm:"size(255)"`
+	ContentType   string `orm:"size(127);index"`
+	Size          int64
+	SHA256        string     `orm:"size(64);index"`
+	RefCount      int        `orm:"default(1)"`
+	UploadedBy    *string    `orm:"size(128);index"`
+	CreatedAt     time.Time  `orm:"type(datetime);default(now());index"`
+	ScanStatus    string     `orm:"size(16);index"`
+	ScanSignature *string    `orm:"size(255)"`
+	ScannedAt     *time.Time `orm:"type(datetime)"`
+}
+
+// FileScanStatus is where a file is in malware scanning. Unconfirmed, pending
+// and infected files are quarantined: they cannot be downloaded or attached.
+type FileScanStatus string
+
+const (
+	// FileScanUnconfirmed is a presigned upload the client has not confirmed:
+	// its content, if any was uploaded, has not been checked or scanned.
+	FileScanUnconfirmed FileScanStatus = "unconfirmed"
+	FileScanPending     FileScanStatus = "pending"
+	FileScanClean       FileScanStatus = "clean"
+	FileScanInfected    FileScanStatus = "infected"
+)
+
+// FileThumbnail is a downscaled copy of an image File, stored in the file
+// repository under ThumbnailID. It was fitted into a Size x Size box, which
+// it came out of as Width x Height.
+type FileThumbnail struct {
+	beeorm.ORM  `orm:"table=FileThumbnail"`
+	ID          uint64 `orm:"pk;auto_increment"`
+	FileID      string `orm:"size(255);unique=FileSize"`
+	Size        int    `orm:"unique=FileSize:2"`
+	ThumbnailID string `orm:"size(255);unique"`
+	ContentType string `orm:"size(127)"`
+	Width       int
+	Height      int
+	CreatedAt   time.Time `orm:"type(datetime);default(now())"`
+}
+
+// FileFilter narrows a file listing; nil fields match everything.
+type FileFilter struct {
+	UploadedBy    *string
+	ContentType   *string
+	ScanStatus    *string
+	CreatedBefore *time.Time
+}
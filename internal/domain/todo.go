@@ -6,24 +6,48 @@ import (
 	"git.ice.global/packages/beeorm/v4"
 )
 
-func Init(registry *beeorm.Registry) {
-	registry.RegisterEntity(&TodoItem{})
-	registry.RegisterEntity(&Outbox{})
-}
-
 type Outbox struct {
 	beeorm.ORM    `orm:"table=outbox"`
-	ID            uint64    `orm:"pk;auto_increment"`
-	AggregateType string    `orm:"size(64);index"`
-	AggregateID   string    `orm:"size(64);index"`
-	EventType     string    `orm:"size(128);index"`
-	Payload       []byte    `orm:"type(json)"`
-	Headers       *string   `orm:"type(json)"`
-	Status        string    `orm:"size(50);default('pending');index"`
-	Attempts      int       `orm:"default(0)"`
-	AvailableAt   time.Time `orm:"default(now());index"`
+	ID            uint64     `orm:"pk;auto_increment"`
+	AggregateType string     `orm:"size(64);index"`
+	AggregateID   string     `orm:"size(64);index"`
+	EventType     string     `orm:"size(128);index"`
+	Payload       []byte     `orm:"type(json)"`
+	Headers       *string    `orm:"type(json)"`
+	Status        string     `orm:"size(50);default('pending');index"`
+	Attempts      int        `orm:"default(0)"`
+	AvailableAt   time.Time  `orm:"default(now());index"`
+	CreatedAt     time.Time  `orm:"type(datetime);default(now())"`
+	LockedBy      *string    `orm:"size(128);index"`
+	LockedUntil   *time.Time `orm:"type(datetime);index"`
+	LastError     *string    `orm:"type(text)"`
+	DeadAt        *time.Time `orm:"type(datetime);index"`
+}
+
+// OutboxAttempt records one failed delivery of an outbox row, so a
+// dead-lettered event can be inspected with its full failure history.
+type OutboxAttempt struct {
+	beeorm.ORM `orm:"table=outbox_attempt"`
+	ID         uint64    `orm:"pk;auto_increment"`
+	OutboxID   uint64    `orm:"index"`
+	Attempt    int       `orm:"default(0)"`
+	Worker     string    `orm:"size(128)"`
+	Error      string    `orm:"type(text)"`
+	FailedAt   time.Time `orm:"type(datetime);default(now())"`
 }
 
+// Outbox row states. A row is claimed by moving it to processing with a lease
+// (LockedBy/LockedUntil); a lease that runs out makes the row claimable again.
+// A failed row waits for its retry at AvailableAt; once the dispatcher gives
+// up it is dead-lettered and only moves again through the admin tooling.
+const (
+	OutboxStatusPending    = "pending"
+	OutboxStatusProcessing = "processing"
+	OutboxStatusPublished  = "published"
+	OutboxStatusFailed     = "failed"
+	OutboxStatusDead       = "dead"
+)
+
 type TodoItem struct {
 	beeorm.ORM  `orm:"table=TodoItem"`
 	ID          uint64     `orm:"pk;auto_increment"`
@@ -31,15 +55,93 @@ type TodoItem struct {
 	Description string     `orm:"size(255)"`
 	DueDate     *time.Time `orm:"type(datetime);index"`
 	FileID      *string    `orm:"size(255);index"`
+	Status      string     `orm:"size(32);default('open');index"`
+	CompletedAt *time.Time `orm:"type(datetime)"`
 	CreatedAt   time.Time  `orm:"type(datetime);default(now())"`
 	UpdatedAt   time.Time  `orm:"type(datetime);default(now());on_update(now())"`
 }
 
+type TodoStatus string
+
+const (
+	TodoStatusOpen       TodoStatus = "open"
+	TodoStatusInProgress TodoStatus = "in_progress"
+	TodoStatusBlocked    TodoStatus = "blocked"
+	TodoStatusDone       TodoStatus = "done"
+	TodoStatusCancelled  TodoStatus = "cancelled"
+)
+
+var (
+	ErrInvalidStatus           = NewError("invalid todo status")
+	ErrInvalidStatusTransition = NewError("invalid todo status transition")
+)
+
+// todoTransitions lists, for every status, the statuses it may move to.
+// done and cancelled are terminal apart from being reopened.
+var todoTransitions = map[TodoStatus][]TodoStatus{
+	TodoStatusOpen:       {TodoStatusInProgress, TodoStatusBlocked, TodoStatusDone, TodoStatusCancelled},
+	TodoStatusInProgress: {TodoStatusOpen, TodoStatusBlocked, TodoStatusDone, TodoStatusCancelled},
+	TodoStatusBlocked:    {TodoStatusOpen, TodoStatusInProgress, TodoStatusCancelled},
+	TodoStatusDone:       {TodoStatusOpen},
+	TodoStatusCancelled:  {TodoStatusOpen},
+}
+
+func ParseTodoStatus(s string) (TodoStatus, error) {
+	st := TodoStatus(s)
+	if !st.IsValid() {
+		return "", ErrInvalidStatus
+	}
+	return st, nil
+}
+
+func (s TodoStatus) IsValid() bool {
+	_, ok := todoTransitions[s]
+	return ok
+}
+
+func (s TodoStatus) CanTransitionTo(to TodoStatus) bool {
+	for _, next := range todoTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentStatus returns the item's status, treating rows written before the
+// status column existed as open.
+func (t *TodoItem) CurrentStatus() TodoStatus {
+	if t.Status == "" {
+		return TodoStatusOpen
+	}
+	return TodoStatus(t.Status)
+}
+
+// TransitionTo moves the item to the given status, stamping CompletedAt when it
+// becomes done and clearing it when it leaves done.
+func (t *TodoItem) TransitionTo(to TodoStatus, now time.Time) error {
+	if !to.IsValid() {
+		return ErrInvalidStatus
+	}
+	if !t.CurrentStatus().CanTransitionTo(to) {
+		return ErrInvalidStatusTransition
+	}
+	t.Status = string(to)
+	if to == TodoStatusDone {
+		t.CompletedAt = &now
+	} else {
+		t.CompletedAt = nil
+	}
+	t.UpdatedAt = now
+	return nil
+}
+
 type TodoFilter struct {
 	Q       *string
 	DueFrom *time.Time
 	DueTo   *time.Time
 	HasFile *bool
+	Status  []TodoStatus
 }
 
 type SortField int
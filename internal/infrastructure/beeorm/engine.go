@@ -22,6 +22,10 @@ func NewEngine(cfg *config.Config) (*beeorm.Engine, error) {
 	// Entities used anywhere in your code must be registered
 	reg.RegisterEntity(&domain.TodoItem{})
 	reg.RegisterEntity(&domain.Outbox{}) // <-- you load/update this via BeeORM
+	reg.RegisterEntity(&domain.OutboxAttempt{})
+	reg.RegisterEntity(&domain.File{})
+	reg.RegisterEntity(&domain.TodoAttachment{})
+	reg.RegisterEntity(&domain.FileThumbnail{})
 
 	reg.SetDefaultEncoding("utf8mb4")
 	reg.SetDefaultCollate("utf8mb4_general_ci")
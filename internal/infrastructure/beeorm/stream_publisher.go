@@ -2,55 +2,62 @@ package beeinfra
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
 	beeorm "git.ice.global/packages/beeorm/v4"
+	"github.com/redis/go-redis/v9"
 
 	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
 )
 
 type StreamPublisher struct {
 	engine *beeorm.Engine
 	stream string
+	source string
+
+	// client XADDs with the retention limit when one is set; BeeORM's
+	// pipeline XAdd cannot pass MAXLEN or MINID.
+	client    redis.Cmdable
+	retention repository.StreamRetention
 }
 
 func NewStreamPublisher(engine *beeorm.Engine, stream string) *StreamPublisher {
-	return &StreamPublisher{engine: engine, stream: stream}
+	return &StreamPublisher{engine: engine, stream: stream, source: repository.DefaultEventSource}
 }
 
-func (p *StreamPublisher) PublishTodoItem(ctx context.Context, todo *domain.TodoItem) (err error) {
-	// NOTE: FileID is optional; if you use *string in domain, handle nil accordingly.
-	var dueStr string
-	if todo.DueDate != nil {
-		dueStr = todo.DueDate.UTC().Format(time.RFC3339Nano)
-	}
-	var fileStr string
-	if todo.FileID != nil {
-		fileStr = *todo.FileID
+// WithRetention publishes through client with XADD ... MAXLEN ~ (or MINID ~
+// when only an age limit is set), so the stream is trimmed in the same round
+// trip. XADD takes one of the two, so with both limits set the age limit is
+// left to the periodic trim job, which also catches streams that go quiet.
+func (p *StreamPublisher) WithRetention(client redis.Cmdable, policy repository.StreamRetention) *StreamPublisher {
+	if policy.Enabled() {
+		p.client = client
+		p.retention = policy
 	}
+	return p
+}
 
-	payload := struct {
-		ID          string `json:"id"`
-		Description string `json:"description"`
-		DueDate     string `json:"due_date,omitempty"`
-		FileID      string `json:"file_id,omitempty"`
-		CreatedAt   string `json:"created_at"`
-		UpdatedAt   string `json:"updated_at"`
-	}{
-		ID:          todo.UUID, // public id
-		Description: todo.Description,
-		DueDate:     dueStr,
-		FileID:      fileStr,
-		CreatedAt:   todo.CreatedAt.UTC().Format(time.RFC3339Nano),
-		UpdatedAt:   todo.UpdatedAt.UTC().Format(time.RFC3339Nano),
+// PublishTodoItem publishes the todo's current state as a todo.snapshot event
+// with a freshly generated id.
+func (p *StreamPublisher) PublishTodoItem(ctx context.Context, todo *domain.TodoItem) (err error) {
+	fields, err := p.todoEvent(repository.SnapshotMeta(), todo)
+	if err != nil {
+		return err
 	}
-	b, err := json.Marshal(payload)
+	return p.xaddOne(ctx, fields)
+}
+
+// PublishTodoEvent writes a todo change as a CloudEvent whose id, type, time
+// and extensions come from meta, so consumers can dedupe on the outbox ID and
+// route on the type.
+func (p *StreamPublisher) PublishTodoEvent(ctx context.Context, meta repository.EventMeta, todo *domain.TodoItem) error {
+	fields, err := p.todoEvent(meta, todo)
 	if err != nil {
 		return err
 	}
-	return p.xaddOne(b)
+	return p.xaddOne(ctx, fields)
 }
 
 // Optional bulk path (your use-case uses a type assertion for this)
@@ -58,6 +65,21 @@ func (p *StreamPublisher) PublishTodoItems(ctx context.Context, todos []*domain.
 	if len(todos) == 0 {
 		return nil
 	}
+	if p.client != nil {
+		pipe := p.client.Pipeline()
+		for _, todo := range todos {
+			fields, mErr := p.todoEvent(repository.SnapshotMeta(), todo)
+			if mErr != nil {
+				return mErr
+			}
+			pipe.XAdd(ctx, p.xaddArgs(fields))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("redis pipeline xadd failed: %w", err)
+		}
+		return nil
+	}
+
 	defer func() {
 		if rec := recover(); rec != nil {
 			err = fmt.Errorf("redis pipeline xadd failed: %v", rec)
@@ -66,36 +88,11 @@ func (p *StreamPublisher) PublishTodoItems(ctx context.Context, todos []*domain.
 
 	pipe := p.engine.GetRedis().PipeLine() // uses the default registered Redis pool
 	for _, todo := range todos {
-		var dueStr string
-		if todo.DueDate != nil {
-			dueStr = todo.DueDate.UTC().Format(time.RFC3339Nano)
-		}
-		var fileStr string
-		if todo.FileID != nil {
-			fileStr = *todo.FileID
-		}
-
-		payload := struct {
-			ID          string `json:"id"`
-			Description string `json:"description"`
-			DueDate     string `json:"due_date,omitempty"`
-			FileID      string `json:"file_id,omitempty"`
-			CreatedAt   string `json:"created_at"`
-			UpdatedAt   string `json:"updated_at"`
-		}{
-			ID:          todo.UUID,
-			Description: todo.Description,
-			DueDate:     dueStr,
-			FileID:      fileStr,
-			CreatedAt:   todo.CreatedAt.UTC().Format(time.RFC3339Nano),
-			UpdatedAt:   todo.UpdatedAt.UTC().Format(time.RFC3339Nano),
-		}
-
-		b, mErr := json.Marshal(payload)
+		fields, mErr := p.todoEvent(repository.SnapshotMeta(), todo)
 		if mErr != nil {
 			return mErr
 		}
-		_ = pipe.XAdd(p.stream, []string{"data", string(b)})
+		_ = pipe.XAdd(p.stream, fields)
 	}
 	pipe.Exec() // panics on error under BeeORM
 	return nil
@@ -103,14 +100,46 @@ func (p *StreamPublisher) PublishTodoItems(ctx context.Context, todos []*domain.
 
 // --- internals ---------------------------------------------------------------
 
-func (p *StreamPublisher) xaddOne(b []byte) (err error) {
+// todoEvent builds the CloudEvent for todo and returns the XADD field list
+// for it.
+func (p *StreamPublisher) todoEvent(meta repository.EventMeta, todo *domain.TodoItem) ([]string, error) {
+	ev, err := repository.NewTodoEvent(meta, p.source, todo)
+	if err != nil {
+		return nil, err
+	}
+	_, body, err := repository.EncodeMessage(ev)
+	if err != nil {
+		return nil, err
+	}
+	return []string{repository.StreamFieldType, ev.Type, repository.StreamFieldEvent, string(body)}, nil
+}
+
+// xaddArgs is the XADD for values carrying the retention limit, the same
+// way RedisStreamsBroker applies it.
+func (p *StreamPublisher) xaddArgs(values []string) *redis.XAddArgs {
+	fields := make([]interface{}, len(values))
+	for i, v := range values {
+		fields[i] = v
+	}
+	args := &redis.XAddArgs{Stream: p.stream, Values: fields}
+	p.retention.Limit(args, time.Now())
+	return args
+}
+
+func (p *StreamPublisher) xaddOne(ctx context.Context, values []string) (err error) {
+	if p.client != nil {
+		if err := p.client.XAdd(ctx, p.xaddArgs(values)).Err(); err != nil {
+			return fmt.Errorf("redis xadd failed: %w", err)
+		}
+		return nil
+	}
 	defer func() {
 		if rec := recover(); rec != nil {
 			err = fmt.Errorf("redis xadd failed: %v", rec)
 		}
 	}()
 	pipe := p.engine.GetRedis().PipeLine()
-	cmd := pipe.XAdd(p.stream, []string{"data", string(b)})
+	cmd := pipe.XAdd(p.stream, values)
 	pipe.Exec()      // will panic on failure; recover above turns it into error
 	_ = cmd.Result() // touch the result; not strictly required
 	return nil
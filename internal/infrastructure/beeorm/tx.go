@@ -2,14 +2,122 @@ package beeinfra
 
 import (
 	"context"
+	"fmt"
 
 	beeorm "git.ice.global/packages/beeorm/v4"
+
+	"github.com/delaram/GoTastic/internal/repository"
+)
+
+var (
+	ErrTxDone        = repository.NewError("transaction already committed or rolled back")
+	ErrUnsupportedTx = repository.NewError("transaction was not started by a BeeORM repository")
 )
 
-type BeeTx struct{ db *beeorm.DB }
+// BeeTx is the unit of work behind repository.Tx. Repositories stage their
+// entity writes on the shared flusher and may run raw SQL through DB(); Commit
+// flushes everything inside the same MySQL transaction, so either all writes
+// land or none do.
+type BeeTx struct {
+	db      *beeorm.DB
+	flusher beeorm.Flusher
+	done    bool
+}
+
+// BeginTx opens a MySQL transaction on the engine's default pool.
+func BeginTx(engine *beeorm.Engine) *BeeTx {
+	db := engine.GetMysql()
+	db.Begin()
+	return &BeeTx{db: db, flusher: engine.NewFlusher()}
+}
+
+// FromTx unwraps a repository.Tx handed to a repository method.
+func FromTx(tx repository.Tx) (*BeeTx, error) {
+	bt, ok := tx.(*BeeTx)
+	if !ok || bt == nil {
+		return nil, ErrUnsupportedTx
+	}
+	if bt.done {
+		return nil, ErrTxDone
+	}
+	return bt, nil
+}
+
+func (t *BeeTx) Track(entity ...beeorm.Entity) {
+	t.flusher.Track(entity...)
+}
+
+func (t *BeeTx) Delete(entity ...beeorm.Entity) {
+	t.flusher.Delete(entity...)
+}
+
+// DB exposes the transactional connection for statements the flusher cannot
+// express (locking reads, bulk updates).
+func (t *BeeTx) DB() *beeorm.DB {
+	return t.db
+}
 
-func newBeeTx(db *beeorm.DB) *BeeTx { return &BeeTx{db: db} }
+// Flush writes what has been staged so far, still inside the transaction,
+// for statements that must see it, e.g. rows referencing a staged insert.
+func (t *BeeTx) Flush() (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("flush failed: %v", rec)
+		}
+	}()
+	return t.flusher.FlushWithCheck()
+}
 
 // repository.Tx impl:
-func (t *BeeTx) Commit(ctx context.Context) error   { t.db.Commit(); return nil }
-func (t *BeeTx) Rollback(ctx context.Context) error { t.db.Rollback(); return nil }
+func (t *BeeTx) Commit(ctx context.Context) (err error) {
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+
+	// BeeORM reports driver failures by panicking; turn them into errors and
+	// make sure nothing staged so far survives.
+	defer func() {
+		if rec := recover(); rec != nil {
+			t.db.Rollback()
+			err = fmt.Errorf("commit failed: %v", rec)
+		}
+	}()
+
+	if err := t.flusher.FlushWithCheck(); err != nil {
+		t.db.Rollback()
+		return err
+	}
+	t.db.Commit()
+	return nil
+}
+
+// Rollback discards staged writes. It is a no-op after Commit so callers can
+// always defer it.
+func (t *BeeTx) Rollback(ctx context.Context) (err error) {
+	if t.done {
+		return nil
+	}
+	t.done = true
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("rollback failed: %v", rec)
+		}
+	}()
+
+	t.flusher.Clear()
+	t.db.Rollback()
+	return nil
+}
+
+// WithTx runs fn inside a fresh unit of work and commits it when fn succeeds.
+func WithTx(ctx context.Context, engine *beeorm.Engine, fn func(tx *BeeTx) error) error {
+	tx := BeginTx(engine)
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
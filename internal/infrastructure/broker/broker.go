@@ -0,0 +1,40 @@
+// Package broker holds the MessageBroker transports. New picks one from
+// config so callers only ever see repository.MessageBroker.
+package broker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DriverRedis  = "redis"
+	DriverNATS   = "nats"
+	DriverMemory = "memory"
+)
+
+var ErrBrokerClosed = repository.NewError("message broker closed")
+
+var timeNow = time.Now
+
+// New builds the broker selected by cfg.Driver. redisClient and retention are
+// only used by the Redis Streams driver.
+func New(cfg config.BrokerConfig, redisClient redis.Cmdable, retention repository.StreamRetention) (repository.MessageBroker, error) {
+	switch cfg.Driver {
+	case "", DriverRedis:
+		if redisClient == nil {
+			return nil, fmt.Errorf("broker driver %q needs a redis client", DriverRedis)
+		}
+		return NewRedisStreamsBroker(redisClient, retention), nil
+	case DriverNATS:
+		return NewNATSBroker(cfg.NATSURL, cfg.ConnectTimeout)
+	case DriverMemory:
+		return NewChannelBroker(), nil
+	default:
+		return nil, fmt.Errorf("unknown broker driver %q", cfg.Driver)
+	}
+}
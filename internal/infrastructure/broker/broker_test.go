@@ -0,0 +1,229 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent(id string) *repository.CloudEvent {
+	return &repository.CloudEvent{
+		SpecVersion: repository.CloudEventsSpecVersion,
+		ID:          id,
+		Source:      repository.DefaultEventSource,
+		Type:        repository.EventTodoCreated,
+	}
+}
+
+func TestChannelBrokerDeliversToSubscribers(t *testing.T) {
+	b := NewChannelBroker()
+	a := b.Subscribe("todos", 1)
+	c := b.Subscribe("todos", 1)
+	other := b.Subscribe("files", 1)
+
+	assert.NoError(t, b.Publish(context.Background(), "todos", testEvent("1")))
+
+	for _, ch := range []<-chan Delivery{a, c} {
+		d := <-ch
+		assert.Equal(t, "todos", d.Stream)
+		assert.Equal(t, repository.EventTodoCreated, d.Type)
+		assert.Contains(t, string(d.Body), `"id":"1"`)
+	}
+	assert.Len(t, other, 0)
+
+	assert.NoError(t, b.Close())
+	_, open := <-a
+	assert.False(t, open)
+	assert.Equal(t, ErrBrokerClosed, b.Publish(context.Background(), "todos", testEvent("2")))
+}
+
+func TestChannelBrokerPublishRespectsContext(t *testing.T) {
+	b := NewChannelBroker()
+	defer b.Close()
+	b.Subscribe("todos", 0) // nobody reads
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Publish(ctx, "todos", testEvent("1")), context.DeadlineExceeded)
+}
+
+func TestChannelBrokerCloseDoesNotWaitForSlowSubscribers(t *testing.T) {
+	b := NewChannelBroker()
+	b.Subscribe("todos", 0) // nobody reads
+
+	published := make(chan error)
+	go func() { published <- b.Publish(context.Background(), "todos", testEvent("1")) }()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error)
+	go func() { closed <- b.Close() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for a blocked Publish")
+	}
+	assert.Equal(t, ErrBrokerClosed, <-published)
+}
+
+// fakeNATS is just enough of a NATS server to exercise the client: it records
+// PUBs and can reject subjects with -ERR.
+type fakeNATS struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	pubs    []string
+	connect []string
+	reject  string
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &fakeNATS{ln: ln}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeNATS) url() string { return "nats://" + s.ln.Addr().String() }
+
+func (s *fakeNATS) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeNATS) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "INFO {\"server_id\":\"fake\",\"max_payload\":1048576}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			s.mu.Lock()
+			s.connect = append(s.connect, strings.TrimPrefix(line, "CONNECT "))
+			s.mu.Unlock()
+		case line == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "PUB "):
+			parts := strings.Fields(line)
+			n, _ := strconv.Atoi(parts[len(parts)-1])
+			body := make([]byte, n+2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return
+			}
+			s.mu.Lock()
+			rejected := parts[1] == s.reject
+			if !rejected {
+				s.pubs = append(s.pubs, parts[1]+" "+string(body[:n]))
+			}
+			s.mu.Unlock()
+			if rejected {
+				fmt.Fprintf(conn, "-ERR 'Permissions Violation for Publish to %s'\r\n", parts[1])
+			}
+		}
+	}
+}
+
+func (s *fakeNATS) published() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.pubs...)
+}
+
+func TestNATSBrokerPublishesToTypedSubject(t *testing.T) {
+	srv := newFakeNATS(t)
+	b, err := NewNATSBroker(srv.url(), time.Second)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.NoError(t, b.Publish(context.Background(), "todos", testEvent("1")))
+	assert.NoError(t, b.Publish(context.Background(), "todos", []byte("raw")))
+
+	pubs := srv.published()
+	assert.Len(t, pubs, 2)
+	assert.True(t, strings.HasPrefix(pubs[0], "todos.todo.created {"))
+	assert.Contains(t, pubs[0], `"id":"1"`)
+	assert.Equal(t, "todos raw", pubs[1])
+}
+
+func TestNATSBrokerSurfacesServerErrors(t *testing.T) {
+	srv := newFakeNATS(t)
+	srv.reject = "todos.todo.created"
+	b, err := NewNATSBroker(srv.url(), time.Second)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	err = b.Publish(context.Background(), "todos", testEvent("1"))
+	assert.ErrorContains(t, err, "Permissions Violation")
+
+	// The connection survives a rejected publish.
+	assert.NoError(t, b.Publish(context.Background(), "other", testEvent("2")))
+	assert.Len(t, srv.published(), 1)
+}
+
+func TestNATSBrokerSendsCredentialsFromURL(t *testing.T) {
+	srv := newFakeNATS(t)
+	b, err := NewNATSBroker("nats://alice:secret@"+srv.ln.Addr().String(), time.Second)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.NoError(t, b.Publish(context.Background(), "todos", testEvent("1")))
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	assert.Len(t, srv.connect, 1)
+	assert.Contains(t, srv.connect[0], `"user":"alice"`)
+	assert.Contains(t, srv.connect[0], `"pass":"secret"`)
+}
+
+func TestNATSBrokerRedialsAfterConnectionLoss(t *testing.T) {
+	srv := newFakeNATS(t)
+	b, err := NewNATSBroker(srv.url(), time.Second)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.NoError(t, b.Publish(context.Background(), "todos", testEvent("1")))
+	b.conn.raw.Close() // simulate the server going away
+
+	// The first publish afterwards may fail on the dead socket; the next one
+	// must go through on a fresh connection.
+	_ = b.Publish(context.Background(), "todos", testEvent("2"))
+	assert.NoError(t, b.Publish(context.Background(), "todos", testEvent("3")))
+}
+
+func TestNewSelectsDriver(t *testing.T) {
+	m, err := New(config.BrokerConfig{Driver: DriverMemory}, nil, repository.StreamRetention{})
+	assert.NoError(t, err)
+	assert.IsType(t, &ChannelBroker{}, m)
+
+	n, err := New(config.BrokerConfig{Driver: DriverNATS, NATSURL: "nats://localhost"}, nil, repository.StreamRetention{})
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:4222", n.(*NATSBroker).addr)
+
+	_, err = New(config.BrokerConfig{Driver: DriverRedis}, nil, repository.StreamRetention{})
+	assert.Error(t, err)
+
+	_, err = New(config.BrokerConfig{Driver: "kafka"}, nil, repository.StreamRetention{})
+	assert.ErrorContains(t, err, "unknown broker driver")
+}
@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/delaram/GoTastic/internal/repository"
+)
+
+// Delivery is one message as seen by a ChannelBroker subscriber.
+type Delivery struct {
+	Stream  string
+	Type    string
+	Body    []byte
+	Message interface{}
+}
+
+// ChannelBroker is an in-process MessageBroker for tests and local runs.
+// Publish hands every message to each subscriber of its stream and blocks
+// until they have taken it (or ctx ends, or the broker is closed), so nothing
+// is silently dropped.
+type ChannelBroker struct {
+	mu     sync.RWMutex
+	subs   map[string][]chan Delivery
+	closed bool
+	// done is closed first by Close, so publishers blocked on a slow
+	// subscriber give up their read lock instead of holding Close up.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewChannelBroker() *ChannelBroker {
+	return &ChannelBroker{subs: make(map[string][]chan Delivery), done: make(chan struct{})}
+}
+
+// Subscribe returns a channel receiving everything published to stream from
+// now on. The channel is closed by Close.
+func (b *ChannelBroker) Subscribe(stream string, buffer int) <-chan Delivery {
+	ch := make(chan Delivery, buffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subs[stream] = append(b.subs[stream], ch)
+	return ch
+}
+
+func (b *ChannelBroker) Publish(ctx context.Context, stream string, message interface{}) error {
+	eventType, body, err := repository.EncodeMessage(message)
+	if err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	d := Delivery{Stream: stream, Type: eventType, Body: body, Message: message}
+	for _, ch := range b.subs[stream] {
+		select {
+		case ch <- d:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.done:
+			return ErrBrokerClosed
+		}
+	}
+	return nil
+}
+
+func (b *ChannelBroker) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, chans := range b.subs {
+		for _, ch := range chans {
+			close(ch)
+		}
+	}
+	b.subs = nil
+	return nil
+}
@@ -0,0 +1,296 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+)
+
+// NATSBroker speaks the NATS client protocol directly over TCP. Every Publish
+// is followed by a PING and waits for the matching PONG, so a nil error means
+// the server has accepted the message. Connections are dialled lazily and
+// re-dialled after any failure.
+//
+// Messages go to the subject "<stream>.<event type>" (just "<stream>" when the
+// message has no type), so subscribers can filter with wildcards such as
+// "todos.todo.>".
+type NATSBroker struct {
+	addr    string
+	user    string
+	pass    string
+	token   string
+	timeout time.Duration
+
+	mu   sync.Mutex // one publish round trip at a time
+	conn *natsConn
+}
+
+func NewNATSBroker(rawURL string, timeout time.Duration) (*NATSBroker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse nats url: %w", err)
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("unsupported nats url scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	b := &NATSBroker{addr: host, timeout: timeout}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			b.user, b.pass = u.User.Username(), pass
+		} else {
+			b.token = u.User.Username()
+		}
+	}
+	return b, nil
+}
+
+func (b *NATSBroker) Publish(ctx context.Context, stream string, message interface{}) error {
+	eventType, body, err := repository.EncodeMessage(message)
+	if err != nil {
+		return err
+	}
+	subject := stream
+	if eventType != "" {
+		subject = stream + "." + eventType
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("invalid nats subject %q", subject)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		conn, err := b.dial(ctx)
+		if err != nil {
+			return err
+		}
+		b.conn = conn
+	}
+
+	if err := b.conn.publish(ctx, subject, body, b.timeout); err != nil {
+		var serverErr *natsServerError
+		if !errors.As(err, &serverErr) {
+			// The connection is in an unknown state; start over next time.
+			b.conn.close()
+			b.conn = nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *NATSBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.close()
+	b.conn = nil
+	return err
+}
+
+func (b *NATSBroker) dial(ctx context.Context) (*natsConn, error) {
+	dctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	var d net.Dialer
+	raw, err := d.DialContext(dctx, "tcp", b.addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial nats %s: %w", b.addr, err)
+	}
+	if deadline, ok := dctx.Deadline(); ok {
+		_ = raw.SetDeadline(deadline)
+	}
+
+	c := &natsConn{
+		raw:   raw,
+		r:     bufio.NewReader(raw),
+		w:     bufio.NewWriter(raw),
+		pongs: make(chan error, 1),
+		dead:  make(chan struct{}),
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("read nats INFO: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		raw.Close()
+		return nil, fmt.Errorf("unexpected nats greeting %q", line)
+	}
+
+	connect := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "gotastic",
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 1,
+	}
+	if b.user != "" {
+		connect["user"] = b.user
+		connect["pass"] = b.pass
+	}
+	if b.token != "" {
+		connect["auth_token"] = b.token
+	}
+	opts, err := json.Marshal(connect)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(c.w, "CONNECT %s\r\nPING\r\n", opts); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	// The handshake is done when the server answers our PING; an auth
+	// failure shows up as -ERR instead.
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("nats handshake: %w", err)
+		}
+		switch {
+		case line == "PONG":
+			_ = raw.SetDeadline(time.Time{})
+			go c.readLoop()
+			return c, nil
+		case strings.HasPrefix(line, "-ERR"):
+			raw.Close()
+			return nil, &natsServerError{msg: serverErrText(line)}
+		}
+	}
+}
+
+type natsServerError struct{ msg string }
+
+func (e *natsServerError) Error() string { return "nats: " + e.msg }
+
+// natsConn is one established connection. The read loop answers server PINGs
+// and reports, for every PONG, whether an -ERR arrived since the previous one.
+type natsConn struct {
+	raw net.Conn
+	r   *bufio.Reader
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	pongs chan error
+	dead  chan struct{}
+	err   error
+	once  sync.Once
+}
+
+func (c *natsConn) publish(ctx context.Context, subject string, body []byte, timeout time.Duration) error {
+	c.wmu.Lock()
+	_ = c.raw.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := fmt.Fprintf(c.w, "PUB %s %d\r\n", subject, len(body))
+	if err == nil {
+		_, err = c.w.Write(body)
+	}
+	if err == nil {
+		_, err = c.w.WriteString("\r\nPING\r\n")
+	}
+	if err == nil {
+		err = c.w.Flush()
+	}
+	c.wmu.Unlock()
+	if err != nil {
+		return fmt.Errorf("nats publish: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-c.pongs:
+		return err
+	case <-c.dead:
+		return fmt.Errorf("nats connection lost: %w", c.err)
+	case <-timer.C:
+		return errors.New("nats publish: timed out waiting for server ack")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *natsConn) readLoop() {
+	var pending error
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch {
+		case line == "PING":
+			c.wmu.Lock()
+			_, werr := c.w.WriteString("PONG\r\n")
+			if werr == nil {
+				werr = c.w.Flush()
+			}
+			c.wmu.Unlock()
+			if werr != nil {
+				c.fail(werr)
+				return
+			}
+		case line == "PONG":
+			c.pongs <- pending
+			pending = nil
+		case strings.HasPrefix(line, "-ERR"):
+			pending = &natsServerError{msg: serverErrText(line)}
+		}
+		// +OK, INFO updates and anything else need no action from a
+		// publish-only client.
+	}
+}
+
+func (c *natsConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *natsConn) fail(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.dead)
+	})
+}
+
+func (c *natsConn) close() error {
+	c.fail(net.ErrClosed)
+	return c.raw.Close()
+}
+
+func serverErrText(line string) string {
+	msg := strings.TrimSpace(strings.TrimPrefix(line, "-ERR"))
+	return strings.Trim(msg, "'")
+}
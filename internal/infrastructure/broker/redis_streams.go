@@ -0,0 +1,41 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStreamsBroker XADDs each message to the stream named by Publish, in
+// the same type/event field layout StreamConsumer reads.
+type RedisStreamsBroker struct {
+	client    redis.Cmdable
+	retention repository.StreamRetention
+}
+
+func NewRedisStreamsBroker(client redis.Cmdable, retention repository.StreamRetention) *RedisStreamsBroker {
+	return &RedisStreamsBroker{client: client, retention: retention}
+}
+
+// Publish applies retention inline with XADD ... MAXLEN ~ (or MINID ~ when
+// only an age limit is configured). XADD takes one of the two, so with both
+// limits set the age limit is left to the periodic trim job.
+func (b *RedisStreamsBroker) Publish(ctx context.Context, stream string, message interface{}) error {
+	eventType, body, err := repository.EncodeMessage(message)
+	if err != nil {
+		return err
+	}
+
+	args := &redis.XAddArgs{
+		Stream: stream,
+		Values: []interface{}{repository.StreamFieldType, eventType, repository.StreamFieldEvent, string(body)},
+	}
+	b.retention.Limit(args, timeNow())
+	return b.client.XAdd(ctx, args).Err()
+}
+
+// Close is a no-op: the Redis client is shared and owned by the caller.
+func (b *RedisStreamsBroker) Close() error {
+	return nil
+}
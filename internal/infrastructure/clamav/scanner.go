@@ -0,0 +1,165 @@
+// Package clamav scans files with a clamd daemon, streaming them over its
+// INSTREAM command.
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+)
+
+// chunkSize is how much of the file goes into each INSTREAM chunk. clamd
+// refuses chunks over its StreamMaxLength, which defaults to 25 MiB.
+const chunkSize = 64 << 10
+
+type Scanner struct {
+	network   string
+	address   string
+	timeout   time.Duration
+	chunkSize int
+}
+
+// NewScanner talks to clamd at address: host:port over TCP, or the path of
+// its unix socket. Each scan is cut off after timeout; 0 leaves it to the
+// context.
+func NewScanner(address string, timeout time.Duration) *Scanner {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	return &Scanner{network: network, address: address, timeout: timeout, chunkSize: chunkSize}
+}
+
+var _ repository.FileScanner = (*Scanner)(nil)
+
+// Scan streams r to clamd. Content clamd refuses, e.g. for being over its
+// StreamMaxLength, is an error rather than a verdict.
+func (s *Scanner) Scan(ctx context.Context, r io.Reader) (*repository.ScanResult, error) {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := s.stream(conn, r); err != nil {
+		// clamd hangs up on a stream it refuses; its reply says why.
+		if reply, rerr := readReply(conn); rerr == nil && strings.HasSuffix(reply, " ERROR") {
+			return nil, fmt.Errorf("clamd: %s", reply)
+		}
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// Ping checks that clamd is up.
+func (s *Scanner) Ping(ctx context.Context) error {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+	return nil
+}
+
+// dial connects to clamd with the scan's deadline set on the connection, and
+// cuts the connection short if ctx is cancelled first.
+func (s *Scanner) dial(ctx context.Context) (net.Conn, error) {
+	scanCtx := ctx
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(scanCtx, s.network, s.address)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+	if deadline, ok := scanCtx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	return &stoppingConn{Conn: conn, stop: stop}, nil
+}
+
+// stream sends r as INSTREAM chunks, each prefixed with its length, and the
+// zero-length chunk that ends the stream.
+func (s *Scanner) stream(conn net.Conn, r io.Reader) error {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+s.chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// readReply reads clamd's null-terminated reply.
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// parseReply reads a verdict such as "stream: OK" or
+// "stream: Win.Test.EICAR_HDB-1 FOUND".
+func parseReply(reply string) (*repository.ScanResult, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return &repository.ScanResult{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &repository.ScanResult{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return nil, fmt.Errorf("clamd: %s", reply)
+}
+
+// stoppingConn stops watching the context once the connection is closed.
+type stoppingConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *stoppingConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
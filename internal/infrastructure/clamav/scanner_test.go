@@ -0,0 +1,160 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository/scantest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClamd speaks enough of the clamd protocol to answer PING and INSTREAM.
+// Streams containing EICAR are infected; streams over maxLen are refused.
+type fakeClamd struct {
+	ln     net.Listener
+	maxLen int
+	chunks chan int
+	stall  bool
+}
+
+func startClamd(t *testing.T, network, address string) *fakeClamd {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeClamd{ln: ln, maxLen: 1 << 20, chunks: make(chan int, 1024)}
+	t.Cleanup(func() { ln.Close() })
+	go d.serve()
+	return d
+}
+
+func (d *fakeClamd) serve() {
+	for {
+		conn, err := d.ln.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch cmd {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		if d.stall {
+			time.Sleep(time.Second)
+			return
+		}
+		var data []byte
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			d.chunks <- int(size)
+			if len(data)+int(size) > d.maxLen {
+				conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				return
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			data = append(data, chunk...)
+		}
+		if bytes.Contains(data, []byte(scantest.EICAR)) {
+			conn.Write([]byte("stream: " + scantest.EICARSignature + " FOUND\x00"))
+			return
+		}
+		conn.Write([]byte("stream: OK\x00"))
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestScannerVerdicts(t *testing.T) {
+	d := startClamd(t, "tcp", "127.0.0.1:0")
+	s := NewScanner(d.ln.Addr().String(), time.Second)
+	s.chunkSize = 16
+
+	result, err := s.Scan(context.Background(), strings.NewReader("just some notes, long enough for a few chunks"))
+	if assert.NoError(t, err) {
+		assert.False(t, result.Infected)
+	}
+	assert.Equal(t, 16, <-d.chunks)
+
+	result, err = s.Scan(context.Background(), strings.NewReader("header "+scantest.EICAR+" trailer"))
+	if assert.NoError(t, err) {
+		assert.True(t, result.Infected)
+		assert.Equal(t, scantest.EICARSignature, result.Signature)
+	}
+
+	assert.NoError(t, s.Ping(context.Background()))
+}
+
+func TestScannerRefusedStreamIsAnError(t *testing.T) {
+	d := startClamd(t, "tcp", "127.0.0.1:0")
+	d.maxLen = 32
+	s := NewScanner(d.ln.Addr().String(), time.Second)
+	s.chunkSize = 16
+
+	_, err := s.Scan(context.Background(), bytes.NewReader(make([]byte, 64<<10)))
+
+	assert.Error(t, err)
+}
+
+func TestScannerOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clamd.sock")
+	startClamd(t, "unix", path)
+	s := NewScanner(path, time.Second)
+	assert.Equal(t, "unix", s.network)
+
+	result, err := s.Scan(context.Background(), strings.NewReader("clean"))
+	if assert.NoError(t, err) {
+		assert.False(t, result.Infected)
+	}
+}
+
+func TestScannerTimesOut(t *testing.T) {
+	d := startClamd(t, "tcp", "127.0.0.1:0")
+	d.stall = true
+	s := NewScanner(d.ln.Addr().String(), 50*time.Millisecond)
+
+	start := time.Now()
+	_, err := s.Scan(context.Background(), strings.NewReader("clean"))
+
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestScannerUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, err = NewScanner(addr, time.Second).Scan(context.Background(), strings.NewReader("clean"))
+	assert.Error(t, err)
+	assert.Error(t, NewScanner(addr, time.Second).Ping(context.Background()))
+}
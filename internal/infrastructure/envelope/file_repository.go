@@ -0,0 +1,393 @@
+// Package envelope encrypts files before they reach the storage backend,
+// whichever it is. Each file gets its own AES-256 data key, stored with the
+// file wrapped by a master key, so the backend only ever holds ciphertext.
+//
+// A file is a header followed by the content in segments of up to
+// SegmentSize bytes, each sealed with AES-GCM. Segments are numbered in
+// their nonces and the last one is marked, so segments cannot be reordered,
+// dropped or cut off unnoticed, and any one of them can be decrypted on its
+// own, which keeps seeking cheap.
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/delaram/GoTastic/internal/repository"
+)
+
+// SegmentSize is how much plaintext each sealed segment holds.
+const SegmentSize = 64 << 10
+
+const (
+	magic      = "GTE\x01"
+	keyIDSize  = 8
+	nonceSize  = 12
+	tagSize    = 16
+	prefixSize = nonceSize - 5
+	// wrappedKeySize is the data key sealed under the master key, after the
+	// nonce it was sealed with.
+	wrappedKeySize = nonceSize + 32 + tagSize
+	headerSize     = len(magic) + keyIDSize + wrappedKeySize + prefixSize + 4
+	// maxSegmentSize bounds what a header may claim, and so what a read
+	// allocates.
+	maxSegmentSize = 16 << 20
+)
+
+var (
+	// ErrWrongKey means a file was encrypted under another master key.
+	ErrWrongKey = errors.New("envelope: file was encrypted with a different master key")
+	// ErrCorrupt means a file failed authentication: it was truncated,
+	// altered, or is not an envelope file despite its header.
+	ErrCorrupt = errors.New("envelope: file is corrupt")
+)
+
+type FileRepository struct {
+	inner  repository.FileRepository
+	master cipher.AEAD
+	keyID  []byte
+}
+
+// NewFileRepository encrypts what it stores in inner with a per-file data key
+// wrapped by masterKey, which must be 32 bytes.
+//
+// Files inner already held without encryption are served as they are, so
+// encryption can be turned on for an existing store. Stored bytes are
+// ciphertext, so the repository does not presign URLs even if inner can.
+func NewFileRepository(inner repository.FileRepository, masterKey []byte) (repository.FileRepository, error) {
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("envelope: master key must be 32 bytes, not %d", len(masterKey))
+	}
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(masterKey)
+	return &FileRepository{inner: inner, master: master, keyID: sum[:keyIDSize]}, nil
+}
+
+var (
+	_ repository.FileRepository = (*FileRepository)(nil)
+	_ repository.FileLister     = (*FileRepository)(nil)
+)
+
+// Upload encrypts file as inner reads it, one segment at a time.
+func (r *FileRepository) Upload(ctx context.Context, file io.Reader, filename string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, r.keyID...)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	header = append(header, nonce...)
+	header = r.master.Seal(header, nonce, dataKey, header[:len(magic)+keyIDSize])
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", err
+	}
+	header = append(header, prefix...)
+	header = binary.BigEndian.AppendUint32(header, SegmentSize)
+
+	return r.inner.Upload(ctx, &sealer{
+		src:    bufio.NewReaderSize(file, SegmentSize),
+		aead:   aead,
+		prefix: prefix,
+		plain:  make([]byte, SegmentSize),
+		out:    header,
+	}, filename)
+}
+
+// Download is Open read from the start.
+func (r *FileRepository) Download(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	return r.Open(ctx, fileID)
+}
+
+func (r *FileRepository) Delete(ctx context.Context, fileID string) error {
+	return r.inner.Delete(ctx, fileID)
+}
+
+func (r *FileRepository) Exists(ctx context.Context, fileID string) (bool, error) {
+	return r.inner.Exists(ctx, fileID)
+}
+
+// Open reads the file's header and unwraps its data key; the content is
+// only fetched and decrypted as it is read.
+func (r *FileRepository) Open(ctx context.Context, fileID string) (repository.FileContent, error) {
+	content, err := r.inner.Open(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := r.open(content)
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	return opened, nil
+}
+
+func (r *FileRepository) open(content repository.FileContent) (repository.FileContent, error) {
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(content, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header[:n], []byte(magic)) {
+		// Stored before encryption was turned on.
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return content, nil
+	}
+	if n < headerSize {
+		return nil, ErrCorrupt
+	}
+
+	rest := header[len(magic):]
+	keyID, rest := rest[:keyIDSize], rest[keyIDSize:]
+	wrapped, rest := rest[:wrappedKeySize], rest[wrappedKeySize:]
+	prefix, rest := rest[:prefixSize], rest[prefixSize:]
+	segmentSize := int64(binary.BigEndian.Uint32(rest))
+	if !bytes.Equal(keyID, r.keyID) {
+		return nil, ErrWrongKey
+	}
+	if segmentSize == 0 || segmentSize > maxSegmentSize {
+		return nil, ErrCorrupt
+	}
+	dataKey, err := r.master.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], header[:len(magic)+keyIDSize])
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	size, segments, ok := plainSize(stored-int64(headerSize), segmentSize)
+	if !ok {
+		return nil, ErrCorrupt
+	}
+	o := &opened{
+		FileContent: content,
+		aead:        aead,
+		prefix:      prefix,
+		segmentSize: segmentSize,
+		stored:      stored,
+		segments:    segments,
+		size:        size,
+		at:          stored,
+		segment:     -1,
+	}
+	// Reading an empty file never touches its one segment, so check it now:
+	// it is what tells an empty file from a longer one cut short.
+	if size == 0 {
+		if err := o.load(0); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// Walk lists inner's files, sized as their plaintext would be. Files stored
+// before encryption was turned on are reported a little short.
+func (r *FileRepository) Walk(ctx context.Context, fn func(repository.StoredFile) error) error {
+	lister, ok := r.inner.(repository.FileLister)
+	if !ok {
+		return errors.New("envelope: backend cannot list its files")
+	}
+	return lister.Walk(ctx, func(f repository.StoredFile) error {
+		if size, _, ok := plainSize(f.Size-int64(headerSize), SegmentSize); ok {
+			f.Size = size
+		}
+		return fn(f)
+	})
+}
+
+// plainSize is the size of the plaintext sealed in body bytes of segments,
+// and how many segments there are. There is always at least one segment, so
+// even an empty file is authenticated.
+func plainSize(body, segmentSize int64) (size, segments int64, ok bool) {
+	if body < tagSize {
+		return 0, 0, false
+	}
+	sealed := segmentSize + tagSize
+	segments = (body + sealed - 1) / sealed
+	if body-(segments-1)*sealed < tagSize {
+		return 0, 0, false
+	}
+	return body - segments*tagSize, segments, true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce is the nonce of segment n: the file's random prefix, the
+// segment number and whether it is the last one.
+func segmentNonce(prefix []byte, n int64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], uint32(n))
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+// sealer reads src a segment at a time and yields the header followed by
+// the sealed segments.
+type sealer struct {
+	src    *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	plain  []byte
+	// out is what has been sealed but not read yet.
+	out     []byte
+	segment int64
+	done    bool
+}
+
+func (s *sealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// seal seals the next segment into out. A full segment is the last one if
+// src ends right after it.
+func (s *sealer) seal() error {
+	if s.segment > 1<<32-1 {
+		return errors.New("envelope: file too large")
+	}
+	n, err := io.ReadFull(s.src, s.plain)
+	last := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !last {
+		return err
+	}
+	if !last {
+		if _, err := s.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	s.out = s.aead.Seal(s.out[:0], segmentNonce(s.prefix, s.segment, last), s.plain[:n], nil)
+	s.segment++
+	s.done = last
+	return nil
+}
+
+// opened decrypts the content inner holds a segment at a time, so a seek
+// only costs fetching and decrypting the segment it lands in.
+type opened struct {
+	repository.FileContent
+	aead        cipher.AEAD
+	prefix      []byte
+	segmentSize int64
+	stored      int64
+	segments    int64
+	size        int64
+
+	offset int64
+	// at is where the inner content is positioned.
+	at int64
+	// plain is the decrypted segment numbered segment, -1 if none is.
+	plain   []byte
+	sealed  []byte
+	segment int64
+}
+
+func (o *opened) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	n := o.offset / o.segmentSize
+	if n != o.segment {
+		if err := o.load(n); err != nil {
+			return 0, err
+		}
+	}
+	read := copy(p, o.plain[o.offset-n*o.segmentSize:])
+	o.offset += int64(read)
+	return read, nil
+}
+
+// load fetches and decrypts segment n, seeking inner only if the previous
+// read did not leave it there.
+func (o *opened) load(n int64) error {
+	start := int64(headerSize) + n*(o.segmentSize+tagSize)
+	end := min(start+o.segmentSize+tagSize, o.stored)
+	if o.at != start {
+		if _, err := o.FileContent.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		o.at = start
+	}
+	if o.sealed == nil {
+		o.sealed = make([]byte, o.segmentSize+tagSize)
+	}
+	sealed := o.sealed[:end-start]
+	read, err := io.ReadFull(o.FileContent, sealed)
+	o.at += int64(read)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorrupt
+	}
+	if err != nil {
+		return err
+	}
+	o.segment = -1
+	o.plain, err = o.aead.Open(o.plain[:0], segmentNonce(o.prefix, n, n == o.segments-1), sealed, nil)
+	if err != nil {
+		return ErrCorrupt
+	}
+	o.segment = n
+	return nil
+}
+
+func (o *opened) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("envelope: seek before start of file")
+	}
+	o.offset = offset
+	return offset, nil
+}
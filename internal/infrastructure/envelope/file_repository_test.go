@@ -0,0 +1,234 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/delaram/GoTastic/internal/infrastructure/localfs"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3/s3test"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/repository/filerepotest"
+	"github.com/stretchr/testify/assert"
+)
+
+var testMasterKey = bytes.Repeat([]byte{1}, 32)
+
+func newLocalRepository(t *testing.T) (repository.FileRepository, string) {
+	root := t.TempDir()
+	inner, err := localfs.NewFileRepository(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewFileRepository(inner, testMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, root
+}
+
+// storedBytes is what the local backend holds for id.
+func storedBytes(t *testing.T, root, id string) []byte {
+	var data []byte
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Name() == id {
+			data, err = os.ReadFile(path)
+		}
+		return err
+	})
+	if data == nil {
+		t.Fatalf("%s is not stored", id)
+	}
+	return data
+}
+
+func rewrite(t *testing.T, root, id string, data []byte) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Name() == id {
+			err = os.WriteFile(path, data, 0o600)
+		}
+		return err
+	})
+}
+
+func read(t *testing.T, repo repository.FileRepository, id string) ([]byte, error) {
+	t.Helper()
+	content, err := repo.Open(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return io.ReadAll(content)
+}
+
+func TestConformanceOverLocalFS(t *testing.T) {
+	filerepotest.Run(t, func(t *testing.T) repository.FileRepository {
+		repo, _ := newLocalRepository(t)
+		return repo
+	})
+}
+
+func TestConformanceOverS3(t *testing.T) {
+	filerepotest.Run(t, func(t *testing.T) repository.FileRepository {
+		repo, err := NewFileRepository(s3.NewFileRepository(s3test.NewMemoryAPI(), "bucket", s3.WithPartSize(5<<20)), testMasterKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
+
+func TestBackendOnlyHoldsCiphertext(t *testing.T) {
+	repo, root := newLocalRepository(t)
+	plain := []byte(strings.Repeat("top secret ", 100))
+
+	id, err := repo.Upload(context.Background(), bytes.NewReader(plain), "a.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	stored := storedBytes(t, root, id)
+	assert.NotContains(t, string(stored), "top secret")
+	assert.Equal(t, headerSize+len(plain)+tagSize, len(stored))
+	_, presigns := repo.(repository.FilePresigner)
+	assert.False(t, presigns)
+}
+
+func TestEachFileHasItsOwnDataKey(t *testing.T) {
+	repo, root := newLocalRepository(t)
+
+	a, err := repo.Upload(context.Background(), strings.NewReader("same"), "a.txt")
+	assert.NoError(t, err)
+	b, err := repo.Upload(context.Background(), strings.NewReader("same"), "b.txt")
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, storedBytes(t, root, a)[len(magic)+keyIDSize:], storedBytes(t, root, b)[len(magic)+keyIDSize:])
+}
+
+func TestSeekAcrossSegments(t *testing.T) {
+	repo, _ := newLocalRepository(t)
+	plain := make([]byte, 3*SegmentSize+100)
+	for i := range plain {
+		plain[i] = byte(i * 31)
+	}
+	id, err := repo.Upload(context.Background(), io.MultiReader(bytes.NewReader(plain)), "big.bin")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	content, err := repo.Open(context.Background(), id)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer content.Close()
+
+	size, err := content.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(plain)), size)
+
+	for _, offset := range []int64{2*SegmentSize + 7, SegmentSize - 3, 0, 3 * SegmentSize} {
+		_, err := content.Seek(offset, io.SeekStart)
+		assert.NoError(t, err)
+		buf := make([]byte, 10)
+		_, err = io.ReadFull(content, buf)
+		if assert.NoError(t, err, offset) {
+			assert.Equal(t, plain[offset:offset+10], buf, offset)
+		}
+	}
+}
+
+func TestExactSegmentAndEmptyFiles(t *testing.T) {
+	repo, _ := newLocalRepository(t)
+
+	for _, size := range []int{0, SegmentSize, 2 * SegmentSize} {
+		plain := bytes.Repeat([]byte{'x'}, size)
+		id, err := repo.Upload(context.Background(), bytes.NewReader(plain), "a.bin")
+		if !assert.NoError(t, err) {
+			return
+		}
+		data, err := read(t, repo, id)
+		assert.NoError(t, err, size)
+		assert.Equal(t, size, len(data))
+	}
+}
+
+func TestTamperedFilesFail(t *testing.T) {
+	plain := make([]byte, 2*SegmentSize+100)
+	for name, tamper := range map[string]func([]byte) []byte{
+		"flipped bit":       func(b []byte) []byte { b[headerSize+SegmentSize+20] ^= 1; return b },
+		"wrapped key":       func(b []byte) []byte { b[len(magic)+keyIDSize+nonceSize] ^= 1; return b },
+		"cut at a segment":  func(b []byte) []byte { return b[:headerSize+2*(SegmentSize+tagSize)] },
+		"last segment gone": func(b []byte) []byte { return b[:len(b)-116] },
+		"cut after header":  func(b []byte) []byte { return b[:headerSize+tagSize] },
+		"segments swapped": func(b []byte) []byte {
+			first := append([]byte(nil), b[headerSize:headerSize+SegmentSize+tagSize]...)
+			copy(b[headerSize:], b[headerSize+SegmentSize+tagSize:headerSize+2*(SegmentSize+tagSize)])
+			copy(b[headerSize+SegmentSize+tagSize:], first)
+			return b
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo, root := newLocalRepository(t)
+			id, err := repo.Upload(context.Background(), bytes.NewReader(plain), "a.bin")
+			if !assert.NoError(t, err) {
+				return
+			}
+			rewrite(t, root, id, tamper(storedBytes(t, root, id)))
+
+			_, err = read(t, repo, id)
+
+			assert.ErrorIs(t, err, ErrCorrupt)
+		})
+	}
+}
+
+func TestWrongMasterKey(t *testing.T) {
+	repo, root := newLocalRepository(t)
+	id, err := repo.Upload(context.Background(), strings.NewReader("secret"), "a.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+	inner, err := localfs.NewFileRepository(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewFileRepository(inner, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = read(t, other, id)
+
+	assert.ErrorIs(t, err, ErrWrongKey)
+}
+
+func TestFilesStoredBeforeEncryptionAreServedAsIs(t *testing.T) {
+	root := t.TempDir()
+	inner, err := localfs.NewFileRepository(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := inner.Upload(context.Background(), strings.NewReader("from before"), "a.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+	repo, err := NewFileRepository(inner, testMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := read(t, repo, id)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "from before", string(data))
+}
+
+func TestNewFileRepositoryNeedsA256BitKey(t *testing.T) {
+	_, err := NewFileRepository(nil, []byte("short"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+// Package localfs stores files in a directory tree, for running without S3
+// on a laptop or in CI.
+package localfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidKey is returned for ids that are not a plain file name, such as
+// "../etc/passwd", so no id can reach outside the root.
+var ErrInvalidKey = repository.NewError("invalid file id")
+
+// tmpDir holds uploads being written, inside the root so that renaming them
+// into place stays on one filesystem and is atomic.
+const tmpDir = ".tmp"
+
+// maxKeyLen is the longest id most filesystems accept as a file name.
+const maxKeyLen = 255
+
+// FileRepository keeps each file at <root>/<aa>/<bb>/<id>, where aa and bb
+// come from a hash of the id so directories stay small however ids are
+// chosen. Files are written to a temporary file and renamed into place, so
+// readers never see a partial upload.
+type FileRepository struct {
+	root string
+}
+
+func NewFileRepository(root string) (repository.FileRepository, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(root, tmpDir), 0o755); err != nil {
+		return nil, fmt.Errorf("create file store: %w", err)
+	}
+	return &FileRepository{root: root}, nil
+}
+
+// validKey reports whether id can be stored as a single file name. Leading
+// dots are refused so ids cannot collide with tmpDir or name "." and "..".
+func validKey(id string) bool {
+	if id == "" || len(id) > maxKeyLen || id[0] == '.' {
+		return false
+	}
+	return !strings.ContainsAny(id, "/\\\x00") && filepath.IsLocal(id)
+}
+
+// path is where id is stored, sharded on the hash of id.
+func (r *FileRepository) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	shard := hex.EncodeToString(sum[:2])
+	return filepath.Join(r.root, shard[:2], shard[2:], id)
+}
+
+// Upload writes file under a new id made of a UUID and the extension of
+// filename. Extensions that would make an invalid id are dropped.
+func (r *FileRepository) Upload(ctx context.Context, file io.Reader, filename string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	fileID := uuid.New().String()
+	if withExt := fileID + filepath.Ext(filename); validKey(withExt) {
+		fileID = withExt
+	}
+
+	if err := r.write(ctx, r.path(fileID), file); err != nil {
+		return "", err
+	}
+	return fileID, nil
+}
+
+// write copies file to a temporary file, syncs it and renames it to dst. The
+// temporary file is removed if anything fails.
+func (r *FileRepository) write(ctx context.Context, dst string, file io.Reader) (err error) {
+	tmp, err := os.CreateTemp(filepath.Join(r.root, tmpDir), "upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmp, &contextReader{ctx: ctx, r: file}); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Download is ErrNotFound if there is no such file.
+func (r *FileRepository) Download(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	if !validKey(fileID) {
+		return nil, ErrInvalidKey
+	}
+	f, err := os.Open(r.path(fileID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Delete removes fileID. Like S3, deleting a missing file is not an error.
+func (r *FileRepository) Delete(ctx context.Context, fileID string) error {
+	if !validKey(fileID) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(r.path(fileID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Exists is false for invalid ids, since no file can be stored under them.
+func (r *FileRepository) Exists(ctx context.Context, fileID string) (bool, error) {
+	if !validKey(fileID) {
+		return false, nil
+	}
+	_, err := os.Stat(r.path(fileID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Open is ErrNotFound for missing files and invalid ids. The ETag is derived
+// from the file's size and modification time, as web servers do; uploads
+// always write a new file, so it changes whenever the content does.
+func (r *FileRepository) Open(ctx context.Context, fileID string) (repository.FileContent, error) {
+	if !validKey(fileID) {
+		return nil, repository.ErrNotFound
+	}
+	f, err := os.Open(r.path(fileID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &file{
+		File:    f,
+		etag:    fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+		modTime: info.ModTime(),
+	}, nil
+}
+
+// Walk visits every stored file. Uploads still being written live in tmpDir
+// and are skipped.
+func (r *FileRepository) Walk(ctx context.Context, fn func(repository.StoredFile) error) error {
+	return filepath.WalkDir(r.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == filepath.Join(r.root, tmpDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || path != r.path(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // deleted while walking
+		}
+		if err != nil {
+			return err
+		}
+		return fn(repository.StoredFile{ID: d.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+type file struct {
+	*os.File
+	etag    string
+	modTime time.Time
+}
+
+func (f *file) ETag() string       { return f.etag }
+func (f *file) ModTime() time.Time { return f.modTime }
+
+// contextReader stops a copy once ctx is done, as the S3 client would.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,102 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/repository/filerepotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRepository(t *testing.T) *FileRepository {
+	repo, err := NewFileRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo.(*FileRepository)
+}
+
+func TestFileRepositoryConformance(t *testing.T) {
+	filerepotest.Run(t, func(t *testing.T) repository.FileRepository {
+		return newTestRepository(t)
+	})
+}
+
+func TestUploadShardsFiles(t *testing.T) {
+	repo := newTestRepository(t)
+
+	id, err := repo.Upload(context.Background(), strings.NewReader("hello"), "a.txt")
+	assert.NoError(t, err)
+
+	rel, err := filepath.Rel(repo.root, repo.path(id))
+	assert.NoError(t, err)
+	parts := strings.Split(rel, string(filepath.Separator))
+	assert.Len(t, parts, 3)
+	assert.Len(t, parts[0], 2)
+	assert.Len(t, parts[1], 2)
+	assert.Equal(t, id, parts[2])
+	assert.FileExists(t, repo.path(id))
+}
+
+func TestUploadLeavesNothingBehindOnFailure(t *testing.T) {
+	repo := newTestRepository(t)
+	readErr := errors.New("connection reset")
+
+	_, err := repo.Upload(context.Background(), io.MultiReader(strings.NewReader("partial"), &failingReader{err: readErr}), "a.txt")
+
+	assert.ErrorIs(t, err, readErr)
+	entries, err := os.ReadDir(repo.root)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "only the temporary directory")
+	tmp, err := os.ReadDir(filepath.Join(repo.root, tmpDir))
+	assert.NoError(t, err)
+	assert.Empty(t, tmp)
+}
+
+func TestUploadDropsUnsafeExtensions(t *testing.T) {
+	repo := newTestRepository(t)
+
+	id, err := repo.Upload(context.Background(), strings.NewReader("x"), `a.t\..\x`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", filepath.Ext(id))
+	assert.True(t, validKey(id))
+}
+
+func TestRejectsPathTraversal(t *testing.T) {
+	root := t.TempDir()
+	secret := filepath.Join(root, "secret.txt")
+	assert.NoError(t, os.WriteFile(secret, []byte("secret"), 0o600))
+	repo, err := NewFileRepository(filepath.Join(root, "files"))
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	for _, id := range []string{"", ".", "..", "../secret.txt", "a/b", `..\secret.txt`, "/etc/passwd", ".tmp", "a\x00b", strings.Repeat("a", 256)} {
+		_, err := repo.Download(ctx, id)
+		assert.Equal(t, ErrInvalidKey, err, "download %q", id)
+		assert.Equal(t, ErrInvalidKey, repo.Delete(ctx, id), "delete %q", id)
+		_, err = repo.Open(ctx, id)
+		assert.Equal(t, repository.ErrNotFound, err, "open %q", id)
+		exists, err := repo.Exists(ctx, id)
+		assert.NoError(t, err)
+		assert.False(t, exists, "exists %q", id)
+	}
+	assert.FileExists(t, secret)
+}
+
+func TestDownloadMissingFile(t *testing.T) {
+	repo := newTestRepository(t)
+
+	_, err := repo.Download(context.Background(), "missing.txt")
+	assert.Equal(t, repository.ErrNotFound, err)
+}
+
+type failingReader struct{ err error }
+
+func (r *failingReader) Read(p []byte) (int, error) { return 0, r.err }
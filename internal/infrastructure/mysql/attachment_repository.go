@@ -0,0 +1,84 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"git.ice.global/packages/beeorm/v4"
+	"github.com/delaram/GoTastic/internal/domain"
+	beeinfra "github.com/delaram/GoTastic/internal/infrastructure/beeorm"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+type AttachmentRepository struct {
+	engine *beeorm.Engine
+	logger logger.Logger
+}
+
+func NewAttachmentRepository(engine *beeorm.Engine, logger logger.Logger) repository.AttachmentRepository {
+	return &AttachmentRepository{engine: engine, logger: logger}
+}
+
+func (r *AttachmentRepository) List(ctx context.Context, todoUUID string) ([]*domain.TodoAttachment, error) {
+	var attachments []*domain.TodoAttachment
+	where := beeorm.NewWhere("TodoUUID = ? ORDER BY Position, ID", todoUUID)
+	r.engine.Search(where, beeorm.NewPager(1, 1000), &attachments)
+	return attachments, nil
+}
+
+func (r *AttachmentRepository) Attach(ctx context.Context, a *domain.TodoAttachment) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return r.AttachTx(ctx, tx, a)
+	})
+}
+
+// AttachTx flushes what tx has staged first, so a todo created in the same
+// transaction exists for the attachment's foreign key and position query.
+func (r *AttachmentRepository) AttachTx(ctx context.Context, tx repository.Tx, a *domain.TodoAttachment) (err error) {
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+	if err := bt.Flush(); err != nil {
+		return err
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("attach failed: %v", rec)
+		}
+	}()
+
+	var attached int
+	bt.DB().QueryRow(beeorm.NewWhere("SELECT COUNT(*) FROM TodoAttachment WHERE TodoUUID = ? AND FileID = ?", a.TodoUUID, a.FileID), &attached)
+	if attached > 0 {
+		return repository.ErrDuplicate
+	}
+	var last sql.NullInt64
+	bt.DB().QueryRow(beeorm.NewWhere("SELECT MAX(Position) FROM TodoAttachment WHERE TodoUUID = ?", a.TodoUUID), &last)
+	a.Position = 0
+	if last.Valid {
+		a.Position = int(last.Int64) + 1
+	}
+	bt.Track(a)
+	return nil
+}
+
+func (r *AttachmentRepository) Detach(ctx context.Context, todoUUID, fileID string) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return execAffecting(tx, repository.ErrNotFound, `DELETE FROM TodoAttachment WHERE TodoUUID = ? AND FileID = ?`, todoUUID, fileID)
+	})
+}
+
+func (r *AttachmentRepository) Reorder(ctx context.Context, todoUUID string, fileIDs []string) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		for i, fileID := range fileIDs {
+			// Rows already in place are not "affected", so no missing check.
+			if err := execAffecting(tx, nil, `UPDATE TodoAttachment SET Position = ? WHERE TodoUUID = ? AND FileID = ?`, i, todoUUID, fileID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,225 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.ice.global/packages/beeorm/v4"
+	"github.com/delaram/GoTastic/internal/domain"
+	beeinfra "github.com/delaram/GoTastic/internal/infrastructure/beeorm"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+type FileMetadataRepository struct {
+	engine *beeorm.Engine
+	logger logger.Logger
+}
+
+func NewFileMetadataRepository(engine *beeorm.Engine, logger logger.Logger) repository.FileMetadataRepository {
+	return &FileMetadataRepository{engine: engine, logger: logger}
+}
+
+func (r *FileMetadataRepository) BeginTx(ctx context.Context) (repository.Tx, error) {
+	return beeinfra.BeginTx(r.engine), nil
+}
+
+func (r *FileMetadataRepository) Create(ctx context.Context, file *domain.File) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return r.CreateTx(ctx, tx, file)
+	})
+}
+
+// CreateTx stages the insert on the caller's unit of work, e.g. next to the
+// outbox row announcing the upload.
+func (r *FileMetadataRepository) CreateTx(ctx context.Context, tx repository.Tx, file *domain.File) error {
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+	bt.Track(file)
+	return nil
+}
+
+// ConfirmTx only updates a row that is still unconfirmed, so a confirmation
+// racing with another one, or with expiry, does nothing.
+func (r *FileMetadataRepository) ConfirmTx(ctx context.Context, tx repository.Tx, file *domain.File) error {
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+	return execAffecting(bt, repository.ErrNotFound, `UPDATE File SET Filename = ?, ContentType = ?, Size = ?, SHA256 = ?, ScanStatus = ? WHERE FileID = ? AND ScanStatus = ?`,
+		file.Filename, file.ContentType, file.Size, file.SHA256, file.ScanStatus, file.FileID, string(domain.FileScanUnconfirmed))
+}
+
+// DeleteUnconfirmed is guarded like ConfirmTx, so of a confirmation and a
+// deletion racing for the same row only one wins.
+func (r *FileMetadataRepository) DeleteUnconfirmed(ctx context.Context, fileID string) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return execAffecting(tx, repository.ErrNotFound, `DELETE FROM File WHERE FileID = ? AND ScanStatus = ?`, fileID, string(domain.FileScanUnconfirmed))
+	})
+}
+
+func (r *FileMetadataRepository) GetByFileID(ctx context.Context, fileID string) (*domain.File, error) {
+	var file domain.File
+	if ok := r.engine.SearchOne(beeorm.NewWhere("FileID = ?", fileID), &file); !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &file, nil
+}
+
+func (r *FileMetadataRepository) List(ctx context.Context, f domain.FileFilter, limit, offset int) ([]*domain.File, int64, error) {
+	conds := []string{"1=1"}
+	args := []any{}
+	if f.UploadedBy != nil {
+		conds = append(conds, "UploadedBy = ?")
+		args = append(args, *f.UploadedBy)
+	}
+	if f.ContentType != nil {
+		conds = append(conds, "ContentType = ?")
+		args = append(args, *f.ContentType)
+	}
+	if f.ScanStatus != nil {
+		conds = append(conds, "ScanStatus = ?")
+		args = append(args, *f.ScanStatus)
+	}
+	if f.CreatedBefore != nil {
+		conds = append(conds, "CreatedAt < ?")
+		args = append(args, *f.CreatedBefore)
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+	page := offset/limit + 1
+
+	var files []*domain.File
+	where := beeorm.NewWhere(strings.Join(conds, " AND ")+" ORDER BY CreatedAt DESC, ID DESC", args...)
+	total := r.engine.SearchWithCount(where, beeorm.NewPager(page, limit), &files)
+	return files, int64(total), nil
+}
+
+func (r *FileMetadataRepository) Delete(ctx context.Context, fileID string) error {
+	var file domain.File
+	if ok := r.engine.SearchOne(beeorm.NewWhere("FileID = ?", fileID), &file); !ok {
+		return repository.ErrNotFound
+	}
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		tx.Delete(&file)
+		return nil
+	})
+}
+
+func (r *FileMetadataRepository) ListBySHA256(ctx context.Context, sha256 string) ([]*domain.File, error) {
+	var files []*domain.File
+	r.engine.Search(beeorm.NewWhere("SHA256 = ? ORDER BY CreatedAt, ID", sha256), beeorm.NewPager(1, 1000), &files)
+	return files, nil
+}
+
+func (r *FileMetadataRepository) DuplicateHashes(ctx context.Context, after string, limit int) (hashes []string, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			hashes, err = nil, fmt.Errorf("duplicate hash lookup failed: %v", rec)
+		}
+	}()
+
+	rows, close := r.engine.GetMysql().Query(`
+    SELECT SHA256 FROM File
+    WHERE SHA256 > ?
+    GROUP BY SHA256
+    HAVING COUNT(*) > 1
+    ORDER BY SHA256
+    LIMIT ?
+`, after, limit)
+	defer close()
+	for rows.Next() {
+		var hash string
+		rows.Scan(&hash)
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// AddReferences locks the row first, so it waits for a DeleteLast of the
+// same file and then finds it gone.
+func (r *FileMetadataRepository) AddReferences(ctx context.Context, fileID string, n int) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		if _, err := lockFile(tx, fileID); err != nil {
+			return err
+		}
+		return execAffecting(tx, repository.ErrNotFound, `UPDATE File SET RefCount = RefCount + ? WHERE FileID = ?`, n, fileID)
+	})
+}
+
+// Release reads the count and decrements under one row lock, like
+// DeleteLast, so the count it reports is the one it left behind even if
+// AddReferences runs alongside.
+func (r *FileMetadataRepository) Release(ctx context.Context, fileID string) (remaining int, err error) {
+	err = beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		refs, err := lockFile(tx, fileID)
+		if err != nil || refs <= 1 {
+			return err
+		}
+		remaining = refs - 1
+		return execAffecting(tx, nil, `UPDATE File SET RefCount = RefCount - 1 WHERE FileID = ?`, fileID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return remaining, nil
+}
+
+// DeleteLast reads the count and deletes under one row lock, so a reference
+// AddReferences adds either lands before, and keeps the file, or finds it
+// gone.
+func (r *FileMetadataRepository) DeleteLast(ctx context.Context, fileID string) (remaining int, err error) {
+	err = beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		refs, err := lockFile(tx, fileID)
+		if err != nil {
+			return err
+		}
+		if refs > 1 {
+			remaining = refs - 1
+			return execAffecting(tx, nil, `UPDATE File SET RefCount = RefCount - 1 WHERE FileID = ?`, fileID)
+		}
+		return execAffecting(tx, repository.ErrNotFound, `DELETE FROM File WHERE FileID = ?`, fileID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return remaining, nil
+}
+
+func (r *FileMetadataRepository) SetScanStatus(ctx context.Context, fileID string, status domain.FileScanStatus, signature *string) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return r.SetScanStatusTx(ctx, tx, fileID, status, signature)
+	})
+}
+
+// SetScanStatusTx records the verdict on the caller's unit of work, e.g.
+// next to the outbox row announcing it.
+func (r *FileMetadataRepository) SetScanStatusTx(ctx context.Context, tx repository.Tx, fileID string, status domain.FileScanStatus, signature *string) error {
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+	return execAffecting(bt, repository.ErrNotFound, `UPDATE File SET ScanStatus = ?, ScanSignature = ?, ScannedAt = ? WHERE FileID = ?`,
+		string(status), signature, time.Now().UTC(), fileID)
+}
+
+// lockFile reads a file's RefCount with SELECT ... FOR UPDATE, holding its
+// row until tx ends. It is repository.ErrNotFound if there is no row.
+func lockFile(tx *beeinfra.BeeTx, fileID string) (refs int, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			refs, err = 0, fmt.Errorf("file lock failed: %v", rec)
+		}
+	}()
+
+	if !tx.DB().QueryRow(beeorm.NewWhere("SELECT RefCount FROM File WHERE FileID = ? FOR UPDATE", fileID), &refs) {
+		return 0, repository.ErrNotFound
+	}
+	return refs, nil
+}
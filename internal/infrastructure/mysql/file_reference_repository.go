@@ -0,0 +1,74 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"git.ice.global/packages/beeorm/v4"
+	beeinfra "github.com/delaram/GoTastic/internal/infrastructure/beeorm"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+type FileReferenceRepository struct {
+	engine *beeorm.Engine
+	logger logger.Logger
+}
+
+func NewFileReferenceRepository(engine *beeorm.Engine, logger logger.Logger) repository.FileReferenceRepository {
+	return &FileReferenceRepository{engine: engine, logger: logger}
+}
+
+// Referenced looks fileIDs up in TodoItem.FileID, TodoAttachment and
+// FileThumbnail.ThumbnailID; all three columns are indexed.
+func (r *FileReferenceRepository) Referenced(ctx context.Context, fileIDs []string) (out map[string]bool, err error) {
+	out = make(map[string]bool, len(fileIDs))
+	if len(fileIDs) == 0 {
+		return out, nil
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			out, err = nil, fmt.Errorf("file reference lookup failed: %v", rec)
+		}
+	}()
+
+	in := strings.TrimSuffix(strings.Repeat("?,", len(fileIDs)), ",")
+	args := make([]interface{}, 0, 3*len(fileIDs))
+	for i := 0; i < 3; i++ {
+		for _, id := range fileIDs {
+			args = append(args, id)
+		}
+	}
+	rows, close := r.engine.GetMysql().Query(`
+    SELECT FileID FROM TodoItem WHERE FileID IN (`+in+`)
+    UNION
+    SELECT FileID FROM TodoAttachment WHERE FileID IN (`+in+`)
+    UNION
+    SELECT ThumbnailID FROM FileThumbnail WHERE ThumbnailID IN (`+in+`)
+`, args...)
+	defer close()
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		out[id] = true
+	}
+	return out, nil
+}
+
+func (r *FileReferenceRepository) Repoint(ctx context.Context, from, to string) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		if err := execAffecting(tx, nil, `UPDATE TodoItem SET FileID = ? WHERE FileID = ?`, to, from); err != nil {
+			return err
+		}
+		// (TodoUUID, FileID) is unique, so drop what would collide first.
+		if err := execAffecting(tx, nil, `
+    DELETE a FROM TodoAttachment a
+    JOIN TodoAttachment b ON b.TodoUUID = a.TodoUUID AND b.FileID = ?
+    WHERE a.FileID = ?
+`, to, from); err != nil {
+			return err
+		}
+		return execAffecting(tx, nil, `UPDATE TodoAttachment SET FileID = ? WHERE FileID = ?`, to, from)
+	})
+}
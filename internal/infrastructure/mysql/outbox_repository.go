@@ -2,21 +2,22 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"git.ice.global/packages/beeorm/v4"
 	"github.com/delaram/GoTastic/internal/domain"
+	beeinfra "github.com/delaram/GoTastic/internal/infrastructure/beeorm"
 	"github.com/delaram/GoTastic/internal/repository"
 	"github.com/delaram/GoTastic/pkg/logger"
 )
 
-type beeTx struct{ db *beeorm.DB }
-
-func (t *beeTx) Commit(ctx context.Context) error   { t.db.Commit(); return nil }
-func (t *beeTx) Rollback(ctx context.Context) error { t.db.Rollback(); return nil }
+// maxErrLen is how much of a publish error is kept on the row.
+const maxErrLen = 2000
 
 type OutboxRepo struct {
 	engine *beeorm.Engine
@@ -28,13 +29,16 @@ func NewOutboxRepo(engine *beeorm.Engine, logger logger.Logger) *OutboxRepo {
 }
 
 func (r *OutboxRepo) BeginTx(ctx context.Context) (repository.Tx, error) {
-	db := r.engine.GetMysql()
-	db.Begin()
-	return &beeTx{db: db}, nil
+	return beeinfra.BeginTx(r.engine), nil
 }
 
 func (r *OutboxRepo) Insert(ctx context.Context, tx repository.Tx, msg repository.OutboxMessage) error {
 	r.logger.Debug("Starting OutboxRepo.Insert with msg: %+v", msg)
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+
 	var headersPtr *string
 	if len(msg.Headers) > 0 {
 		b, err := json.Marshal(msg.Headers)
@@ -55,110 +59,97 @@ func (r *OutboxRepo) Insert(ctx context.Context, tx repository.Tx, msg repositor
 		EventType:     msg.EventType,
 		Payload:       msg.Payload,
 		Headers:       headersPtr,
-		Status:        "pending",
+		Status:        domain.OutboxStatusPending,
 		Attempts:      0,
 		AvailableAt:   time.Now().UTC(),
+		CreatedAt:     time.Now().UTC(),
 	}
 	r.logger.Debug("Created Outbox entity: %+v", e)
 
-	fl := r.engine.NewFlusher()
-	r.logger.Debug("New Flusher created")
-	fl.Track(e)
-	r.logger.Debug("Entity tracked in flusher")
-
-	err := fl.FlushWithCheck()
-	if err != nil {
-		r.logger.Error("FlushWithCheck failed", err)
-		return err
-	}
-	r.logger.Debug("FlushWithCheck succeeded, Outbox ID: %d", e.ID)
+	// Staged on the caller's unit of work so the row commits (or rolls back)
+	// together with the aggregate write.
+	bt.Track(e)
+	r.logger.Debug("Outbox entity tracked in transaction")
 
 	return nil
 }
 
-func (r *OutboxRepo) FetchAndLock(ctx context.Context, limit int, lockForSeconds int) ([]repository.LockedOutboxRow, error) {
-	tx, err := r.BeginTx(ctx)
-	if err != nil {
-		return nil, err
-	}
+// FetchAndLock claims a batch for owner. The SELECT ... FOR UPDATE SKIP LOCKED
+// and the claiming UPDATE run in one transaction, so concurrent dispatchers
+// never see the same row; each one just gets a different slice of the queue.
+func (r *OutboxRepo) FetchAndLock(ctx context.Context, owner string, limit int, lockForSeconds int) (out []repository.LockedOutboxRow, err error) {
+	tx := beeinfra.BeginTx(r.engine)
 	defer tx.Rollback(ctx)
 
-	db := tx.(*beeTx).db
-	now := time.Now()
-	db.Exec(`
-        UPDATE outbox
-        SET Status = 'pending'
-        WHERE Status = 'pending'
-          AND AvailableAt <= ?
-        ORDER BY id
-        LIMIT ?
-    `, now, limit)
+	defer func() {
+		if rec := recover(); rec != nil {
+			out, err = nil, fmt.Errorf("outbox claim failed: %v", rec)
+		}
+	}()
+
+	db := tx.DB()
+	now := time.Now().UTC()
+	until := now.Add(time.Duration(lockForSeconds) * time.Second)
+
 	rows, close := db.Query(`
-    SELECT id, AggregateType, AggregateID, EventType, Payload, Attempts
+    SELECT ID, AggregateType, AggregateID, EventType, Payload, Headers, Attempts, CreatedAt
     FROM outbox
-    WHERE Status = 'pending'
-      AND AvailableAt <= ?
-    ORDER BY id
-`, now)
-	defer close()
-
-	var out []repository.LockedOutboxRow
+    WHERE (Status IN ('pending', 'failed') AND AvailableAt <= ?)
+       OR (Status = 'processing' AND LockedUntil <= ?)
+    ORDER BY ID
+    LIMIT ?
+    FOR UPDATE SKIP LOCKED
+`, now, now, limit)
 	for rows.Next() {
 		var (
-			id        uint64
-			aggType   string
-			aggID     string
-			eventType string
-			payload   []byte
-			attempts  int
+			row     repository.LockedOutboxRow
+			headers sql.NullString
 		)
-		var scanErr error
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					scanErr = fmt.Errorf("panic in rows.Scan: %v", r)
-				}
-			}()
-			rows.Scan(&id, &aggType, &aggID, &eventType, &payload, &attempts)
-		}()
-		if scanErr != nil {
-			log.Printf("Failed to scan row: %v", scanErr)
-			return nil, scanErr
+		rows.Scan(&row.ID, &row.AggregateType, &row.AggregateID, &row.EventType, &row.Payload, &headers, &row.Attempts, &row.CreatedAt)
+		if headers.Valid && headers.String != "" {
+			if err := json.Unmarshal([]byte(headers.String), &row.Headers); err != nil {
+				r.logger.Warn(fmt.Sprintf("Outbox: ignoring malformed headers on row %d", row.ID), err)
+			}
 		}
-		log.Printf("Scanned row: id=%d, aggType=%s, aggID=%s, eventType=%s, attempts=%d", id, aggType, aggID, eventType, attempts)
-		out = append(out, repository.LockedOutboxRow{
-			ID:            id,
-			AggregateType: aggType,
-			AggregateID:   aggID,
-			EventType:     eventType,
-			Payload:       payload,
-			Attempts:      attempts,
-		})
+		row.LockedBy = owner
+		row.LockedUntil = until
+		out = append(out, row)
+	}
+	close()
+
+	if len(out) == 0 {
+		return nil, tx.Commit(ctx)
 	}
+
+	ids := make([]interface{}, 0, len(out)+2)
+	ids = append(ids, owner, until)
+	for _, row := range out {
+		ids = append(ids, row.ID)
+	}
+	db.Exec(`
+        UPDATE outbox
+        SET Status = 'processing', LockedBy = ?, LockedUntil = ?
+        WHERE ID IN (`+strings.TrimSuffix(strings.Repeat("?,", len(out)), ",")+`)
+    `, ids...)
+
 	if err := tx.Commit(ctx); err != nil {
 		return nil, err
 	}
+	r.logger.Debug("Outbox: %s claimed %d rows until %s", owner, len(out), until.Format(time.RFC3339))
 	return out, nil
 }
-func (r *OutboxRepo) MarkPublished(ctx context.Context, id uint64) error {
-	var row domain.Outbox
-	if ok := r.engine.LoadByID(id, &row); !ok {
-		return repository.ErrNotFound
-	}
 
-	row.Status = "published"
-
-	fl := r.engine.NewFlusher()
-	fl.Track(&row)
-	return fl.FlushWithCheck()
+func (r *OutboxRepo) MarkPublished(ctx context.Context, id uint64, owner string) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return execAffecting(tx, repository.ErrLeaseLost, `
+        UPDATE outbox
+        SET Status = 'published', LockedBy = NULL, LockedUntil = NULL
+        WHERE ID = ? AND Status = 'processing' AND LockedBy = ?
+    `, id, owner)
+	})
 }
 
-func (r *OutboxRepo) MarkFailed(ctx context.Context, id uint64, nextAvailableAt string, errMsg string) error {
-	var row domain.Outbox
-	if ok := r.engine.LoadByID(id, &row); !ok {
-		return repository.ErrNotFound
-	}
-
+func (r *OutboxRepo) MarkFailed(ctx context.Context, id uint64, owner string, nextAvailableAt string, errMsg string) error {
 	ts, err := time.Parse(time.RFC3339, nextAvailableAt)
 	if err != nil {
 		if t2, err2 := time.Parse("2006-01-02 15:04:05", nextAvailableAt); err2 == nil {
@@ -168,13 +159,145 @@ func (r *OutboxRepo) MarkFailed(ctx context.Context, id uint64, nextAvailableAt
 		}
 	}
 
-	row.Status = "pending"
-	row.Attempts = row.Attempts + 1
-	row.AvailableAt = ts
+	errMsg = truncateErr(errMsg)
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		if err := execAffecting(tx, repository.ErrLeaseLost, `
+        UPDATE outbox
+        SET Status = 'failed', Attempts = Attempts + 1, AvailableAt = ?, LastError = ?, LockedBy = NULL, LockedUntil = NULL
+        WHERE ID = ? AND Status = 'processing' AND LockedBy = ?
+    `, ts, errMsg, id, owner); err != nil {
+			return err
+		}
+		return recordAttempt(tx, id, owner, errMsg)
+	})
+}
 
-	fl := r.engine.NewFlusher()
-	fl.Track(&row)
-	return fl.FlushWithCheck()
+// MarkDead parks the row in the dead-letter state. The dispatcher stops
+// claiming it until an operator requeues it.
+func (r *OutboxRepo) MarkDead(ctx context.Context, id uint64, owner string, errMsg string) error {
+	errMsg = truncateErr(errMsg)
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		if err := execAffecting(tx, repository.ErrLeaseLost, `
+        UPDATE outbox
+        SET Status = 'dead', Attempts = Attempts + 1, LastError = ?, DeadAt = ?, LockedBy = NULL, LockedUntil = NULL
+        WHERE ID = ? AND Status = 'processing' AND LockedBy = ?
+    `, errMsg, time.Now().UTC(), id, owner); err != nil {
+			return err
+		}
+		return recordAttempt(tx, id, owner, errMsg)
+	})
+}
+
+// ListDead pages with LIMIT/OFFSET directly: a BeeORM pager only moves in
+// whole pages, so an offset that is not a multiple of limit would be lost.
+func (r *OutboxRepo) ListDead(ctx context.Context, limit, offset int) (out []*domain.Outbox, total int64, err error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			out, total, err = nil, 0, fmt.Errorf("outbox dead-letter listing failed: %v", rec)
+		}
+	}()
+
+	db := r.engine.GetMysql()
+	counted, closeCount := db.Query(`SELECT COUNT(*) FROM outbox WHERE Status = ?`, domain.OutboxStatusDead)
+	if counted.Next() {
+		counted.Scan(&total)
+	}
+	closeCount()
+
+	rows, close := db.Query(`
+    SELECT ID, AggregateType, AggregateID, EventType, Payload, Headers, Status, Attempts,
+           AvailableAt, CreatedAt, LockedBy, LockedUntil, LastError, DeadAt
+    FROM outbox
+    WHERE Status = ?
+    ORDER BY DeadAt DESC, ID DESC
+    LIMIT ? OFFSET ?
+`, domain.OutboxStatusDead, limit, offset)
+	defer close()
+	for rows.Next() {
+		row := &domain.Outbox{}
+		rows.Scan(&row.ID, &row.AggregateType, &row.AggregateID, &row.EventType, &row.Payload, &row.Headers, &row.Status, &row.Attempts,
+			&row.AvailableAt, &row.CreatedAt, &row.LockedBy, &row.LockedUntil, &row.LastError, &row.DeadAt)
+		out = append(out, row)
+	}
+	return out, total, nil
+}
+
+func (r *OutboxRepo) GetDead(ctx context.Context, id uint64) (*domain.Outbox, error) {
+	var row domain.Outbox
+	where := beeorm.NewWhere("ID = ? AND Status = ?", id, domain.OutboxStatusDead)
+	if ok := r.engine.SearchOne(where, &row); !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &row, nil
+}
+
+func (r *OutboxRepo) ListAttempts(ctx context.Context, outboxID uint64) ([]*domain.OutboxAttempt, error) {
+	var attempts []*domain.OutboxAttempt
+	where := beeorm.NewWhere("OutboxID = ? ORDER BY Attempt, ID", outboxID)
+	r.engine.Search(where, beeorm.NewPager(1, 1000), &attempts)
+	return attempts, nil
+}
+
+func (r *OutboxRepo) Requeue(ctx context.Context, id uint64) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return execAffecting(tx, repository.ErrNotFound, `
+        UPDATE outbox
+        SET Status = 'pending', Attempts = 0, AvailableAt = ?, DeadAt = NULL, LockedBy = NULL, LockedUntil = NULL
+        WHERE ID = ? AND Status = 'dead'
+    `, time.Now().UTC(), id)
+	})
+}
+
+func (r *OutboxRepo) Purge(ctx context.Context, id uint64) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		if err := execAffecting(tx, repository.ErrNotFound, `DELETE FROM outbox WHERE ID = ? AND Status = 'dead'`, id); err != nil {
+			return err
+		}
+		return execAffecting(tx, nil, `DELETE FROM outbox_attempt WHERE OutboxID = ?`, id)
+	})
+}
+
+// execAffecting runs a guarded statement inside tx and returns missing when
+// it matched no rows (lease lost, or the row is not in the expected state).
+func execAffecting(tx *beeinfra.BeeTx, missing error, query string, args ...interface{}) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("update failed: %v", rec)
+		}
+	}()
+
+	res := tx.DB().Exec(query, args...)
+	if missing != nil && res.RowsAffected() == 0 {
+		return missing
+	}
+	return nil
+}
+
+// recordAttempt appends the failure that was just counted on the row to its
+// attempt history, in the same transaction as the status change.
+func recordAttempt(tx *beeinfra.BeeTx, id uint64, owner string, errMsg string) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("outbox attempt record failed: %v", rec)
+		}
+	}()
+
+	var attempt int
+	tx.DB().QueryRow(beeorm.NewWhere("SELECT Attempts FROM outbox WHERE ID = ?", id), &attempt)
+	tx.Track(&domain.OutboxAttempt{
+		OutboxID: id,
+		Attempt:  attempt,
+		Worker:   owner,
+		Error:    errMsg,
+		FailedAt: time.Now().UTC(),
+	})
+	return nil
 }
 
 func (r *OutboxRepo) GetByID(ctx context.Context, id uint64) (*domain.Outbox, error) {
@@ -191,14 +314,21 @@ func (r *OutboxRepo) Delete(ctx context.Context, id uint64) error {
 	if ok := r.engine.LoadByID(id, &row); !ok {
 		return repository.ErrNotFound
 	}
-	fl := r.engine.NewFlusher()
-	fl.Delete(&row)
-	return fl.FlushWithCheck()
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		tx.Delete(&row)
+		return nil
+	})
 }
 
+// truncateErr caps s at maxErrLen bytes without splitting a UTF-8 sequence,
+// which a utf8mb4 column would refuse.
 func truncateErr(s string) string {
-	if len(s) > 2000 {
-		return s[:2000]
+	if len(s) <= maxErrLen {
+		return s
+	}
+	cut := maxErrLen
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
 	}
-	return s
+	return s[:cut]
 }
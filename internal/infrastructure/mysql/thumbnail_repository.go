@@ -0,0 +1,83 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"git.ice.global/packages/beeorm/v4"
+	"github.com/delaram/GoTastic/internal/domain"
+	beeinfra "github.com/delaram/GoTastic/internal/infrastructure/beeorm"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+type ThumbnailRepository struct {
+	engine *beeorm.Engine
+	logger logger.Logger
+}
+
+func NewThumbnailRepository(engine *beeorm.Engine, logger logger.Logger) repository.ThumbnailRepository {
+	return &ThumbnailRepository{engine: engine, logger: logger}
+}
+
+func (r *ThumbnailRepository) List(ctx context.Context, fileIDs []string) (map[string][]*domain.FileThumbnail, error) {
+	out := make(map[string][]*domain.FileThumbnail, len(fileIDs))
+	if len(fileIDs) == 0 {
+		return out, nil
+	}
+	args := make([]interface{}, len(fileIDs))
+	for i, id := range fileIDs {
+		args[i] = id
+	}
+
+	var thumbs []*domain.FileThumbnail
+	in := strings.TrimSuffix(strings.Repeat("?,", len(fileIDs)), ",")
+	where := beeorm.NewWhere("FileID IN ("+in+") ORDER BY FileID, Size", args...)
+	r.engine.Search(where, beeorm.NewPager(1, 1000), &thumbs)
+	for _, t := range thumbs {
+		out[t.FileID] = append(out[t.FileID], t)
+	}
+	return out, nil
+}
+
+func (r *ThumbnailRepository) Save(ctx context.Context, thumbs []*domain.FileThumbnail) error {
+	for _, t := range thumbs {
+		var existing domain.FileThumbnail
+		if r.engine.SearchOne(beeorm.NewWhere("FileID = ? AND Size = ?", t.FileID, t.Size), &existing) {
+			return repository.ErrDuplicate
+		}
+	}
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		for _, t := range thumbs {
+			tx.Track(t)
+		}
+		return nil
+	})
+}
+
+func (r *ThumbnailRepository) Known(ctx context.Context, ids []string) (out map[string]bool, err error) {
+	out = make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			out, err = nil, fmt.Errorf("thumbnail lookup failed: %v", rec)
+		}
+	}()
+
+	in := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, close := r.engine.GetMysql().Query(`SELECT ThumbnailID FROM FileThumbnail WHERE ThumbnailID IN (`+in+`)`, args...)
+	defer close()
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		out[id] = true
+	}
+	return out, nil
+}
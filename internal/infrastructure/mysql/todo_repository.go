@@ -7,6 +7,7 @@ import (
 
 	"git.ice.global/packages/beeorm/v4"
 	"github.com/delaram/GoTastic/internal/domain"
+	beeinfra "github.com/delaram/GoTastic/internal/infrastructure/beeorm"
 	"github.com/delaram/GoTastic/internal/repository"
 	"github.com/delaram/GoTastic/pkg/logger"
 )
@@ -21,24 +22,24 @@ func NewTodoRepository(engine *beeorm.Engine, logger logger.Logger) repository.T
 }
 
 func (r *TodoRepository) BeginTx(ctx context.Context) (repository.Tx, error) {
-	// Reuses beeTx from the same package (defined in outbox repo file)
-	db := r.engine.GetMysql()
-	db.Begin()
-	return &beeTx{db: db}, nil
+	return beeinfra.BeginTx(r.engine), nil
 }
 
-// ---- CreateTx (kept simple; uses BeeORM flusher like your pattern) ----
-func (r *TodoRepository) CreateTx(ctx context.Context, _ repository.Tx, t *domain.TodoItem) error {
-	// If you truly need domain+outbox atomic, pass a shared Flusher/UoW instead.
-	fl := r.engine.NewFlusher()
-	fl.Track(t)
-	return fl.FlushWithCheck()
+// CreateTx stages the insert on the caller's unit of work; nothing reaches
+// MySQL until the transaction commits.
+func (r *TodoRepository) CreateTx(ctx context.Context, tx repository.Tx, t *domain.TodoItem) error {
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+	bt.Track(t)
+	return nil
 }
 
 func (r *TodoRepository) Create(ctx context.Context, todo *domain.TodoItem) error {
-	fl := r.engine.NewFlusher()
-	fl.Track(todo)
-	return fl.FlushWithCheck()
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return r.CreateTx(ctx, tx, todo)
+	})
 }
 
 func (r *TodoRepository) GetByID(ctx context.Context, id string) (*domain.TodoItem, error) {
@@ -59,7 +60,12 @@ func (r *TodoRepository) List(ctx context.Context) ([]*domain.TodoItem, error) {
 	return todos, nil
 }
 
-func (r *TodoRepository) Update(ctx context.Context, todo *domain.TodoItem) error {
+func (r *TodoRepository) UpdateTx(ctx context.Context, tx repository.Tx, todo *domain.TodoItem) error {
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+
 	var existing domain.TodoItem
 	if ok := r.engine.SearchOne(beeorm.NewWhere("UUID = ?", todo.UUID), &existing); !ok {
 		return repository.ErrNotFound
@@ -70,9 +76,37 @@ func (r *TodoRepository) Update(ctx context.Context, todo *domain.TodoItem) erro
 	existing.FileID = todo.FileID
 	existing.UpdatedAt = time.Now().UTC()
 
-	fl := r.engine.NewFlusher()
-	fl.Track(&existing)
-	return fl.FlushWithCheck()
+	bt.Track(&existing)
+	return nil
+}
+
+func (r *TodoRepository) Update(ctx context.Context, todo *domain.TodoItem) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return r.UpdateTx(ctx, tx, todo)
+	})
+}
+
+// UpdateStatusTx is a compare-and-set on Status: it only matches the row
+// while it still has the status the transition was validated against. Rows
+// written before the column existed are open.
+func (r *TodoRepository) UpdateStatusTx(ctx context.Context, tx repository.Tx, todo *domain.TodoItem, from domain.TodoStatus) error {
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE TodoItem SET Status = ?, CompletedAt = ?, UpdatedAt = ? WHERE UUID = ? AND Status = ?`
+	if from == domain.TodoStatusOpen {
+		query = `UPDATE TodoItem SET Status = ?, CompletedAt = ?, UpdatedAt = ? WHERE UUID = ? AND (Status = ? OR Status = '')`
+	}
+	return execAffecting(bt, domain.ErrInvalidStatusTransition, query,
+		todo.Status, todo.CompletedAt, time.Now().UTC(), todo.UUID, string(from))
+}
+
+func (r *TodoRepository) UpdateStatus(ctx context.Context, todo *domain.TodoItem, from domain.TodoStatus) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return r.UpdateStatusTx(ctx, tx, todo, from)
+	})
 }
 
 func (r *TodoRepository) ListPaged(
@@ -104,6 +138,14 @@ func (r *TodoRepository) ListPaged(
 			conds = append(conds, "(FileID IS NULL OR FileID = '')")
 		}
 	}
+	if len(f.Status) > 0 {
+		placeholders := make([]string, len(f.Status))
+		for i, st := range f.Status {
+			placeholders[i] = "?"
+			args = append(args, string(st))
+		}
+		conds = append(conds, "Status IN ("+strings.Join(placeholders, ",")+")")
+	}
 
 	// SORT
 	field := "UpdatedAt"
@@ -151,12 +193,22 @@ func (r *TodoRepository) ListPaged(
 	return todos, total, nil
 }
 
-func (r *TodoRepository) Delete(ctx context.Context, uuid string) error {
+func (r *TodoRepository) DeleteTx(ctx context.Context, tx repository.Tx, uuid string) error {
+	bt, err := beeinfra.FromTx(tx)
+	if err != nil {
+		return err
+	}
+
 	var todo domain.TodoItem
 	if ok := r.engine.SearchOne(beeorm.NewWhere("UUID = ?", uuid), &todo); !ok {
 		return repository.ErrNotFound
 	}
-	fl := r.engine.NewFlusher()
-	fl.Delete(&todo)
-	return fl.FlushWithCheck()
+	bt.Delete(&todo)
+	return nil
+}
+
+func (r *TodoRepository) Delete(ctx context.Context, uuid string) error {
+	return beeinfra.WithTx(ctx, r.engine, func(tx *beeinfra.BeeTx) error {
+		return r.DeleteTx(ctx, tx, uuid)
+	})
 }
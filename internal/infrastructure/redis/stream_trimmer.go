@@ -0,0 +1,61 @@
+package redisinfra
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/redis/go-redis/v9"
+)
+
+// retentionStats is published on /debug/vars as "stream_retention", keyed
+// "<stream>.trimmed", "<stream>.trim_errors" and "<stream>.length".
+var retentionStats = expvar.NewMap("stream_retention")
+
+// StreamTrimmer trims with the approximate (~) forms of XTRIM, which only
+// drop whole radix-tree nodes. Publishers trim inline on XADD; this is the
+// periodic catch-up.
+type StreamTrimmer struct {
+	client redis.Cmdable
+}
+
+func NewStreamTrimmer(client redis.Cmdable) *StreamTrimmer {
+	return &StreamTrimmer{client: client}
+}
+
+func (t *StreamTrimmer) Trim(ctx context.Context, stream string, policy repository.StreamRetention) (trimmed int64, err error) {
+	defer func() {
+		retentionStats.Add(stream+".trimmed", trimmed)
+		if err != nil {
+			retentionStats.Add(stream+".trim_errors", 1)
+		}
+	}()
+
+	if policy.MaxLen > 0 {
+		n, err := t.client.XTrimMaxLenApprox(ctx, stream, policy.MaxLen, 0).Result()
+		if err != nil {
+			return trimmed, err
+		}
+		trimmed += n
+	}
+	if policy.MaxAge > 0 {
+		n, err := t.client.XTrimMinIDApprox(ctx, stream, policy.MinID(time.Now()), 0).Result()
+		if err != nil {
+			return trimmed, err
+		}
+		trimmed += n
+	}
+	return trimmed, nil
+}
+
+func (t *StreamTrimmer) Len(ctx context.Context, stream string) (int64, error) {
+	n, err := t.client.XLen(ctx, stream).Result()
+	if err != nil {
+		return 0, err
+	}
+	length := new(expvar.Int)
+	length.Set(n)
+	retentionStats.Set(stream+".length", length)
+	return n, nil
+}
@@ -0,0 +1,122 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Server-side encryption modes.
+const (
+	// SSES3 encrypts with keys S3 manages.
+	SSES3 = "sse-s3"
+	// SSEKMS encrypts with a KMS key: KMSKeyID, or the account's AWS managed
+	// key for S3 if that is empty.
+	SSEKMS = "sse-kms"
+	// SSEC encrypts with CustomerKey, which S3 does not keep: every read has
+	// to send it again, so objects cannot be read through presigned URLs.
+	SSEC = "sse-c"
+)
+
+// Encryption is the server-side encryption S3 applies to uploaded objects.
+// The zero value leaves it to the bucket's default.
+type Encryption struct {
+	Mode        string
+	KMSKeyID    string
+	CustomerKey []byte
+}
+
+// ParseEncryption builds an Encryption from config, where the SSE-C
+// customer key is base64 encoded.
+func ParseEncryption(mode, kmsKeyID, customerKey string) (Encryption, error) {
+	switch mode {
+	case "", SSES3, SSEKMS:
+		return Encryption{Mode: mode, KMSKeyID: kmsKeyID}, nil
+	case SSEC:
+		key, err := base64.StdEncoding.DecodeString(customerKey)
+		if err != nil {
+			return Encryption{}, fmt.Errorf("s3: SSE-C customer key is not base64: %w", err)
+		}
+		if len(key) != 32 {
+			return Encryption{}, fmt.Errorf("s3: SSE-C customer key must be 32 bytes, not %d", len(key))
+		}
+		return Encryption{Mode: mode, CustomerKey: key}, nil
+	default:
+		return Encryption{}, fmt.Errorf("s3: unknown server-side encryption %q", mode)
+	}
+}
+
+// WithEncryption sets the server-side encryption of uploaded objects. With
+// SSEC the customer key is also sent on every read.
+func WithEncryption(e Encryption) Option {
+	return func(r *FileRepository) {
+		r.sse = sseHeaders{}
+		switch e.Mode {
+		case SSES3:
+			r.sse.encryption = types.ServerSideEncryptionAes256
+		case SSEKMS:
+			r.sse.encryption = types.ServerSideEncryptionAwsKms
+			if e.KMSKeyID != "" {
+				r.sse.kmsKeyID = aws.String(e.KMSKeyID)
+			}
+		case SSEC:
+			sum := md5.Sum(e.CustomerKey)
+			r.sse.customer = &customerKey{
+				algorithm: aws.String("AES256"),
+				key:       aws.String(base64.StdEncoding.EncodeToString(e.CustomerKey)),
+				keyMD5:    aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+			}
+		}
+	}
+}
+
+// sseHeaders are the encryption parameters each request carries.
+type sseHeaders struct {
+	encryption types.ServerSideEncryption
+	kmsKeyID   *string
+	customer   *customerKey
+}
+
+type customerKey struct {
+	algorithm *string
+	key       *string
+	keyMD5    *string
+}
+
+func (h sseHeaders) put(in *s3.PutObjectInput) {
+	in.ServerSideEncryption = h.encryption
+	in.SSEKMSKeyId = h.kmsKeyID
+	if c := h.customer; c != nil {
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = c.algorithm, c.key, c.keyMD5
+	}
+}
+
+func (h sseHeaders) createMultipart(in *s3.CreateMultipartUploadInput) {
+	in.ServerSideEncryption = h.encryption
+	in.SSEKMSKeyId = h.kmsKeyID
+	if c := h.customer; c != nil {
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = c.algorithm, c.key, c.keyMD5
+	}
+}
+
+func (h sseHeaders) uploadPart(in *s3.UploadPartInput) {
+	if c := h.customer; c != nil {
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = c.algorithm, c.key, c.keyMD5
+	}
+}
+
+func (h sseHeaders) get(in *s3.GetObjectInput) {
+	if c := h.customer; c != nil {
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = c.algorithm, c.key, c.keyMD5
+	}
+}
+
+func (h sseHeaders) head(in *s3.HeadObjectInput) {
+	if c := h.customer; c != nil {
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = c.algorithm, c.key, c.keyMD5
+	}
+}
@@ -0,0 +1,114 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3/s3test"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/repository/filerepotest"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCustomerKey = bytes.Repeat([]byte{7}, 32)
+
+func TestFileRepositoryConformanceWithSSEC(t *testing.T) {
+	filerepotest.Run(t, func(t *testing.T) repository.FileRepository {
+		return NewFileRepository(s3test.NewMemoryAPI(), "bucket", WithPartSize(minPartSize),
+			WithEncryption(Encryption{Mode: SSEC, CustomerKey: testCustomerKey}))
+	})
+}
+
+func TestUploadRequestsServerSideEncryption(t *testing.T) {
+	for name, tc := range map[string]struct {
+		enc  Encryption
+		want s3test.Encryption
+	}{
+		"default": {Encryption{}, s3test.Encryption{}},
+		"sse-s3":  {Encryption{Mode: SSES3}, s3test.Encryption{Mode: types.ServerSideEncryptionAes256}},
+		"sse-kms": {Encryption{Mode: SSEKMS, KMSKeyID: "alias/files"}, s3test.Encryption{Mode: types.ServerSideEncryptionAwsKms, KMSKeyID: "alias/files"}},
+		"sse-c":   {Encryption{Mode: SSEC, CustomerKey: testCustomerKey}, s3test.Encryption{CustomerKeyMD5: "y4HAEFCYWuvAXWFTtA1Qpg=="}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := s3test.NewMemoryAPI()
+			repo := NewFileRepository(api, "bucket", WithPartSize(minPartSize), WithEncryption(tc.enc))
+
+			small, err := repo.Upload(context.Background(), bytes.NewReader([]byte("hello")), "a.txt")
+			if !assert.NoError(t, err) {
+				return
+			}
+			large, err := repo.Upload(context.Background(), bytes.NewReader(make([]byte, minPartSize+1)), "b.bin")
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, tc.want, api.Encryption(small))
+			assert.Equal(t, tc.want, api.Encryption(large), "multipart uploads are encrypted too")
+		})
+	}
+}
+
+func TestSSECObjectsNeedTheKey(t *testing.T) {
+	api := s3test.NewMemoryAPI()
+	repo := NewFileRepository(api, "bucket", WithEncryption(Encryption{Mode: SSEC, CustomerKey: testCustomerKey}))
+	id, err := repo.Upload(context.Background(), bytes.NewReader([]byte("secret")), "a.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = NewFileRepository(api, "bucket").Download(context.Background(), id)
+	assert.Error(t, err)
+
+	content, err := repo.Open(context.Background(), id)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(data))
+}
+
+func TestParseEncryption(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testCustomerKey)
+
+	enc, err := ParseEncryption(SSEC, "", key)
+	assert.NoError(t, err)
+	assert.Equal(t, testCustomerKey, enc.CustomerKey)
+
+	enc, err = ParseEncryption(SSEKMS, "alias/files", "")
+	assert.NoError(t, err)
+	assert.Equal(t, Encryption{Mode: SSEKMS, KMSKeyID: "alias/files"}, enc)
+
+	_, err = ParseEncryption(SSEC, "", "not base64!")
+	assert.Error(t, err)
+	_, err = ParseEncryption(SSEC, "", base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+	_, err = ParseEncryption("rot13", "", "")
+	assert.Error(t, err)
+}
+
+func TestPresignUploadSignsEncryption(t *testing.T) {
+	repo := newPresigningRepo(WithEncryption(Encryption{Mode: SSEKMS, KMSKeyID: "alias/files"}))
+
+	_, presigned, err := repo.PresignUpload(context.Background(), "report.pdf", "application/pdf", 1234)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "aws:kms", presigned.Headers["X-Amz-Server-Side-Encryption"])
+	assert.Equal(t, "alias/files", presigned.Headers["X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"])
+}
+
+func TestPresignWithSSECIsUnsupported(t *testing.T) {
+	repo := newPresigningRepo(WithEncryption(Encryption{Mode: SSEC, CustomerKey: testCustomerKey}))
+
+	_, _, err := repo.PresignUpload(context.Background(), "a.txt", "text/plain", 1)
+	assert.Equal(t, repository.ErrPresignUnsupported, err)
+	_, err = repo.PresignDownload(context.Background(), "a.txt", "a.txt")
+	assert.Equal(t, repository.ErrPresignUnsupported, err)
+}
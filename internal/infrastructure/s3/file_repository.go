@@ -1,58 +1,200 @@
 package s3
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"errors"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 	"github.com/delaram/GoTastic/internal/repository"
 	"github.com/google/uuid"
 )
 
 
+// DefaultPartSize is the part size of multipart uploads. Bodies that fit in
+// one part are sent with a single PutObject.
+const DefaultPartSize = 8 << 20
+
+// minPartSize is the smallest part S3 accepts, except for the last one.
+const minPartSize = 5 << 20
+
+// maxParts is the most parts one multipart upload can have.
+const maxParts = 10000
+
+// API is the subset of *s3.Client the repository uses.
+type API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+
 type FileRepository struct {
-	client     *s3.Client
-	bucketName string
+	client        API
+	bucketName    string
+	partSize      int
+	buffers       sync.Pool
+	presigner     Presigner
+	presignExpiry time.Duration
+	sse           sseHeaders
+}
+
+// Option configures a FileRepository.
+type Option func(*FileRepository)
+
+// WithPartSize sets the multipart part size, and so the memory one upload
+// holds at a time. Values below S3's 5 MiB minimum are raised to it.
+func WithPartSize(n int) Option {
+	return func(r *FileRepository) {
+		if n < minPartSize {
+			n = minPartSize
+		}
+		r.partSize = n
+	}
 }
 
 
-func NewFileRepository(client *s3.Client, bucketName string) repository.FileRepository {
-	return &FileRepository{
-		client:     client,
-		bucketName: bucketName,
+func NewFileRepository(client API, bucketName string, opts ...Option) repository.FileRepository {
+	r := &FileRepository{
+		client:        client,
+		bucketName:    bucketName,
+		partSize:      DefaultPartSize,
+		presignExpiry: DefaultPresignExpiry,
+	}
+	if c, ok := client.(*s3.Client); ok {
+		r.presigner = s3.NewPresignClient(c)
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.buffers.New = func() interface{} {
+		buf := make([]byte, r.partSize)
+		return &buf
 	}
+	return r
 }
 
 
+// Upload streams file to S3 holding at most one part in memory: a body that
+// fits in one part is sent with PutObject, anything larger as a multipart
+// upload, which is aborted if reading or uploading fails.
 func (r *FileRepository) Upload(ctx context.Context, file io.Reader, filename string) (string, error) {
 
 	fileID := uuid.New().String() + filepath.Ext(filename)
 
+	bufp := r.buffers.Get().(*[]byte)
+	defer r.buffers.Put(bufp)
+	buf := *bufp
 
-	_, err := r.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(r.bucketName),
-		Key:    aws.String(fileID),
-		Body:   file,
-	})
-
+	n, err := io.ReadFull(file, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		in := &s3.PutObjectInput{
+			Bucket:        aws.String(r.bucketName),
+			Key:           aws.String(fileID),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		}
+		r.sse.put(in)
+		if _, err := r.client.PutObject(ctx, in); err != nil {
+			return "", err
+		}
+		return fileID, nil
+	}
 	if err != nil {
 		return "", err
 	}
 
+	if err := r.uploadMultipart(ctx, fileID, file, buf); err != nil {
+		return "", err
+	}
 	return fileID, nil
 }
 
+// uploadMultipart uploads buf, which holds the first full part, and the rest
+// of file as a multipart upload under key.
+func (r *FileRepository) uploadMultipart(ctx context.Context, key string, file io.Reader, buf []byte) (err error) {
+	create := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(r.bucketName),
+		Key:    aws.String(key),
+	}
+	r.sse.createMultipart(create)
+	created, err := r.client.CreateMultipartUpload(ctx, create)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Abort even if ctx was cancelled, or the parts are billed forever.
+		if _, abortErr := r.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(r.bucketName),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		}); abortErr != nil {
+			err = errors.Join(err, fmt.Errorf("abort multipart upload: %w", abortErr))
+		}
+	}()
+
+	var parts []types.CompletedPart
+	n := len(buf)
+	for number := int32(1); n > 0; number++ {
+		if number > maxParts {
+			return fmt.Errorf("upload exceeds %d parts of %d bytes", maxParts, len(buf))
+		}
+		part := &s3.UploadPartInput{
+			Bucket:        aws.String(r.bucketName),
+			Key:           aws.String(key),
+			UploadId:      created.UploadId,
+			PartNumber:    aws.Int32(number),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		}
+		r.sse.uploadPart(part)
+		out, err := r.client.UploadPart(ctx, part)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+
+		n, err = io.ReadFull(file, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+
+	_, err = r.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(r.bucketName),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
 
 func (r *FileRepository) Download(ctx context.Context, fileID string) (io.ReadCloser, error) {
-	result, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+	in := &s3.GetObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(fileID),
-	})
+	}
+	r.sse.get(in)
+	result, err := r.client.GetObject(ctx, in)
 
 	if err != nil {
 		return nil, err
@@ -73,16 +215,47 @@ func (r *FileRepository) Delete(ctx context.Context, fileID string) error {
 
 		
 func (r *FileRepository) Exists(ctx context.Context, fileID string) (bool, error) {
-	_, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+	in := &s3.HeadObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(fileID),
-	})
+	}
+	r.sse.head(in)
+	_, err := r.client.HeadObject(ctx, in)
 	if err != nil {
-		var apiErr smithy.APIError
-		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound" {
+		if isNotFound(err) {
 			return false, nil
 		}
 		return false, err
 	}
 	return true, nil
 }
+
+// Walk lists the bucket a page at a time.
+func (r *FileRepository) Walk(ctx context.Context, fn func(repository.StoredFile) error) error {
+	in := &s3.ListObjectsV2Input{Bucket: aws.String(r.bucketName)}
+	for {
+		out, err := r.client.ListObjectsV2(ctx, in)
+		if err != nil {
+			return err
+		}
+		for _, obj := range out.Contents {
+			if err := fn(repository.StoredFile{
+				ID:      aws.ToString(obj.Key),
+				Size:    aws.ToInt64(obj.Size),
+				ModTime: aws.ToTime(obj.LastModified),
+			}); err != nil {
+				return err
+			}
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return nil
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+// isNotFound reports whether a HeadObject failed because there is no such key.
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound"
+}
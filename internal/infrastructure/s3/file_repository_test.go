@@ -0,0 +1,147 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3/s3test"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/repository/filerepotest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPI records what the repository sends to S3. Parts are stored by
+// number so the final object can be reassembled.
+type fakeAPI struct {
+	API
+	mu        sync.Mutex
+	objects   map[string][]byte
+	parts     map[int32][]byte
+	partSizes []int
+	completed bool
+	aborted   bool
+	partErr   error
+	ranges    []string
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{objects: map[string][]byte{}, parts: map[int32][]byte{}}
+}
+
+func (f *fakeAPI) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[*in.Key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeAPI) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (f *fakeAPI) UploadPart(ctx context.Context, in *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if f.partErr != nil {
+		return nil, f.partErr
+	}
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.parts[*in.PartNumber] = data
+	f.partSizes = append(f.partSizes, len(data))
+	return &s3.UploadPartOutput{ETag: aws.String("etag")}, nil
+}
+
+func (f *fakeAPI) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var object []byte
+	for _, part := range in.MultipartUpload.Parts {
+		object = append(object, f.parts[*part.PartNumber]...)
+	}
+	f.objects[*in.Key] = object
+	f.completed = true
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeAPI) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestFileRepositoryConformance(t *testing.T) {
+	filerepotest.Run(t, func(t *testing.T) repository.FileRepository {
+		return NewFileRepository(s3test.NewMemoryAPI(), "bucket", WithPartSize(minPartSize))
+	})
+}
+
+func TestUploadSendsSmallBodiesWithPutObject(t *testing.T) {
+	api := newFakeAPI()
+	repo := NewFileRepository(api, "bucket")
+
+	id, err := repo.Upload(context.Background(), bytes.NewReader([]byte("hello")), "a.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), api.objects[id])
+	assert.Empty(t, api.partSizes)
+}
+
+func TestUploadSplitsLargeBodiesIntoParts(t *testing.T) {
+	api := newFakeAPI()
+	repo := NewFileRepository(api, "bucket", WithPartSize(minPartSize))
+	body := make([]byte, 2*minPartSize+123)
+	for i := range body {
+		body[i] = byte(i)
+	}
+
+	// A plain io.Reader, so the repository cannot learn the size up front.
+	id, err := repo.Upload(context.Background(), io.MultiReader(bytes.NewReader(body)), "big.bin")
+
+	assert.NoError(t, err)
+	assert.True(t, api.completed)
+	assert.Equal(t, []int{minPartSize, minPartSize, 123}, api.partSizes)
+	assert.Equal(t, body, api.objects[id])
+}
+
+func TestUploadAbortsMultipartUploadOnFailure(t *testing.T) {
+	api := newFakeAPI()
+	api.partErr = errors.New("connection reset")
+	repo := NewFileRepository(api, "bucket", WithPartSize(minPartSize))
+
+	_, err := repo.Upload(context.Background(), bytes.NewReader(make([]byte, minPartSize+1)), "big.bin")
+
+	assert.ErrorIs(t, err, api.partErr)
+	assert.True(t, api.aborted)
+	assert.False(t, api.completed)
+}
+
+func TestUploadAbortsWhenTheBodyFails(t *testing.T) {
+	api := newFakeAPI()
+	repo := NewFileRepository(api, "bucket", WithPartSize(minPartSize))
+	readErr := errors.New("body too large")
+	body := io.MultiReader(bytes.NewReader(make([]byte, minPartSize+10)), &failingReader{err: readErr})
+
+	_, err := repo.Upload(context.Background(), body, "big.bin")
+
+	assert.ErrorIs(t, err, readErr)
+	assert.True(t, api.aborted)
+	assert.False(t, api.completed)
+}
+
+type failingReader struct{ err error }
+
+func (r *failingReader) Read(p []byte) (int, error) { return 0, r.err }
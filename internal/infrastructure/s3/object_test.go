@@ -0,0 +1,75 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func (f *fakeAPI) HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NotFound"}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(data))),
+		ETag:          aws.String(`"v1"`),
+		LastModified:  aws.Time(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+	}, nil
+}
+
+// GetObject only understands the open-ended ranges the repository sends.
+func (f *fakeAPI) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ranges = append(f.ranges, aws.ToString(in.Range))
+	if aws.ToString(in.IfMatch) != `"v1"` {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	var from int
+	if _, err := fmt.Sscanf(aws.ToString(in.Range), "bytes=%d-", &from); err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(f.objects[*in.Key][from:]))}, nil
+}
+
+func TestOpenReadsFromTheSeekOffset(t *testing.T) {
+	api := newFakeAPI()
+	api.objects["a.txt"] = []byte("hello world")
+	repo := NewFileRepository(api, "bucket")
+
+	content, err := repo.Open(context.Background(), "a.txt")
+	assert.NoError(t, err)
+	defer content.Close()
+	assert.Equal(t, `"v1"`, content.ETag())
+
+	size, err := content.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), size)
+	assert.Empty(t, api.ranges, "finding the size must not fetch the object")
+
+	_, err = content.Seek(6, io.SeekStart)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(content)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+	assert.Equal(t, []string{"bytes=6-"}, api.ranges)
+}
+
+func TestOpenMissingObject(t *testing.T) {
+	repo := NewFileRepository(newFakeAPI(), "bucket")
+
+	_, err := repo.Open(context.Background(), "missing.txt")
+	assert.Equal(t, repository.ErrNotFound, err)
+}
@@ -0,0 +1,107 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/google/uuid"
+)
+
+// DefaultPresignExpiry is how long presigned URLs stay valid.
+const DefaultPresignExpiry = 15 * time.Minute
+
+var errNoPresigner = errors.New("s3: no presigner configured")
+
+// Presigner is the subset of *s3.PresignClient the repository uses.
+type Presigner interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// WithPresigner sets what signs URLs. By default the repository presigns
+// with its own client, which only works if clients reach S3 at the same
+// endpoint; pass a presigner built on a client for the public endpoint
+// otherwise.
+func WithPresigner(p Presigner) Option {
+	return func(r *FileRepository) {
+		r.presigner = p
+	}
+}
+
+// WithPresignExpiry sets how long presigned URLs stay valid.
+func WithPresignExpiry(d time.Duration) Option {
+	return func(r *FileRepository) {
+		if d > 0 {
+			r.presignExpiry = d
+		}
+	}
+}
+
+// PresignUpload and PresignDownload are repository.ErrPresignUnsupported
+// with SSE-C, whose key the client would need to send.
+func (r *FileRepository) PresignUpload(ctx context.Context, filename, contentType string, size int64) (string, *repository.PresignedURL, error) {
+	if r.presigner == nil {
+		return "", nil, errNoPresigner
+	}
+	if r.sse.customer != nil {
+		return "", nil, repository.ErrPresignUnsupported
+	}
+	fileID := uuid.New().String() + filepath.Ext(filename)
+	// Content type and length are signed, so S3 refuses any other body.
+	// So is the encryption, which the client sends as a header.
+	in := &s3.PutObjectInput{
+		Bucket:        aws.String(r.bucketName),
+		Key:           aws.String(fileID),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}
+	r.sse.put(in)
+	req, err := r.presigner.PresignPutObject(ctx, in, s3.WithPresignExpires(r.presignExpiry))
+	if err != nil {
+		return "", nil, err
+	}
+	return fileID, r.presigned(req), nil
+}
+
+func (r *FileRepository) PresignDownload(ctx context.Context, fileID, filename string) (*repository.PresignedURL, error) {
+	if r.presigner == nil {
+		return nil, errNoPresigner
+	}
+	if r.sse.customer != nil {
+		return nil, repository.ErrPresignUnsupported
+	}
+	req, err := r.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(r.bucketName),
+		Key:                        aws.String(fileID),
+		ResponseContentDisposition: aws.String(mime.FormatMediaType("attachment", map[string]string{"filename": filename})),
+	}, s3.WithPresignExpires(r.presignExpiry))
+	if err != nil {
+		return nil, err
+	}
+	return r.presigned(req), nil
+}
+
+func (r *FileRepository) presigned(req *v4.PresignedHTTPRequest) *repository.PresignedURL {
+	headers := map[string]string{}
+	for name, values := range req.SignedHeader {
+		// Set by the client's HTTP stack itself; browsers refuse to set them.
+		if name == "Host" || name == "Content-Length" || len(values) == 0 {
+			continue
+		}
+		headers[http.CanonicalHeaderKey(name)] = values[0]
+	}
+	return &repository.PresignedURL{
+		URL:       req.URL,
+		Method:    req.Method,
+		Headers:   headers,
+		ExpiresAt: time.Now().Add(r.presignExpiry),
+	}
+}
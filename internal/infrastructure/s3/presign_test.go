@@ -0,0 +1,66 @@
+package s3
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// Presigning is done offline, so a client pointed nowhere is enough.
+func newPresigningRepo(opts ...Option) *FileRepository {
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint: aws.String("http://files.example.com"),
+		UsePathStyle: true,
+	})
+	return NewFileRepository(client, "bucket", opts...).(*FileRepository)
+}
+
+func TestPresignUploadSignsTypeAndLength(t *testing.T) {
+	repo := newPresigningRepo(WithPresignExpiry(5 * time.Minute))
+	var _ repository.FilePresigner = repo
+
+	id, presigned, err := repo.PresignUpload(context.Background(), "report.pdf", "application/pdf", 1234)
+
+	assert.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f-]{36}\.pdf$`, id)
+	assert.Equal(t, "PUT", presigned.Method)
+	u, err := url.Parse(presigned.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "files.example.com", u.Host)
+	assert.Equal(t, "/bucket/"+id, u.Path)
+	assert.Equal(t, "300", u.Query().Get("X-Amz-Expires"))
+	assert.Contains(t, u.Query().Get("X-Amz-SignedHeaders"), "content-length")
+	assert.Equal(t, map[string]string{"Content-Type": "application/pdf"}, presigned.Headers)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), presigned.ExpiresAt, time.Second)
+}
+
+func TestPresignDownloadNamesTheFile(t *testing.T) {
+	repo := newPresigningRepo()
+
+	presigned, err := repo.PresignDownload(context.Background(), "abc.pdf", "Q3 report.pdf")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "GET", presigned.Method)
+	u, err := url.Parse(presigned.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "/bucket/abc.pdf", u.Path)
+	assert.Equal(t, `attachment; filename="Q3 report.pdf"`, u.Query().Get("response-content-disposition"))
+	assert.Equal(t, "900", u.Query().Get("X-Amz-Expires"))
+}
+
+func TestPresignWithoutPresignerFails(t *testing.T) {
+	repo := NewFileRepository(newFakeAPI(), "bucket").(*FileRepository)
+
+	_, _, err := repo.PresignUpload(context.Background(), "a.txt", "text/plain", 1)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,258 @@
+// Package s3test provides an in-memory S3 for tests that need a working
+// s3.FileRepository rather than one that records calls.
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+	sse     Encryption
+}
+
+// Encryption is the server-side encryption an object was stored with.
+// Objects stored with a customer key (SSE-C) can only be read with it.
+type Encryption struct {
+	Mode           types.ServerSideEncryption
+	KMSKeyID       string
+	CustomerKeyMD5 string
+}
+
+// MemoryAPI implements the s3.API subset the file repository uses. It keeps
+// one bucket's worth of objects, whatever bucket a request names, and only
+// understands the open-ended "bytes=N-" ranges the repository sends.
+type MemoryAPI struct {
+	mu         sync.Mutex
+	objects    map[string]*object
+	uploads    map[string]map[int32][]byte
+	uploadsSSE map[string]Encryption
+	nextID     int
+}
+
+func NewMemoryAPI() *MemoryAPI {
+	return &MemoryAPI{objects: map[string]*object{}, uploads: map[string]map[int32][]byte{}, uploadsSSE: map[string]Encryption{}}
+}
+
+func (m *MemoryAPI) put(key string, data []byte, sse Encryption) {
+	sum := md5.Sum(data)
+	m.objects[key] = &object{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, modTime: time.Now().UTC(), sse: sse}
+}
+
+// get finds key, refusing it unless customerKeyMD5 matches the key an SSE-C
+// object was stored with.
+func (m *MemoryAPI) get(key string, customerKeyMD5 *string) (*object, error) {
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NotFound"}
+	}
+	if obj.sse.CustomerKeyMD5 != aws.ToString(customerKeyMD5) {
+		return nil, &smithy.GenericAPIError{Code: "InvalidRequest", Message: "the customer key does not match"}
+	}
+	return obj, nil
+}
+
+func (m *MemoryAPI) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(aws.ToString(in.Key), data, Encryption{
+		Mode:           in.ServerSideEncryption,
+		KMSKeyID:       aws.ToString(in.SSEKMSKeyId),
+		CustomerKeyMD5: aws.ToString(in.SSECustomerKeyMD5),
+	})
+	return &s3.PutObjectOutput{ETag: aws.String(m.objects[aws.ToString(in.Key)].etag)}, nil
+}
+
+func (m *MemoryAPI) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, err := m.get(aws.ToString(in.Key), in.SSECustomerKeyMD5)
+	if err != nil {
+		if _, ok := m.objects[aws.ToString(in.Key)]; ok {
+			return nil, err
+		}
+		return nil, &smithy.GenericAPIError{Code: "NoSuchKey"}
+	}
+	if in.IfMatch != nil && aws.ToString(in.IfMatch) != obj.etag {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	var from int
+	if in.Range != nil {
+		if _, err := fmt.Sscanf(aws.ToString(in.Range), "bytes=%d-", &from); err != nil {
+			return nil, err
+		}
+		if from > len(obj.data) {
+			return nil, &smithy.GenericAPIError{Code: "InvalidRange"}
+		}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(obj.data[from:])),
+		ContentLength: aws.Int64(int64(len(obj.data) - from)),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.modTime),
+	}, nil
+}
+
+func (m *MemoryAPI) HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, err := m.get(aws.ToString(in.Key), in.SSECustomerKeyMD5)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.data))),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.modTime),
+	}, nil
+}
+
+// DeleteObject succeeds for missing keys, as S3 does.
+func (m *MemoryAPI) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, aws.ToString(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *MemoryAPI) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	id := strconv.Itoa(m.nextID)
+	m.uploads[id] = map[int32][]byte{}
+	m.uploadsSSE[id] = Encryption{
+		Mode:           in.ServerSideEncryption,
+		KMSKeyID:       aws.ToString(in.SSEKMSKeyId),
+		CustomerKeyMD5: aws.ToString(in.SSECustomerKeyMD5),
+	}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (m *MemoryAPI) UploadPart(ctx context.Context, in *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts, ok := m.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchUpload"}
+	}
+	if m.uploadsSSE[aws.ToString(in.UploadId)].CustomerKeyMD5 != aws.ToString(in.SSECustomerKeyMD5) {
+		return nil, &smithy.GenericAPIError{Code: "InvalidRequest", Message: "the customer key does not match"}
+	}
+	parts[aws.ToInt32(in.PartNumber)] = data
+	return &s3.UploadPartOutput{ETag: aws.String(strconv.Itoa(int(aws.ToInt32(in.PartNumber))))}, nil
+}
+
+func (m *MemoryAPI) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts, ok := m.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchUpload"}
+	}
+	completed := append(in.MultipartUpload.Parts[:0:0], in.MultipartUpload.Parts...)
+	sort.Slice(completed, func(i, j int) bool {
+		return aws.ToInt32(completed[i].PartNumber) < aws.ToInt32(completed[j].PartNumber)
+	})
+	var data []byte
+	for _, part := range completed {
+		data = append(data, parts[aws.ToInt32(part.PartNumber)]...)
+	}
+	sse := m.uploadsSSE[aws.ToString(in.UploadId)]
+	delete(m.uploads, aws.ToString(in.UploadId))
+	delete(m.uploadsSSE, aws.ToString(in.UploadId))
+	m.put(aws.ToString(in.Key), data, sse)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (m *MemoryAPI) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, aws.ToString(in.UploadId))
+	delete(m.uploadsSSE, aws.ToString(in.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// ListObjectsV2 pages through the keys in order, 1000 at a time unless
+// MaxKeys asks for fewer.
+func (m *MemoryAPI) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.objects))
+	for key := range m.objects {
+		if key > aws.ToString(in.ContinuationToken) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	limit := 1000
+	if n := int(aws.ToInt32(in.MaxKeys)); n > 0 && n < limit {
+		limit = n
+	}
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(len(keys) > limit)}
+	if len(keys) > limit {
+		keys = keys[:limit]
+		out.NextContinuationToken = aws.String(keys[limit-1])
+	}
+	for _, key := range keys {
+		obj := m.objects[key]
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(obj.data))),
+			ETag:         aws.String(obj.etag),
+			LastModified: aws.Time(obj.modTime),
+		})
+	}
+	out.KeyCount = aws.Int32(int32(len(out.Contents)))
+	return out, nil
+}
+
+// Touch sets when key was last modified, so tests can age objects.
+func (m *MemoryAPI) Touch(key string, modTime time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if obj, ok := m.objects[key]; ok {
+		obj.modTime = modTime
+	}
+}
+
+// Encryption is how key was encrypted when stored.
+func (m *MemoryAPI) Encryption(key string) Encryption {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if obj, ok := m.objects[key]; ok {
+		return obj.sse
+	}
+	return Encryption{}
+}
+
+// Len is the number of stored objects.
+func (m *MemoryAPI) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.objects)
+}
@@ -0,0 +1,65 @@
+// Package storage picks the FileRepository backend from config, so callers
+// only ever see repository.FileRepository.
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/delaram/GoTastic/internal/infrastructure/envelope"
+	"github.com/delaram/GoTastic/internal/infrastructure/localfs"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
+)
+
+const (
+	DriverS3    = "s3"
+	DriverLocal = "local"
+)
+
+// New builds the file repository selected by cfg.Driver, encrypting files
+// before they reach it if cfg has an EncryptionKey. s3Cfg is only used by
+// the S3 driver; when it has a PublicEndpoint, URLs are presigned for it.
+func New(cfg config.StorageConfig, s3Cfg config.S3Config) (repository.FileRepository, error) {
+	repo, err := newBackend(cfg, s3Cfg)
+	if err != nil || cfg.EncryptionKey == "" {
+		return repo, err
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("storage encryption key is not base64: %w", err)
+	}
+	return envelope.NewFileRepository(repo, key)
+}
+
+func newBackend(cfg config.StorageConfig, s3Cfg config.S3Config) (repository.FileRepository, error) {
+	switch cfg.Driver {
+	case "", DriverS3:
+		sse, err := s3.ParseEncryption(s3Cfg.SSE, s3Cfg.SSEKMSKeyID, s3Cfg.SSECustomerKey)
+		if err != nil {
+			return nil, err
+		}
+		client, err := s3.NewS3Client(s3Cfg.Endpoint, s3Cfg.Region, s3Cfg.AccessKey, s3Cfg.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		opts := []s3.Option{s3.WithPartSize(s3Cfg.PartSize), s3.WithPresignExpiry(s3Cfg.PresignExpiry), s3.WithEncryption(sse)}
+		if s3Cfg.PublicEndpoint != "" && s3Cfg.PublicEndpoint != s3Cfg.Endpoint {
+			public, err := s3.NewS3Client(s3Cfg.PublicEndpoint, s3Cfg.Region, s3Cfg.AccessKey, s3Cfg.SecretKey)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, s3.WithPresigner(s3sdk.NewPresignClient(public)))
+		}
+		return s3.NewFileRepository(client, s3Cfg.Bucket, opts...), nil
+	case DriverLocal:
+		if cfg.LocalRoot == "" {
+			return nil, fmt.Errorf("storage driver %q needs a local root", DriverLocal)
+		}
+		return localfs.NewFileRepository(cfg.LocalRoot)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
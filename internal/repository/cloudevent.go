@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CloudEventsSpecVersion is the CloudEvents version every stream message follows.
+const CloudEventsSpecVersion = "1.0"
+
+// Stream entry fields. Each entry carries the structured-mode event JSON in
+// StreamFieldEvent; StreamFieldType duplicates the event type so consumers can
+// route without decoding the envelope.
+const (
+	StreamFieldType  = "type"
+	StreamFieldEvent = "event"
+)
+
+// EventMeta identifies an occurrence independently of its payload. Events
+// relayed from the outbox use the outbox row ID as ID and its stored headers
+// as Headers.
+type EventMeta struct {
+	ID      string
+	Type    string
+	Time    time.Time
+	Headers map[string]string
+}
+
+// CloudEvent is a CloudEvents 1.0 event in structured JSON mode. Extensions
+// are serialized as top-level attributes next to the context attributes.
+type CloudEvent struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	Data            json.RawMessage
+	Extensions      map[string]string
+}
+
+var reservedAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true,
+	"subject": true, "time": true, "datacontenttype": true,
+	"dataschema": true, "data": true, "data_base64": true,
+}
+
+var (
+	ErrMissingSpecVersion = errors.New("cloudevent: missing specversion")
+	// ErrBinaryData is returned for events carrying data_base64: Data only
+	// holds JSON.
+	ErrBinaryData = errors.New("cloudevent: binary data (data_base64) is not supported")
+)
+
+// validExtensionName reports whether name can be serialized as an extension
+// attribute: lower-case ASCII letters and digits, and not a context attribute.
+func validExtensionName(name string) bool {
+	if name == "" || reservedAttributes[name] {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ExtensionName maps an arbitrary header key onto a valid extension attribute
+// name: lower-case ASCII letters and digits only. Keys that would shadow a
+// context attribute are prefixed with "x" (source -> xsource).
+func ExtensionName(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(key) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	name := b.String()
+	if reservedAttributes[name] {
+		name = "x" + name
+	}
+	return name
+}
+
+// SetExtension stores value under the sanitized form of key.
+func (e *CloudEvent) SetExtension(key, value string) {
+	name := ExtensionName(key)
+	if name == "" {
+		return
+	}
+	if e.Extensions == nil {
+		e.Extensions = make(map[string]string)
+	}
+	e.Extensions[name] = value
+}
+
+// MarshalJSON flattens Extensions next to the context attributes. An
+// extension name that is invalid or would shadow a context attribute is an
+// error; SetExtension never produces one.
+func (e CloudEvent) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, 8+len(e.Extensions))
+	for k, v := range e.Extensions {
+		if !validExtensionName(k) {
+			return nil, fmt.Errorf("cloudevent: invalid extension attribute name %q", k)
+		}
+		out[k] = v
+	}
+	specVersion := e.SpecVersion
+	if specVersion == "" {
+		specVersion = CloudEventsSpecVersion
+	}
+	out["specversion"] = specVersion
+	out["id"] = e.ID
+	out["source"] = e.Source
+	out["type"] = e.Type
+	if e.Subject != "" {
+		out["subject"] = e.Subject
+	}
+	if !e.Time.IsZero() {
+		out["time"] = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if len(e.Data) > 0 {
+		contentType := e.DataContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		out["datacontenttype"] = contentType
+		out["data"] = e.Data
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads a structured-mode event. It must have a specversion,
+// and its data must be JSON rather than data_base64.
+func (e *CloudEvent) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*e = CloudEvent{}
+	str := func(key string) (string, error) {
+		v, ok := raw[key]
+		if !ok {
+			return "", nil
+		}
+		var s string
+		err := json.Unmarshal(v, &s)
+		return s, err
+	}
+
+	var err error
+	if e.SpecVersion, err = str("specversion"); err != nil {
+		return err
+	}
+	if e.SpecVersion == "" {
+		return ErrMissingSpecVersion
+	}
+	if _, ok := raw["data_base64"]; ok {
+		return ErrBinaryData
+	}
+	if e.ID, err = str("id"); err != nil {
+		return err
+	}
+	if e.Source, err = str("source"); err != nil {
+		return err
+	}
+	if e.Type, err = str("type"); err != nil {
+		return err
+	}
+	if e.Subject, err = str("subject"); err != nil {
+		return err
+	}
+	if e.DataContentType, err = str("datacontenttype"); err != nil {
+		return err
+	}
+	ts, err := str("time")
+	if err != nil {
+		return err
+	}
+	if ts != "" {
+		if e.Time, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+			return err
+		}
+	}
+	if data, ok := raw["data"]; ok {
+		e.Data = data
+	}
+
+	for k, v := range raw {
+		if reservedAttributes[k] {
+			continue
+		}
+		if !validExtensionName(k) {
+			return fmt.Errorf("cloudevent: invalid extension attribute name %q", k)
+		}
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			// Non-string extensions are kept in their JSON form.
+			s = string(v)
+		}
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[k] = s
+	}
+	return nil
+}
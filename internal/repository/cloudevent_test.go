@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudEventRoundTrip(t *testing.T) {
+	ev := CloudEvent{
+		ID:      "42",
+		Source:  "/todos",
+		Type:    "todo.created",
+		Subject: "t1",
+		Time:    time.Date(2024, 5, 1, 12, 0, 0, 123, time.UTC),
+		Data:    json.RawMessage(`{"uuid":"t1"}`),
+	}
+	ev.SetExtension("traceparent", "00-abc-def-01")
+	ev.SetExtension("X-Request-ID", "req-1")
+
+	b, err := json.Marshal(ev)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Extensions are flattened next to the context attributes.
+	var flat map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &flat))
+	assert.Equal(t, "1.0", flat["specversion"])
+	assert.Equal(t, "application/json", flat["datacontenttype"])
+	assert.Equal(t, "00-abc-def-01", flat["traceparent"])
+	assert.Equal(t, "req-1", flat["xrequestid"])
+	assert.NotContains(t, flat, "Extensions")
+
+	var got CloudEvent
+	if !assert.NoError(t, json.Unmarshal(b, &got)) {
+		return
+	}
+	ev.SpecVersion = CloudEventsSpecVersion
+	ev.DataContentType = "application/json"
+	assert.Equal(t, ev, got)
+}
+
+func TestExtensionNameSanitizes(t *testing.T) {
+	assert.Equal(t, "xrequestid", ExtensionName("X-Request-ID"))
+	assert.Equal(t, "xsource", ExtensionName("Source"), "context attributes are not shadowed")
+	assert.Equal(t, "xdata", ExtensionName("data"))
+	assert.Equal(t, "", ExtensionName("--"))
+
+	var ev CloudEvent
+	ev.SetExtension("--", "dropped")
+	assert.Empty(t, ev.Extensions)
+}
+
+func TestCloudEventMarshalRejectsBadExtensionNames(t *testing.T) {
+	for _, name := range []string{"id", "data", "Trace-Parent", "", "naïve"} {
+		_, err := json.Marshal(CloudEvent{ID: "1", Source: "/s", Type: "t", Extensions: map[string]string{name: "v"}})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestCloudEventUnmarshal(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json    string
+		wantErr error
+		check   func(t *testing.T, ev CloudEvent)
+	}{
+		"json data": {
+			json: `{"specversion":"1.0","id":"1","source":"/s","type":"t","data":{"a":1}}`,
+			check: func(t *testing.T, ev CloudEvent) {
+				assert.JSONEq(t, `{"a":1}`, string(ev.Data))
+			},
+		},
+		"non-string extension": {
+			json: `{"specversion":"1.0","id":"1","source":"/s","type":"t","retries":3}`,
+			check: func(t *testing.T, ev CloudEvent) {
+				assert.Equal(t, map[string]string{"retries": "3"}, ev.Extensions)
+			},
+		},
+		"missing specversion": {
+			json:    `{"id":"1","source":"/s","type":"t"}`,
+			wantErr: ErrMissingSpecVersion,
+		},
+		"data_base64": {
+			json:    `{"specversion":"1.0","id":"1","source":"/s","type":"t","data_base64":"aGk="}`,
+			wantErr: ErrBinaryData,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var ev CloudEvent
+			err := json.Unmarshal([]byte(tc.json), &ev)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			if assert.NoError(t, err) {
+				tc.check(t, ev)
+			}
+		})
+	}
+
+	var ev CloudEvent
+	err := json.Unmarshal([]byte(`{"specversion":"1.0","id":"1","source":"/s","type":"t","Bad-Name":"v"}`), &ev)
+	assert.Error(t, err, "invalid extension names are refused")
+	err = json.Unmarshal([]byte(`{"specversion":"1.0","id":"1","source":"/s","type":"t","time":"yesterday"}`), &ev)
+	assert.Error(t, err)
+}
@@ -0,0 +1,39 @@
+package repository
+
+import "github.com/delaram/GoTastic/internal/domain"
+
+// FileUploaded is the payload of a file.uploaded event, and the data of the
+// CloudEvent it is published as.
+type FileUploaded struct {
+	FileID      string `json:"file_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// FileScanned is the payload of a file.scanned event: the file, as
+// file.uploaded describes it, and the verdict recorded on it.
+type FileScanned struct {
+	FileUploaded
+	ScanStatus    string  `json:"scan_status"`
+	ScanSignature *string `json:"scan_signature,omitempty"`
+}
+
+func NewFileScanned(file *domain.File) FileScanned {
+	return FileScanned{
+		FileUploaded:  NewFileUploaded(file),
+		ScanStatus:    file.ScanStatus,
+		ScanSignature: file.ScanSignature,
+	}
+}
+
+func NewFileUploaded(file *domain.File) FileUploaded {
+	return FileUploaded{
+		FileID:      file.FileID,
+		Filename:    file.Filename,
+		ContentType: file.ContentType,
+		Size:        file.Size,
+		SHA256:      file.SHA256,
+	}
+}
@@ -0,0 +1,205 @@
+// Package filerepotest is a conformance suite for repository.FileRepository
+// backends. Each backend runs it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		filerepotest.Run(t, func(t *testing.T) repository.FileRepository { ... })
+//	}
+package filerepotest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// LargeSize is bigger than one S3 multipart part, so backends that split
+// uploads have to reassemble them.
+const LargeSize = 5<<20 + 123
+
+// Run checks the FileRepository contract against a fresh repository from
+// newRepo in every subtest.
+func Run(t *testing.T, newRepo func(t *testing.T) repository.FileRepository) {
+	ctx := context.Background()
+
+	t.Run("UploadThenDownload", func(t *testing.T) {
+		repo := newRepo(t)
+
+		id, err := repo.Upload(ctx, strings.NewReader("hello world"), "notes.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, ".txt", filepath.Ext(id), "ids keep the extension")
+
+		assert.Equal(t, "hello world", download(t, repo, id))
+	})
+
+	t.Run("UploadLargeFile", func(t *testing.T) {
+		repo := newRepo(t)
+		body := make([]byte, LargeSize)
+		for i := range body {
+			body[i] = byte(i * 7)
+		}
+
+		// A plain io.Reader, so the size is not known up front.
+		id, err := repo.Upload(ctx, io.MultiReader(bytes.NewReader(body)), "big.bin")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, string(body), download(t, repo, id))
+	})
+
+	t.Run("UploadPicksNewIDs", func(t *testing.T) {
+		repo := newRepo(t)
+
+		a, err := repo.Upload(ctx, strings.NewReader("a"), "same.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+		b, err := repo.Upload(ctx, strings.NewReader("b"), "same.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.NotEqual(t, a, b)
+		assert.Equal(t, "a", download(t, repo, a))
+		assert.Equal(t, "b", download(t, repo, b))
+	})
+
+	t.Run("UploadFailsWithTheBody", func(t *testing.T) {
+		repo := newRepo(t)
+		readErr := errors.New("body too large")
+
+		_, err := repo.Upload(ctx, io.MultiReader(strings.NewReader("partial"), &failingReader{err: readErr}), "a.txt")
+
+		assert.ErrorIs(t, err, readErr)
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		repo := newRepo(t)
+		id, err := repo.Upload(ctx, strings.NewReader("x"), "a.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		exists, err := repo.Exists(ctx, id)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = repo.Exists(ctx, "missing.txt")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("OpenSeeksAndReads", func(t *testing.T) {
+		repo := newRepo(t)
+		id, err := repo.Upload(ctx, strings.NewReader("hello world"), "a.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		content, err := repo.Open(ctx, id)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer content.Close()
+
+		etag := content.ETag()
+		assert.True(t, len(etag) > 2 && strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`), "ETag %s is not quoted", etag)
+		assert.False(t, content.ModTime().IsZero())
+
+		size, err := content.Seek(0, io.SeekEnd)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(11), size)
+
+		_, err = content.Seek(6, io.SeekStart)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(content)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(data))
+	})
+
+	t.Run("OpenMissingFile", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.Open(ctx, "missing.txt")
+		assert.Equal(t, repository.ErrNotFound, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repo := newRepo(t)
+		id, err := repo.Upload(ctx, strings.NewReader("x"), "a.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if !assert.NoError(t, repo.Delete(ctx, id)) {
+			return
+		}
+
+		exists, err := repo.Exists(ctx, id)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		_, err = repo.Open(ctx, id)
+		assert.Equal(t, repository.ErrNotFound, err)
+		assert.NoError(t, repo.Delete(ctx, id), "deleting twice is not an error")
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		repo := newRepo(t)
+		lister, ok := repo.(repository.FileLister)
+		if !ok {
+			t.Skip("repository cannot list its files")
+		}
+		kept, err := repo.Upload(ctx, strings.NewReader("kept"), "a.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+		deleted, err := repo.Upload(ctx, strings.NewReader("x"), "b.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+		if !assert.NoError(t, repo.Delete(ctx, deleted)) {
+			return
+		}
+
+		var files []repository.StoredFile
+		assert.NoError(t, lister.Walk(ctx, func(f repository.StoredFile) error {
+			files = append(files, f)
+			return nil
+		}))
+		if assert.Len(t, files, 1) {
+			assert.Equal(t, kept, files[0].ID)
+			assert.Equal(t, int64(4), files[0].Size)
+			assert.False(t, files[0].ModTime.IsZero())
+		}
+
+		stop := errors.New("stop")
+		assert.Equal(t, stop, lister.Walk(ctx, func(repository.StoredFile) error { return stop }))
+	})
+}
+
+func download(t *testing.T, repo repository.FileRepository, id string) string {
+	t.Helper()
+	rc, err := repo.Download(context.Background(), id)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	return string(data)
+}
+
+type failingReader struct{ err error }
+
+func (r *failingReader) Read(p []byte) (int, error) { return 0, r.err }
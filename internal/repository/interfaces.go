@@ -9,7 +9,13 @@ import (
 )
 
 var (
-	ErrNotFound = NewError("not found")
+	ErrNotFound  = NewError("not found")
+	ErrLeaseLost = NewError("outbox lease lost")
+	ErrDuplicate = NewError("already exists")
+
+	// ErrPresignUnsupported is returned by a FilePresigner that cannot
+	// presign URLs for the files it holds.
+	ErrPresignUnsupported = NewError("presigned URLs are not supported")
 )
 
 type Error struct {
@@ -32,7 +38,14 @@ type TodoRepository interface {
 	List(ctx context.Context) ([]*domain.TodoItem, error)
 	ListPaged(ctx context.Context, f domain.TodoFilter, s domain.TodoSort, limit, offset int) ([]*domain.TodoItem, int64, error)
 	Update(ctx context.Context, todo *domain.TodoItem) error
+	UpdateTx(ctx context.Context, tx Tx, todo *domain.TodoItem) error
+	// UpdateStatus writes todo's status only if the stored one is still
+	// from, the status the transition was checked against, and is
+	// domain.ErrInvalidStatusTransition otherwise.
+	UpdateStatus(ctx context.Context, todo *domain.TodoItem, from domain.TodoStatus) error
+	UpdateStatusTx(ctx context.Context, tx Tx, todo *domain.TodoItem, from domain.TodoStatus) error
 	Delete(ctx context.Context, id string) error
+	DeleteTx(ctx context.Context, tx Tx, id string) error
 }
 
 type FileRepository interface {
@@ -40,14 +53,158 @@ type FileRepository interface {
 	Download(ctx context.Context, id string) (io.ReadCloser, error)
 	Delete(ctx context.Context, id string) error
 	Exists(ctx context.Context, id string) (bool, error)
+	// Open is ErrNotFound if there is no such file.
+	Open(ctx context.Context, id string) (FileContent, error)
+}
+
+// FileContent is a stored file opened for serving. Seeking is cheap: content
+// is only fetched from the seek position on the next Read, so byte ranges can
+// be served without reading the rest of the file. ETag is quoted, as in HTTP.
+type FileContent interface {
+	io.ReadSeekCloser
+	ETag() string
+	ModTime() time.Time
+}
+
+// StoredFile is a file as the FileRepository holds it, whether or not any
+// metadata was recorded for it.
+type StoredFile struct {
+	ID      string
+	Size    int64
+	ModTime time.Time
+}
+
+// FileLister is implemented by file repositories that can enumerate their
+// files. Walk calls fn for each file in no particular order and stops at
+// the first error fn returns.
+type FileLister interface {
+	Walk(ctx context.Context, fn func(StoredFile) error) error
+}
+
+// PresignedURL lets a client transfer a file straight to or from storage
+// until ExpiresAt. Headers must be sent with the request as given.
+type PresignedURL struct {
+	URL       string
+	Method    string
+	Headers   map[string]string
+	ExpiresAt time.Time
+}
+
+// FilePresigner is implemented by file repositories clients can reach
+// directly. PresignUpload picks the new file's id, like Upload does; the
+// URL only accepts a body of exactly size bytes of contentType.
+type FilePresigner interface {
+	PresignUpload(ctx context.Context, filename, contentType string, size int64) (string, *PresignedURL, error)
+	// PresignDownload serves the file as an attachment named filename.
+	PresignDownload(ctx context.Context, id, filename string) (*PresignedURL, error)
+}
+
+// ScanResult is a malware scanner's verdict on a file. Signature names what
+// was found in an infected one.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// FileScanner checks file content for malware. An error means the content
+// could not be scanned, not that it is infected.
+type FileScanner interface {
+	Scan(ctx context.Context, r io.Reader) (*ScanResult, error)
+}
+
+// FileMetadataRepository records what was uploaded: name, type, size and
+// uploader. Rows are keyed by the id the content has in the FileRepository.
+type FileMetadataRepository interface {
+	TxStarter
+	Create(ctx context.Context, file *domain.File) error
+	CreateTx(ctx context.Context, tx Tx, file *domain.File) error
+	// ConfirmTx records the checked name, type, size, hash and scan status of
+	// an unconfirmed file. It is ErrNotFound if the file is no longer
+	// unconfirmed, e.g. because it was confirmed or expired meanwhile.
+	ConfirmTx(ctx context.Context, tx Tx, file *domain.File) error
+	// DeleteUnconfirmed deletes the metadata of a file that is still
+	// unconfirmed, and is ErrNotFound if it no longer is.
+	DeleteUnconfirmed(ctx context.Context, fileID string) error
+	GetByFileID(ctx context.Context, fileID string) (*domain.File, error)
+	// List returns the newest files first, with the total matching f.
+	List(ctx context.Context, f domain.FileFilter, limit, offset int) ([]*domain.File, int64, error)
+	Delete(ctx context.Context, fileID string) error
+	// ListBySHA256 returns the files with the given content hash, oldest first.
+	ListBySHA256(ctx context.Context, sha256 string) ([]*domain.File, error)
+	// DuplicateHashes returns up to limit content hashes recorded for more
+	// than one file, in order, starting after the hash after.
+	DuplicateHashes(ctx context.Context, after string, limit int) ([]string, error)
+	// AddReferences adds n to a file's RefCount, with the file's row locked.
+	// It is ErrNotFound if the file was deleted, e.g. by DeleteLast.
+	AddReferences(ctx context.Context, fileID string, n int) error
+	// Release drops one reference to a file and returns how many are left.
+	// The last reference is not dropped: Release returns 0 and the caller
+	// deletes the file with DeleteLast.
+	Release(ctx context.Context, fileID string) (int, error)
+	// DeleteLast drops the reference Release kept. If it is still the last
+	// one, the file's metadata is deleted and DeleteLast returns 0;
+	// otherwise a reference was added since, and it returns how many are
+	// left. The row is locked like AddReferences locks it, so a file cannot
+	// gain a reference while it is being deleted.
+	DeleteLast(ctx context.Context, fileID string) (int, error)
+	// SetScanStatus records a file's scan verdict; signature is nil unless
+	// it is infected.
+	SetScanStatus(ctx context.Context, fileID string, status domain.FileScanStatus, signature *string) error
+	SetScanStatusTx(ctx context.Context, tx Tx, fileID string, status domain.FileScanStatus, signature *string) error
+}
+
+// FileReferenceRepository tells which files are still in use: referred to by
+// a todo, as its FileID or as an attachment, or a thumbnail of a file.
+type FileReferenceRepository interface {
+	// Referenced returns the subset of fileIDs that are in use.
+	Referenced(ctx context.Context, fileIDs []string) (map[string]bool, error)
+	// Repoint makes every todo that refers to from refer to to instead. A
+	// todo that already has both attached keeps a single attachment.
+	Repoint(ctx context.Context, from, to string) error
+}
+
+// ThumbnailRepository records the thumbnails generated for image files. A
+// file's thumbnails are deleted with its metadata.
+type ThumbnailRepository interface {
+	// List returns the thumbnails of each of fileIDs, smallest first. Files
+	// without thumbnails are left out of the map.
+	List(ctx context.Context, fileIDs []string) (map[string][]*domain.FileThumbnail, error)
+	// Save records thumbnails in one go. A file has one thumbnail per size;
+	// saving a size it already has is ErrDuplicate and saves nothing.
+	Save(ctx context.Context, thumbs []*domain.FileThumbnail) error
+	// Known returns the subset of ids that are stored thumbnails.
+	Known(ctx context.Context, ids []string) (map[string]bool, error)
+}
+
+// AttachmentRepository keeps the ordered list of files attached to each todo.
+type AttachmentRepository interface {
+	// List returns a todo's attachments in order.
+	List(ctx context.Context, todoUUID string) ([]*domain.TodoAttachment, error)
+	// Attach appends the attachment after the todo's last one. Attaching the
+	// same file twice is ErrDuplicate.
+	Attach(ctx context.Context, attachment *domain.TodoAttachment) error
+	// AttachTx is Attach on the caller's unit of work, so the attachment
+	// commits with the todo write it belongs to.
+	AttachTx(ctx context.Context, tx Tx, attachment *domain.TodoAttachment) error
+	Detach(ctx context.Context, todoUUID, fileID string) error
+	// Reorder sets the positions of the given attachments to their index in
+	// fileIDs.
+	Reorder(ctx context.Context, todoUUID string, fileIDs []string) error
 }
 
+// CacheRepository is a byte store for cached values; pkg/cache layers typed
+// values and codecs on top. Get returns nil, nil for a missing key. Keys can
+// be grouped under tags and evicted together with InvalidateTags.
 type CacheRepository interface {
-	Get(ctx context.Context, key string) (interface{}, error)
-	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
 	Delete(ctx context.Context, key string) error
+	Tag(ctx context.Context, key string, expiration time.Duration, tags ...string) error
+	InvalidateTags(ctx context.Context, tags ...string) error
 }
 
+// Tx is a unit of work: writes made through the *Tx repository methods are
+// only persisted when Commit succeeds.
 type Tx interface {
 	Commit(ctx context.Context) error
 	Rollback(ctx context.Context) error
@@ -57,6 +214,10 @@ type TxStarter interface {
 	BeginTx(ctx context.Context) (Tx, error)
 }
 
+// StreamPublisher writes todo events to the stream as CloudEvents.
+// PublishTodoItem emits a todo.snapshot event with a generated id;
+// PublishTodoEvent takes its identity from meta (the outbox row).
 type StreamPublisher interface {
 	PublishTodoItem(ctx context.Context, todo *domain.TodoItem) error
+	PublishTodoEvent(ctx context.Context, meta EventMeta, todo *domain.TodoItem) error
 }
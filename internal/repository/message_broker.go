@@ -6,9 +6,13 @@ import (
 )
 
 
+// MessageBroker is the transport events leave the service through. stream
+// names the destination (a Redis stream, a NATS subject prefix, ...); message
+// is encoded with EncodeMessage.
 type MessageBroker interface {
 
 	Publish(ctx context.Context, stream string, message interface{}) error
+	Close() error
 }
 
 
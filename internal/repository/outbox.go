@@ -1,7 +1,32 @@
 // internal/repository/outbox.go
 package repository
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+)
+
+// Event types written to the outbox for the todo aggregate.
+const (
+	EventTodoCreated = "todo.created"
+	EventTodoUpdated = "todo.updated"
+	EventTodoDeleted = "todo.deleted"
+
+	// EventTodoSnapshot carries the current state of a todo outside the
+	// outbox, e.g. when re-publishing existing items.
+	EventTodoSnapshot = "todo.snapshot"
+)
+
+// Event types written to the outbox for the file aggregate. file.uploaded
+// is written when an upload's metadata is recorded, with a FileUploaded
+// payload; file.scanned when a malware scan records its verdict, with a
+// FileScanned payload.
+const (
+	EventFileUploaded = "file.uploaded"
+	EventFileScanned  = "file.scanned"
+)
 
 type OutboxMessage struct {
 	AggregateType string            // "todo"
@@ -15,12 +40,30 @@ type OutboxRepository interface {
 	// Insert within the same DB transaction as your aggregate write.
 	Insert(ctx context.Context, tx Tx, msg OutboxMessage) error
 
-	// Worker: fetch a batch of due messages and lock them for N seconds to avoid duplicate workers.
-	FetchAndLock(ctx context.Context, limit int, lockForSeconds int) ([]LockedOutboxRow, error)
+	// Worker: claim up to limit due messages for owner, leased for lockForSeconds.
+	// Rows held by another worker are skipped, not waited on; rows whose lease
+	// has run out are claimed again.
+	FetchAndLock(ctx context.Context, owner string, limit int, lockForSeconds int) ([]LockedOutboxRow, error)
+
+	// Mark publish result. All three return ErrLeaseLost when owner no longer
+	// holds the row, in which case another worker has taken it over. Failures
+	// keep errMsg as LastError and append it to the row's attempt history.
+	MarkPublished(ctx context.Context, id uint64, owner string) error
+	MarkFailed(ctx context.Context, id uint64, owner string, nextAvailableAt string, errMsg string) error
+	MarkDead(ctx context.Context, id uint64, owner string, errMsg string) error
+}
+
+// DeadLetterRepository backs the admin tooling for dead-lettered outbox rows.
+// Every method only sees rows in the dead state; anything else is ErrNotFound.
+type DeadLetterRepository interface {
+	ListDead(ctx context.Context, limit, offset int) ([]*domain.Outbox, int64, error)
+	GetDead(ctx context.Context, id uint64) (*domain.Outbox, error)
+	ListAttempts(ctx context.Context, outboxID uint64) ([]*domain.OutboxAttempt, error)
 
-	// Mark publish result.
-	MarkPublished(ctx context.Context, id uint64) error
-	MarkFailed(ctx context.Context, id uint64, nextAvailableAt string, errMsg string) error
+	// Requeue hands the row back to the dispatcher with a fresh attempt budget.
+	Requeue(ctx context.Context, id uint64) error
+	// Purge deletes the row together with its attempt history.
+	Purge(ctx context.Context, id uint64) error
 }
 
 type LockedOutboxRow struct {
@@ -30,4 +73,8 @@ type LockedOutboxRow struct {
 	EventType     string
 	Payload       []byte
 	Attempts      int
+	Headers       map[string]string
+	CreatedAt     time.Time
+	LockedBy      string
+	LockedUntil   time.Time
 }
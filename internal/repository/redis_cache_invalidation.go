@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/delaram/GoTastic/pkg/cache"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisInvalidationBus broadcasts L1 cache invalidations over a Redis pub/sub
+// channel.
+type RedisInvalidationBus struct {
+	logger  logger.Logger
+	client  *redis.Client
+	channel string
+}
+
+func NewRedisInvalidationBus(logger logger.Logger, client *redis.Client, channel string) *RedisInvalidationBus {
+	return &RedisInvalidationBus{
+		logger:  logger,
+		client:  client,
+		channel: channel,
+	}
+}
+
+func (b *RedisInvalidationBus) Publish(ctx context.Context, inv cache.Invalidation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	if err := b.client.Publish(ctx, b.channel, data).Err(); err != nil {
+		b.logger.Error("Failed to publish cache invalidation", err)
+		return err
+	}
+	return nil
+}
+
+// Subscribe delivers invalidations until ctx is done. Pub/sub drops messages
+// sent while the connection is down, so every (re)subscription is reported
+// as an invalidation of everything.
+func (b *RedisInvalidationBus) Subscribe(ctx context.Context, handle func(cache.Invalidation)) error {
+	sub := b.client.Subscribe(ctx, b.channel)
+	defer sub.Close()
+
+	ch := sub.ChannelWithSubscriptions()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			switch m := msg.(type) {
+			case *redis.Subscription:
+				if m.Kind == "subscribe" {
+					handle(cache.Invalidation{All: true})
+				}
+			case *redis.Message:
+				var inv cache.Invalidation
+				if err := json.Unmarshal([]byte(m.Payload), &inv); err != nil {
+					b.logger.Warn("Dropping malformed cache invalidation", err)
+					continue
+				}
+				handle(inv)
+			}
+		}
+	}
+}
@@ -2,7 +2,6 @@ package repository
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
 	"github.com/delaram/GoTastic/pkg/logger"
@@ -24,8 +23,8 @@ func NewRedisCacheRepository(logger logger.Logger, client *redis.Client) CacheRe
 }
 
 
-func (r *RedisCacheRepository) Get(ctx context.Context, key string) (interface{}, error) {
-	val, err := r.client.Get(ctx, key).Result()
+func (r *RedisCacheRepository) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := r.client.Get(ctx, key).Bytes()
 	if err == redis.Nil {
 		return nil, nil
 	}
@@ -33,23 +32,12 @@ func (r *RedisCacheRepository) Get(ctx context.Context, key string) (interface{}
 		r.logger.Error("Failed to get from cache", err)
 		return nil, err
 	}
-
-	var result interface{}
-	if err := json.Unmarshal([]byte(val), &result); err != nil {
-		r.logger.Error("Failed to unmarshal cache value", err)
-		return nil, err
-	}
-	return result, nil
+	return val, nil
 }
 
 
-func (r *RedisCacheRepository) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
-	data, err := json.Marshal(value)
-	if err != nil {
-		r.logger.Error("Failed to marshal cache value", err)
-		return err
-	}
-	if err := r.client.Set(ctx, key, data, expiration).Err(); err != nil {
+func (r *RedisCacheRepository) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	if err := r.client.Set(ctx, key, value, expiration).Err(); err != nil {
 		r.logger.Error("Failed to set cache value", err)
 		return err
 	}
@@ -64,3 +52,46 @@ func (r *RedisCacheRepository) Delete(ctx context.Context, key string) error {
 	}
 	return nil
 }
+
+// tagKey is the Redis set holding the keys cached under tag.
+func tagKey(tag string) string {
+	return "cachetag:" + tag
+}
+
+func (r *RedisCacheRepository) Tag(ctx context.Context, key string, expiration time.Duration, tags ...string) error {
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, tag := range tags {
+			p.SAdd(ctx, tagKey(tag), key)
+			if expiration > 0 {
+				p.Expire(ctx, tagKey(tag), expiration)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.Error("Failed to tag cache key", err)
+		return err
+	}
+	return nil
+}
+
+// invalidateTagScript deletes a tag's members and the tag in one step, so a
+// key tagged while we invalidate is either evicted now or kept in the set.
+var invalidateTagScript = redis.NewScript(`
+local members = redis.call('SMEMBERS', KEYS[1])
+for i = 1, #members, 500 do
+	redis.call('DEL', unpack(members, i, math.min(i + 499, #members)))
+end
+redis.call('DEL', KEYS[1])
+return #members
+`)
+
+func (r *RedisCacheRepository) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		if err := invalidateTagScript.Run(ctx, r.client, []string{tagKey(tag)}).Err(); err != nil {
+			r.logger.Error("Failed to invalidate cache tag "+tag, err)
+			return err
+		}
+	}
+	return nil
+}
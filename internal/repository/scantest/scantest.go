@@ -0,0 +1,76 @@
+// Package scantest provides a FileScanner for tests, so uploads can be
+// quarantined and released without a clamd daemon.
+package scantest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/delaram/GoTastic/internal/repository"
+)
+
+// EICAR is the standard antivirus test file. Real scanners report it as
+// infected without it being harmful, so it works against clamd too.
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// EICARSignature is what clamd names EICAR.
+const EICARSignature = "Win.Test.EICAR_HDB-1"
+
+// Scanner reports content containing one of its patterns as infected. While
+// an error is set with Fail, every scan fails with it.
+type Scanner struct {
+	mu       sync.Mutex
+	patterns map[string]string
+	err      error
+	scans    int
+}
+
+// New returns a Scanner that only knows EICAR.
+func New() *Scanner {
+	return &Scanner{patterns: map[string]string{EICAR: EICARSignature}}
+}
+
+var _ repository.FileScanner = (*Scanner)(nil)
+
+// Detect reports content containing pattern as infected with signature.
+func (s *Scanner) Detect(pattern, signature string) *Scanner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.patterns[pattern] = signature
+	return s
+}
+
+// Fail makes scans fail with err, as if the scanner was down; nil recovers.
+func (s *Scanner) Fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Scans is how many scans were attempted.
+func (s *Scanner) Scans() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scans
+}
+
+func (s *Scanner) Scan(ctx context.Context, r io.Reader) (*repository.ScanResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scans++
+	if s.err != nil {
+		return nil, s.err
+	}
+	for pattern, signature := range s.patterns {
+		if bytes.Contains(data, []byte(pattern)) {
+			return &repository.ScanResult{Infected: true, Signature: signature}, nil
+		}
+	}
+	return &repository.ScanResult{}, nil
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamRetention bounds a stream by entry count, entry age, or both. A zero
+// field means no limit of that kind.
+type StreamRetention struct {
+	MaxLen int64
+	MaxAge time.Duration
+}
+
+func (r StreamRetention) Enabled() bool {
+	return r.MaxLen > 0 || r.MaxAge > 0
+}
+
+// MinID is the oldest stream ID MaxAge keeps at now. Stream IDs start with
+// their millisecond timestamp, so this is what XTRIM MINID expects.
+func (r StreamRetention) MinID(now time.Time) string {
+	return fmt.Sprintf("%d-0", now.Add(-r.MaxAge).UnixMilli())
+}
+
+// Limit makes args trim the stream as XADD appends to it: MAXLEN ~ when
+// there is a length limit, otherwise MINID ~ for the age limit. XADD takes
+// one of the two, so with both set the age limit is left to the periodic
+// trim job.
+func (r StreamRetention) Limit(args *redis.XAddArgs, now time.Time) {
+	switch {
+	case r.MaxLen > 0:
+		args.MaxLen = r.MaxLen
+		args.Approx = true
+	case r.MaxAge > 0:
+		args.MinID = r.MinID(now)
+		args.Approx = true
+	}
+}
+
+// StreamTrimmer applies a retention policy to a stream and reports its size.
+type StreamTrimmer interface {
+	Trim(ctx context.Context, stream string, policy StreamRetention) (int64, error)
+	Len(ctx context.Context, stream string) (int64, error)
+}
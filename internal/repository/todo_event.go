@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/google/uuid"
+)
+
+// DefaultEventSource is the CloudEvents source attribute of everything this
+// service publishes.
+const DefaultEventSource = "/gotastic"
+
+// SnapshotMeta identifies a todo.snapshot event published outside the outbox.
+func SnapshotMeta() EventMeta {
+	return EventMeta{
+		ID:   uuid.NewString(),
+		Type: EventTodoSnapshot,
+		Time: time.Now().UTC(),
+	}
+}
+
+// NewTodoEvent wraps todo in a CloudEvent identified by meta. Every transport
+// publishes todos through this, so consumers see one payload shape whichever
+// broker is configured.
+func NewTodoEvent(meta EventMeta, source string, todo *domain.TodoItem) (*CloudEvent, error) {
+	data, err := todoEventData(todo)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              meta.ID,
+		Source:          source,
+		Type:            meta.Type,
+		Subject:         todo.UUID,
+		Time:            meta.Time,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	for k, v := range meta.Headers {
+		ev.SetExtension(k, v)
+	}
+	return ev, nil
+}
+
+// EncodeMessage turns a value handed to MessageBroker.Publish into its wire
+// body, along with its event type when it has one. CloudEvents are encoded in
+// structured mode.
+func EncodeMessage(message interface{}) (eventType string, body []byte, err error) {
+	switch m := message.(type) {
+	case *CloudEvent:
+		body, err = json.Marshal(m)
+		return m.Type, body, err
+	case CloudEvent:
+		body, err = json.Marshal(m)
+		return m.Type, body, err
+	case *Message:
+		body, err = json.Marshal(m)
+		return m.Type, body, err
+	case []byte:
+		return "", m, nil
+	default:
+		body, err = json.Marshal(m)
+		return "", body, err
+	}
+}
+
+func todoEventData(todo *domain.TodoItem) ([]byte, error) {
+	// NOTE: FileID is optional; if you use *string in domain, handle nil accordingly.
+	var dueStr string
+	if todo.DueDate != nil {
+		dueStr = todo.DueDate.UTC().Format(time.RFC3339Nano)
+	}
+	var fileStr string
+	if todo.FileID != nil {
+		fileStr = *todo.FileID
+	}
+	var completedStr string
+	if todo.CompletedAt != nil {
+		completedStr = todo.CompletedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	payload := struct {
+		ID          string `json:"id"`
+		Description string `json:"description"`
+		DueDate     string `json:"due_date,omitempty"`
+		FileID      string `json:"file_id,omitempty"`
+		Status      string `json:"status"`
+		CompletedAt string `json:"completed_at,omitempty"`
+		CreatedAt   string `json:"created_at"`
+		UpdatedAt   string `json:"updated_at"`
+	}{
+		ID:          todo.UUID, // public id
+		Description: todo.Description,
+		DueDate:     dueStr,
+		FileID:      fileStr,
+		Status:      string(todo.CurrentStatus()),
+		CompletedAt: completedStr,
+		CreatedAt:   todo.CreatedAt.UTC().Format(time.RFC3339Nano),
+		UpdatedAt:   todo.UpdatedAt.UTC().Format(time.RFC3339Nano),
+	}
+	return json.Marshal(payload)
+}
@@ -0,0 +1,143 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+var (
+	ErrAlreadyAttached        = errors.New("file is already attached to this todo")
+	ErrInvalidAttachmentOrder = errors.New("attachment order must list every attached file exactly once")
+)
+
+// AttachmentUseCase manages the files attached to a todo. TodoItem.FileID
+// predates it; migration 000008 copied those files into the attachments, and
+// TodoUseCase.WithAttachments attaches files set as FileID since.
+type AttachmentUseCase struct {
+	logger      logger.Logger
+	todoRepo    repository.TodoRepository
+	fileRepo    repository.FileRepository
+	attachments repository.AttachmentRepository
+	metadata    repository.FileMetadataRepository
+	scanning    bool
+}
+
+func NewAttachmentUseCase(logger logger.Logger,
+	todoRepo repository.TodoRepository,
+	fileRepo repository.FileRepository,
+	attachments repository.AttachmentRepository,
+) *AttachmentUseCase {
+	return &AttachmentUseCase{
+		logger:      logger,
+		todoRepo:    todoRepo,
+		fileRepo:    fileRepo,
+		attachments: attachments,
+	}
+}
+
+// WithQuarantine makes AttachFile refuse files that are waiting for, or
+// failed, their malware scan. With scanning, set when uploads are scanned,
+// files without metadata are refused too, since nothing scanned them.
+func (u *AttachmentUseCase) WithQuarantine(metadata repository.FileMetadataRepository, scanning bool) *AttachmentUseCase {
+	u.metadata, u.scanning = metadata, scanning
+	return u
+}
+
+// ListAttachments returns the todo's attachments in order, or
+// repository.ErrNotFound if there is no such todo.
+func (u *AttachmentUseCase) ListAttachments(ctx context.Context, todoID string) ([]*domain.TodoAttachment, error) {
+	if _, err := u.todoRepo.GetByID(ctx, todoID); err != nil {
+		return nil, err
+	}
+	attachments, err := u.attachments.List(ctx, todoID)
+	if err != nil {
+		u.logger.Error("Failed to list attachments", err)
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// AttachFile adds fileID after the todo's other attachments.
+func (u *AttachmentUseCase) AttachFile(ctx context.Context, todoID, fileID string) (*domain.TodoAttachment, error) {
+	if _, err := u.todoRepo.GetByID(ctx, todoID); err != nil {
+		return nil, err
+	}
+	exists, err := u.fileRepo.Exists(ctx, fileID)
+	if err != nil {
+		u.logger.Error("Failed to check file existence", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrFileNotFound
+	}
+	if u.metadata != nil {
+		if err := checkScan(ctx, u.metadata, fileID, u.scanning); err != nil {
+			return nil, err
+		}
+	}
+
+	attachment := &domain.TodoAttachment{
+		TodoUUID:  todoID,
+		FileID:    fileID,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := u.attachments.Attach(ctx, attachment); err != nil {
+		if err == repository.ErrDuplicate {
+			return nil, ErrAlreadyAttached
+		}
+		u.logger.Error("Failed to attach file", err)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// DetachFile removes fileID from the todo's attachments; the file itself is
+// kept. It is repository.ErrNotFound if the file was not attached.
+func (u *AttachmentUseCase) DetachFile(ctx context.Context, todoID, fileID string) error {
+	if err := u.attachments.Detach(ctx, todoID, fileID); err != nil {
+		if err != repository.ErrNotFound {
+			u.logger.Error("Failed to detach file", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReorderAttachments puts the todo's attachments in the order of fileIDs,
+// which must name each of them exactly once.
+func (u *AttachmentUseCase) ReorderAttachments(ctx context.Context, todoID string, fileIDs []string) ([]*domain.TodoAttachment, error) {
+	current, err := u.ListAttachments(ctx, todoID)
+	if err != nil {
+		return nil, err
+	}
+	if !sameFiles(current, fileIDs) {
+		return nil, ErrInvalidAttachmentOrder
+	}
+	if err := u.attachments.Reorder(ctx, todoID, fileIDs); err != nil {
+		u.logger.Error("Failed to reorder attachments", err)
+		return nil, err
+	}
+	return u.attachments.List(ctx, todoID)
+}
+
+func sameFiles(attachments []*domain.TodoAttachment, fileIDs []string) bool {
+	if len(attachments) != len(fileIDs) {
+		return false
+	}
+	want := make(map[string]bool, len(attachments))
+	for _, a := range attachments {
+		want[a.FileID] = true
+	}
+	for _, id := range fileIDs {
+		if !want[id] {
+			return false
+		}
+		delete(want, id)
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const attachmentTodoID = "0b7e7a5e-4a51-4f4e-9d2c-5c1d0c6f9a10"
+
+func newAttachmentUseCase() (*AttachmentUseCase, *MockTodoRepository, *MockFileRepository, *MockAttachmentRepository) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	todoRepo := new(MockTodoRepository)
+	fileRepo := new(MockFileRepository)
+	attachments := new(MockAttachmentRepository)
+	todoRepo.On("GetByID", mock.Anything, attachmentTodoID).Return(&domain.TodoItem{UUID: attachmentTodoID}, nil)
+	todoRepo.On("GetByID", mock.Anything, mock.Anything).Return(nil, repository.ErrNotFound)
+	return NewAttachmentUseCase(log, todoRepo, fileRepo, attachments), todoRepo, fileRepo, attachments
+}
+
+func TestAttachFile(t *testing.T) {
+	uc, _, fileRepo, attachments := newAttachmentUseCase()
+	fileRepo.On("Exists", mock.Anything, "a.pdf").Return(true, nil)
+	fileRepo.On("Exists", mock.Anything, "gone.pdf").Return(false, nil)
+	attachments.On("Attach", mock.Anything, mock.MatchedBy(func(a *domain.TodoAttachment) bool {
+		return a.TodoUUID == attachmentTodoID && a.FileID == "a.pdf"
+	})).Return(nil).Once()
+
+	attachment, err := uc.AttachFile(context.Background(), attachmentTodoID, "a.pdf")
+	assert.NoError(t, err)
+	assert.Equal(t, "a.pdf", attachment.FileID)
+
+	attachments.On("Attach", mock.Anything, mock.Anything).Return(repository.ErrDuplicate).Once()
+	_, err = uc.AttachFile(context.Background(), attachmentTodoID, "a.pdf")
+	assert.ErrorIs(t, err, ErrAlreadyAttached)
+
+	_, err = uc.AttachFile(context.Background(), attachmentTodoID, "gone.pdf")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+
+	_, err = uc.AttachFile(context.Background(), "no-such-todo", "a.pdf")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	attachments.AssertExpectations(t)
+}
+
+func TestReorderAttachments(t *testing.T) {
+	uc, _, _, attachments := newAttachmentUseCase()
+	current := []*domain.TodoAttachment{
+		{TodoUUID: attachmentTodoID, FileID: "a.pdf", Position: 0},
+		{TodoUUID: attachmentTodoID, FileID: "b.png", Position: 1},
+	}
+	attachments.On("List", mock.Anything, attachmentTodoID).Return(current, nil)
+	attachments.On("Reorder", mock.Anything, attachmentTodoID, []string{"b.png", "a.pdf"}).Return(nil)
+
+	_, err := uc.ReorderAttachments(context.Background(), attachmentTodoID, []string{"b.png", "a.pdf"})
+	assert.NoError(t, err)
+
+	for _, order := range [][]string{
+		{"b.png"},
+		{"b.png", "b.png"},
+		{"b.png", "a.pdf", "c.txt"},
+		{"b.png", "c.txt"},
+	} {
+		_, err := uc.ReorderAttachments(context.Background(), attachmentTodoID, order)
+		assert.ErrorIs(t, err, ErrInvalidAttachmentOrder, "%v", order)
+	}
+	attachments.AssertNumberOfCalls(t, "Reorder", 1)
+}
+
+func TestDetachFile(t *testing.T) {
+	uc, _, _, attachments := newAttachmentUseCase()
+	attachments.On("Detach", mock.Anything, attachmentTodoID, "a.pdf").Return(nil)
+	attachments.On("Detach", mock.Anything, attachmentTodoID, "b.png").Return(repository.ErrNotFound)
+
+	assert.NoError(t, uc.DetachFile(context.Background(), attachmentTodoID, "a.pdf"))
+	assert.ErrorIs(t, uc.DetachFile(context.Background(), attachmentTodoID, "b.png"), repository.ErrNotFound)
+}
@@ -0,0 +1,214 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+// dedupBatch is how many duplicated hashes are merged per query.
+const dedupBatch = 100
+
+// FileBackfillReport is what one backfill did, or with DryRun would do.
+// Indexed files had no metadata and now have their hash recorded; Merged
+// files were copies of an older file and now resolve to it, freeing
+// Reclaimed bytes.
+type FileBackfillReport struct {
+	DryRun    bool
+	Scanned   int
+	Indexed   int
+	Merged    int
+	Failed    int
+	Reclaimed int64
+}
+
+// FileDedupUseCase brings files stored before uploads were deduplicated
+// into the content index.
+type FileDedupUseCase struct {
+	logger   logger.Logger
+	fileRepo repository.FileRepository
+	metadata repository.FileMetadataRepository
+	refs     repository.FileReferenceRepository
+	thumbs   repository.ThumbnailRepository
+	policy   *FilePolicy
+}
+
+func NewFileDedupUseCase(logger logger.Logger, fileRepo repository.FileRepository, metadata repository.FileMetadataRepository, refs repository.FileReferenceRepository) *FileDedupUseCase {
+	return &FileDedupUseCase{
+		logger:   logger,
+		fileRepo: fileRepo,
+		metadata: metadata,
+		refs:     refs,
+		policy:   DefaultFilePolicy(),
+	}
+}
+
+// WithPolicy sets the policy used to type files that have no metadata.
+func (u *FileDedupUseCase) WithPolicy(policy *FilePolicy) *FileDedupUseCase {
+	u.policy = policy
+	return u
+}
+
+// WithThumbnails keeps the backfill from indexing stored thumbnails as files.
+func (u *FileDedupUseCase) WithThumbnails(thumbs repository.ThumbnailRepository) *FileDedupUseCase {
+	u.thumbs = thumbs
+	return u
+}
+
+// Backfill first hashes every stored file that has no metadata and records
+// it, then merges each group of files with the same content into the oldest:
+// todos referring to a copy are repointed, the copy's references are added to
+// the oldest file, and the copy is deleted. Files that fail are logged,
+// counted and skipped.
+func (u *FileDedupUseCase) Backfill(ctx context.Context, dryRun bool) (*FileBackfillReport, error) {
+	lister, ok := u.fileRepo.(repository.FileLister)
+	if !ok {
+		return nil, ErrFileGCUnsupported
+	}
+	report := &FileBackfillReport{DryRun: dryRun}
+
+	err := lister.Walk(ctx, func(f repository.StoredFile) error {
+		report.Scanned++
+		_, err := u.metadata.GetByFileID(ctx, f.ID)
+		if err != repository.ErrNotFound {
+			return err
+		}
+		if u.thumbs != nil {
+			known, err := u.thumbs.Known(ctx, []string{f.ID})
+			if err != nil {
+				return err
+			}
+			if known[f.ID] {
+				return nil
+			}
+		}
+		if !dryRun {
+			if err := u.index(ctx, f); err != nil {
+				u.logger.Error("File backfill: failed to index "+f.ID, err)
+				report.Failed++
+				return nil
+			}
+		}
+		report.Indexed++
+		return nil
+	})
+	if err != nil {
+		u.logger.Error("File backfill failed", err)
+		return report, err
+	}
+
+	if err := u.mergeAll(ctx, report); err != nil {
+		u.logger.Error("File backfill failed", err)
+		return report, err
+	}
+	u.logger.Info("File backfill: scanned %d files, indexed %d, merged %d (%d bytes), %d failed, dry run %t",
+		report.Scanned, report.Indexed, report.Merged, report.Reclaimed, report.Failed, dryRun)
+	return report, nil
+}
+
+// index hashes a stored file and records metadata for it, named after its id.
+// Its content type is the policy's if it passes, and unknown otherwise.
+func (u *FileDedupUseCase) index(ctx context.Context, f repository.StoredFile) error {
+	reader, err := u.fileRepo.Download(ctx, f.ID)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	mime, _, err := u.policy.Check(f.ID, head)
+	if err != nil {
+		mime = "application/octet-stream"
+	}
+	// Stored files are not held to the upload limits again.
+	body := newUploadReader(io.MultiReader(bytes.NewReader(head), reader), 1<<62)
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+
+	return u.metadata.Create(ctx, &domain.File{
+		FileID:      f.ID,
+		Filename:    f.ID,
+		ContentType: mime,
+		Size:        body.read,
+		SHA256:      hex.EncodeToString(body.hash.Sum(nil)),
+		RefCount:    1,
+		CreatedAt:   f.ModTime.UTC(),
+	})
+}
+
+func (u *FileDedupUseCase) mergeAll(ctx context.Context, report *FileBackfillReport) error {
+	after := ""
+	for {
+		hashes, err := u.metadata.DuplicateHashes(ctx, after, dedupBatch)
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if err := u.merge(ctx, hash, report); err != nil {
+				return err
+			}
+		}
+		if len(hashes) < dedupBatch {
+			return nil
+		}
+		after = hashes[len(hashes)-1]
+	}
+}
+
+// merge folds every file with the given hash into the oldest one.
+func (u *FileDedupUseCase) merge(ctx context.Context, hash string, report *FileBackfillReport) error {
+	files, err := u.metadata.ListBySHA256(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if len(files) < 2 {
+		return nil
+	}
+	keep := files[0]
+	for _, dup := range files[1:] {
+		if dup.Size != keep.Size {
+			continue
+		}
+		if !report.DryRun {
+			if err := u.fold(ctx, dup, keep); err != nil {
+				u.logger.Error("File backfill: failed to merge "+dup.FileID+" into "+keep.FileID, err)
+				report.Failed++
+				continue
+			}
+		}
+		report.Merged++
+		report.Reclaimed += dup.Size
+	}
+	return nil
+}
+
+// fold moves dup's references to keep, then deletes dup. References move
+// first, so a failure part way leaves an extra copy rather than a todo
+// pointing at nothing.
+func (u *FileDedupUseCase) fold(ctx context.Context, dup, keep *domain.File) error {
+	if err := u.refs.Repoint(ctx, dup.FileID, keep.FileID); err != nil {
+		return err
+	}
+	refs := dup.RefCount
+	if refs < 1 {
+		refs = 1
+	}
+	if err := u.metadata.AddReferences(ctx, keep.FileID, refs); err != nil {
+		return err
+	}
+	if err := u.metadata.Delete(ctx, dup.FileID); err != nil && err != repository.ErrNotFound {
+		return err
+	}
+	return u.fileRepo.Delete(ctx, dup.FileID)
+}
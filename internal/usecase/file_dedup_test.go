@@ -0,0 +1,93 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newFileDedupFixture stores "hello" twice and "other" once. The first
+// "hello" and "other" have metadata; the second "hello" predates it.
+func newFileDedupFixture(t *testing.T) (*FileDedupUseCase, repository.FileRepository, *MockFileMetadataRepository, *MockFileReferenceRepository, [3]string) {
+	f := newStoredFiles(t, [3]string{"hello", "hello", "other"})
+	f.metadata.On("GetByFileID", mock.Anything, f.ids[0]).Return(&domain.File{FileID: f.ids[0]}, nil)
+	f.metadata.On("GetByFileID", mock.Anything, f.ids[1]).Return(nil, repository.ErrNotFound)
+	f.metadata.On("GetByFileID", mock.Anything, f.ids[2]).Return(&domain.File{FileID: f.ids[2]}, nil)
+	return NewFileDedupUseCase(f.log, f.fileRepo, f.metadata, f.refs), f.fileRepo, f.metadata, f.refs, f.ids
+}
+
+func TestFileBackfillIndexesAndMergesCopies(t *testing.T) {
+	dedup, fileRepo, metadata, refs, ids := newFileDedupFixture(t)
+	sum := sha256.Sum256([]byte("hello"))
+	hash := hex.EncodeToString(sum[:])
+	keep := &domain.File{FileID: ids[0], Size: 5, SHA256: hash, RefCount: 2}
+	dup := &domain.File{FileID: ids[1], Size: 5, SHA256: hash, RefCount: 1}
+
+	metadata.On("Create", mock.Anything, mock.MatchedBy(func(f *domain.File) bool {
+		return f.FileID == ids[1] && f.SHA256 == hash && f.Size == 5 && f.ContentType == "text/plain" && f.RefCount == 1
+	})).Return(nil)
+	metadata.On("DuplicateHashes", mock.Anything, "", dedupBatch).Return([]string{hash}, nil)
+	metadata.On("ListBySHA256", mock.Anything, hash).Return([]*domain.File{keep, dup}, nil)
+	refs.On("Repoint", mock.Anything, ids[1], ids[0]).Return(nil)
+	metadata.On("AddReferences", mock.Anything, ids[0], 1).Return(nil)
+	metadata.On("Delete", mock.Anything, ids[1]).Return(nil)
+
+	report, err := dedup.Backfill(context.Background(), false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Scanned)
+	assert.Equal(t, 1, report.Indexed)
+	assert.Equal(t, 1, report.Merged)
+	assert.Equal(t, int64(5), report.Reclaimed)
+	assert.Equal(t, 0, report.Failed)
+	for i, want := range []bool{true, false, true} {
+		exists, err := fileRepo.Exists(context.Background(), ids[i])
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, ids[i])
+	}
+	metadata.AssertExpectations(t)
+	refs.AssertExpectations(t)
+}
+
+func TestFileBackfillDryRunOnlyReports(t *testing.T) {
+	dedup, fileRepo, metadata, refs, ids := newFileDedupFixture(t)
+	dup := &domain.File{FileID: ids[2], Size: 5}
+	metadata.On("DuplicateHashes", mock.Anything, "", dedupBatch).Return([]string{"abc"}, nil)
+	metadata.On("ListBySHA256", mock.Anything, "abc").Return([]*domain.File{{FileID: ids[0], Size: 5}, dup}, nil)
+
+	report, err := dedup.Backfill(context.Background(), true)
+
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.Indexed)
+	assert.Equal(t, 1, report.Merged)
+	exists, err := fileRepo.Exists(context.Background(), ids[2])
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	metadata.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+	refs.AssertNotCalled(t, "Repoint", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestFileBackfillKeepsCopyWhenRepointFails(t *testing.T) {
+	dedup, fileRepo, metadata, refs, ids := newFileDedupFixture(t)
+	metadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+	metadata.On("DuplicateHashes", mock.Anything, "", dedupBatch).Return([]string{"abc"}, nil)
+	metadata.On("ListBySHA256", mock.Anything, "abc").Return([]*domain.File{{FileID: ids[0], Size: 5}, {FileID: ids[1], Size: 5}}, nil)
+	refs.On("Repoint", mock.Anything, ids[1], ids[0]).Return(assert.AnError)
+
+	report, err := dedup.Backfill(context.Background(), false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Merged)
+	assert.Equal(t, 1, report.Failed)
+	exists, err := fileRepo.Exists(context.Background(), ids[1])
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	metadata.AssertNotCalled(t, "AddReferences", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,163 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+var ErrFileGCUnsupported = errors.New("file store cannot list its files")
+
+// fileGCBatch is how many files are checked for references in one query.
+const fileGCBatch = 500
+
+// FileGCReport is what one collection found. Orphans are the stored files
+// past the grace period that no todo refers to; unless DryRun, they have been
+// deleted, except for the Failed ones. Bytes is their total size.
+// Unconfirmed counts the presigned uploads past the grace period that were
+// never confirmed, which are expired (deleted) unless DryRun.
+type FileGCReport struct {
+	DryRun      bool
+	Scanned     int
+	Orphans     []repository.StoredFile
+	Deleted     int
+	Failed      int
+	Bytes       int64
+	Unconfirmed int
+}
+
+// FileGCUseCase removes stored files no todo refers to: files left behind by
+// deleted todos, detached attachments and uploads that were never attached.
+type FileGCUseCase struct {
+	logger   logger.Logger
+	fileRepo repository.FileRepository
+	metadata repository.FileMetadataRepository
+	refs     repository.FileReferenceRepository
+}
+
+func NewFileGCUseCase(logger logger.Logger, fileRepo repository.FileRepository, metadata repository.FileMetadataRepository, refs repository.FileReferenceRepository) *FileGCUseCase {
+	return &FileGCUseCase{
+		logger:   logger,
+		fileRepo: fileRepo,
+		metadata: metadata,
+		refs:     refs,
+	}
+}
+
+// Collect walks the file store and deletes every unreferenced file older
+// than grace, together with its metadata. References are checked in batches
+// just before deleting, so a file attached while the walk runs is kept. With
+// dryRun nothing is deleted. A file that fails to delete is logged, counted
+// and skipped; the walk carries on. Presigned uploads still unconfirmed after
+// grace are expired too, whether or not their content was ever uploaded.
+func (u *FileGCUseCase) Collect(ctx context.Context, grace time.Duration, dryRun bool) (*FileGCReport, error) {
+	lister, ok := u.fileRepo.(repository.FileLister)
+	if !ok {
+		return nil, ErrFileGCUnsupported
+	}
+
+	report := &FileGCReport{DryRun: dryRun}
+	cutoff := time.Now().Add(-grace)
+	var batch []repository.StoredFile
+	err := lister.Walk(ctx, func(f repository.StoredFile) error {
+		report.Scanned++
+		if f.ModTime.After(cutoff) {
+			return nil
+		}
+		batch = append(batch, f)
+		if len(batch) < fileGCBatch {
+			return nil
+		}
+		err := u.sweep(ctx, batch, report)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil && len(batch) > 0 {
+		err = u.sweep(ctx, batch, report)
+	}
+	if err == nil {
+		err = u.expireUnconfirmed(ctx, cutoff, report)
+	}
+	if err != nil {
+		u.logger.Error("File GC failed", err)
+		return report, err
+	}
+	u.logger.Info("File GC: scanned %d files, %d orphaned (%d bytes), %d deleted, %d unconfirmed expired, %d failed, dry run %t",
+		report.Scanned, len(report.Orphans), report.Bytes, report.Deleted, report.Unconfirmed, report.Failed, dryRun)
+	return report, nil
+}
+
+// sweep deletes the files in batch that nothing refers to.
+func (u *FileGCUseCase) sweep(ctx context.Context, batch []repository.StoredFile, report *FileGCReport) error {
+	ids := make([]string, len(batch))
+	for i, f := range batch {
+		ids[i] = f.ID
+	}
+	referenced, err := u.refs.Referenced(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range batch {
+		if referenced[f.ID] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, f)
+		report.Bytes += f.Size
+		if report.DryRun {
+			continue
+		}
+		if err := u.fileRepo.Delete(ctx, f.ID); err != nil {
+			u.logger.Error("File GC: failed to delete "+f.ID, err)
+			report.Failed++
+			continue
+		}
+		if err := u.metadata.Delete(ctx, f.ID); err != nil && err != repository.ErrNotFound {
+			u.logger.Error("File GC: failed to delete metadata of "+f.ID, err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+	}
+	return nil
+}
+
+// expireUnconfirmed deletes the uploads requested before cutoff that were
+// never confirmed: their content, if the client uploaded any, and their
+// metadata. A failed delete stops the expiry until the next collection, so
+// the listing can always start from the top.
+func (u *FileGCUseCase) expireUnconfirmed(ctx context.Context, cutoff time.Time, report *FileGCReport) error {
+	unconfirmed := string(domain.FileScanUnconfirmed)
+	filter := domain.FileFilter{ScanStatus: &unconfirmed, CreatedBefore: &cutoff}
+	if report.DryRun {
+		_, total, err := u.metadata.List(ctx, filter, 1, 0)
+		report.Unconfirmed = int(total)
+		return err
+	}
+	for {
+		files, _, err := u.metadata.List(ctx, filter, fileGCBatch, 0)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := u.fileRepo.Delete(ctx, f.FileID); err != nil {
+				u.logger.Error("File GC: failed to delete unconfirmed "+f.FileID, err)
+				report.Failed++
+				return nil
+			}
+			if err := u.metadata.Delete(ctx, f.FileID); err != nil && err != repository.ErrNotFound {
+				u.logger.Error("File GC: failed to delete metadata of unconfirmed "+f.FileID, err)
+				report.Failed++
+				return nil
+			}
+			report.Unconfirmed++
+		}
+		if len(files) < fileGCBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3/s3test"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// storedFiles is an in-memory file store holding one file per body, with
+// mocked metadata and references, for the use cases that walk the store.
+type storedFiles struct {
+	log      logger.Logger
+	api      *s3test.MemoryAPI
+	fileRepo repository.FileRepository
+	metadata *MockFileMetadataRepository
+	refs     *MockFileReferenceRepository
+	ids      [3]string
+}
+
+func newStoredFiles(t *testing.T, bodies [3]string) *storedFiles {
+	api := s3test.NewMemoryAPI()
+	f := &storedFiles{
+		log: logger.New(logger.Config{
+			Level:      "info",
+			TimeFormat: time.RFC3339,
+			Pretty:     true,
+		}),
+		api:      api,
+		fileRepo: s3.NewFileRepository(api, "bucket"),
+		metadata: new(MockFileMetadataRepository),
+		refs:     new(MockFileReferenceRepository),
+	}
+	for i, body := range bodies {
+		id, err := f.fileRepo.Upload(context.Background(), strings.NewReader(body), body+".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.ids[i] = id
+	}
+	return f
+}
+
+// newFileGCFixture stores three files: an old referenced one, an old orphan
+// and a fresh orphan still inside the grace period.
+func newFileGCFixture(t *testing.T) (*FileGCUseCase, repository.FileRepository, *MockFileMetadataRepository, *MockFileReferenceRepository, [3]string) {
+	f := newStoredFiles(t, [3]string{"referenced", "orphan", "fresh"})
+	old := time.Now().Add(-48 * time.Hour)
+	f.api.Touch(f.ids[0], old)
+	f.api.Touch(f.ids[1], old)
+
+	f.refs.On("Referenced", mock.Anything, mock.Anything).Return(map[string]bool{f.ids[0]: true}, nil)
+	f.metadata.On("List", mock.Anything, mock.MatchedBy(isUnconfirmedFilter), mock.Anything, 0).Return([]*domain.File{}, int64(0), nil).Maybe()
+	return NewFileGCUseCase(f.log, f.fileRepo, f.metadata, f.refs), f.fileRepo, f.metadata, f.refs, f.ids
+}
+
+func isUnconfirmedFilter(f domain.FileFilter) bool {
+	return f.ScanStatus != nil && *f.ScanStatus == string(domain.FileScanUnconfirmed) && f.CreatedBefore != nil
+}
+
+func TestFileGCDeletesOldUnreferencedFiles(t *testing.T) {
+	gc, fileRepo, metadata, refs, ids := newFileGCFixture(t)
+	metadata.On("Delete", mock.Anything, ids[1]).Return(nil)
+
+	report, err := gc.Collect(context.Background(), 24*time.Hour, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Scanned)
+	if assert.Len(t, report.Orphans, 1) {
+		assert.Equal(t, ids[1], report.Orphans[0].ID)
+	}
+	assert.Equal(t, 1, report.Deleted)
+	assert.Equal(t, int64(len("orphan")), report.Bytes)
+	for i, want := range []bool{true, false, true} {
+		exists, err := fileRepo.Exists(context.Background(), ids[i])
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, ids[i])
+	}
+	// The fresh file is not even looked up.
+	refs.AssertCalled(t, "Referenced", mock.Anything, mock.MatchedBy(func(fileIDs []string) bool {
+		return len(fileIDs) == 2
+	}))
+	metadata.AssertExpectations(t)
+}
+
+func TestFileGCDryRunOnlyReports(t *testing.T) {
+	gc, fileRepo, metadata, _, ids := newFileGCFixture(t)
+
+	report, err := gc.Collect(context.Background(), 24*time.Hour, true)
+
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Len(t, report.Orphans, 1)
+	assert.Equal(t, 0, report.Deleted)
+	exists, err := fileRepo.Exists(context.Background(), ids[1])
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	metadata.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestFileGCStopsWhenReferencesCannotBeChecked(t *testing.T) {
+	gc, fileRepo, _, refs, ids := newFileGCFixture(t)
+	dbErr := errors.New("connection refused")
+	refs.ExpectedCalls = nil
+	refs.On("Referenced", mock.Anything, mock.Anything).Return(nil, dbErr)
+
+	_, err := gc.Collect(context.Background(), 24*time.Hour, false)
+
+	assert.ErrorIs(t, err, dbErr)
+	exists, err := fileRepo.Exists(context.Background(), ids[1])
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestFileGCNeedsAListableStore(t *testing.T) {
+	gc := NewFileGCUseCase(nil, new(MockFileRepository), nil, nil)
+
+	_, err := gc.Collect(context.Background(), time.Hour, true)
+
+	assert.Equal(t, ErrFileGCUnsupported, err)
+}
+
+func TestFileGCExpiresUnconfirmedUploads(t *testing.T) {
+	gc, fileRepo, metadata, _, _ := newFileGCFixture(t)
+	uploaded, err := fileRepo.Upload(context.Background(), strings.NewReader("never confirmed"), "late.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+	metadata.ExpectedCalls = metadata.ExpectedCalls[:0]
+	metadata.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	metadata.On("List", mock.Anything, mock.MatchedBy(func(f domain.FileFilter) bool {
+		return isUnconfirmedFilter(f) && f.CreatedBefore.Before(time.Now().Add(-23*time.Hour))
+	}), fileGCBatch, 0).Return([]*domain.File{
+		{FileID: uploaded, ScanStatus: string(domain.FileScanUnconfirmed)},
+		{FileID: "never-uploaded.txt", ScanStatus: string(domain.FileScanUnconfirmed)},
+	}, int64(2), nil)
+
+	report, err := gc.Collect(context.Background(), 24*time.Hour, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Unconfirmed)
+	exists, err := fileRepo.Exists(context.Background(), uploaded)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	metadata.AssertCalled(t, "Delete", mock.Anything, uploaded)
+	metadata.AssertCalled(t, "Delete", mock.Anything, "never-uploaded.txt")
+}
+
+func TestFileGCDryRunCountsUnconfirmedUploads(t *testing.T) {
+	gc, _, metadata, _, _ := newFileGCFixture(t)
+	metadata.ExpectedCalls = metadata.ExpectedCalls[:0]
+	metadata.On("List", mock.Anything, mock.MatchedBy(isUnconfirmedFilter), 1, 0).Return([]*domain.File{{}}, int64(4), nil)
+
+	report, err := gc.Collect(context.Background(), 24*time.Hour, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Unconfirmed)
+	metadata.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// sniffLen is how much of an upload is read to detect its content type.
+const sniffLen = 3072
+
+// UnsupportedFileTypeError rejects an upload because of its type: either the
+// extension is not allowed, or the content does not match it. It matches
+// ErrInvalidFileType.
+type UnsupportedFileTypeError struct {
+	Extension string
+	// Detected is the sniffed content type; empty when the extension alone
+	// was enough to reject the file.
+	Detected string
+	// Expected is the content type Extension is allowed for.
+	Expected string
+}
+
+func (e *UnsupportedFileTypeError) Error() string {
+	if e.Detected == "" {
+		return fmt.Sprintf("file type %q is not allowed", e.Extension)
+	}
+	return fmt.Sprintf("file content is %s, but %q files must be %s", e.Detected, e.Extension, e.Expected)
+}
+
+func (e *UnsupportedFileTypeError) Is(target error) bool {
+	return target == ErrInvalidFileType
+}
+
+// FileTooLargeError rejects an upload over the limit for its type. It
+// matches ErrFileTooLarge.
+type FileTooLargeError struct {
+	MIME  string
+	Limit int64
+}
+
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("file too large: %s files are limited to %d bytes", e.MIME, e.Limit)
+}
+
+func (e *FileTooLargeError) Is(target error) bool {
+	return target == ErrFileTooLarge
+}
+
+// FilePolicy decides which uploads are accepted. The file's extension must
+// be allowed, its content, sniffed from the magic bytes, must be the type the
+// extension is allowed for, and it must fit that type's size limit.
+type FilePolicy struct {
+	maxSize int64
+	byExt   map[string]allowedType
+}
+
+type allowedType struct {
+	mime    string
+	maxSize int64
+}
+
+func NewFilePolicy(cfg config.FilesConfig) (*FilePolicy, error) {
+	p := &FilePolicy{maxSize: cfg.MaxSize, byExt: map[string]allowedType{}}
+	if p.maxSize <= 0 {
+		p.maxSize = MaxFileSize
+	}
+	for _, t := range cfg.Types {
+		if mimetype.Lookup(t.MIME) == nil {
+			return nil, fmt.Errorf("file type %q cannot be detected", t.MIME)
+		}
+		if len(t.Extensions) == 0 {
+			return nil, fmt.Errorf("file type %q has no extensions", t.MIME)
+		}
+		limit := t.MaxSize
+		if limit <= 0 || limit > p.maxSize {
+			limit = p.maxSize
+		}
+		for _, ext := range t.Extensions {
+			ext = normalizeExt(ext)
+			if other, ok := p.byExt[ext]; ok {
+				return nil, fmt.Errorf("extension %q is allowed for both %s and %s", ext, other.mime, t.MIME)
+			}
+			p.byExt[ext] = allowedType{mime: t.MIME, maxSize: limit}
+		}
+	}
+	return p, nil
+}
+
+// DefaultFilePolicy allows config.DefaultFileTypes up to MaxFileSize.
+func DefaultFilePolicy() *FilePolicy {
+	p, err := NewFilePolicy(config.FilesConfig{MaxSize: MaxFileSize, Types: config.DefaultFileTypes})
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// MaxSize is the largest upload any type allows.
+func (p *FilePolicy) MaxSize() int64 {
+	return p.maxSize
+}
+
+// Allowed looks an upload up by name alone and returns the content type it
+// must have and the size limit that applies.
+func (p *FilePolicy) Allowed(filename string) (string, int64, error) {
+	ext := normalizeExt(filepath.Ext(filename))
+	t, ok := p.byExt[ext]
+	if !ok {
+		return "", 0, &UnsupportedFileTypeError{Extension: ext}
+	}
+	return t.mime, t.maxSize, nil
+}
+
+// Check validates an upload by name and its first bytes (up to sniffLen),
+// and returns the detected content type and the size limit that applies.
+func (p *FilePolicy) Check(filename string, head []byte) (string, int64, error) {
+	ext := normalizeExt(filepath.Ext(filename))
+	t, ok := p.byExt[ext]
+	if !ok {
+		return "", 0, &UnsupportedFileTypeError{Extension: ext}
+	}
+	detected := mimetype.Detect(head)
+	// Accept subtypes too, e.g. UTF-8 text for text/plain.
+	for m := detected; m != nil; m = m.Parent() {
+		if m.Is(t.mime) {
+			return t.mime, t.maxSize, nil
+		}
+	}
+	return "", 0, &UnsupportedFileTypeError{Extension: ext, Detected: detected.String(), Expected: t.mime}
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
@@ -0,0 +1,229 @@
+package usecase
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+)
+
+var (
+	ErrPresignUnsupported = errors.New("file store does not support presigned URLs")
+	ErrInvalidFileSize    = errors.New("file size must be positive")
+	ErrUploadUnconfirmed  = errors.New("file upload has not been confirmed")
+)
+
+// RequestUpload starts a direct upload: the client PUTs the file to the
+// returned URL itself, then calls ConfirmUpload with the returned id. The
+// policy is applied up front on the name and declared size, and the URL only
+// accepts a body of that size and type. The file is recorded as unconfirmed
+// right away, so it cannot be downloaded or attached before ConfirmUpload
+// has checked it, and file GC expires it if it never is.
+func (u *FileUseCase) RequestUpload(ctx context.Context, filename string, size int64) (string, *repository.PresignedURL, error) {
+	presigner, ok := u.fileRepo.(repository.FilePresigner)
+	if !ok {
+		return "", nil, ErrPresignUnsupported
+	}
+	mime, limit, err := u.policy.Allowed(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	if size <= 0 {
+		return "", nil, ErrInvalidFileSize
+	}
+	if size > limit {
+		return "", nil, &FileTooLargeError{MIME: mime, Limit: limit}
+	}
+
+	fileID, url, err := presigner.PresignUpload(ctx, filename, mime, size)
+	if errors.Is(err, repository.ErrPresignUnsupported) {
+		return "", nil, ErrPresignUnsupported
+	}
+	if err != nil {
+		u.logger.Error("Failed to presign upload", err)
+		return "", nil, err
+	}
+
+	err = u.metadata.Create(ctx, &domain.File{
+		FileID:      fileID,
+		Filename:    filepath.Base(filename),
+		ContentType: mime,
+		Size:        size,
+		UploadedBy:  uploaderFrom(ctx),
+		CreatedAt:   time.Now().UTC(),
+		ScanStatus:  string(domain.FileScanUnconfirmed),
+	})
+	if err != nil {
+		u.logger.Error("Failed to record requested upload", err)
+		return "", nil, err
+	}
+	return fileID, url, nil
+}
+
+// ConfirmUpload records a file uploaded through RequestUpload. The object
+// is read back so its content is checked against the policy and hashed like
+// any other upload; one that fails the check is deleted. Content that is
+// already stored is deduplicated like StoreFile does: the new copy is deleted
+// and the stored file returned. Otherwise, with WithScanner, it is then
+// scanned. Confirming a file twice returns the recorded metadata.
+//
+// Only objects RequestUpload recorded are ever deleted. One without a row
+// may be a file stored before metadata was, which todos can still refer to,
+// so it is only checked and recorded as it is.
+func (u *FileUseCase) ConfirmUpload(ctx context.Context, fileID, filename string) (*domain.File, error) {
+	if !strings.EqualFold(filepath.Ext(fileID), filepath.Ext(filename)) {
+		return nil, fmt.Errorf("%w: %q does not match file %s", ErrInvalidFileType, filename, fileID)
+	}
+	requested, err := u.metadata.GetByFileID(ctx, fileID)
+	switch {
+	case err == nil && requested.ScanStatus != string(domain.FileScanUnconfirmed):
+		return requested, nil
+	case err == repository.ErrNotFound:
+		// Requested before uploads were recorded at request time.
+		requested = nil
+	case err != nil:
+		u.logger.Error("Failed to get file metadata", err)
+		return nil, err
+	}
+
+	exists, err := u.fileRepo.Exists(ctx, fileID)
+	if err != nil {
+		u.logger.Error("Failed to check file existence", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrFileNotFound
+	}
+	reader, err := u.fileRepo.Download(ctx, fileID)
+	if err != nil {
+		u.logger.Error("Failed to read uploaded file", err)
+		return nil, err
+	}
+	defer reader.Close()
+
+	mime, body, err := u.inspect(reader, filename)
+	if err == nil {
+		_, err = io.Copy(io.Discard, body)
+		if body.exceeded {
+			err = &FileTooLargeError{MIME: mime, Limit: body.limit}
+		}
+	}
+	if err != nil {
+		if requested != nil && (errors.Is(err, ErrInvalidFileType) || errors.Is(err, ErrFileTooLarge)) {
+			if err := u.fileRepo.Delete(ctx, fileID); err != nil {
+				u.logger.Error("Failed to delete rejected file", err)
+			}
+		}
+		return nil, err
+	}
+
+	if requested != nil {
+		if existing := u.duplicateOf(ctx, hex.EncodeToString(body.hash.Sum(nil)), body.read); existing != nil {
+			return u.confirmDuplicate(ctx, fileID, existing)
+		}
+	}
+	var file *domain.File
+	if requested == nil {
+		file, err = u.record(ctx, fileID, filename, mime, body)
+	} else {
+		file, err = u.confirm(ctx, requested, filename, mime, body)
+	}
+	if err != nil || u.scanner == nil {
+		return file, err
+	}
+	if err := u.scan(ctx, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// confirm records the checked content of the unconfirmed file requested.
+// If another confirmation got there first, its result is returned.
+func (u *FileUseCase) confirm(ctx context.Context, requested *domain.File, filename, mime string, body *uploadReader) (*domain.File, error) {
+	file := u.newFile(ctx, requested.FileID, filename, mime, body)
+	file.ID, file.UploadedBy, file.CreatedAt = requested.ID, requested.UploadedBy, requested.CreatedAt
+	err := u.writeWithEvent(ctx, file, u.metadata.ConfirmTx)
+	if err == repository.ErrNotFound {
+		return u.GetFile(ctx, file.FileID)
+	}
+	if err != nil {
+		u.logger.Error("Failed to confirm upload", err)
+		return nil, err
+	}
+	u.logger.Debug("Confirmed %s file %s (%d bytes, sha256 %s)", mime, file.FileID, file.Size, file.SHA256)
+	return file, nil
+}
+
+// confirmDuplicate settles an upload whose content duplicateOf found stored
+// as existing and took a reference to: the unconfirmed row and the new copy
+// are deleted. If another confirmation or expiry got to the row first, the
+// reference is given back and the row's fate is returned instead.
+func (u *FileUseCase) confirmDuplicate(ctx context.Context, fileID string, existing *domain.File) (*domain.File, error) {
+	if err := u.metadata.DeleteUnconfirmed(ctx, fileID); err != nil {
+		if _, releaseErr := u.metadata.Release(ctx, existing.FileID); releaseErr != nil {
+			u.logger.Error("Failed to release stored file", releaseErr)
+		}
+		if err == repository.ErrNotFound {
+			return u.GetFile(ctx, fileID)
+		}
+		u.logger.Error("Failed to delete confirmed duplicate", err)
+		return nil, err
+	}
+	if err := u.fileRepo.Delete(ctx, fileID); err != nil {
+		u.logger.Error("Failed to delete duplicate file", err)
+	}
+	u.logger.Debug("Upload of %s resolved to stored file %s", fileID, existing.FileID)
+	return existing, nil
+}
+
+// DownloadURL returns a presigned URL the client downloads fileID from
+// directly, named after the file's original name. Quarantined files get none.
+func (u *FileUseCase) DownloadURL(ctx context.Context, fileID string) (*repository.PresignedURL, error) {
+	presigner, ok := u.fileRepo.(repository.FilePresigner)
+	if !ok {
+		return nil, ErrPresignUnsupported
+	}
+	filename := fileID
+	file, err := u.metadata.GetByFileID(ctx, fileID)
+	switch {
+	case err == nil:
+		if err := scanError(file); err != nil {
+			return nil, err
+		}
+		filename = file.Filename
+	case err == repository.ErrNotFound:
+		// Uploaded before metadata was recorded; presigning alone would
+		// not notice a missing object.
+		exists, err := u.fileRepo.Exists(ctx, fileID)
+		if err != nil {
+			u.logger.Error("Failed to check file existence", err)
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrFileNotFound
+		}
+		if err := unscanned(u.scanner != nil); err != nil {
+			return nil, err
+		}
+	default:
+		u.logger.Error("Failed to get file metadata", err)
+		return nil, err
+	}
+
+	url, err := presigner.PresignDownload(ctx, fileID, filename)
+	if errors.Is(err, repository.ErrPresignUnsupported) {
+		return nil, ErrPresignUnsupported
+	}
+	if err != nil {
+		u.logger.Error("Failed to presign download", err)
+		return nil, err
+	}
+	return url, nil
+}
@@ -0,0 +1,271 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// presigningFileRepo is a MockFileRepository that can also presign URLs.
+type presigningFileRepo struct {
+	*MockFileRepository
+}
+
+func (m presigningFileRepo) PresignUpload(ctx context.Context, filename, contentType string, size int64) (string, *repository.PresignedURL, error) {
+	args := m.Called(ctx, filename, contentType, size)
+	return args.String(0), args.Get(1).(*repository.PresignedURL), args.Error(2)
+}
+
+func (m presigningFileRepo) PresignDownload(ctx context.Context, id, filename string) (*repository.PresignedURL, error) {
+	args := m.Called(ctx, id, filename)
+	return args.Get(0).(*repository.PresignedURL), args.Error(1)
+}
+
+// fileContent is file content as a repository would return it.
+type fileContent struct {
+	*bytes.Reader
+}
+
+func (fileContent) Close() error       { return nil }
+func (fileContent) ETag() string       { return `"v1"` }
+func (fileContent) ModTime() time.Time { return time.Time{} }
+
+func newPresigningFileUseCase() (*FileUseCase, presigningFileRepo, *MockFileMetadataRepository) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	fileRepo := presigningFileRepo{new(MockFileRepository)}
+	metadata := new(MockFileMetadataRepository)
+	return NewFileUseCase(log, fileRepo, metadata), fileRepo, metadata
+}
+
+func TestRequestUploadAppliesPolicyUpFront(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	url := &repository.PresignedURL{URL: "https://s3/bucket/id.pdf", Method: "PUT"}
+	fileRepo.On("PresignUpload", mock.Anything, "report.pdf", "application/pdf", int64(2048)).Return("id.pdf", url, nil)
+	metadata.On("Create", mock.Anything, mock.MatchedBy(func(f *domain.File) bool {
+		return f.FileID == "id.pdf" && f.Filename == "report.pdf" && f.ScanStatus == string(domain.FileScanUnconfirmed)
+	})).Return(nil)
+
+	id, got, err := uc.RequestUpload(context.Background(), "report.pdf", 2048)
+	assert.NoError(t, err)
+	assert.Equal(t, "id.pdf", id)
+	assert.Equal(t, url, got)
+
+	_, _, err = uc.RequestUpload(context.Background(), "setup.exe", 2048)
+	assert.ErrorIs(t, err, ErrInvalidFileType)
+	_, _, err = uc.RequestUpload(context.Background(), "notes.txt", 2<<20)
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+	_, _, err = uc.RequestUpload(context.Background(), "notes.txt", 0)
+	assert.ErrorIs(t, err, ErrInvalidFileSize)
+	fileRepo.AssertNumberOfCalls(t, "PresignUpload", 1)
+	metadata.AssertNumberOfCalls(t, "Create", 1)
+
+	plain := NewFileUseCase(logger.New(logger.Config{Level: "info"}), new(MockFileRepository), new(MockFileMetadataRepository))
+	_, _, err = plain.RequestUpload(context.Background(), "report.pdf", 2048)
+	assert.ErrorIs(t, err, ErrPresignUnsupported)
+}
+
+func TestRequestUploadFailsWhenItCannotBeRecorded(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	url := &repository.PresignedURL{URL: "https://s3/bucket/id.pdf", Method: "PUT"}
+	fileRepo.On("PresignUpload", mock.Anything, "report.pdf", "application/pdf", int64(2048)).Return("id.pdf", url, nil)
+	metadata.On("Create", mock.Anything, mock.Anything).Return(errors.New("db down"))
+
+	_, got, err := uc.RequestUpload(context.Background(), "report.pdf", 2048)
+
+	assert.Error(t, err)
+	assert.Nil(t, got, "an unrecorded upload gets no URL")
+}
+
+func TestConfirmUploadConfirmsTheRequestedFile(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	content := []byte("meeting notes")
+	uploader := "alice"
+	requested := &domain.File{ID: 3, FileID: "id.txt", Filename: "notes.txt", UploadedBy: &uploader, ScanStatus: string(domain.FileScanUnconfirmed)}
+	tx := new(MockTx)
+	metadata.On("GetByFileID", mock.Anything, "id.txt").Return(requested, nil)
+	fileRepo.On("Exists", mock.Anything, "id.txt").Return(true, nil)
+	fileRepo.On("Download", mock.Anything, "id.txt").Return(io.NopCloser(bytes.NewReader(content)), nil)
+	metadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+	metadata.On("BeginTx", mock.Anything).Return(tx, nil)
+	metadata.On("ConfirmTx", mock.Anything, tx, mock.MatchedBy(func(f *domain.File) bool {
+		return f.ID == 3 && f.ContentType == "text/plain" && f.Size == int64(len(content)) && f.SHA256 != "" &&
+			f.ScanStatus == "" && f.UploadedBy == &uploader
+	})).Return(nil)
+	tx.On("Commit", mock.Anything).Return(nil)
+	tx.On("Rollback", mock.Anything).Return(nil)
+
+	file, err := uc.ConfirmUpload(context.Background(), "id.txt", "notes.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", file.ScanStatus)
+	metadata.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+	tx.AssertExpectations(t)
+}
+
+func TestConfirmUploadResolvesDuplicateContent(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	content := []byte("meeting notes")
+	sum := sha256.Sum256(content)
+	existing := &domain.File{FileID: "first.txt", Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:]), RefCount: 1}
+	metadata.On("GetByFileID", mock.Anything, "id.txt").Return(&domain.File{FileID: "id.txt", ScanStatus: string(domain.FileScanUnconfirmed)}, nil)
+	fileRepo.On("Exists", mock.Anything, mock.Anything).Return(true, nil)
+	fileRepo.On("Download", mock.Anything, "id.txt").Return(io.NopCloser(bytes.NewReader(content)), nil)
+	metadata.On("ListBySHA256", mock.Anything, existing.SHA256).Return([]*domain.File{existing}, nil)
+	metadata.On("AddReferences", mock.Anything, "first.txt", 1).Return(nil)
+	metadata.On("DeleteUnconfirmed", mock.Anything, "id.txt").Return(nil)
+	fileRepo.On("Delete", mock.Anything, "id.txt").Return(nil)
+
+	file, err := uc.ConfirmUpload(context.Background(), "id.txt", "notes.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "first.txt", file.FileID)
+	fileRepo.AssertExpectations(t)
+	metadata.AssertExpectations(t)
+	metadata.AssertNotCalled(t, "BeginTx", mock.Anything)
+}
+
+func TestConfirmUploadLosingTheRaceGivesTheReferenceBack(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	content := []byte("meeting notes")
+	existing := &domain.File{FileID: "first.txt", Size: int64(len(content)), RefCount: 1}
+	confirmed := &domain.File{FileID: "id.txt", Filename: "notes.txt"}
+	metadata.On("GetByFileID", mock.Anything, "id.txt").Return(&domain.File{FileID: "id.txt", ScanStatus: string(domain.FileScanUnconfirmed)}, nil).Once()
+	metadata.On("GetByFileID", mock.Anything, "id.txt").Return(confirmed, nil)
+	fileRepo.On("Exists", mock.Anything, mock.Anything).Return(true, nil)
+	fileRepo.On("Download", mock.Anything, "id.txt").Return(io.NopCloser(bytes.NewReader(content)), nil)
+	metadata.On("ListBySHA256", mock.Anything, mock.Anything).Return([]*domain.File{existing}, nil)
+	metadata.On("AddReferences", mock.Anything, "first.txt", 1).Return(nil)
+	// Another confirmation of id.txt got there first.
+	metadata.On("DeleteUnconfirmed", mock.Anything, "id.txt").Return(repository.ErrNotFound)
+	metadata.On("Release", mock.Anything, "first.txt").Return(1, nil)
+
+	file, err := uc.ConfirmUpload(context.Background(), "id.txt", "notes.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, confirmed, file)
+	metadata.AssertCalled(t, "Release", mock.Anything, "first.txt")
+	fileRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestUnconfirmedUploadsCannotBeServed(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	unconfirmed := &domain.File{FileID: "id.txt", Filename: "notes.txt", ScanStatus: string(domain.FileScanUnconfirmed)}
+	metadata.On("GetByFileID", mock.Anything, "id.txt").Return(unconfirmed, nil)
+	fileRepo.On("Exists", mock.Anything, "id.txt").Return(true, nil)
+	fileRepo.On("Open", mock.Anything, "id.txt").Return(fileContent{bytes.NewReader([]byte("notes"))}, nil)
+
+	_, err := uc.OpenFile(context.Background(), "id.txt")
+	assert.ErrorIs(t, err, ErrUploadUnconfirmed)
+	_, err = uc.DownloadFile(context.Background(), "id.txt")
+	assert.ErrorIs(t, err, ErrUploadUnconfirmed)
+	_, err = uc.DownloadURL(context.Background(), "id.txt")
+	assert.ErrorIs(t, err, ErrUploadUnconfirmed)
+	fileRepo.AssertNotCalled(t, "PresignDownload", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestConfirmUploadChecksAndRecordsTheObject(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	content := []byte("meeting notes")
+	metadata.On("GetByFileID", mock.Anything, "id.txt").Return(nil, repository.ErrNotFound).Once()
+	fileRepo.On("Exists", mock.Anything, "id.txt").Return(true, nil)
+	fileRepo.On("Download", mock.Anything, "id.txt").Return(io.NopCloser(bytes.NewReader(content)), nil)
+	metadata.On("Create", mock.Anything, mock.MatchedBy(func(f *domain.File) bool {
+		return f.FileID == "id.txt" && f.Filename == "notes.txt" && f.ContentType == "text/plain" && f.Size == int64(len(content))
+	})).Return(nil)
+
+	file, err := uc.ConfirmUpload(context.Background(), "id.txt", "notes.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "id.txt", file.FileID)
+
+	// A second confirmation returns what was recorded.
+	metadata.On("GetByFileID", mock.Anything, "id.txt").Return(file, nil)
+	again, err := uc.ConfirmUpload(context.Background(), "id.txt", "notes.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, file, again)
+	metadata.AssertNumberOfCalls(t, "Create", 1)
+
+	_, err = uc.ConfirmUpload(context.Background(), "id.txt", "notes.pdf")
+	assert.ErrorIs(t, err, ErrInvalidFileType)
+}
+
+func TestConfirmUploadDeletesRejectedContent(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	metadata.On("GetByFileID", mock.Anything, "id.png").Return(&domain.File{FileID: "id.png", ScanStatus: string(domain.FileScanUnconfirmed)}, nil)
+	metadata.On("GetByFileID", mock.Anything, "gone.png").Return(nil, repository.ErrNotFound)
+	fileRepo.On("Exists", mock.Anything, "id.png").Return(true, nil)
+	fileRepo.On("Exists", mock.Anything, "gone.png").Return(false, nil)
+	fileRepo.On("Download", mock.Anything, "id.png").Return(io.NopCloser(bytes.NewReader([]byte("not an image"))), nil)
+	fileRepo.On("Delete", mock.Anything, "id.png").Return(nil)
+
+	_, err := uc.ConfirmUpload(context.Background(), "id.png", "photo.png")
+	assert.ErrorIs(t, err, ErrInvalidFileType)
+	fileRepo.AssertCalled(t, "Delete", mock.Anything, "id.png")
+
+	_, err = uc.ConfirmUpload(context.Background(), "gone.png", "photo.png")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+	metadata.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestConfirmUploadNeverDeletesUnrequestedObjects(t *testing.T) {
+	// legacy.png and legacy.txt have no row: they may be files stored
+	// before metadata was, still referred to by todos.
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	content := []byte("meeting notes")
+	metadata.On("GetByFileID", mock.Anything, mock.Anything).Return(nil, repository.ErrNotFound)
+	fileRepo.On("Exists", mock.Anything, mock.Anything).Return(true, nil)
+	fileRepo.On("Download", mock.Anything, "legacy.png").Return(io.NopCloser(bytes.NewReader([]byte("not an image"))), nil)
+	fileRepo.On("Download", mock.Anything, "legacy.txt").Return(io.NopCloser(bytes.NewReader(content)), nil)
+	metadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := uc.ConfirmUpload(context.Background(), "legacy.png", "photo.png")
+	assert.ErrorIs(t, err, ErrInvalidFileType)
+
+	// Its content is stored under another id too.
+	file, err := uc.ConfirmUpload(context.Background(), "legacy.txt", "notes.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy.txt", file.FileID)
+
+	fileRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	metadata.AssertNotCalled(t, "ListBySHA256", mock.Anything, mock.Anything)
+	metadata.AssertNotCalled(t, "AddReferences", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDownloadURLUsesOriginalFilename(t *testing.T) {
+	uc, fileRepo, metadata := newPresigningFileUseCase()
+	url := &repository.PresignedURL{URL: "https://s3/bucket/id.pdf", Method: "GET"}
+	metadata.On("GetByFileID", mock.Anything, "id.pdf").Return(&domain.File{FileID: "id.pdf", Filename: "report.pdf"}, nil)
+	metadata.On("GetByFileID", mock.Anything, "gone.pdf").Return(nil, repository.ErrNotFound)
+	fileRepo.On("Exists", mock.Anything, "gone.pdf").Return(false, nil)
+	fileRepo.On("PresignDownload", mock.Anything, "id.pdf", "report.pdf").Return(url, nil)
+
+	got, err := uc.DownloadURL(context.Background(), "id.pdf")
+	assert.NoError(t, err)
+	assert.Equal(t, url, got)
+
+	_, err = uc.DownloadURL(context.Background(), "gone.pdf")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestPresigningRefusedByTheStoreIsUnsupported(t *testing.T) {
+	uc, fileRepo, _ := newPresigningFileUseCase()
+	fileRepo.On("PresignUpload", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", (*repository.PresignedURL)(nil), repository.ErrPresignUnsupported)
+
+	_, _, err := uc.RequestUpload(context.Background(), "report.pdf", 2048)
+
+	assert.Equal(t, ErrPresignUnsupported, err)
+}
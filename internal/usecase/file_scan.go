@@ -0,0 +1,155 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+)
+
+var (
+	ErrFileQuarantined = errors.New("file is quarantined until it passes a malware scan")
+	ErrFileInfected    = errors.New("file failed the malware scan")
+)
+
+// scanBatch is how many pending files ScanPending loads at a time.
+const scanBatch = 100
+
+// WithScanner quarantines every new upload until scanner has passed it.
+// Files are recorded as pending, scanned right after they are stored, and
+// cannot be downloaded or attached until they are clean.
+func (u *FileUseCase) WithScanner(scanner repository.FileScanner) *FileUseCase {
+	u.scanner = scanner
+	return u
+}
+
+// scan runs the scanner over a recorded file and records its verdict on
+// file, announced as file.scanned under WithEvents. An infected file is kept,
+// quarantined, and is ErrFileInfected. When the scanner fails the file stays
+// pending for ScanPending to retry, and the upload itself still succeeds.
+func (u *FileUseCase) scan(ctx context.Context, file *domain.File) error {
+	reader, err := u.fileRepo.Download(ctx, file.FileID)
+	if err != nil {
+		u.logger.Error("Failed to read file for scanning", err)
+		return nil
+	}
+	result, err := u.scanner.Scan(ctx, reader)
+	reader.Close()
+	if err != nil {
+		u.logger.Error("Failed to scan file "+file.FileID, err)
+		return nil
+	}
+
+	status, signature := domain.FileScanClean, (*string)(nil)
+	if result.Infected {
+		status, signature = domain.FileScanInfected, &result.Signature
+	}
+	scanned := *file
+	scanned.ScanStatus, scanned.ScanSignature = string(status), signature
+	if err := u.recordVerdict(ctx, &scanned); err != nil {
+		u.logger.Error("Failed to record scan result", err)
+		return err
+	}
+	file.ScanStatus, file.ScanSignature = scanned.ScanStatus, scanned.ScanSignature
+	if result.Infected {
+		u.logger.Warn("Quarantined infected file "+file.FileID, fmt.Errorf("%s", result.Signature))
+		return fmt.Errorf("%w: %s", ErrFileInfected, result.Signature)
+	}
+	return nil
+}
+
+// recordVerdict records file's scan status, together with its file.scanned
+// event under WithEvents. Consumers that skipped the file while it was
+// quarantined, like the thumbnail worker, learn from it that it was released.
+func (u *FileUseCase) recordVerdict(ctx context.Context, file *domain.File) error {
+	status := domain.FileScanStatus(file.ScanStatus)
+	if u.outbox == nil {
+		return u.metadata.SetScanStatus(ctx, file.FileID, status, file.ScanSignature)
+	}
+	tx, err := u.metadata.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := u.metadata.SetScanStatusTx(ctx, tx, file.FileID, status, file.ScanSignature); err != nil {
+		return err
+	}
+	if err := u.announce(ctx, tx, file.FileID, repository.EventFileScanned, repository.NewFileScanned(file)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ScanPending scans the files still waiting for a verdict, e.g. because the
+// scanner was down when they were uploaded, and returns how many it cleared
+// and how many were infected. Files that fail again stay pending.
+func (u *FileUseCase) ScanPending(ctx context.Context) (clean, infected int, err error) {
+	if u.scanner == nil {
+		return 0, 0, nil
+	}
+	pending := string(domain.FileScanPending)
+	offset := 0
+	for {
+		files, _, err := u.metadata.List(ctx, domain.FileFilter{ScanStatus: &pending}, scanBatch, offset)
+		if err != nil {
+			u.logger.Error("Failed to list pending files", err)
+			return clean, infected, err
+		}
+		for _, file := range files {
+			err := u.scan(ctx, file)
+			switch {
+			case errors.Is(err, ErrFileInfected):
+				infected++
+			case err != nil:
+				return clean, infected, err
+			case file.ScanStatus == string(domain.FileScanClean):
+				clean++
+			default:
+				// Still pending; skip past it on the next page.
+				offset++
+			}
+		}
+		if len(files) < scanBatch {
+			return clean, infected, nil
+		}
+	}
+}
+
+// checkScan refuses quarantined files. A file without metadata predates
+// metadata and was never scanned: it passes unless scanning is on, in which
+// case it is ErrFileQuarantined.
+func checkScan(ctx context.Context, metadata repository.FileMetadataRepository, fileID string, scanning bool) error {
+	file, err := metadata.GetByFileID(ctx, fileID)
+	if err == repository.ErrNotFound {
+		return unscanned(scanning)
+	}
+	if err != nil {
+		return err
+	}
+	return scanError(file)
+}
+
+// unscanned is what a file without metadata is: ErrFileQuarantined when
+// scanning is on, since nothing ever scanned it.
+func unscanned(scanning bool) error {
+	if scanning {
+		return ErrFileQuarantined
+	}
+	return nil
+}
+
+// scanError is ErrUploadUnconfirmed, ErrFileQuarantined or ErrFileInfected
+// for a quarantined file.
+func scanError(file *domain.File) error {
+	switch domain.FileScanStatus(file.ScanStatus) {
+	case domain.FileScanUnconfirmed:
+		return ErrUploadUnconfirmed
+	case domain.FileScanPending:
+		return ErrFileQuarantined
+	case domain.FileScanInfected:
+		return ErrFileInfected
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3/s3test"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/repository/scantest"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newScanningFileUseCase() (*FileUseCase, *MockFileMetadataRepository, *scantest.Scanner, *s3test.MemoryAPI) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	api := s3test.NewMemoryAPI()
+	metadata := new(MockFileMetadataRepository)
+	scanner := scantest.New()
+	metadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+	uc := NewFileUseCase(log, s3.NewFileRepository(api, "bucket"), metadata).WithScanner(scanner)
+	return uc, metadata, scanner, api
+}
+
+func TestStoreFileQuarantinesUntilClean(t *testing.T) {
+	uc, metadata, scanner, _ := newScanningFileUseCase()
+	metadata.On("Create", mock.Anything, mock.MatchedBy(func(f *domain.File) bool {
+		return f.ScanStatus == string(domain.FileScanPending)
+	})).Return(nil)
+	metadata.On("SetScanStatus", mock.Anything, mock.Anything, domain.FileScanClean, (*string)(nil)).Return(nil)
+
+	file, err := uc.StoreFile(context.Background(), bytes.NewReader([]byte("plain notes")), "notes.txt")
+
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, string(domain.FileScanClean), file.ScanStatus)
+	assert.Equal(t, 1, scanner.Scans())
+	metadata.AssertExpectations(t)
+}
+
+func TestStoreFileKeepsInfectedFileQuarantined(t *testing.T) {
+	uc, metadata, _, api := newScanningFileUseCase()
+	metadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+	metadata.On("SetScanStatus", mock.Anything, mock.Anything, domain.FileScanInfected, mock.MatchedBy(func(sig *string) bool {
+		return sig != nil && *sig == scantest.EICARSignature
+	})).Return(nil)
+
+	_, err := uc.StoreFile(context.Background(), bytes.NewReader([]byte(scantest.EICAR)), "eicar.txt")
+
+	assert.ErrorIs(t, err, ErrFileInfected)
+	assert.Contains(t, err.Error(), scantest.EICARSignature)
+	// Kept for inspection; the orphan collector removes it in time.
+	assert.Equal(t, 1, api.Len())
+	metadata.AssertExpectations(t)
+}
+
+func TestStoreFileStaysPendingWhenScannerIsDown(t *testing.T) {
+	uc, metadata, scanner, _ := newScanningFileUseCase()
+	scanner.Fail(errors.New("connection refused"))
+	var stored *domain.File
+	metadata.On("Create", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*domain.File)
+	}).Return(nil)
+
+	file, err := uc.StoreFile(context.Background(), bytes.NewReader([]byte("plain notes")), "notes.txt")
+
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, string(domain.FileScanPending), file.ScanStatus)
+	metadata.AssertNotCalled(t, "SetScanStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// Once the scanner is back, the pending file is released.
+	scanner.Fail(nil)
+	pending := string(domain.FileScanPending)
+	metadata.On("List", mock.Anything, domain.FileFilter{ScanStatus: &pending}, scanBatch, 0).Return([]*domain.File{stored}, int64(1), nil)
+	metadata.On("SetScanStatus", mock.Anything, file.ID, domain.FileScanClean, (*string)(nil)).Return(nil)
+
+	clean, infected, err := uc.ScanPending(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, clean)
+	assert.Equal(t, 0, infected)
+}
+
+func TestScanVerdictsAreAnnounced(t *testing.T) {
+	uc, metadata, _, _ := newScanningFileUseCase()
+	outbox := new(MockOutboxRepository)
+	tx := new(MockTx)
+	uc.WithEvents(outbox)
+	id, err := uc.fileRepo.Upload(context.Background(), bytes.NewReader([]byte("plain notes")), "notes.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+	pending := string(domain.FileScanPending)
+	released := &domain.File{FileID: id, ContentType: "text/plain", ScanStatus: pending}
+	metadata.On("List", mock.Anything, domain.FileFilter{ScanStatus: &pending}, scanBatch, 0).Return([]*domain.File{released}, int64(1), nil)
+	metadata.On("BeginTx", mock.Anything).Return(tx, nil)
+	metadata.On("SetScanStatusTx", mock.Anything, tx, id, domain.FileScanClean, (*string)(nil)).Return(nil)
+	outbox.On("Insert", mock.Anything, tx, mock.MatchedBy(func(msg repository.OutboxMessage) bool {
+		return msg.EventType == repository.EventFileScanned && msg.AggregateID == id &&
+			bytes.Contains(msg.Payload, []byte(`"file_id":"`+id+`"`)) && bytes.Contains(msg.Payload, []byte(`"scan_status":"clean"`))
+	})).Return(nil)
+	tx.On("Commit", mock.Anything).Return(nil)
+	tx.On("Rollback", mock.Anything).Return(nil)
+
+	clean, _, err := uc.ScanPending(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, clean)
+	outbox.AssertExpectations(t)
+	tx.AssertCalled(t, "Commit", mock.Anything)
+	metadata.AssertNotCalled(t, "SetScanStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestQuarantinedFilesCannotBeDownloaded(t *testing.T) {
+	uc, metadata, _, _ := newScanningFileUseCase()
+	metadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+	metadata.On("SetScanStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	file, err := uc.StoreFile(context.Background(), bytes.NewReader([]byte("plain notes")), "notes.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for status, want := range map[domain.FileScanStatus]error{
+		domain.FileScanPending:  ErrFileQuarantined,
+		domain.FileScanInfected: ErrFileInfected,
+		domain.FileScanClean:    nil,
+		// No metadata: nothing ever scanned it.
+		"": ErrFileQuarantined,
+	} {
+		metadata.ExpectedCalls = metadata.ExpectedCalls[:0]
+		if status == "" {
+			metadata.On("GetByFileID", mock.Anything, file.ID).Return(nil, repository.ErrNotFound)
+		} else {
+			metadata.On("GetByFileID", mock.Anything, file.ID).Return(&domain.File{FileID: file.ID, Filename: "notes.txt", ScanStatus: string(status)}, nil)
+		}
+
+		download, err := uc.OpenFile(context.Background(), file.ID)
+		if want == nil {
+			assert.NoError(t, err, status)
+			download.Close()
+		} else {
+			assert.ErrorIs(t, err, want, status)
+		}
+		rc, err := uc.DownloadFile(context.Background(), file.ID)
+		if want == nil {
+			assert.NoError(t, err, status)
+			rc.Close()
+		} else {
+			assert.ErrorIs(t, err, want, status)
+		}
+	}
+}
+
+func TestStoreFileDoesNotResolveToInfectedFile(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	metadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, s3.NewFileRepository(s3test.NewMemoryAPI(), "bucket"), metadata).WithScanner(scantest.New())
+	metadata.On("ListBySHA256", mock.Anything, mock.Anything).Return([]*domain.File{
+		{FileID: "earlier.txt", Size: int64(len(scantest.EICAR)), ScanStatus: string(domain.FileScanInfected)},
+	}, nil)
+	metadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+	metadata.On("SetScanStatus", mock.Anything, mock.Anything, domain.FileScanInfected, mock.Anything).Return(nil)
+
+	_, err := uc.StoreFile(context.Background(), bytes.NewReader([]byte(scantest.EICAR)), "eicar.txt")
+
+	assert.ErrorIs(t, err, ErrFileInfected)
+	metadata.AssertNotCalled(t, "AddReferences", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestQuarantinedFilesCannotBeAttached(t *testing.T) {
+	uc, _, fileRepo, attachments := newAttachmentUseCase()
+	metadata := new(MockFileMetadataRepository)
+	uc.WithQuarantine(metadata, false)
+	fileRepo.On("Exists", mock.Anything, mock.Anything).Return(true, nil)
+	metadata.On("GetByFileID", mock.Anything, "pending.pdf").Return(&domain.File{ScanStatus: string(domain.FileScanPending)}, nil)
+	metadata.On("GetByFileID", mock.Anything, "infected.pdf").Return(&domain.File{ScanStatus: string(domain.FileScanInfected)}, nil)
+	metadata.On("GetByFileID", mock.Anything, "unconfirmed.pdf").Return(&domain.File{ScanStatus: string(domain.FileScanUnconfirmed)}, nil)
+	metadata.On("GetByFileID", mock.Anything, "legacy.pdf").Return(nil, repository.ErrNotFound)
+	attachments.On("Attach", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := uc.AttachFile(context.Background(), attachmentTodoID, "pending.pdf")
+	assert.ErrorIs(t, err, ErrFileQuarantined)
+	_, err = uc.AttachFile(context.Background(), attachmentTodoID, "infected.pdf")
+	assert.ErrorIs(t, err, ErrFileInfected)
+	_, err = uc.AttachFile(context.Background(), attachmentTodoID, "unconfirmed.pdf")
+	assert.ErrorIs(t, err, ErrUploadUnconfirmed)
+	_, err = uc.AttachFile(context.Background(), attachmentTodoID, "legacy.pdf")
+	assert.NoError(t, err)
+	attachments.AssertNumberOfCalls(t, "Attach", 1)
+
+	uc.WithQuarantine(metadata, true)
+	_, err = uc.AttachFile(context.Background(), attachmentTodoID, "legacy.pdf")
+	assert.ErrorIs(t, err, ErrFileQuarantined)
+	attachments.AssertNumberOfCalls(t, "Attach", 1)
+}
+
+func TestTodosRefuseQuarantinedFiles(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	fileRepo := new(MockFileRepository)
+	metadata := new(MockFileMetadataRepository)
+	todoRepo := new(MockTodoRepository)
+	uc := NewTodoUseCase(log, todoRepo, fileRepo, new(MockCacheRepository), new(MockOutboxRepository)).WithQuarantine(metadata, true)
+	fileRepo.On("Exists", mock.Anything, mock.Anything).Return(true, nil)
+	metadata.On("GetByFileID", mock.Anything, "pending.pdf").Return(&domain.File{ScanStatus: string(domain.FileScanPending)}, nil)
+	metadata.On("GetByFileID", mock.Anything, "legacy.pdf").Return(nil, repository.ErrNotFound)
+
+	_, err := uc.CreateTodoItem(context.Background(), "read it", time.Now(), "pending.pdf")
+	assert.ErrorIs(t, err, ErrFileQuarantined)
+	_, err = uc.CreateTodoItem(context.Background(), "read it", time.Now(), "legacy.pdf")
+	assert.ErrorIs(t, err, ErrFileQuarantined)
+
+	fileID := "pending.pdf"
+	err = uc.UpdateTodoItem(context.Background(), &domain.TodoItem{UUID: "t1", FileID: &fileID})
+	assert.ErrorIs(t, err, ErrFileQuarantined)
+	todoRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+}
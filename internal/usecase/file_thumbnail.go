@@ -0,0 +1,313 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+// ErrThumbnailUnsupported is returned for images that cannot be decoded or
+// are too large to thumbnail. Retrying does not help.
+var ErrThumbnailUnsupported = errors.New("image cannot be thumbnailed")
+
+// thumbnailTypes maps the content types thumbnails are made of to the type
+// the thumbnails are encoded as. PNG keeps transparency.
+var thumbnailTypes = map[string]string{
+	"image/jpeg": "image/jpeg",
+	"image/png":  "image/png",
+	"image/gif":  "image/png",
+}
+
+// ThumbnailPath is where the API serves a file's thumbnail of the given size.
+func ThumbnailPath(fileID string, size int) string {
+	return fmt.Sprintf("/api/v1/files/%s/thumbnails/%d", fileID, size)
+}
+
+// Thumbnails returns the thumbnails of each of files, smallest first.
+// Quarantined files have none, since a thumbnail would show their content.
+// It is empty without WithThumbnails.
+func (u *FileUseCase) Thumbnails(ctx context.Context, files []*domain.File) (map[string][]*domain.FileThumbnail, error) {
+	if u.thumbs == nil {
+		return map[string][]*domain.FileThumbnail{}, nil
+	}
+	var ids []string
+	for _, file := range files {
+		if scanError(file) == nil {
+			ids = append(ids, file.FileID)
+		}
+	}
+	if len(ids) == 0 {
+		return map[string][]*domain.FileThumbnail{}, nil
+	}
+	thumbs, err := u.thumbs.List(ctx, ids)
+	if err != nil {
+		u.logger.Error("Failed to list thumbnails", err)
+		return nil, err
+	}
+	return thumbs, nil
+}
+
+// OpenThumbnail opens fileID's thumbnail of the given size for serving, or
+// returns ErrFileNotFound. Thumbnails of quarantined files are refused like
+// the files themselves. The caller closes the download.
+func (u *FileUseCase) OpenThumbnail(ctx context.Context, fileID string, size int) (*FileDownload, error) {
+	if u.thumbs == nil {
+		return nil, ErrFileNotFound
+	}
+	if err := checkScan(ctx, u.metadata, fileID, u.scanner != nil); err != nil {
+		return nil, err
+	}
+	thumbs, err := u.thumbs.List(ctx, []string{fileID})
+	if err != nil {
+		u.logger.Error("Failed to list thumbnails", err)
+		return nil, err
+	}
+	for _, t := range thumbs[fileID] {
+		if t.Size != size {
+			continue
+		}
+		content, err := u.fileRepo.Open(ctx, t.ThumbnailID)
+		if err == repository.ErrNotFound {
+			return nil, ErrFileNotFound
+		}
+		if err != nil {
+			u.logger.Error("Failed to open thumbnail", err)
+			return nil, err
+		}
+		return &FileDownload{FileContent: content, Filename: t.ThumbnailID, ContentType: t.ContentType}, nil
+	}
+	return nil, ErrFileNotFound
+}
+
+// ThumbnailUseCase makes the thumbnails of uploaded images. It runs in the
+// background, off file.uploaded events, and is safe to run more than once
+// for the same file.
+type ThumbnailUseCase struct {
+	logger   logger.Logger
+	fileRepo repository.FileRepository
+	metadata repository.FileMetadataRepository
+	thumbs   repository.ThumbnailRepository
+	cfg      config.ThumbnailConfig
+}
+
+func NewThumbnailUseCase(logger logger.Logger, fileRepo repository.FileRepository, metadata repository.FileMetadataRepository, thumbs repository.ThumbnailRepository, cfg config.ThumbnailConfig) *ThumbnailUseCase {
+	return &ThumbnailUseCase{
+		logger:   logger,
+		fileRepo: fileRepo,
+		metadata: metadata,
+		thumbs:   thumbs,
+		cfg:      cfg,
+	}
+}
+
+// Generate stores the thumbnails fileID is missing, one per configured size.
+// Files that are gone, infected, are not JPEG, PNG or GIF images, or already
+// have every size are left alone, and so are files still unconfirmed or
+// waiting for their scan: confirming a file announces it with file.uploaded,
+// and releasing it with file.scanned, and Generate runs again on those. An
+// image that does not decode, or has more than MaxPixels, is
+// ErrThumbnailUnsupported.
+func (u *ThumbnailUseCase) Generate(ctx context.Context, fileID string) error {
+	file, err := u.metadata.GetByFileID(ctx, fileID)
+	if err == repository.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch domain.FileScanStatus(file.ScanStatus) {
+	case domain.FileScanUnconfirmed, domain.FileScanPending:
+		u.logger.Debug("Not thumbnailing quarantined file %s until it is released", fileID)
+		return nil
+	case domain.FileScanInfected:
+		u.logger.Warn("Not thumbnailing infected file "+fileID, ErrFileInfected)
+		return nil
+	}
+	if _, ok := thumbnailTypes[file.ContentType]; !ok {
+		return nil
+	}
+
+	existing, err := u.thumbs.List(ctx, []string{fileID})
+	if err != nil {
+		return err
+	}
+	have := make(map[int]bool)
+	for _, t := range existing[fileID] {
+		have[t.Size] = true
+	}
+	var sizes []int
+	for _, size := range u.cfg.Sizes {
+		if size > 0 && !have[size] {
+			have[size] = true
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+
+	img, err := u.decode(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	var stored []*domain.FileThumbnail
+	for _, size := range sizes {
+		t, err := u.store(ctx, file, img, size)
+		if err != nil {
+			u.discard(ctx, stored)
+			return err
+		}
+		stored = append(stored, t)
+	}
+	if err := u.thumbs.Save(ctx, stored); err != nil {
+		u.discard(ctx, stored)
+		if err == repository.ErrDuplicate {
+			// Another worker got there first.
+			return nil
+		}
+		return err
+	}
+	u.logger.Debug("Generated %d thumbnails of %s", len(stored), fileID)
+	return nil
+}
+
+// decode reads fileID as an image, checking its dimensions before decoding
+// the pixels so a small file cannot claim a huge canvas.
+func (u *ThumbnailUseCase) decode(ctx context.Context, fileID string) (*image.RGBA, error) {
+	reader, err := u.fileRepo.Download(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrThumbnailUnsupported, err)
+	}
+	if u.cfg.MaxPixels > 0 && cfg.Width*cfg.Height > u.cfg.MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d is over %d pixels", ErrThumbnailUnsupported, cfg.Width, cfg.Height, u.cfg.MaxPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrThumbnailUnsupported, err)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba, nil
+}
+
+// store scales img into a size x size box and uploads it next to file.
+func (u *ThumbnailUseCase) store(ctx context.Context, file *domain.File, img *image.RGBA, size int) (*domain.FileThumbnail, error) {
+	w, h := fit(img.Bounds().Dx(), img.Bounds().Dy(), size)
+	thumb := downscale(img, w, h)
+
+	var buf bytes.Buffer
+	mime := thumbnailTypes[file.ContentType]
+	ext := ".png"
+	var err error
+	if mime == "image/jpeg" {
+		ext = ".jpg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: u.jpegQuality()})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename)), size, ext)
+	id, err := u.fileRepo.Upload(ctx, &buf, name)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.FileThumbnail{
+		FileID:      file.FileID,
+		Size:        size,
+		ThumbnailID: id,
+		ContentType: mime,
+		Width:       w,
+		Height:      h,
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// discard deletes thumbnails that were stored but will not be recorded.
+func (u *ThumbnailUseCase) discard(ctx context.Context, thumbs []*domain.FileThumbnail) {
+	for _, t := range thumbs {
+		if err := u.fileRepo.Delete(ctx, t.ThumbnailID); err != nil {
+			u.logger.Error("Failed to delete unrecorded thumbnail", err)
+		}
+	}
+}
+
+func (u *ThumbnailUseCase) jpegQuality() int {
+	if q := u.cfg.JPEGQuality; q > 0 && q <= 100 {
+		return q
+	}
+	return jpeg.DefaultQuality
+}
+
+// fit scales w x h down to fit a box x box square, keeping the aspect ratio.
+// Images that already fit keep their size.
+func fit(w, h, box int) (int, int) {
+	if w <= box && h <= box {
+		return w, h
+	}
+	if w >= h {
+		return box, max(1, h*box/w)
+	}
+	return max(1, w*box/h), box
+}
+
+// downscale shrinks src to w x h, each destination pixel the average of the
+// source pixels it covers. Averaging premultiplied RGBA keeps transparent
+// pixels from bleeding their color into the edges.
+func downscale(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if w == sw && h == sh {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[src.PixOffset(x0, sy):src.PixOffset(x1, sy)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			d := dst.PixOffset(x, y)
+			for i := range sum {
+				dst.Pix[d+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,240 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3/s3test"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newThumbnailFixture stores a 300x150 PNG as "photo.png".
+func newThumbnailFixture(t *testing.T, cfg config.ThumbnailConfig) (*ThumbnailUseCase, *s3test.MemoryAPI, *MockFileMetadataRepository, *MockThumbnailRepository, *domain.File) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	api := s3test.NewMemoryAPI()
+	fileRepo := s3.NewFileRepository(api, "bucket")
+	metadata := new(MockFileMetadataRepository)
+	thumbs := new(MockThumbnailRepository)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 150))
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 300; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	id, err := fileRepo.Upload(context.Background(), &buf, "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &domain.File{FileID: id, Filename: "photo.png", ContentType: "image/png"}
+	metadata.On("GetByFileID", mock.Anything, id).Return(file, nil)
+	return NewThumbnailUseCase(log, fileRepo, metadata, thumbs, cfg), api, metadata, thumbs, file
+}
+
+func TestGenerateThumbnails(t *testing.T) {
+	gen, api, _, thumbs, file := newThumbnailFixture(t, config.ThumbnailConfig{Sizes: []int{128, 512}})
+	var saved []*domain.FileThumbnail
+	thumbs.On("List", mock.Anything, []string{file.FileID}).Return(map[string][]*domain.FileThumbnail{}, nil)
+	thumbs.On("Save", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(1).([]*domain.FileThumbnail)
+	}).Return(nil)
+
+	assert.NoError(t, gen.Generate(context.Background(), file.FileID))
+
+	if !assert.Len(t, saved, 2) {
+		return
+	}
+	assert.Equal(t, 128, saved[0].Size)
+	assert.Equal(t, [2]int{128, 64}, [2]int{saved[0].Width, saved[0].Height})
+	// Images are never scaled up.
+	assert.Equal(t, 512, saved[1].Size)
+	assert.Equal(t, [2]int{300, 150}, [2]int{saved[1].Width, saved[1].Height})
+	assert.Equal(t, 3, api.Len())
+
+	reader, err := gen.fileRepo.Download(context.Background(), saved[0].ThumbnailID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer reader.Close()
+	img, err := png.Decode(reader)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, image.Rect(0, 0, 128, 64), img.Bounds())
+	r, g, b, a := img.At(10, 10).RGBA()
+	assert.Equal(t, [4]uint32{0xffff, 0, 0, 0xffff}, [4]uint32{r, g, b, a})
+}
+
+func TestGenerateThumbnailsOnlyMakesMissingSizes(t *testing.T) {
+	gen, api, _, thumbs, file := newThumbnailFixture(t, config.ThumbnailConfig{Sizes: []int{128, 512}})
+	thumbs.On("List", mock.Anything, []string{file.FileID}).Return(map[string][]*domain.FileThumbnail{
+		file.FileID: {{FileID: file.FileID, Size: 128}},
+	}, nil)
+	thumbs.On("Save", mock.Anything, mock.MatchedBy(func(saved []*domain.FileThumbnail) bool {
+		return len(saved) == 1 && saved[0].Size == 512
+	})).Return(nil)
+
+	assert.NoError(t, gen.Generate(context.Background(), file.FileID))
+	assert.Equal(t, 2, api.Len())
+	thumbs.AssertExpectations(t)
+}
+
+func TestGenerateThumbnailsSkipsOtherFiles(t *testing.T) {
+	gen, api, metadata, thumbs, _ := newThumbnailFixture(t, config.ThumbnailConfig{Sizes: []int{128}})
+	metadata.On("GetByFileID", mock.Anything, "notes.pdf").Return(&domain.File{FileID: "notes.pdf", ContentType: "application/pdf"}, nil)
+	metadata.On("GetByFileID", mock.Anything, "gone.png").Return(nil, repository.ErrNotFound)
+
+	assert.NoError(t, gen.Generate(context.Background(), "notes.pdf"))
+	assert.NoError(t, gen.Generate(context.Background(), "gone.png"))
+	assert.Equal(t, 1, api.Len())
+	thumbs.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+}
+
+func TestGenerateThumbnailsWaitsForTheScan(t *testing.T) {
+	gen, api, metadata, thumbs, file := newThumbnailFixture(t, config.ThumbnailConfig{Sizes: []int{128}})
+
+	for _, status := range []domain.FileScanStatus{domain.FileScanUnconfirmed, domain.FileScanPending, domain.FileScanInfected} {
+		scanned := *file
+		scanned.ScanStatus = string(status)
+		metadata.ExpectedCalls = metadata.ExpectedCalls[:0]
+		metadata.On("GetByFileID", mock.Anything, file.FileID).Return(&scanned, nil)
+
+		// Acked rather than retried: file.scanned brings released files back.
+		err := gen.Generate(context.Background(), file.FileID)
+
+		assert.NoError(t, err, status)
+	}
+	assert.Equal(t, 1, api.Len())
+	thumbs.AssertNotCalled(t, "List", mock.Anything, mock.Anything)
+}
+
+func TestThumbnailsOfQuarantinedFilesAreHidden(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	thumbs := new(MockThumbnailRepository)
+	uc := NewFileUseCase(log, s3.NewFileRepository(s3test.NewMemoryAPI(), "bucket"), new(MockFileMetadataRepository)).WithThumbnails(thumbs)
+	thumbs.On("List", mock.Anything, []string{"clean.png", "legacy.png"}).Return(map[string][]*domain.FileThumbnail{
+		"clean.png": {{FileID: "clean.png", Size: 128}},
+	}, nil)
+
+	got, err := uc.Thumbnails(context.Background(), []*domain.File{
+		{FileID: "clean.png", ScanStatus: string(domain.FileScanClean)},
+		{FileID: "pending.png", ScanStatus: string(domain.FileScanPending)},
+		{FileID: "infected.png", ScanStatus: string(domain.FileScanInfected)},
+		{FileID: "legacy.png"},
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, got["clean.png"], 1)
+	assert.Empty(t, got["pending.png"])
+
+	got, err = uc.Thumbnails(context.Background(), []*domain.File{{FileID: "unconfirmed.png", ScanStatus: string(domain.FileScanUnconfirmed)}})
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+	thumbs.AssertNumberOfCalls(t, "List", 1)
+}
+
+func TestGenerateThumbnailsRejectsHugeImages(t *testing.T) {
+	gen, api, _, thumbs, file := newThumbnailFixture(t, config.ThumbnailConfig{Sizes: []int{128}, MaxPixels: 300*150 - 1})
+	thumbs.On("List", mock.Anything, mock.Anything).Return(map[string][]*domain.FileThumbnail{}, nil)
+
+	err := gen.Generate(context.Background(), file.FileID)
+
+	assert.ErrorIs(t, err, ErrThumbnailUnsupported)
+	assert.Equal(t, 1, api.Len())
+}
+
+func TestGenerateThumbnailsRejectsCorruptImages(t *testing.T) {
+	gen, _, metadata, thumbs, _ := newThumbnailFixture(t, config.ThumbnailConfig{Sizes: []int{128}})
+	id, err := gen.fileRepo.Upload(context.Background(), bytes.NewReader([]byte("\x89PNG\r\n\x1a\nnot really")), "broken.png")
+	if !assert.NoError(t, err) {
+		return
+	}
+	metadata.On("GetByFileID", mock.Anything, id).Return(&domain.File{FileID: id, Filename: "broken.png", ContentType: "image/png"}, nil)
+	thumbs.On("List", mock.Anything, mock.Anything).Return(map[string][]*domain.FileThumbnail{}, nil)
+
+	assert.ErrorIs(t, gen.Generate(context.Background(), id), ErrThumbnailUnsupported)
+}
+
+func TestGenerateThumbnailsLosingARaceDiscardsCopies(t *testing.T) {
+	gen, api, _, thumbs, file := newThumbnailFixture(t, config.ThumbnailConfig{Sizes: []int{128, 512}})
+	thumbs.On("List", mock.Anything, mock.Anything).Return(map[string][]*domain.FileThumbnail{}, nil)
+	thumbs.On("Save", mock.Anything, mock.Anything).Return(repository.ErrDuplicate)
+
+	assert.NoError(t, gen.Generate(context.Background(), file.FileID))
+	assert.Equal(t, 1, api.Len())
+}
+
+func TestDownscaleAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.RGBA{R: 200, A: 255})
+	src.Set(1, 0, color.RGBA{R: 100, A: 255})
+	src.Set(0, 1, color.RGBA{G: 40, A: 255})
+	src.Set(1, 1, color.RGBA{A: 0})
+
+	dst := downscale(src, 1, 1)
+
+	assert.Equal(t, color.RGBA{R: 75, G: 10, A: 191}, dst.RGBAAt(0, 0))
+	assert.Equal(t, [2]int{128, 64}, func() [2]int { w, h := fit(1000, 500, 128); return [2]int{w, h} }())
+	assert.Equal(t, [2]int{1, 128}, func() [2]int { w, h := fit(10, 5000, 128); return [2]int{w, h} }())
+}
+
+func TestOpenThumbnail(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	fileRepo := s3.NewFileRepository(s3test.NewMemoryAPI(), "bucket")
+	thumbs := new(MockThumbnailRepository)
+	metadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, fileRepo, metadata).WithThumbnails(thumbs)
+	metadata.On("GetByFileID", mock.Anything, "photo.png").Return(&domain.File{FileID: "photo.png", ScanStatus: string(domain.FileScanClean)}, nil)
+	metadata.On("GetByFileID", mock.Anything, "pending.png").Return(&domain.File{FileID: "pending.png", ScanStatus: string(domain.FileScanPending)}, nil)
+	id, err := fileRepo.Upload(context.Background(), bytes.NewReader([]byte("thumb")), "photo-128.png")
+	if !assert.NoError(t, err) {
+		return
+	}
+	thumbs.On("List", mock.Anything, []string{"photo.png"}).Return(map[string][]*domain.FileThumbnail{
+		"photo.png": {{FileID: "photo.png", Size: 128, ThumbnailID: id, ContentType: "image/png"}},
+	}, nil)
+
+	download, err := uc.OpenThumbnail(context.Background(), "photo.png", 128)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer download.Close()
+	data, _ := io.ReadAll(download)
+	assert.Equal(t, "thumb", string(data))
+	assert.Equal(t, "image/png", download.ContentType)
+
+	_, err = uc.OpenThumbnail(context.Background(), "photo.png", 512)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+	_, err = uc.OpenThumbnail(context.Background(), "pending.png", 128)
+	assert.ErrorIs(t, err, ErrFileQuarantined)
+	_, err = NewFileUseCase(log, fileRepo, nil).OpenThumbnail(context.Background(), "photo.png", 128)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
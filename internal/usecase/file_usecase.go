@@ -3,10 +3,16 @@ package usecase
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"hash"
 	"io"
 	"path/filepath"
+	"time"
 
+	"github.com/delaram/GoTastic/internal/domain"
 	"github.com/delaram/GoTastic/internal/repository"
 	"github.com/delaram/GoTastic/pkg/logger"
 )
@@ -15,67 +21,269 @@ var (
 	ErrFileTooLarge    = errors.New("file too large")
 	ErrInvalidFileType = errors.New("invalid file type")
 	ErrFileNotFound    = errors.New("file not found")
+	ErrFileInUse       = errors.New("file is still referenced by a todo")
 )
 
-const (
-	MaxFileSize = 10 << 20
-
-	AllowedFileTypes = ".jpg,.jpeg,.png,.gif,.pdf,.txt,.doc,.docx"
-)
-
-func isAllowedFileType(ext string) bool {
-	allowed := map[string]bool{
-		".txt": true,
-		".pdf": true,
-		".jpg": true,
-		".png": true,
-	}
-	return allowed[ext]
-}
+// MaxFileSize caps uploads under the default FilePolicy.
+const MaxFileSize = 10 << 20
 
 
 type FileUseCase struct {
 	logger   logger.Logger
 	fileRepo repository.FileRepository
+	metadata repository.FileMetadataRepository
+	policy   *FilePolicy
+	refs     repository.FileReferenceRepository
+	outbox   repository.OutboxRepository
+	thumbs   repository.ThumbnailRepository
+	scanner  repository.FileScanner
 }
 
 
-func NewFileUseCase(logger logger.Logger, fileRepo repository.FileRepository) *FileUseCase {
+func NewFileUseCase(logger logger.Logger, fileRepo repository.FileRepository, metadata repository.FileMetadataRepository) *FileUseCase {
 	return &FileUseCase{
 		logger:   logger,
 		fileRepo: fileRepo,
+		metadata: metadata,
+		policy:   DefaultFilePolicy(),
 	}
 }
 
+type uploaderKey struct{}
+
+// ContextWithUploader records who is making the request; files stored with
+// the returned context are attributed to them.
+func ContextWithUploader(ctx context.Context, uploader string) context.Context {
+	if uploader == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, uploaderKey{}, uploader)
+}
+
+func uploaderFrom(ctx context.Context) *string {
+	if uploader, ok := ctx.Value(uploaderKey{}).(string); ok {
+		return &uploader
+	}
+	return nil
+}
+
+// WithPolicy sets the policy uploads are checked against.
+func (u *FileUseCase) WithPolicy(policy *FilePolicy) *FileUseCase {
+	u.policy = policy
+	return u
+}
+
+// WithReferences makes DeleteFile refuse files that todos still refer to.
+func (u *FileUseCase) WithReferences(refs repository.FileReferenceRepository) *FileUseCase {
+	u.refs = refs
+	return u
+}
+
+// WithEvents makes every recorded upload write a file.uploaded event to the
+// outbox, in the same transaction as its metadata.
+func (u *FileUseCase) WithEvents(outbox repository.OutboxRepository) *FileUseCase {
+	u.outbox = outbox
+	return u
+}
+
+// WithThumbnails makes the thumbnails generated for image files available
+// through Thumbnails and OpenThumbnail.
+func (u *FileUseCase) WithThumbnails(thumbs repository.ThumbnailRepository) *FileUseCase {
+	u.thumbs = thumbs
+	return u
+}
+
+// MaxUploadSize is the largest upload the policy allows for any type.
+func (u *FileUseCase) MaxUploadSize() int64 {
+	return u.policy.MaxSize()
+}
+
 
 func (u *FileUseCase) UploadFile(ctx context.Context, reader io.Reader, filename string) (string, error) {
+	file, err := u.StoreFile(ctx, reader, filename)
+	if err != nil {
+		return "", err
+	}
+	return file.ID, nil
+}
+
+// UploadedFile describes a file StoreFile wrote. ScanStatus is empty without
+// WithScanner.
+type UploadedFile struct {
+	ID         string
+	Size       int64
+	SHA256     string
+	ScanStatus string
+}
 
-	ext := filepath.Ext(filename)
-	if !isAllowedFileType(ext) {
-		return "", ErrInvalidFileType
+// StoreFile streams reader to the file repository without holding it in
+// memory. The first bytes are sniffed and checked against the policy, which
+// fails with an *UnsupportedFileTypeError; the body is then cut off with a
+// *FileTooLargeError as soon as it passes its type's limit, and hashed on the
+// way through. Content that is already stored resolves to the existing file,
+// which gains a reference, and the new copy is deleted. Otherwise the file's
+// metadata is recorded; if that fails the content is deleted again. With
+// WithScanner the new file is then scanned, and an infected one is
+// ErrFileInfected.
+func (u *FileUseCase) StoreFile(ctx context.Context, reader io.Reader, filename string) (*UploadedFile, error) {
+	mime, body, err := u.inspect(reader, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fileID, err := u.fileRepo.Upload(ctx, body, filename)
+	if body.exceeded {
+		// The repository may wrap the read error, or not see it at all.
+		if err == nil {
+			if err := u.fileRepo.Delete(ctx, fileID); err != nil {
+				u.logger.Error("Failed to delete oversized file", err)
+			}
+		}
+		return nil, &FileTooLargeError{MIME: mime, Limit: body.limit}
+	}
+	if err != nil {
+		u.logger.Error("Failed to upload file", err)
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(body.hash.Sum(nil))
+	if existing := u.duplicateOf(ctx, sum, body.read); existing != nil {
+		if err := u.fileRepo.Delete(ctx, fileID); err != nil {
+			u.logger.Error("Failed to delete duplicate file", err)
+		}
+		u.logger.Debug("Upload of %s resolved to stored file %s", fileID, existing.FileID)
+		return &UploadedFile{ID: existing.FileID, Size: existing.Size, SHA256: existing.SHA256, ScanStatus: existing.ScanStatus}, nil
 	}
 
+	file, err := u.record(ctx, fileID, filename, mime, body)
+	if err != nil {
+		if err := u.fileRepo.Delete(ctx, fileID); err != nil {
+			u.logger.Error("Failed to delete file without metadata", err)
+		}
+		return nil, err
+	}
+	if u.scanner != nil {
+		if err := u.scan(ctx, file); err != nil {
+			return nil, err
+		}
+	}
+	return &UploadedFile{ID: file.FileID, Size: file.Size, SHA256: file.SHA256, ScanStatus: file.ScanStatus}, nil
+}
 
-	content, err := io.ReadAll(reader)
+// duplicateOf finds a stored file with the given content and adds a
+// reference to it. Deduplication only saves space, so when the lookup fails
+// the upload is kept as a file of its own.
+func (u *FileUseCase) duplicateOf(ctx context.Context, sha256 string, size int64) *domain.File {
+	files, err := u.metadata.ListBySHA256(ctx, sha256)
 	if err != nil {
+		u.logger.Warn("Failed to look up file by content hash", err)
+		return nil
+	}
+	for _, file := range files {
+		// An infected file stays quarantined; the upload is scanned afresh.
+		if file.Size != size || file.ScanStatus == string(domain.FileScanInfected) {
+			continue
+		}
+		// Metadata can outlive its content if a delete half failed.
+		if exists, err := u.fileRepo.Exists(ctx, file.FileID); err != nil || !exists {
+			continue
+		}
+		if err := u.metadata.AddReferences(ctx, file.FileID, 1); err != nil {
+			u.logger.Warn("Failed to reference stored file", err)
+			return nil
+		}
+		return file
+	}
+	return nil
+}
+
+// inspect sniffs the start of reader and checks it against the policy. The
+// returned reader yields the whole body again, hashing it and cutting it off
+// past the type's limit.
+func (u *FileUseCase) inspect(reader io.Reader, filename string) (string, *uploadReader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		u.logger.Error("Failed to read file content", err)
-		return "", err
+		return "", nil, err
 	}
-	if len(content) > MaxFileSize {
-		return "", ErrFileTooLarge
+	head = head[:n]
+	mime, limit, err := u.policy.Check(filename, head)
+	if err != nil {
+		return "", nil, err
 	}
+	return mime, newUploadReader(io.MultiReader(bytes.NewReader(head), reader), limit), nil
+}
 
+// record saves the metadata of a file whose body has been read through.
+func (u *FileUseCase) record(ctx context.Context, fileID, filename, mime string, body *uploadReader) (*domain.File, error) {
+	file := u.newFile(ctx, fileID, filename, mime, body)
+	if err := u.create(ctx, file); err != nil {
+		u.logger.Error("Failed to record file metadata", err)
+		return nil, err
+	}
+	u.logger.Debug("Stored %s file %s (%d bytes, sha256 %s)", mime, file.FileID, file.Size, file.SHA256)
+	return file, nil
+}
 
-	contentReader := bytes.NewReader(content)
+// newFile is the metadata of a file whose body has been read through.
+func (u *FileUseCase) newFile(ctx context.Context, fileID, filename, mime string, body *uploadReader) *domain.File {
+	file := &domain.File{
+		FileID:      fileID,
+		Filename:    filepath.Base(filename),
+		ContentType: mime,
+		Size:        body.read,
+		SHA256:      hex.EncodeToString(body.hash.Sum(nil)),
+		UploadedBy:  uploaderFrom(ctx),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if u.scanner != nil {
+		file.ScanStatus = string(domain.FileScanPending)
+	}
+	return file
+}
+
+// create saves file, together with its file.uploaded event under WithEvents.
+func (u *FileUseCase) create(ctx context.Context, file *domain.File) error {
+	if u.outbox == nil {
+		return u.metadata.Create(ctx, file)
+	}
+	return u.writeWithEvent(ctx, file, u.metadata.CreateTx)
+}
 
-	fileID, err := u.fileRepo.Upload(ctx, contentReader, filename)
+// writeWithEvent runs write in a transaction, together with file's
+// file.uploaded event under WithEvents.
+func (u *FileUseCase) writeWithEvent(ctx context.Context, file *domain.File, write func(ctx context.Context, tx repository.Tx, file *domain.File) error) error {
+	tx, err := u.metadata.BeginTx(ctx)
 	if err != nil {
-		u.logger.Error("Failed to upload file", err)
-		return "", err
+		return err
 	}
-	return fileID, nil
+	defer tx.Rollback(ctx)
+	if err := write(ctx, tx, file); err != nil {
+		return err
+	}
+	if u.outbox != nil {
+		if err := u.announce(ctx, tx, file.FileID, repository.EventFileUploaded, repository.NewFileUploaded(file)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
 }
 
+// announce stages a file event on tx.
+func (u *FileUseCase) announce(ctx context.Context, tx repository.Tx, fileID, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return u.outbox.Insert(ctx, tx, repository.OutboxMessage{
+		AggregateType: "file",
+		AggregateID:   fileID,
+		EventType:     eventType,
+		Payload:       payload,
+		Headers:       map[string]string{"origin": "api", "schemaversion": "v1"},
+	})
+}
 
 func (u *FileUseCase) DownloadFile(ctx context.Context, fileID string) (io.ReadCloser, error) {
 
@@ -87,6 +295,9 @@ func (u *FileUseCase) DownloadFile(ctx context.Context, fileID string) (io.ReadC
 	if !exists {
 		return nil, ErrFileNotFound
 	}
+	if err := checkScan(ctx, u.metadata, fileID, u.scanner != nil); err != nil {
+		return nil, err
+	}
 
 	reader, err := u.fileRepo.Download(ctx, fileID)
 	if err != nil {
@@ -96,7 +307,56 @@ func (u *FileUseCase) DownloadFile(ctx context.Context, fileID string) (io.ReadC
 	return reader, nil
 }
 
+// FileDownload is a file opened for serving, named and typed after its
+// metadata. Files uploaded before metadata was recorded are named after their
+// id and have no ContentType.
+type FileDownload struct {
+	repository.FileContent
+	Filename    string
+	ContentType string
+}
+
+// OpenFile opens fileID for serving, or returns ErrFileNotFound. A
+// quarantined file is ErrFileQuarantined or ErrFileInfected, and so is one
+// without metadata under WithScanner. The caller closes the download.
+func (u *FileUseCase) OpenFile(ctx context.Context, fileID string) (*FileDownload, error) {
+	content, err := u.fileRepo.Open(ctx, fileID)
+	if err == repository.ErrNotFound {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		u.logger.Error("Failed to open file", err)
+		return nil, err
+	}
+
+	download := &FileDownload{FileContent: content, Filename: fileID}
+	file, err := u.metadata.GetByFileID(ctx, fileID)
+	switch {
+	case err == nil:
+		if err := scanError(file); err != nil {
+			content.Close()
+			return nil, err
+		}
+		download.Filename, download.ContentType = file.Filename, file.ContentType
+	case err == repository.ErrNotFound:
+		if err := unscanned(u.scanner != nil); err != nil {
+			content.Close()
+			return nil, err
+		}
+	default:
+		content.Close()
+		u.logger.Error("Failed to get file metadata", err)
+		return nil, err
+	}
+	return download, nil
+}
 
+// DeleteFile drops one reference to a file; the content and metadata are
+// only deleted with the last one. With WithReferences, deleting the last
+// reference to a file a todo still refers to is ErrFileInUse. The metadata
+// goes first, so an upload cannot be resolved to content about to be
+// deleted; content left behind by a failed delete is the orphaned file
+// collector's.
 func (u *FileUseCase) DeleteFile(ctx context.Context, fileID string) error {
 
 	exists, err := u.fileRepo.Exists(ctx, fileID)
@@ -107,7 +367,36 @@ func (u *FileUseCase) DeleteFile(ctx context.Context, fileID string) error {
 	if !exists {
 		return ErrFileNotFound
 	}
+	// Files uploaded before metadata was recorded have a single reference.
+	remaining, err := u.metadata.Release(ctx, fileID)
+	if err != nil && err != repository.ErrNotFound {
+		u.logger.Error("Failed to release file", err)
+		return err
+	}
+	if remaining > 0 {
+		return nil
+	}
+	if u.refs != nil {
+		referenced, err := u.refs.Referenced(ctx, []string{fileID})
+		if err != nil {
+			u.logger.Error("Failed to check file references", err)
+			return err
+		}
+		if referenced[fileID] {
+			return ErrFileInUse
+		}
+	}
 
+	// Files uploaded before metadata was recorded have none to delete.
+	remaining, err = u.metadata.DeleteLast(ctx, fileID)
+	if err != nil && err != repository.ErrNotFound {
+		u.logger.Error("Failed to delete file metadata", err)
+		return err
+	}
+	if remaining > 0 {
+		// An upload of the same content took a reference meanwhile.
+		return nil
+	}
 	if err := u.fileRepo.Delete(ctx, fileID); err != nil {
 		u.logger.Error("Failed to delete file", err)
 		return err
@@ -115,6 +404,36 @@ func (u *FileUseCase) DeleteFile(ctx context.Context, fileID string) error {
 	return nil
 }
 
+// GetFile returns the metadata recorded for fileID, or ErrFileNotFound.
+func (u *FileUseCase) GetFile(ctx context.Context, fileID string) (*domain.File, error) {
+	file, err := u.metadata.GetByFileID(ctx, fileID)
+	if err == repository.ErrNotFound {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		u.logger.Error("Failed to get file metadata", err)
+		return nil, err
+	}
+	return file, nil
+}
+
+// ListFiles returns a page of file metadata, newest first, and the total
+// number of files matching f.
+func (u *FileUseCase) ListFiles(ctx context.Context, f domain.FileFilter, limit, offset int) ([]*domain.File, int64, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	files, total, err := u.metadata.List(ctx, f, limit, offset)
+	if err != nil {
+		u.logger.Error("Failed to list files", err)
+		return nil, 0, err
+	}
+	return files, total, nil
+}
+
 		
 func (u *FileUseCase) FileExists(ctx context.Context, fileID string) (bool, error) {
 	exists, err := u.fileRepo.Exists(ctx, fileID)
@@ -124,3 +443,36 @@ func (u *FileUseCase) FileExists(ctx context.Context, fileID string) (bool, erro
 	}
 	return exists, nil
 }
+
+// uploadReader passes an upload through, hashing it and failing with
+// ErrFileTooLarge once more than limit bytes have been read.
+type uploadReader struct {
+	r        io.Reader
+	limit    int64
+	read     int64
+	hash     hash.Hash
+	exceeded bool
+}
+
+func newUploadReader(r io.Reader, limit int64) *uploadReader {
+	return &uploadReader{r: r, limit: limit, hash: sha256.New()}
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	if u.exceeded {
+		return 0, ErrFileTooLarge
+	}
+	// Read one byte past the limit so an exactly-full body still succeeds.
+	if room := u.limit - u.read + 1; int64(len(p)) > room {
+		p = p[:room]
+	}
+	n, err := u.r.Read(p)
+	if u.read+int64(n) > u.limit {
+		u.exceeded = true
+		n = int(u.limit - u.read)
+		err = ErrFileTooLarge
+	}
+	u.read += int64(n)
+	u.hash.Write(p[:n])
+	return n, err
+}
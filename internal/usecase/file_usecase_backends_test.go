@@ -0,0 +1,159 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/infrastructure/envelope"
+	"github.com/delaram/GoTastic/internal/infrastructure/localfs"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3"
+	"github.com/delaram/GoTastic/internal/infrastructure/s3/s3test"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// fileBackends are the real FileRepository implementations the file use
+// case tests run against, on top of the mocked ones.
+var fileBackends = []struct {
+	name string
+	new  func(t *testing.T) repository.FileRepository
+}{
+	{"s3", func(t *testing.T) repository.FileRepository {
+		return s3.NewFileRepository(s3test.NewMemoryAPI(), "bucket")
+	}},
+	{"local", func(t *testing.T) repository.FileRepository {
+		repo, err := localfs.NewFileRepository(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}},
+	{"encrypted local", func(t *testing.T) repository.FileRepository {
+		inner, err := localfs.NewFileRepository(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := envelope.NewFileRepository(inner, bytes.Repeat([]byte{1}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}},
+}
+
+func TestFileUseCaseBackends(t *testing.T) {
+	for _, backend := range fileBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			testFileUseCaseBackend(t, backend.new)
+		})
+	}
+}
+
+func testFileUseCaseBackend(t *testing.T, newRepo func(t *testing.T) repository.FileRepository) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	ctx := context.Background()
+
+	t.Run("UploadDownloadDelete", func(t *testing.T) {
+		mockMetadata := new(MockFileMetadataRepository)
+		uc := NewFileUseCase(log, newRepo(t), mockMetadata)
+		content := []byte("test file content")
+		mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+		mockMetadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+		mockMetadata.On("Release", mock.Anything, mock.Anything).Return(0, nil)
+		mockMetadata.On("DeleteLast", mock.Anything, mock.Anything).Return(0, nil)
+		mockMetadata.On("GetByFileID", mock.Anything, mock.Anything).Return(nil, repository.ErrNotFound)
+
+		file, err := uc.StoreFile(ctx, bytes.NewReader(content), "test.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+		sum := sha256.Sum256(content)
+		assert.Equal(t, hex.EncodeToString(sum[:]), file.SHA256)
+
+		rc, err := uc.DownloadFile(ctx, file.ID)
+		if !assert.NoError(t, err) {
+			return
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+
+		assert.NoError(t, uc.DeleteFile(ctx, file.ID))
+		exists, err := uc.FileExists(ctx, file.ID)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		assert.ErrorIs(t, uc.DeleteFile(ctx, file.ID), ErrFileNotFound)
+		_, err = uc.DownloadFile(ctx, file.ID)
+		assert.ErrorIs(t, err, ErrFileNotFound)
+	})
+
+	t.Run("OpenFileServesRanges", func(t *testing.T) {
+		mockMetadata := new(MockFileMetadataRepository)
+		uc := NewFileUseCase(log, newRepo(t), mockMetadata)
+		mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+		mockMetadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+		mockMetadata.On("GetByFileID", mock.Anything, mock.Anything).Return(nil, repository.ErrNotFound)
+
+		file, err := uc.StoreFile(ctx, bytes.NewReader([]byte("hello world")), "hello.txt")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		download, err := uc.OpenFile(ctx, file.ID)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer download.Close()
+		assert.Equal(t, file.ID, download.Filename, "files without metadata are named after their id")
+		_, err = download.Seek(6, io.SeekStart)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(download)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(data))
+
+		_, err = uc.OpenFile(ctx, "missing.txt")
+		assert.ErrorIs(t, err, ErrFileNotFound)
+	})
+
+	t.Run("StoreFileDeletesContentWhenMetadataFails", func(t *testing.T) {
+		mockMetadata := new(MockFileMetadataRepository)
+		var stored string
+		mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+		mockMetadata.On("Create", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			stored = args.Get(1).(*domain.File).FileID
+		}).Return(errors.New("connection refused"))
+		repo := newRepo(t)
+		uc := NewFileUseCase(log, repo, mockMetadata)
+
+		_, err := uc.StoreFile(ctx, bytes.NewReader([]byte("some notes")), "notes.txt")
+
+		assert.Error(t, err)
+		assert.NotEmpty(t, stored)
+		exists, err := repo.Exists(ctx, stored)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("StoreFileStopsReadingPastLimit", func(t *testing.T) {
+		uc := NewFileUseCase(log, newRepo(t), new(MockFileMetadataRepository))
+
+		_, err := uc.StoreFile(ctx, &countingReader{r: textReader{}}, "endless.txt")
+
+		var tooLarge *FileTooLargeError
+		assert.ErrorAs(t, err, &tooLarge)
+	})
+}
@@ -3,10 +3,15 @@ package usecase
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"testing"
 	"time"
 
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
 	"github.com/delaram/GoTastic/pkg/logger"
 	"github.com/stretchr/testify/mock"
 )
@@ -19,7 +24,8 @@ func BenchmarkUploadFile(b *testing.B) {
 		Pretty:     true,
 	})
 	mockFileRepo := new(MockFileRepository)
-	uc := NewFileUseCase(log, mockFileRepo)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
 
 
 	fileContent := []byte("test file content")
@@ -28,6 +34,8 @@ func BenchmarkUploadFile(b *testing.B) {
 
 
 	mockFileRepo.On("Upload", mock.Anything, mock.Anything, filename).Return("test-file-id", nil)
+	mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+	mockMetadata.On("Create", mock.Anything, mock.Anything).Return(nil)
 
 
 	b.ResetTimer()
@@ -49,11 +57,13 @@ func BenchmarkDownloadFile(b *testing.B) {
 		Pretty:     true,
 	})
 	mockRepo := new(MockFileRepository)
-	useCase := NewFileUseCase(log, mockRepo)
+	mockMetadata := new(MockFileMetadataRepository)
+	useCase := NewFileUseCase(log, mockRepo, mockMetadata)
 
 
 	mockRepo.On("Exists", mock.Anything, "test-file-id").Return(true, nil)
 	mockRepo.On("Download", mock.Anything, "test-file-id").Return(io.NopCloser(bytes.NewReader([]byte("test content"))), nil)
+	mockMetadata.On("GetByFileID", mock.Anything, "test-file-id").Return(nil, repository.ErrNotFound)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -74,11 +84,14 @@ func BenchmarkDeleteFile(b *testing.B) {
 		Pretty:     true,
 	})
 	mockRepo := new(MockFileRepository)
-	useCase := NewFileUseCase(log, mockRepo)
+	mockMetadata := new(MockFileMetadataRepository)
+	useCase := NewFileUseCase(log, mockRepo, mockMetadata)
 
 			
 	mockRepo.On("Exists", mock.Anything, "test-file-id").Return(true, nil)
+	mockMetadata.On("Release", mock.Anything, "test-file-id").Return(0, nil)
 	mockRepo.On("Delete", mock.Anything, "test-file-id").Return(nil)
+	mockMetadata.On("DeleteLast", mock.Anything, "test-file-id").Return(0, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -88,3 +101,70 @@ func BenchmarkDeleteFile(b *testing.B) {
 		}
 	}
 }
+
+// discardFileRepo drains uploads like a streaming backend, without the
+// bookkeeping of the mock, so the benchmarks measure the use case alone.
+type discardFileRepo struct {
+	repository.FileRepository
+}
+
+func (discardFileRepo) Upload(ctx context.Context, file io.Reader, filename string) (string, error) {
+	if _, err := io.Copy(io.Discard, file); err != nil {
+		return "", err
+	}
+	return "test-file-id", nil
+}
+
+type discardMetadataRepo struct {
+	repository.FileMetadataRepository
+}
+
+func (discardMetadataRepo) Create(ctx context.Context, file *domain.File) error {
+	return nil
+}
+
+func (discardMetadataRepo) ListBySHA256(ctx context.Context, sha256 string) ([]*domain.File, error) {
+	return nil, nil
+}
+
+// BenchmarkStoreFileStreaming uploads bodies of growing size. Bytes per
+// operation stay flat because nothing holds the whole body; oversized bodies
+// are rejected after MaxFileSize bytes whatever their length.
+func BenchmarkStoreFileStreaming(b *testing.B) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	// Allow text up to MaxFileSize so one type covers every size below.
+	policy, err := NewFilePolicy(config.FilesConfig{
+		MaxSize: MaxFileSize,
+		Types:   []config.FileTypeConfig{{MIME: "text/plain", Extensions: []string{".txt"}}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	uc := NewFileUseCase(log, discardFileRepo{}, discardMetadataRepo{}).WithPolicy(policy)
+
+	for _, size := range []int64{64 << 10, 1 << 20, MaxFileSize} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := uc.StoreFile(context.Background(), io.LimitReader(textReader{}, size), "bench.txt"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	b.Run("Oversized1GiB", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := uc.StoreFile(context.Background(), io.LimitReader(textReader{}, 1<<30), "bench.txt")
+			if !errors.Is(err, ErrFileTooLarge) {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -3,10 +3,16 @@ package usecase
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"testing"
 	"time"
 
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
 	"github.com/delaram/GoTastic/pkg/logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -20,7 +26,8 @@ func TestUploadFile(t *testing.T) {
 		Pretty:     true,
 	})
 	mockFileRepo := new(MockFileRepository)
-	uc := NewFileUseCase(log, mockFileRepo)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
 
 
 	fileContent := []byte("test file content")
@@ -29,15 +36,21 @@ func TestUploadFile(t *testing.T) {
 
 
 	mockFileRepo.On("Upload", mock.Anything, mock.Anything, filename).Return("test-file-id", nil)
+	mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return([]*domain.File{}, nil)
+	mockMetadata.On("Create", mock.Anything, mock.MatchedBy(func(f *domain.File) bool {
+		return f.FileID == "test-file-id" && f.Filename == filename && f.ContentType == "text/plain" &&
+			f.Size == int64(len(fileContent)) && f.UploadedBy != nil && *f.UploadedBy == "alice"
+	})).Return(nil)
 
 
-	fileID, err := uc.UploadFile(context.Background(), reader, filename)
+	fileID, err := uc.UploadFile(ContextWithUploader(context.Background(), "alice"), reader, filename)
 
 
 	assert.NoError(t, err)
 	assert.Equal(t, "test-file-id", fileID)
 
 	mockFileRepo.AssertExpectations(t)
+	mockMetadata.AssertExpectations(t)
 }
 
 func TestUploadFileTooLarge(t *testing.T) {
@@ -48,10 +61,11 @@ func TestUploadFileTooLarge(t *testing.T) {
 		Pretty:     true,
 	})
 	mockFileRepo := new(MockFileRepository)
-	uc := NewFileUseCase(log, mockFileRepo)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
 
 
-	fileContent := make([]byte, MaxFileSize+1)
+	fileContent := bytes.Repeat([]byte("a"), MaxFileSize+1)
 	reader := bytes.NewReader(fileContent)
 	filename := "test.txt"
 
@@ -59,8 +73,7 @@ func TestUploadFileTooLarge(t *testing.T) {
 	_, err := uc.UploadFile(context.Background(), reader, filename)
 
 
-	assert.Error(t, err)
-	assert.Equal(t, ErrFileTooLarge, err)
+	assert.ErrorIs(t, err, ErrFileTooLarge)
 	mockFileRepo.AssertNotCalled(t, "Upload")
 }
 
@@ -72,7 +85,8 @@ func TestUploadFileInvalidType(t *testing.T) {
 		Pretty:     true,
 	})
 	mockFileRepo := new(MockFileRepository)
-	uc := NewFileUseCase(log, mockFileRepo)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
 
 
 	fileContent := []byte("test file content")
@@ -83,8 +97,7 @@ func TestUploadFileInvalidType(t *testing.T) {
 	_, err := uc.UploadFile(context.Background(), reader, filename)
 
 
-	assert.Error(t, err)
-	assert.Equal(t, ErrInvalidFileType, err)
+	assert.ErrorIs(t, err, ErrInvalidFileType)
 	mockFileRepo.AssertNotCalled(t, "Upload")
 }
 
@@ -96,7 +109,8 @@ func TestDownloadFile(t *testing.T) {
 		Pretty:     true,
 	})
 	mockFileRepo := new(MockFileRepository)
-	uc := NewFileUseCase(log, mockFileRepo)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
 
 	
 	fileID := "test-file-id"
@@ -106,6 +120,7 @@ func TestDownloadFile(t *testing.T) {
 	
 	mockFileRepo.On("Exists", mock.Anything, fileID).Return(true, nil)
 	mockFileRepo.On("Download", mock.Anything, fileID).Return(reader, nil)
+	mockMetadata.On("GetByFileID", mock.Anything, fileID).Return(nil, repository.ErrNotFound)
 
 	
 	rc, err := uc.DownloadFile(context.Background(), fileID)
@@ -130,14 +145,17 @@ func TestDeleteFile(t *testing.T) {
 		Pretty:     true,
 	})
 	mockFileRepo := new(MockFileRepository)
-	uc := NewFileUseCase(log, mockFileRepo)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
 
 	
 	fileID := "test-file-id"
 
 	
 	mockFileRepo.On("Exists", mock.Anything, fileID).Return(true, nil)
+	mockMetadata.On("Release", mock.Anything, fileID).Return(0, nil)
 	mockFileRepo.On("Delete", mock.Anything, fileID).Return(nil)
+	mockMetadata.On("DeleteLast", mock.Anything, fileID).Return(0, nil)
 
 			
 	err := uc.DeleteFile(context.Background(), fileID)
@@ -146,4 +164,331 @@ func TestDeleteFile(t *testing.T) {
 	assert.NoError(t, err)
 
 	mockFileRepo.AssertExpectations(t)
+	mockMetadata.AssertExpectations(t)
+}
+
+func TestStoreFileStreamsAndHashesBody(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
+
+	// Text files are limited to 1 MiB by default; this one is exactly full.
+	content := bytes.Repeat([]byte("a"), 1<<20)
+	mockFileRepo.On("Upload", mock.Anything, mock.Anything, "full.txt").Return("test-file-id", nil)
+	mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+	mockMetadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	file, err := uc.StoreFile(context.Background(), bytes.NewReader(content), "full.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test-file-id", file.ID)
+	assert.Equal(t, int64(1<<20), file.Size)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), file.SHA256)
+}
+
+func TestStoreFileStopsReadingPastLimit(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
+
+	// An endless body must be cut off, not drained.
+	body := &countingReader{r: textReader{}}
+	_, err := uc.StoreFile(context.Background(), body, "endless.txt")
+
+	var tooLarge *FileTooLargeError
+	assert.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, "text/plain", tooLarge.MIME)
+	assert.Equal(t, int64(1<<20), tooLarge.Limit)
+	assert.LessOrEqual(t, body.n, int64(1<<20+sniffLen))
+	mockFileRepo.AssertNotCalled(t, "Upload")
+}
+
+// textReader is an endless plain-text body.
+type textReader struct{}
+
+func (textReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestFilePolicy(t *testing.T) {
+	policy, err := NewFilePolicy(config.FilesConfig{
+		MaxSize: 1 << 20,
+		Types: []config.FileTypeConfig{
+			{MIME: "image/png", Extensions: []string{"png"}, MaxSize: 4 << 20},
+			{MIME: "text/plain", Extensions: []string{".txt", ".TEXT"}, MaxSize: 1 << 10},
+		},
+	})
+	assert.NoError(t, err)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	mime, limit, err := policy.Check("photo.PNG", png)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mime)
+	assert.Equal(t, int64(1<<20), limit, "a type cannot raise the overall limit")
+
+	mime, limit, err = policy.Check("notes.text", []byte("plain words"))
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", mime)
+	assert.Equal(t, int64(1<<10), limit)
+
+	_, _, err = policy.Check("photo.txt", png)
+	var unsupported *UnsupportedFileTypeError
+	assert.ErrorAs(t, err, &unsupported)
+	assert.Equal(t, "image/png", unsupported.Detected)
+	assert.Equal(t, "text/plain", unsupported.Expected)
+	assert.ErrorIs(t, err, ErrInvalidFileType)
+
+	_, _, err = policy.Check("setup.exe", []byte("MZ"))
+	assert.ErrorAs(t, err, &unsupported)
+	assert.Equal(t, "", unsupported.Detected)
+
+	_, err = NewFilePolicy(config.FilesConfig{Types: []config.FileTypeConfig{{MIME: "image/nope", Extensions: []string{".nope"}}}})
+	assert.Error(t, err)
+	_, err = NewFilePolicy(config.FilesConfig{Types: []config.FileTypeConfig{
+		{MIME: "image/jpeg", Extensions: []string{".jpg"}},
+		{MIME: "image/png", Extensions: []string{".JPG"}},
+	}})
+	assert.Error(t, err)
+}
+
+func TestStoreFileRejectsMismatchedContent(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
+
+	// A PDF renamed to look like an image.
+	_, err := uc.StoreFile(context.Background(), bytes.NewReader([]byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")), "cat.jpg")
+
+	assert.ErrorIs(t, err, ErrInvalidFileType)
+	assert.EqualError(t, err, `file content is application/pdf, but ".jpg" files must be image/jpeg`)
+	mockFileRepo.AssertNotCalled(t, "Upload", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestStoreFileDeletesContentWhenMetadataFails(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
+	dbErr := errors.New("connection refused")
+
+	mockFileRepo.On("Upload", mock.Anything, mock.Anything, "notes.txt").Return("test-file-id", nil)
+	mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+	mockMetadata.On("Create", mock.Anything, mock.Anything).Return(dbErr)
+	mockFileRepo.On("Delete", mock.Anything, "test-file-id").Return(nil)
+
+	_, err := uc.StoreFile(context.Background(), bytes.NewReader([]byte("some notes")), "notes.txt")
+
+	assert.ErrorIs(t, err, dbErr)
+	mockFileRepo.AssertExpectations(t)
+}
+
+func TestGetAndListFiles(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, new(MockFileRepository), mockMetadata)
+	file := &domain.File{FileID: "a.pdf", Filename: "report.pdf", ContentType: "application/pdf", Size: 42}
+	uploader := "alice"
+	filter := domain.FileFilter{UploadedBy: &uploader}
+
+	mockMetadata.On("GetByFileID", mock.Anything, "a.pdf").Return(file, nil)
+	mockMetadata.On("GetByFileID", mock.Anything, "missing.pdf").Return(nil, repository.ErrNotFound)
+	// Out-of-range paging falls back to the defaults.
+	mockMetadata.On("List", mock.Anything, filter, 20, 0).Return([]*domain.File{file}, int64(1), nil)
+
+	got, err := uc.GetFile(context.Background(), "a.pdf")
+	assert.NoError(t, err)
+	assert.Equal(t, file, got)
+
+	_, err = uc.GetFile(context.Background(), "missing.pdf")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+
+	files, total, err := uc.ListFiles(context.Background(), filter, 1000, -5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []*domain.File{file}, files)
+}
+
+func TestDeleteFileRefusesReferencedFiles(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	mockRefs := new(MockFileReferenceRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata).WithReferences(mockRefs)
+
+	mockFileRepo.On("Exists", mock.Anything, "used.pdf").Return(true, nil)
+	mockFileRepo.On("Exists", mock.Anything, "free.pdf").Return(true, nil)
+	mockMetadata.On("Release", mock.Anything, mock.Anything).Return(0, nil)
+	mockRefs.On("Referenced", mock.Anything, []string{"used.pdf"}).Return(map[string]bool{"used.pdf": true}, nil)
+	mockRefs.On("Referenced", mock.Anything, []string{"free.pdf"}).Return(map[string]bool{}, nil)
+	mockFileRepo.On("Delete", mock.Anything, "free.pdf").Return(nil)
+	mockMetadata.On("DeleteLast", mock.Anything, "free.pdf").Return(0, nil)
+
+	assert.ErrorIs(t, uc.DeleteFile(context.Background(), "used.pdf"), ErrFileInUse)
+	assert.NoError(t, uc.DeleteFile(context.Background(), "free.pdf"))
+	mockFileRepo.AssertNotCalled(t, "Delete", mock.Anything, "used.pdf")
+}
+
+func TestStoreFileResolvesDuplicateContent(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
+	content := []byte("same notes")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	existing := &domain.File{FileID: "first.txt", Size: int64(len(content)), SHA256: hash, RefCount: 1}
+
+	mockFileRepo.On("Upload", mock.Anything, mock.Anything, "notes.txt").Return("second.txt", nil)
+	mockMetadata.On("ListBySHA256", mock.Anything, hash).Return([]*domain.File{existing}, nil)
+	mockFileRepo.On("Exists", mock.Anything, "first.txt").Return(true, nil)
+	mockMetadata.On("AddReferences", mock.Anything, "first.txt", 1).Return(nil)
+	mockFileRepo.On("Delete", mock.Anything, "second.txt").Return(nil)
+
+	file, err := uc.StoreFile(context.Background(), bytes.NewReader(content), "notes.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "first.txt", file.ID)
+	assert.Equal(t, hash, file.SHA256)
+	mockFileRepo.AssertExpectations(t)
+	mockMetadata.AssertExpectations(t)
+	mockMetadata.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestStoreFileKeepsUploadWhenDuplicateIsGone(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
+	content := []byte("same notes")
+	stale := &domain.File{FileID: "first.txt", Size: int64(len(content))}
+
+	mockFileRepo.On("Upload", mock.Anything, mock.Anything, "notes.txt").Return("second.txt", nil)
+	mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return([]*domain.File{stale}, nil)
+	mockFileRepo.On("Exists", mock.Anything, "first.txt").Return(false, nil)
+	mockMetadata.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	file, err := uc.StoreFile(context.Background(), bytes.NewReader(content), "notes.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "second.txt", file.ID)
+	mockMetadata.AssertNotCalled(t, "AddReferences", mock.Anything, mock.Anything, mock.Anything)
+	mockFileRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestDeleteFileReleasesSharedFile(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
+
+	mockFileRepo.On("Exists", mock.Anything, "shared.txt").Return(true, nil)
+	mockMetadata.On("Release", mock.Anything, "shared.txt").Return(1, nil)
+
+	assert.NoError(t, uc.DeleteFile(context.Background(), "shared.txt"))
+	mockFileRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	mockMetadata.AssertNotCalled(t, "DeleteLast", mock.Anything, mock.Anything)
+}
+
+func TestDeleteFileKeepsFileReferencedMeanwhile(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata)
+
+	mockFileRepo.On("Exists", mock.Anything, "shared.txt").Return(true, nil)
+	mockMetadata.On("Release", mock.Anything, "shared.txt").Return(0, nil)
+	// A duplicate upload referenced the file between Release and DeleteLast.
+	mockMetadata.On("DeleteLast", mock.Anything, "shared.txt").Return(1, nil)
+
+	assert.NoError(t, uc.DeleteFile(context.Background(), "shared.txt"))
+	mockFileRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestStoreFilePublishesUploadedEvent(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockFileRepo := new(MockFileRepository)
+	mockMetadata := new(MockFileMetadataRepository)
+	mockOutbox := new(MockOutboxRepository)
+	tx := new(MockTx)
+	uc := NewFileUseCase(log, mockFileRepo, mockMetadata).WithEvents(mockOutbox)
+
+	mockFileRepo.On("Upload", mock.Anything, mock.Anything, "notes.txt").Return("notes-id.txt", nil)
+	mockMetadata.On("ListBySHA256", mock.Anything, mock.Anything).Return(nil, nil)
+	mockMetadata.On("BeginTx", mock.Anything).Return(tx, nil)
+	mockMetadata.On("CreateTx", mock.Anything, tx, mock.Anything).Return(nil)
+	mockOutbox.On("Insert", mock.Anything, tx, mock.MatchedBy(func(msg repository.OutboxMessage) bool {
+		return msg.EventType == repository.EventFileUploaded && msg.AggregateID == "notes-id.txt" &&
+			bytes.Contains(msg.Payload, []byte(`"file_id":"notes-id.txt"`))
+	})).Return(nil)
+	tx.On("Commit", mock.Anything).Return(nil)
+	tx.On("Rollback", mock.Anything).Return(nil)
+
+	_, err := uc.StoreFile(context.Background(), bytes.NewReader([]byte("notes")), "notes.txt")
+
+	assert.NoError(t, err)
+	mockOutbox.AssertExpectations(t)
+	tx.AssertCalled(t, "Commit", mock.Anything)
+	mockMetadata.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -13,12 +14,15 @@ type MockCacheRepository struct {
 	mock.Mock
 }
 
-func (m *MockCacheRepository) Get(ctx context.Context, key string) (interface{}, error) {
+func (m *MockCacheRepository) Get(ctx context.Context, key string) ([]byte, error) {
 	args := m.Called(ctx, key)
-	return args.Get(0), args.Error(1)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]byte), args.Error(1)
 }
 
-func (m *MockCacheRepository) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+func (m *MockCacheRepository) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
 	args := m.Called(ctx, key, value, expiration)
 	return args.Error(0)
 }
@@ -28,10 +32,47 @@ func (m *MockCacheRepository) Delete(ctx context.Context, key string) error {
 	return args.Error(0)
 }
 
+func (m *MockCacheRepository) Tag(ctx context.Context, key string, expiration time.Duration, tags ...string) error {
+	args := m.Called(ctx, key, expiration, tags)
+	return args.Error(0)
+}
+
+func (m *MockCacheRepository) InvalidateTags(ctx context.Context, tags ...string) error {
+	args := m.Called(ctx, tags)
+	return args.Error(0)
+}
+
+type MockTx struct {
+	mock.Mock
+}
+
+func (m *MockTx) Commit(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *MockTx) Rollback(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
 type MockTodoRepository struct {
 	mock.Mock
 }
 
+func (m *MockTodoRepository) BeginTx(ctx context.Context) (repository.Tx, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(repository.Tx), args.Error(1)
+}
+
+func (m *MockTodoRepository) CreateTx(ctx context.Context, tx repository.Tx, todo *domain.TodoItem) error {
+	args := m.Called(ctx, tx, todo)
+	return args.Error(0)
+}
+
 func (m *MockTodoRepository) Create(ctx context.Context, todo *domain.TodoItem) error {
 	args := m.Called(ctx, todo)
 	return args.Error(0)
@@ -50,21 +91,116 @@ func (m *MockTodoRepository) List(ctx context.Context) ([]*domain.TodoItem, erro
 	return args.Get(0).([]*domain.TodoItem), args.Error(1)
 }
 
+func (m *MockTodoRepository) ListPaged(ctx context.Context, f domain.TodoFilter, s domain.TodoSort, limit, offset int) ([]*domain.TodoItem, int64, error) {
+	args := m.Called(ctx, f, s, limit, offset)
+	return args.Get(0).([]*domain.TodoItem), args.Get(1).(int64), args.Error(2)
+}
+
 func (m *MockTodoRepository) Update(ctx context.Context, todo *domain.TodoItem) error {
 	args := m.Called(ctx, todo)
 	return args.Error(0)
 }
 
+func (m *MockTodoRepository) UpdateTx(ctx context.Context, tx repository.Tx, todo *domain.TodoItem) error {
+	args := m.Called(ctx, tx, todo)
+	return args.Error(0)
+}
+
+func (m *MockTodoRepository) UpdateStatus(ctx context.Context, todo *domain.TodoItem, from domain.TodoStatus) error {
+	args := m.Called(ctx, todo, from)
+	return args.Error(0)
+}
+
+func (m *MockTodoRepository) UpdateStatusTx(ctx context.Context, tx repository.Tx, todo *domain.TodoItem, from domain.TodoStatus) error {
+	args := m.Called(ctx, tx, todo, from)
+	return args.Error(0)
+}
+
 func (m *MockTodoRepository) Delete(ctx context.Context, id string) error {
 	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
+func (m *MockTodoRepository) DeleteTx(ctx context.Context, tx repository.Tx, id string) error {
+	args := m.Called(ctx, tx, id)
+	return args.Error(0)
+}
+
+type MockOutboxRepository struct {
+	mock.Mock
+}
+
+func (m *MockOutboxRepository) Insert(ctx context.Context, tx repository.Tx, msg repository.OutboxMessage) error {
+	args := m.Called(ctx, tx, msg)
+	return args.Error(0)
+}
+
+func (m *MockOutboxRepository) FetchAndLock(ctx context.Context, owner string, limit int, lockForSeconds int) ([]repository.LockedOutboxRow, error) {
+	args := m.Called(ctx, owner, limit, lockForSeconds)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]repository.LockedOutboxRow), args.Error(1)
+}
+
+func (m *MockOutboxRepository) MarkPublished(ctx context.Context, id uint64, owner string) error {
+	args := m.Called(ctx, id, owner)
+	return args.Error(0)
+}
+
+func (m *MockOutboxRepository) MarkFailed(ctx context.Context, id uint64, owner string, nextAvailableAt string, errMsg string) error {
+	args := m.Called(ctx, id, owner, nextAvailableAt, errMsg)
+	return args.Error(0)
+}
+
+func (m *MockOutboxRepository) MarkDead(ctx context.Context, id uint64, owner string, errMsg string) error {
+	args := m.Called(ctx, id, owner, errMsg)
+	return args.Error(0)
+}
+
+type MockDeadLetterRepository struct {
+	mock.Mock
+}
+
+func (m *MockDeadLetterRepository) ListDead(ctx context.Context, limit, offset int) ([]*domain.Outbox, int64, error) {
+	args := m.Called(ctx, limit, offset)
+	return args.Get(0).([]*domain.Outbox), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MockDeadLetterRepository) GetDead(ctx context.Context, id uint64) (*domain.Outbox, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Outbox), args.Error(1)
+}
+
+func (m *MockDeadLetterRepository) ListAttempts(ctx context.Context, outboxID uint64) ([]*domain.OutboxAttempt, error) {
+	args := m.Called(ctx, outboxID)
+	return args.Get(0).([]*domain.OutboxAttempt), args.Error(1)
+}
+
+func (m *MockDeadLetterRepository) Requeue(ctx context.Context, id uint64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockDeadLetterRepository) Purge(ctx context.Context, id uint64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
 type MockFileRepository struct {
 	mock.Mock
 }
 
+// Upload consumes file like a real backend would, so size limits and
+// checksums applied while streaming take effect; read errors are returned
+// before the call is recorded.
 func (m *MockFileRepository) Upload(ctx context.Context, file io.Reader, filename string) (string, error) {
+	if _, err := io.Copy(io.Discard, file); err != nil {
+		return "", err
+	}
 	args := m.Called(ctx, file, filename)
 	return args.String(0), args.Error(1)
 }
@@ -87,16 +223,186 @@ func (m *MockFileRepository) Exists(ctx context.Context, fileID string) (bool, e
 	return args.Bool(0), args.Error(1)
 }
 
-type MockStreamPublisher struct {
+func (m *MockFileRepository) Open(ctx context.Context, fileID string) (repository.FileContent, error) {
+	args := m.Called(ctx, fileID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(repository.FileContent), args.Error(1)
+}
+
+type MockFileMetadataRepository struct {
 	mock.Mock
 }
 
-func (m *MockStreamPublisher) PublishTodoItem(ctx context.Context, todo *domain.TodoItem) error {
-	args := m.Called(ctx, todo)
+func (m *MockFileMetadataRepository) BeginTx(ctx context.Context) (repository.Tx, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(repository.Tx), args.Error(1)
+}
+
+func (m *MockFileMetadataRepository) Create(ctx context.Context, file *domain.File) error {
+	args := m.Called(ctx, file)
 	return args.Error(0)
 }
-		
-func (m *MockStreamPublisher) PublishTodoItems(ctx context.Context, todos []*domain.TodoItem) error {
-	args := m.Called(ctx, todos)
+
+func (m *MockFileMetadataRepository) CreateTx(ctx context.Context, tx repository.Tx, file *domain.File) error {
+	args := m.Called(ctx, tx, file)
+	return args.Error(0)
+}
+
+func (m *MockFileMetadataRepository) ConfirmTx(ctx context.Context, tx repository.Tx, file *domain.File) error {
+	args := m.Called(ctx, tx, file)
+	return args.Error(0)
+}
+
+func (m *MockFileMetadataRepository) DeleteUnconfirmed(ctx context.Context, fileID string) error {
+	args := m.Called(ctx, fileID)
+	return args.Error(0)
+}
+
+func (m *MockFileMetadataRepository) GetByFileID(ctx context.Context, fileID string) (*domain.File, error) {
+	args := m.Called(ctx, fileID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.File), args.Error(1)
+}
+
+func (m *MockFileMetadataRepository) List(ctx context.Context, f domain.FileFilter, limit, offset int) ([]*domain.File, int64, error) {
+	args := m.Called(ctx, f, limit, offset)
+	return args.Get(0).([]*domain.File), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MockFileMetadataRepository) Delete(ctx context.Context, fileID string) error {
+	args := m.Called(ctx, fileID)
+	return args.Error(0)
+}
+
+func (m *MockFileMetadataRepository) ListBySHA256(ctx context.Context, sha256 string) ([]*domain.File, error) {
+	args := m.Called(ctx, sha256)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.File), args.Error(1)
+}
+
+func (m *MockFileMetadataRepository) DuplicateHashes(ctx context.Context, after string, limit int) ([]string, error) {
+	args := m.Called(ctx, after, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockFileMetadataRepository) AddReferences(ctx context.Context, fileID string, n int) error {
+	args := m.Called(ctx, fileID, n)
+	return args.Error(0)
+}
+
+func (m *MockFileMetadataRepository) Release(ctx context.Context, fileID string) (int, error) {
+	args := m.Called(ctx, fileID)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockFileMetadataRepository) DeleteLast(ctx context.Context, fileID string) (int, error) {
+	args := m.Called(ctx, fileID)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockFileMetadataRepository) SetScanStatus(ctx context.Context, fileID string, status domain.FileScanStatus, signature *string) error {
+	args := m.Called(ctx, fileID, status, signature)
+	return args.Error(0)
+}
+
+func (m *MockFileMetadataRepository) SetScanStatusTx(ctx context.Context, tx repository.Tx, fileID string, status domain.FileScanStatus, signature *string) error {
+	args := m.Called(ctx, tx, fileID, status, signature)
+	return args.Error(0)
+}
+
+type MockFileReferenceRepository struct {
+	mock.Mock
+}
+
+func (m *MockFileReferenceRepository) Referenced(ctx context.Context, fileIDs []string) (map[string]bool, error) {
+	args := m.Called(ctx, fileIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]bool), args.Error(1)
+}
+
+func (m *MockFileReferenceRepository) Repoint(ctx context.Context, from, to string) error {
+	args := m.Called(ctx, from, to)
+	return args.Error(0)
+}
+
+type MockThumbnailRepository struct {
+	mock.Mock
+}
+
+func (m *MockThumbnailRepository) List(ctx context.Context, fileIDs []string) (map[string][]*domain.FileThumbnail, error) {
+	args := m.Called(ctx, fileIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string][]*domain.FileThumbnail), args.Error(1)
+}
+
+func (m *MockThumbnailRepository) Save(ctx context.Context, thumbs []*domain.FileThumbnail) error {
+	args := m.Called(ctx, thumbs)
+	return args.Error(0)
+}
+
+func (m *MockThumbnailRepository) Known(ctx context.Context, ids []string) (map[string]bool, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]bool), args.Error(1)
+}
+
+type MockAttachmentRepository struct {
+	mock.Mock
+}
+
+func (m *MockAttachmentRepository) List(ctx context.Context, todoUUID string) ([]*domain.TodoAttachment, error) {
+	args := m.Called(ctx, todoUUID)
+	return args.Get(0).([]*domain.TodoAttachment), args.Error(1)
+}
+
+func (m *MockAttachmentRepository) Attach(ctx context.Context, attachment *domain.TodoAttachment) error {
+	args := m.Called(ctx, attachment)
+	return args.Error(0)
+}
+
+func (m *MockAttachmentRepository) AttachTx(ctx context.Context, tx repository.Tx, attachment *domain.TodoAttachment) error {
+	args := m.Called(ctx, tx, attachment)
+	return args.Error(0)
+}
+
+func (m *MockAttachmentRepository) Detach(ctx context.Context, todoUUID, fileID string) error {
+	args := m.Called(ctx, todoUUID, fileID)
+	return args.Error(0)
+}
+
+func (m *MockAttachmentRepository) Reorder(ctx context.Context, todoUUID string, fileIDs []string) error {
+	args := m.Called(ctx, todoUUID, fileIDs)
+	return args.Error(0)
+}
+
+type MockMessageBroker struct {
+	mock.Mock
+}
+
+func (m *MockMessageBroker) Publish(ctx context.Context, stream string, message interface{}) error {
+	args := m.Called(ctx, stream, message)
+	return args.Error(0)
+}
+
+func (m *MockMessageBroker) Close() error {
+	args := m.Called()
 	return args.Error(0)
 }
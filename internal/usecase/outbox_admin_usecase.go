@@ -0,0 +1,77 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+// DeadLetter is a dead-lettered outbox row together with every failed
+// delivery that led to it.
+type DeadLetter struct {
+	Message  *domain.Outbox
+	Attempts []*domain.OutboxAttempt
+}
+
+// OutboxAdminUseCase is the operator side of the outbox: it lets the REST
+// admin endpoints and the admin CLI look at and replay dead-lettered events.
+type OutboxAdminUseCase struct {
+	logger      logger.Logger
+	deadLetters repository.DeadLetterRepository
+}
+
+func NewOutboxAdminUseCase(logger logger.Logger, deadLetters repository.DeadLetterRepository) *OutboxAdminUseCase {
+	return &OutboxAdminUseCase{
+		logger:      logger,
+		deadLetters: deadLetters,
+	}
+}
+
+func (u *OutboxAdminUseCase) ListDeadLetters(ctx context.Context, limit, offset int) ([]*domain.Outbox, int64, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return u.deadLetters.ListDead(ctx, limit, offset)
+}
+
+func (u *OutboxAdminUseCase) GetDeadLetter(ctx context.Context, id uint64) (*DeadLetter, error) {
+	msg, err := u.deadLetters.GetDead(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := u.deadLetters.ListAttempts(ctx, id)
+	if err != nil {
+		u.logger.Error("Failed to list outbox attempts", err)
+		return nil, err
+	}
+	return &DeadLetter{Message: msg, Attempts: attempts}, nil
+}
+
+// RequeueDeadLetter puts the event back in front of the dispatcher with a
+// fresh attempt budget. Its attempt history is kept.
+func (u *OutboxAdminUseCase) RequeueDeadLetter(ctx context.Context, id uint64) error {
+	if err := u.deadLetters.Requeue(ctx, id); err != nil {
+		if err != repository.ErrNotFound {
+			u.logger.Error("Failed to requeue dead letter", err)
+		}
+		return err
+	}
+	u.logger.Info("Dead-lettered outbox event %d requeued", id)
+	return nil
+}
+
+func (u *OutboxAdminUseCase) PurgeDeadLetter(ctx context.Context, id uint64) error {
+	if err := u.deadLetters.Purge(ctx, id); err != nil {
+		if err != repository.ErrNotFound {
+			u.logger.Error("Failed to purge dead letter", err)
+		}
+		return err
+	}
+	u.logger.Info("Dead-lettered outbox event %d purged", id)
+	return nil
+}
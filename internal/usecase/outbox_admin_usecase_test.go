@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newOutboxAdminUseCase() (*OutboxAdminUseCase, *MockDeadLetterRepository) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	repo := new(MockDeadLetterRepository)
+	return NewOutboxAdminUseCase(log, repo), repo
+}
+
+func TestListDeadLettersCapsLimit(t *testing.T) {
+	uc, repo := newOutboxAdminUseCase()
+
+	dead := []*domain.Outbox{{ID: 1, Status: domain.OutboxStatusDead}}
+	repo.On("ListDead", mock.Anything, 20, 0).Return(dead, int64(1), nil)
+
+	rows, total, err := uc.ListDeadLetters(context.Background(), 500, -3)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, dead, rows)
+	repo.AssertExpectations(t)
+}
+
+func TestGetDeadLetterIncludesAttempts(t *testing.T) {
+	uc, repo := newOutboxAdminUseCase()
+
+	lastErr := "redis down"
+	msg := &domain.Outbox{ID: 9, EventType: repository.EventTodoUpdated, Status: domain.OutboxStatusDead, Attempts: 2, LastError: &lastErr}
+	attempts := []*domain.OutboxAttempt{
+		{OutboxID: 9, Attempt: 1, Error: "timeout"},
+		{OutboxID: 9, Attempt: 2, Error: lastErr},
+	}
+	repo.On("GetDead", mock.Anything, uint64(9)).Return(msg, nil)
+	repo.On("ListAttempts", mock.Anything, uint64(9)).Return(attempts, nil)
+
+	dl, err := uc.GetDeadLetter(context.Background(), 9)
+
+	assert.NoError(t, err)
+	assert.Equal(t, msg, dl.Message)
+	assert.Len(t, dl.Attempts, 2)
+	repo.AssertExpectations(t)
+}
+
+func TestGetDeadLetterNotFound(t *testing.T) {
+	uc, repo := newOutboxAdminUseCase()
+
+	repo.On("GetDead", mock.Anything, uint64(4)).Return(nil, repository.ErrNotFound)
+
+	dl, err := uc.GetDeadLetter(context.Background(), 4)
+
+	assert.Equal(t, repository.ErrNotFound, err)
+	assert.Nil(t, dl)
+	repo.AssertNotCalled(t, "ListAttempts", mock.Anything, mock.Anything)
+}
+
+func TestRequeueDeadLetter(t *testing.T) {
+	uc, repo := newOutboxAdminUseCase()
+
+	repo.On("Requeue", mock.Anything, uint64(3)).Return(nil)
+	repo.On("Requeue", mock.Anything, uint64(8)).Return(repository.ErrNotFound)
+
+	assert.NoError(t, uc.RequeueDeadLetter(context.Background(), 3))
+	assert.Equal(t, repository.ErrNotFound, uc.RequeueDeadLetter(context.Background(), 8))
+	repo.AssertExpectations(t)
+}
+
+func TestPurgeDeadLetter(t *testing.T) {
+	uc, repo := newOutboxAdminUseCase()
+
+	repo.On("Purge", mock.Anything, uint64(3)).Return(nil)
+
+	assert.NoError(t, uc.PurgeDeadLetter(context.Background(), 3))
+	repo.AssertExpectations(t)
+}
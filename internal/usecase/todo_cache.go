@@ -0,0 +1,73 @@
+package usecase
+
+import (
+	"context"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/cache"
+)
+
+// Every todo cache key is built here. Items are keyed by UUID, the public id
+// handlers and resolvers use; list and page entries are tagged with
+// todoQueryTag so one invalidation evicts every query result at once.
+const (
+	todoQueryTag    = "todos"
+	todoListCacheID = "all"
+)
+
+// todoPage is a cached ListTodoItemsPaged result.
+type todoPage struct {
+	Items []*domain.TodoItem
+	Total int64
+}
+
+type todoCaches struct {
+	item *cache.Cache[*domain.TodoItem]
+	list *cache.Cache[[]*domain.TodoItem]
+	page *cache.Cache[todoPage]
+	tags cache.TagStore
+}
+
+func newTodoCaches(store repository.CacheRepository, codec cache.Codec, ttl, staleTTL time.Duration) *todoCaches {
+	opts := func(namespace string) cache.Options {
+		return cache.Options{Namespace: namespace, Version: 1, Codec: codec, TTL: ttl, StaleTTL: staleTTL}
+	}
+	return &todoCaches{
+		item: cache.New[*domain.TodoItem](store, opts("todo")),
+		list: cache.New[[]*domain.TodoItem](store, opts("todos")),
+		page: cache.New[todoPage](store, opts("todos_page")),
+		tags: store,
+	}
+}
+
+// todoItemCacheID is the cache id of one todo.
+func todoItemCacheID(uuid string) string {
+	return uuid
+}
+
+// todoPageCacheID identifies a page of a filtered, sorted listing by a hash
+// of everything that shapes the result.
+func todoPageCacheID(f domain.TodoFilter, s domain.TodoSort, limit, offset int) (string, error) {
+	return cache.HashID(struct {
+		Filter domain.TodoFilter
+		Sort   domain.TodoSort
+		Limit  int
+		Offset int
+	}{f, s, limit, offset})
+}
+
+// invalidate evicts everything a change to the todo with uuid can make stale:
+// the item itself and every cached list and page. uuid may be empty when
+// only query results are affected, e.g. after a create.
+func (c *todoCaches) invalidate(ctx context.Context, uuid string) error {
+	var firstErr error
+	if uuid != "" {
+		firstErr = c.item.Delete(ctx, todoItemCacheID(uuid))
+	}
+	if err := c.tags.InvalidateTags(ctx, todoQueryTag); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
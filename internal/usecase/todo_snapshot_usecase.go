@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/google/uuid"
+)
+
+// TodoSnapshotUseCase republishes the current state of every todo as
+// todo.snapshot events. Reads never publish anything; a consumer that needs
+// to rebuild its view asks an operator to run a snapshot instead.
+type TodoSnapshotUseCase struct {
+	logger   logger.Logger
+	todoRepo repository.TodoRepository
+	broker   repository.MessageBroker
+	stream   string
+}
+
+func NewTodoSnapshotUseCase(logger logger.Logger, todoRepo repository.TodoRepository, broker repository.MessageBroker, stream string) *TodoSnapshotUseCase {
+	return &TodoSnapshotUseCase{
+		logger:   logger,
+		todoRepo: todoRepo,
+		broker:   broker,
+		stream:   stream,
+	}
+}
+
+// Resync publishes every todo, oldest first, reading batchSize rows at a
+// time, and returns how many it published. Every event of one run carries the
+// same "snapshotid" extension so consumers can tell runs apart. It stops at
+// the first failure; rerunning is safe because snapshots describe state
+// rather than changes.
+func (u *TodoSnapshotUseCase) Resync(ctx context.Context, batchSize int) (int, error) {
+	if batchSize <= 0 || batchSize > 1000 {
+		batchSize = 500
+	}
+	snapshotID := uuid.NewString()
+	sort := domain.TodoSort{Field: domain.SortCreatedAt, Direction: domain.SortAsc}
+
+	published := 0
+	for offset := 0; ; offset += batchSize {
+		if err := ctx.Err(); err != nil {
+			return published, err
+		}
+		todos, _, err := u.todoRepo.ListPaged(ctx, domain.TodoFilter{}, sort, batchSize, offset)
+		if err != nil {
+			u.logger.Error("Failed to read todos for snapshot", err)
+			return published, err
+		}
+
+		for _, todo := range todos {
+			meta := repository.SnapshotMeta()
+			meta.Headers = map[string]string{"snapshotid": snapshotID}
+			ev, err := repository.NewTodoEvent(meta, repository.DefaultEventSource, todo)
+			if err != nil {
+				return published, err
+			}
+			if err := u.broker.Publish(ctx, u.stream, ev); err != nil {
+				u.logger.Error("Failed to publish todo snapshot", err)
+				return published, err
+			}
+			published++
+		}
+
+		if len(todos) < batchSize {
+			break
+		}
+	}
+
+	u.logger.Info("Snapshot %s published %d todos to %s", snapshotID, published, u.stream)
+	return published, nil
+}
@@ -0,0 +1,67 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTodoSnapshotUseCase() (*TodoSnapshotUseCase, *MockTodoRepository, *MockMessageBroker) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	repo := new(MockTodoRepository)
+	broker := new(MockMessageBroker)
+	return NewTodoSnapshotUseCase(log, repo, broker, "todos"), repo, broker
+}
+
+var snapshotSort = domain.TodoSort{Field: domain.SortCreatedAt, Direction: domain.SortAsc}
+
+func TestResyncPublishesEveryPage(t *testing.T) {
+	uc, repo, broker := newTodoSnapshotUseCase()
+
+	page1 := []*domain.TodoItem{{UUID: "a"}, {UUID: "b"}}
+	page2 := []*domain.TodoItem{{UUID: "c"}}
+	repo.On("ListPaged", mock.Anything, domain.TodoFilter{}, snapshotSort, 2, 0).Return(page1, int64(3), nil)
+	repo.On("ListPaged", mock.Anything, domain.TodoFilter{}, snapshotSort, 2, 2).Return(page2, int64(3), nil)
+
+	var events []*repository.CloudEvent
+	broker.On("Publish", mock.Anything, "todos", mock.AnythingOfType("*repository.CloudEvent")).
+		Run(func(args mock.Arguments) { events = append(events, args.Get(2).(*repository.CloudEvent)) }).
+		Return(nil)
+
+	n, err := uc.Resync(context.Background(), 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Len(t, events, 3)
+	for i, subject := range []string{"a", "b", "c"} {
+		assert.Equal(t, repository.EventTodoSnapshot, events[i].Type)
+		assert.Equal(t, subject, events[i].Subject)
+		assert.Equal(t, events[0].Extensions["snapshotid"], events[i].Extensions["snapshotid"])
+	}
+	repo.AssertExpectations(t)
+}
+
+func TestResyncStopsOnPublishError(t *testing.T) {
+	uc, repo, broker := newTodoSnapshotUseCase()
+
+	repo.On("ListPaged", mock.Anything, domain.TodoFilter{}, snapshotSort, 500, 0).
+		Return([]*domain.TodoItem{{UUID: "a"}, {UUID: "b"}}, int64(2), nil)
+	broker.On("Publish", mock.Anything, "todos", mock.Anything).Return(errors.New("broker down")).Once()
+
+	n, err := uc.Resync(context.Background(), 0)
+
+	assert.EqualError(t, err, "broker down")
+	assert.Equal(t, 0, n)
+	broker.AssertNumberOfCalls(t, "Publish", 1)
+}
@@ -3,54 +3,109 @@ package usecase
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"strconv"
 	"time"
 
 	"github.com/delaram/GoTastic/internal/domain"
 	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/cache"
 	"github.com/delaram/GoTastic/pkg/logger"
 	"github.com/google/uuid"
 )
 
 type TodoUseCase struct {
-	logger          logger.Logger
-	todoRepo        repository.TodoRepository
-	fileRepo        repository.FileRepository
-	cacheRepo       repository.CacheRepository
-	streamPublisher repository.StreamPublisher
-	outboxRepo      repository.OutboxRepository
+	logger     logger.Logger
+	todoRepo   repository.TodoRepository
+	fileRepo   repository.FileRepository
+	cacheRepo  repository.CacheRepository
+	caches     *todoCaches
+	outboxRepo repository.OutboxRepository
+	metadata   repository.FileMetadataRepository
+	scanning   bool
+	// attachments, when set, gets a todo's FileID attached in the same
+	// transaction that writes it.
+	attachments repository.AttachmentRepository
 }
 
 func NewTodoUseCase(logger logger.Logger,
 	todoRepo repository.TodoRepository,
 	fileRepo repository.FileRepository,
 	cacheRepo repository.CacheRepository,
-	streamPublisher repository.StreamPublisher,
 	outboxRepo repository.OutboxRepository,
 ) *TodoUseCase {
-	return &TodoUseCase{
-		logger:          logger,
-		todoRepo:        todoRepo,
-		fileRepo:        fileRepo,
-		cacheRepo:       cacheRepo,
-		streamPublisher: streamPublisher,
-		outboxRepo:      outboxRepo,
+	u := &TodoUseCase{
+		logger:     logger,
+		todoRepo:   todoRepo,
+		fileRepo:   fileRepo,
+		cacheRepo:  cacheRepo,
+		outboxRepo: outboxRepo,
 	}
+	return u.WithCache(cache.JSON, time.Hour, 0)
+}
+
+// WithCache sets the codec and TTL todos are cached with, and for how long
+// after the TTL an entry is still served while it is reloaded (0 disables
+// stale-while-revalidate).
+func (u *TodoUseCase) WithCache(codec cache.Codec, ttl, staleTTL time.Duration) *TodoUseCase {
+	u.caches = newTodoCaches(u.cacheRepo, codec, ttl, staleTTL)
+	return u
+}
+
+// WithQuarantine makes todos refuse files that are waiting for, or failed,
+// their malware scan. With scanning, set when uploads are scanned, files
+// without metadata are refused too, since nothing scanned them.
+func (u *TodoUseCase) WithQuarantine(metadata repository.FileMetadataRepository, scanning bool) *TodoUseCase {
+	u.metadata, u.scanning = metadata, scanning
+	return u
+}
+
+// WithAttachments keeps TodoItem.FileID and the todo's attachments in step:
+// the file set as FileID is also attached, in the same transaction.
+func (u *TodoUseCase) WithAttachments(attachments repository.AttachmentRepository) *TodoUseCase {
+	u.attachments = attachments
+	return u
+}
+
+// attach records fileID as an attachment of todoUUID on tx. A file that is
+// already attached is left where it is.
+func (u *TodoUseCase) attach(ctx context.Context, tx repository.Tx, todoUUID string, fileID *string) error {
+	if u.attachments == nil || fileID == nil || *fileID == "" {
+		return nil
+	}
+	err := u.attachments.AttachTx(ctx, tx, &domain.TodoAttachment{
+		TodoUUID:  todoUUID,
+		FileID:    *fileID,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err == repository.ErrDuplicate {
+		return nil
+	}
+	return err
+}
+
+// checkFile is repository.ErrNotFound if fileID is not stored, and refuses
+// quarantined files under WithQuarantine.
+func (u *TodoUseCase) checkFile(ctx context.Context, fileID string) error {
+	exists, err := u.fileRepo.Exists(ctx, fileID)
+	if err != nil {
+		u.logger.Error("Failed to check file existence", err)
+		return err
+	}
+	if !exists {
+		return repository.ErrNotFound
+	}
+	if u.metadata != nil {
+		return checkScan(ctx, u.metadata, fileID, u.scanning)
+	}
+	return nil
 }
 
 func (u *TodoUseCase) CreateTodoItem(ctx context.Context, description string, dueDate time.Time, fileID string) (*domain.TodoItem, error) {
 	u.logger.Debug("Starting CreateTodoItem with description: %s, dueDate: %v, fileID: %s", description, dueDate, fileID)
 	var filePtr *string
 	if fileID != "" {
-		exists, err := u.fileRepo.Exists(ctx, fileID)
-		if err != nil {
-			u.logger.Error("Failed to check file existence", err)
+		if err := u.checkFile(ctx, fileID); err != nil {
 			return nil, err
 		}
-		if !exists {
-			return nil, repository.ErrNotFound
-		}
 		filePtr = &fileID
 		u.logger.Debug("FileID exists, set to: %s", fileID)
 	} else {
@@ -62,65 +117,22 @@ func (u *TodoUseCase) CreateTodoItem(ctx context.Context, description string, du
 		Description: description,
 		DueDate:     &dueDate,
 		FileID:      filePtr,
+		Status:      string(domain.TodoStatusOpen),
 		CreatedAt:   time.Now().UTC(),
 		UpdatedAt:   time.Now().UTC(),
 	}
 	u.logger.Debug("Created TodoItem: %+v", todo)
 
-	txStarter, ok := u.todoRepo.(repository.TxStarter)
-	if !ok {
-		u.logger.Error("todoRepo does not support transactions", nil)
-		return nil, fmt.Errorf("todoRepo does not support transactions")
-	}
-	tx, err := txStarter.BeginTx(ctx)
-	if err != nil {
-		u.logger.Error("Failed to begin transaction", err)
-		return nil, err
-	}
-	u.logger.Debug("Transaction begun")
-	defer func() {
-		if err := tx.Rollback(ctx); err != nil {
-			u.logger.Warn("Rollback failed", err)
-		} else {
-			u.logger.Debug("Transaction rolled back (deferred)")
+	if err := u.writeWithOutbox(ctx, repository.EventTodoCreated, todo, func(tx repository.Tx) error {
+		if err := u.todoRepo.CreateTx(ctx, tx, todo); err != nil {
+			return err
 		}
-	}()
-
-	if err := u.todoRepo.CreateTx(ctx, tx, todo); err != nil {
-		u.logger.Error("Failed to create todo", err)
+		return u.attach(ctx, tx, todo.UUID, todo.FileID)
+	}); err != nil {
 		return nil, err
 	}
-	u.logger.Debug("Todo created successfully with ID: %d", todo.ID)
 
-	payload, err := json.Marshal(todo)
-	if err != nil {
-		u.logger.Error("Failed to marshal todo for outbox payload", err)
-		return nil, err
-	}
-	u.logger.Debug("Outbox payload marshaled: %s", string(payload))
-
-	outboxMsg := repository.OutboxMessage{
-		AggregateType: "todo",
-		AggregateID:   todo.UUID,
-		EventType:     "todo.created",
-		Payload:       payload,
-		Headers:       map[string]string{"source": "api", "schema": "v1"},
-	}
-	u.logger.Debug("Outbox message prepared: %+v", outboxMsg)
-
-	if err := u.outboxRepo.Insert(ctx, tx, outboxMsg); err != nil {
-		u.logger.Error("Failed to insert outbox message", err)
-		return nil, err
-	}
-	u.logger.Debug("Outbox message inserted successfully")
-
-	if err := tx.Commit(ctx); err != nil {
-		u.logger.Error("Failed to commit transaction", err)
-		return nil, err
-	}
-	u.logger.Debug("Transaction committed successfully")
-
-	if err := u.cacheRepo.Delete(ctx, "todos"); err != nil {
+	if err := u.caches.invalidate(ctx, ""); err != nil {
 		u.logger.Warn("Failed to invalidate cache", err)
 	} else {
 		u.logger.Debug("Cache invalidated successfully")
@@ -138,84 +150,110 @@ func (u *TodoUseCase) ListTodoItemsPaged(ctx context.Context, f domain.TodoFilte
 	if offset < 0 {
 		offset = 0
 	}
-	return u.todoRepo.ListPaged(ctx, f, s, limit, offset)
-}
 
-func (u *TodoUseCase) GetTodoItem(ctx context.Context, id string) (*domain.TodoItem, error) {
-	cacheKey := "todo:" + id
-	cached, _ := u.cacheRepo.Get(ctx, cacheKey)
-	if cached != nil {
-		if todo, ok := cached.(*domain.TodoItem); ok {
-			return todo, nil
-		}
-	}
-	todo, err := u.todoRepo.GetByID(ctx, id)
+	id, err := todoPageCacheID(f, s, limit, offset)
 	if err != nil {
-		return nil, err
+		u.logger.Warn("Failed to build todo page cache key", err)
+		return u.todoRepo.ListPaged(ctx, f, s, limit, offset)
 	}
-	u.cacheRepo.Set(ctx, cacheKey, todo, time.Hour)
-
-	if err := u.streamPublisher.PublishTodoItem(ctx, todo); err != nil {
-		u.logger.Warn("Failed to publish todo item to stream", err)
+	page, err := u.caches.page.GetOrLoad(ctx, id, func(ctx context.Context) (todoPage, error) {
+		items, total, err := u.todoRepo.ListPaged(ctx, f, s, limit, offset)
+		return todoPage{Items: items, Total: total}, err
+	}, todoQueryTag)
+	if err != nil {
+		return nil, 0, err
 	}
+	return page.Items, page.Total, nil
+}
 
-	return todo, nil
+// GetTodoItem reads through the cache, as do the listings: concurrent misses
+// for one id share a repository call, and a stale entry is served while it
+// is reloaded in the background.
+func (u *TodoUseCase) GetTodoItem(ctx context.Context, id string) (*domain.TodoItem, error) {
+	return u.caches.item.GetOrLoad(ctx, todoItemCacheID(id), func(ctx context.Context) (*domain.TodoItem, error) {
+		return u.todoRepo.GetByID(ctx, id)
+	})
 }
 
 func (u *TodoUseCase) ListTodoItems(ctx context.Context) ([]*domain.TodoItem, error) {
-	cacheKey := "todos"
-	cached, _ := u.cacheRepo.Get(ctx, cacheKey)
-	if cached != nil {
-		if todos, ok := cached.([]*domain.TodoItem); ok {
-			return todos, nil
-		}
-	}
-	todos, err := u.todoRepo.List(ctx)
+	todos, err := u.caches.list.GetOrLoad(ctx, todoListCacheID, func(ctx context.Context) ([]*domain.TodoItem, error) {
+		return u.todoRepo.List(ctx)
+	}, todoQueryTag)
 	if err != nil {
 		u.logger.Error("Failed to list todos", err)
 		return nil, err
 	}
-	u.cacheRepo.Set(ctx, cacheKey, todos, time.Hour)
-
-	if publisher, ok := u.streamPublisher.(interface {
-		PublishTodoItems(ctx context.Context, todos []*domain.TodoItem) error
-	}); ok {
-		if err := publisher.PublishTodoItems(ctx, todos); err != nil {
-			u.logger.Warn("Failed to publish todo items to stream", err)
-		}
-	}
-
 	return todos, nil
 }
 
 func (u *TodoUseCase) UpdateTodoItem(ctx context.Context, todo *domain.TodoItem) error {
 	if todo.FileID != nil && *todo.FileID != "" {
-		exists, err := u.fileRepo.Exists(ctx, *todo.FileID)
-		if err != nil {
-			u.logger.Error("Failed to check file existence", err)
+		if err := u.checkFile(ctx, *todo.FileID); err != nil {
 			return err
 		}
-		if !exists {
-			return repository.ErrNotFound
-		}
 	}
 
-	todo.UpdatedAt = time.Now()
+	// Load the stored item so the outbox event carries the full state, not
+	// just the fields the caller sent.
+	existing, err := u.todoRepo.GetByID(ctx, todo.UUID)
+	if err != nil {
+		u.logger.Error("Failed to get todo for update", err)
+		return err
+	}
+	existing.Description = todo.Description
+	existing.DueDate = todo.DueDate
+	existing.FileID = todo.FileID
+	existing.UpdatedAt = time.Now().UTC()
 
-	if err := u.todoRepo.Update(ctx, todo); err != nil {
+	if err := u.writeWithOutbox(ctx, repository.EventTodoUpdated, existing, func(tx repository.Tx) error {
+		if err := u.todoRepo.UpdateTx(ctx, tx, existing); err != nil {
+			return err
+		}
+		return u.attach(ctx, tx, existing.UUID, existing.FileID)
+	}); err != nil {
 		u.logger.Error("Failed to update todo", err)
 		return err
 	}
 
-	if err := u.cacheRepo.Delete(ctx, "todo:"+strconv.FormatUint(todo.ID, 10)); err != nil {
+	if err := u.caches.invalidate(ctx, existing.UUID); err != nil {
 		u.logger.Warn("Failed to invalidate todo cache", err)
 	}
 
-	if err := u.streamPublisher.PublishTodoItem(ctx, todo); err != nil {
-		u.logger.Warn("Failed to publish todo item to stream", err)
+	return nil
+}
+
+// ChangeTodoStatus moves a todo through its lifecycle. Illegal transitions are
+// rejected with domain.ErrInvalidStatusTransition and leave the item untouched.
+func (u *TodoUseCase) ChangeTodoStatus(ctx context.Context, uuid string, status domain.TodoStatus) (*domain.TodoItem, error) {
+	if !status.IsValid() {
+		return nil, domain.ErrInvalidStatus
 	}
 
-	return nil
+	todo, err := u.todoRepo.GetByID(ctx, uuid)
+	if err != nil {
+		u.logger.Error("Failed to get todo for status change", err)
+		return nil, err
+	}
+
+	from := todo.CurrentStatus()
+	if err := todo.TransitionTo(status, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	// The write is guarded on from, so a concurrent change that got there
+	// first turns this one into an invalid transition.
+	if err := u.writeWithOutbox(ctx, repository.EventTodoUpdated, todo, func(tx repository.Tx) error {
+		return u.todoRepo.UpdateStatusTx(ctx, tx, todo, from)
+	}); err != nil {
+		u.logger.Error("Failed to update todo status", err)
+		return nil, err
+	}
+
+	if err := u.caches.invalidate(ctx, uuid); err != nil {
+		u.logger.Warn("Failed to invalidate todo cache", err)
+	}
+
+	return todo, nil
 }
 
 func (u *TodoUseCase) DeleteTodoItem(ctx context.Context, uuid string) error {
@@ -225,18 +263,69 @@ func (u *TodoUseCase) DeleteTodoItem(ctx context.Context, uuid string) error {
 		return err
 	}
 
-	if err := u.todoRepo.Delete(ctx, uuid); err != nil {
+	if err := u.writeWithOutbox(ctx, repository.EventTodoDeleted, todo, func(tx repository.Tx) error {
+		return u.todoRepo.DeleteTx(ctx, tx, uuid)
+	}); err != nil {
 		u.logger.Error("Failed to delete todo", err)
 		return err
 	}
 
-	if err := u.cacheRepo.Delete(ctx, "todo:"+uuid); err != nil {
+	if err := u.caches.invalidate(ctx, uuid); err != nil {
 		u.logger.Warn("Failed to invalidate todo cache", err)
 	}
 
-	if err := u.streamPublisher.PublishTodoItem(ctx, todo); err != nil {
-		u.logger.Warn("Failed to publish todo item to stream", err)
+	return nil
+}
+
+// writeWithOutbox runs write and records the matching outbox event in one
+// transaction, so the todo change and its event are committed together.
+func (u *TodoUseCase) writeWithOutbox(ctx context.Context, eventType string, todo *domain.TodoItem, write func(tx repository.Tx) error) error {
+	tx, err := u.todoRepo.BeginTx(ctx)
+	if err != nil {
+		u.logger.Error("Failed to begin transaction", err)
+		return err
 	}
+	u.logger.Debug("Transaction begun")
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil {
+			u.logger.Warn("Rollback failed", err)
+		} else {
+			u.logger.Debug("Transaction rolled back (deferred)")
+		}
+	}()
+
+	if err := write(tx); err != nil {
+		u.logger.Error("Failed to write todo", err)
+		return err
+	}
+	u.logger.Debug("Todo %s staged in transaction for %s", todo.UUID, eventType)
 
+	payload, err := json.Marshal(todo)
+	if err != nil {
+		u.logger.Error("Failed to marshal todo for outbox payload", err)
+		return err
+	}
+	u.logger.Debug("Outbox payload marshaled: %s", string(payload))
+
+	outboxMsg := repository.OutboxMessage{
+		AggregateType: "todo",
+		AggregateID:   todo.UUID,
+		EventType:     eventType,
+		Payload:       payload,
+		Headers:       map[string]string{"origin": "api", "schemaversion": "v1"},
+	}
+	u.logger.Debug("Outbox message prepared: %+v", outboxMsg)
+
+	if err := u.outboxRepo.Insert(ctx, tx, outboxMsg); err != nil {
+		u.logger.Error("Failed to insert outbox message", err)
+		return err
+	}
+	u.logger.Debug("Outbox message inserted successfully")
+
+	if err := tx.Commit(ctx); err != nil {
+		u.logger.Error("Failed to commit transaction", err)
+		return err
+	}
+	u.logger.Debug("Transaction committed successfully")
 	return nil
 }
@@ -22,20 +22,24 @@ func BenchmarkCreateTodoItem(b *testing.B) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	useCase := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
+	mockOutboxRepo := new(MockOutboxRepository)
+	mockTx := new(MockTx)
+	useCase := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
 
 	defer func() {
 		mockTodoRepo.AssertExpectations(b)
 		mockFileRepo.AssertExpectations(b)
 		mockCacheRepo.AssertExpectations(b)
-		mockStreamPublisher.AssertExpectations(b)
+		mockOutboxRepo.AssertExpectations(b)
 	}()
 
 	mockFileRepo.On("Exists", mock.Anything, "test-file-id").Return(true, nil)
-	mockTodoRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todos").Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, mock.Anything).Return(nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("CreateTx", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -56,31 +60,31 @@ func BenchmarkGetTodoItem(b *testing.B) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	useCase := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
+	mockOutboxRepo := new(MockOutboxRepository)
+	useCase := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
 
 	defer func() {
 		mockTodoRepo.AssertExpectations(b)
 		mockFileRepo.AssertExpectations(b)
 		mockCacheRepo.AssertExpectations(b)
-		mockStreamPublisher.AssertExpectations(b)
 	}()
 
-	todoID := uuid.New()
+	todoID := uuid.NewString()
+	dueDate := time.Now().Add(24 * time.Hour)
 	expectedTodo := &domain.TodoItem{
-		ID:          todoID,
+		ID:          1,
+		UUID:        todoID,
 		Description: "Test todo",
-		DueDate:     time.Now().Add(24 * time.Hour),
+		DueDate:     &dueDate,
 	}
 
-	mockCacheRepo.On("Get", mock.Anything, "todo:"+todoID.String()).Return(nil, errors.New("cache miss"))
-	mockTodoRepo.On("GetByID", mock.Anything, todoID.String()).Return(expectedTodo, nil)
-	mockCacheRepo.On("Set", mock.Anything, "todo:"+todoID.String(), expectedTodo, time.Hour).Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, expectedTodo).Return(nil)
+	mockCacheRepo.On("Get", mock.Anything, "todo:v1:json:"+todoID).Return(nil, errors.New("cache miss"))
+	mockTodoRepo.On("GetByID", mock.Anything, todoID).Return(expectedTodo, nil)
+	mockCacheRepo.On("Set", mock.Anything, "todo:v1:json:"+todoID, mock.Anything, time.Hour).Return(nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := useCase.GetTodoItem(ctx, todoID.String())
+		_, err := useCase.GetTodoItem(ctx, todoID)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -97,33 +101,36 @@ func BenchmarkListTodoItems(b *testing.B) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	useCase := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
+	mockOutboxRepo := new(MockOutboxRepository)
+	useCase := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
 
 	defer func() {
 		mockTodoRepo.AssertExpectations(b)
 		mockFileRepo.AssertExpectations(b)
 		mockCacheRepo.AssertExpectations(b)
-		mockStreamPublisher.AssertExpectations(b)
 	}()
 
+	due1 := time.Now().Add(24 * time.Hour)
+	due2 := time.Now().Add(48 * time.Hour)
 	expectedTodos := []*domain.TodoItem{
 		{
-			ID:          uuid.New(),
+			ID:          1,
+			UUID:        uuid.NewString(),
 			Description: "Todo 1",
-			DueDate:     time.Now().Add(24 * time.Hour),
+			DueDate:     &due1,
 		},
 		{
-			ID:          uuid.New(),
+			ID:          2,
+			UUID:        uuid.NewString(),
 			Description: "Todo 2",
-			DueDate:     time.Now().Add(48 * time.Hour),
+			DueDate:     &due2,
 		},
 	}
 
-	mockCacheRepo.On("Get", mock.Anything, "todos").Return(nil, errors.New("cache miss"))
+	mockCacheRepo.On("Get", mock.Anything, "todos:v1:json:all").Return(nil, errors.New("cache miss"))
 	mockTodoRepo.On("List", mock.Anything).Return(expectedTodos, nil)
-	mockCacheRepo.On("Set", mock.Anything, "todos", expectedTodos, time.Hour).Return(nil)
-	mockStreamPublisher.On("PublishTodoItems", mock.Anything, expectedTodos).Return(nil)
+	mockCacheRepo.On("Set", mock.Anything, "todos:v1:json:all", mock.Anything, time.Hour).Return(nil)
+	mockCacheRepo.On("Tag", mock.Anything, "todos:v1:json:all", time.Hour, []string{"todos"}).Return(nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -133,7 +140,7 @@ func BenchmarkListTodoItems(b *testing.B) {
 		}
 	}
 }
-				
+
 func BenchmarkUpdateTodoItem(b *testing.B) {
 	log := logger.New(logger.Config{
 		Level:      "info",
@@ -143,22 +150,29 @@ func BenchmarkUpdateTodoItem(b *testing.B) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	useCase := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
+	mockOutboxRepo := new(MockOutboxRepository)
+	useCase := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
 
+	dueDate := time.Now().Add(24 * time.Hour)
+	fileID := "updated-file"
 	todo := &domain.TodoItem{
-		ID:          uuid.New(),
+		ID:          1,
+		UUID:        uuid.NewString(),
 		Description: "Updated todo",
-		DueDate:     time.Now().Add(24 * time.Hour),
-		FileID:      "updated-file",
+		DueDate:     &dueDate,
+		FileID:      &fileID,
 	}
 
-	mockTodoRepo.On("Update", mock.Anything, todo).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, mock.MatchedBy(func(key string) bool {
-		return key == "todos" || (len(key) > 5 && key[:5] == "todo:")
-	})).Return(nil)
+	mockTx := new(MockTx)
+	mockTodoRepo.On("GetByID", mock.Anything, todo.UUID).Return(&domain.TodoItem{ID: todo.ID, UUID: todo.UUID}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateTx", mock.Anything, mockTx, mock.AnythingOfType("*domain.TodoItem")).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.AnythingOfType("repository.OutboxMessage")).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("Delete", mock.Anything, "todo:v1:json:"+todo.UUID).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
 	mockFileRepo.On("Exists", mock.Anything, "updated-file").Return(true, nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, todo).Return(nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -2,10 +2,14 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/cache"
 	"github.com/delaram/GoTastic/pkg/logger"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -21,14 +25,16 @@ func TestCreateTodoItem(t *testing.T) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
+	mockOutboxRepo := new(MockOutboxRepository)
+	mockTx := new(MockTx)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
 
 	defer func() {
 		mockTodoRepo.AssertExpectations(t)
 		mockFileRepo.AssertExpectations(t)
 		mockCacheRepo.AssertExpectations(t)
-		mockStreamPublisher.AssertExpectations(t)
+		mockOutboxRepo.AssertExpectations(t)
+		mockTx.AssertExpectations(t)
 	}()
 
 	description := "Test todo"
@@ -36,19 +42,26 @@ func TestCreateTodoItem(t *testing.T) {
 	fileID := "test-file-id"
 
 	mockFileRepo.On("Exists", mock.Anything, fileID).Return(true, nil)
-	mockTodoRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todos").Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, mock.MatchedBy(func(todo *domain.TodoItem) bool {
-		return todo.Description == description && todo.DueDate.Equal(dueDate) && todo.FileID == fileID
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("CreateTx", mock.Anything, mockTx, mock.MatchedBy(func(todo *domain.TodoItem) bool {
+		return todo.Description == description && todo.DueDate.Equal(dueDate) && *todo.FileID == fileID
 	})).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.MatchedBy(func(msg repository.OutboxMessage) bool {
+		return msg.AggregateType == "todo" && msg.EventType == "todo.created"
+	})).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
 
 	todo, err := uc.CreateTodoItem(context.Background(), description, dueDate, fileID)
 
 	assert.NoError(t, err)
 	assert.NotNil(t, todo)
 	assert.Equal(t, description, todo.Description)
-	assert.Equal(t, dueDate, todo.DueDate)
-	assert.Equal(t, fileID, todo.FileID)
+	assert.Equal(t, dueDate, *todo.DueDate)
+	assert.Equal(t, fileID, *todo.FileID)
+	assert.Equal(t, domain.TodoStatusOpen, todo.CurrentStatus())
+	assert.Nil(t, todo.CompletedAt)
 }
 
 func TestGetTodoItem(t *testing.T) {
@@ -60,31 +73,30 @@ func TestGetTodoItem(t *testing.T) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
+	mockOutboxRepo := new(MockOutboxRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
 
-	id := uuid.New()
+	id := uuid.NewString()
+	dueDate := time.Now().Add(24 * time.Hour)
+	fileID := "test-file-id"
 	expectedTodo := &domain.TodoItem{
-		ID:          id,
+		ID:          1,
+		UUID:        id,
 		Description: "Test todo",
-		DueDate:     time.Now().Add(24 * time.Hour),
-		FileID:      "test-file-id",
+		DueDate:     &dueDate,
+		FileID:      &fileID,
 	}
 
-	mockCacheRepo.On("Get", mock.Anything, "todo:"+id.String()).Return(nil, nil)
-	mockTodoRepo.On("GetByID", mock.Anything, id.String()).Return(expectedTodo, nil)
-	mockCacheRepo.On("Set", mock.Anything, "todo:"+id.String(), expectedTodo, time.Hour).Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, mock.MatchedBy(func(todo *domain.TodoItem) bool {
-		return todo.ID == id
-	})).Return(nil)
+	mockCacheRepo.On("Get", mock.Anything, "todo:v1:json:"+id).Return(nil, nil)
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(expectedTodo, nil)
+	mockCacheRepo.On("Set", mock.Anything, "todo:v1:json:"+id, mock.Anything, time.Hour).Return(nil)
 
-	todo, err := uc.GetTodoItem(context.Background(), id.String())
+	todo, err := uc.GetTodoItem(context.Background(), id)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedTodo, todo)
 	mockTodoRepo.AssertExpectations(t)
 	mockCacheRepo.AssertExpectations(t)
-	mockStreamPublisher.AssertExpectations(t)
 }
 
 func TestListTodoItems(t *testing.T) {
@@ -96,43 +108,44 @@ func TestListTodoItems(t *testing.T) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
+	mockOutboxRepo := new(MockOutboxRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
 
+	due1 := time.Now().Add(24 * time.Hour)
+	due2 := time.Now().Add(48 * time.Hour)
+	file1 := "file-1"
+	file2 := "file-2"
 	expectedTodos := []*domain.TodoItem{
 		{
-			ID:          uuid.New(),
+			ID:          1,
+			UUID:        uuid.NewString(),
 			Description: "Todo 1",
-			DueDate:     time.Now().Add(24 * time.Hour),
-			FileID:      "file-1",
+			DueDate:     &due1,
+			FileID:      &file1,
 		},
 		{
-			ID:          uuid.New(),
+			ID:          2,
+			UUID:        uuid.NewString(),
 			Description: "Todo 2",
-			DueDate:     time.Now().Add(48 * time.Hour),
-			FileID:      "file-2",
+			DueDate:     &due2,
+			FileID:      &file2,
 		},
 	}
 
-	
-	mockCacheRepo.On("Get", mock.Anything, "todos").Return(nil, nil)
+	mockCacheRepo.On("Get", mock.Anything, "todos:v1:json:all").Return(nil, nil)
 	mockTodoRepo.On("List", mock.Anything).Return(expectedTodos, nil)
-	mockCacheRepo.On("Set", mock.Anything, "todos", expectedTodos, time.Hour).Return(nil)
-	mockStreamPublisher.On("PublishTodoItems", mock.Anything, mock.Anything).Return(nil)
+	mockCacheRepo.On("Set", mock.Anything, "todos:v1:json:all", mock.Anything, time.Hour).Return(nil)
+	mockCacheRepo.On("Tag", mock.Anything, "todos:v1:json:all", time.Hour, []string{"todos"}).Return(nil)
 
-	
 	todos, err := uc.ListTodoItems(context.Background())
 
-	
 	assert.NoError(t, err)
 	assert.Equal(t, expectedTodos, todos)
 	mockTodoRepo.AssertExpectations(t)
 	mockCacheRepo.AssertExpectations(t)
-	mockStreamPublisher.AssertExpectations(t)
 }
 
 func TestUpdateTodoItem(t *testing.T) {
-	
 	log := logger.New(logger.Config{
 		Level:      "info",
 		TimeFormat: time.RFC3339,
@@ -141,39 +154,46 @@ func TestUpdateTodoItem(t *testing.T) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
+	mockOutboxRepo := new(MockOutboxRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
 
-	
+	dueDate := time.Now().Add(24 * time.Hour)
+	fileID := "updated-file"
+	mockTx := new(MockTx)
 	todo := &domain.TodoItem{
-		ID:          uuid.New(),
+		ID:          7,
+		UUID:        uuid.NewString(),
 		Description: "Updated todo",
-		DueDate:     time.Now().Add(24 * time.Hour),
-		FileID:      "updated-file",
+		DueDate:     &dueDate,
+		FileID:      &fileID,
 	}
 
-	
-	mockFileRepo.On("Exists", mock.Anything, todo.FileID).Return(true, nil)
-	mockTodoRepo.On("Update", mock.Anything, todo).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todos").Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todo:"+todo.ID.String()).Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, mock.MatchedBy(func(updatedTodo *domain.TodoItem) bool {
-		return updatedTodo.ID == todo.ID && updatedTodo.Description == todo.Description
+	mockFileRepo.On("Exists", mock.Anything, fileID).Return(true, nil)
+	mockTodoRepo.On("GetByID", mock.Anything, todo.UUID).Return(&domain.TodoItem{ID: todo.ID, UUID: todo.UUID, Description: "Old todo"}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateTx", mock.Anything, mockTx, mock.MatchedBy(func(updated *domain.TodoItem) bool {
+		return updated.UUID == todo.UUID && updated.Description == todo.Description
 	})).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.MatchedBy(func(msg repository.OutboxMessage) bool {
+		return msg.EventType == repository.EventTodoUpdated && msg.AggregateID == todo.UUID
+	})).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	// Invalidation uses the UUID the item is cached under, not the numeric ID.
+	mockCacheRepo.On("Delete", mock.Anything, "todo:v1:json:"+todo.UUID).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
 
-	
 	err := uc.UpdateTodoItem(context.Background(), todo)
 
-	
 	assert.NoError(t, err)
 	mockFileRepo.AssertExpectations(t)
 	mockTodoRepo.AssertExpectations(t)
 	mockCacheRepo.AssertExpectations(t)
-	mockStreamPublisher.AssertExpectations(t)
+	mockOutboxRepo.AssertExpectations(t)
+	mockTx.AssertExpectations(t)
 }
 
 func TestDeleteTodoItem(t *testing.T) {
-	
 	log := logger.New(logger.Config{
 		Level:      "info",
 		TimeFormat: time.RFC3339,
@@ -182,26 +202,603 @@ func TestDeleteTodoItem(t *testing.T) {
 	mockTodoRepo := new(MockTodoRepository)
 	mockFileRepo := new(MockFileRepository)
 	mockCacheRepo := new(MockCacheRepository)
-	mockStreamPublisher := new(MockStreamPublisher)
-	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockStreamPublisher)
-
-	
-	id := uuid.New().String()
-
-	
-	mockTodoRepo.On("Delete", mock.Anything, id).Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todos").Return(nil)
-	mockCacheRepo.On("Delete", mock.Anything, "todo:"+id).Return(nil)
-	mockStreamPublisher.On("PublishTodoItem", mock.Anything, mock.MatchedBy(func(todo *domain.TodoItem) bool {
-		return todo.ID.String() == id
+	mockOutboxRepo := new(MockOutboxRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
+
+	id := uuid.NewString()
+	existing := &domain.TodoItem{ID: 3, UUID: id, Description: "Doomed todo"}
+	mockTx := new(MockTx)
+
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(existing, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("DeleteTx", mock.Anything, mockTx, id).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.MatchedBy(func(msg repository.OutboxMessage) bool {
+		return msg.EventType == repository.EventTodoDeleted && msg.AggregateID == id
 	})).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("Delete", mock.Anything, "todo:v1:json:"+id).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
 
-			
 	err := uc.DeleteTodoItem(context.Background(), id)
 
-	
 	assert.NoError(t, err)
 	mockTodoRepo.AssertExpectations(t)
 	mockCacheRepo.AssertExpectations(t)
-	mockStreamPublisher.AssertExpectations(t)
+	mockOutboxRepo.AssertExpectations(t)
+	mockTx.AssertExpectations(t)
+}
+
+func TestDeleteTodoItemKeepsTodoWhenOutboxInsertFails(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockCacheRepo := new(MockCacheRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	mockTx := new(MockTx)
+	uc := NewTodoUseCase(log, mockTodoRepo, new(MockFileRepository), mockCacheRepo, mockOutboxRepo)
+
+	id := uuid.NewString()
+	insertErr := errors.New("outbox unavailable")
+
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(&domain.TodoItem{ID: 3, UUID: id}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("DeleteTx", mock.Anything, mockTx, id).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.Anything).Return(insertErr)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+
+	err := uc.DeleteTodoItem(context.Background(), id)
+
+	assert.Equal(t, insertErr, err)
+	mockTx.AssertNotCalled(t, "Commit", mock.Anything)
+	mockCacheRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestChangeTodoStatus(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockFileRepo := new(MockFileRepository)
+	mockCacheRepo := new(MockCacheRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
+
+	id := uuid.NewString()
+	existing := &domain.TodoItem{ID: 5, UUID: id, Description: "Ship it", Status: string(domain.TodoStatusInProgress)}
+	mockTx := new(MockTx)
+
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(existing, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateStatusTx", mock.Anything, mockTx, mock.MatchedBy(func(todo *domain.TodoItem) bool {
+		return todo.Status == string(domain.TodoStatusDone) && todo.CompletedAt != nil
+	}), domain.TodoStatusInProgress).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.MatchedBy(func(msg repository.OutboxMessage) bool {
+		return msg.EventType == repository.EventTodoUpdated
+	})).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("Delete", mock.Anything, "todo:v1:json:"+id).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
+
+	todo, err := uc.ChangeTodoStatus(context.Background(), id, domain.TodoStatusDone)
+
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TodoStatusDone, todo.CurrentStatus())
+	assert.NotNil(t, todo.CompletedAt)
+	mockTodoRepo.AssertExpectations(t)
+	mockCacheRepo.AssertExpectations(t)
+	mockOutboxRepo.AssertExpectations(t)
+	mockTx.AssertExpectations(t)
+}
+
+func TestChangeTodoStatusReopenClearsCompletedAt(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockFileRepo := new(MockFileRepository)
+	mockCacheRepo := new(MockCacheRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
+
+	id := uuid.NewString()
+	completedAt := time.Now().Add(-time.Hour)
+	existing := &domain.TodoItem{ID: 6, UUID: id, Status: string(domain.TodoStatusDone), CompletedAt: &completedAt}
+
+	mockTx := new(MockTx)
+
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(existing, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateStatusTx", mock.Anything, mockTx, mock.Anything, domain.TodoStatusDone).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, mock.Anything).Return(nil)
+
+	todo, err := uc.ChangeTodoStatus(context.Background(), id, domain.TodoStatusOpen)
+
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TodoStatusOpen, todo.CurrentStatus())
+	assert.Nil(t, todo.CompletedAt)
+}
+
+func TestChangeTodoStatusRejectsIllegalTransition(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+
+	cases := []struct {
+		from domain.TodoStatus
+		to   domain.TodoStatus
+	}{
+		{domain.TodoStatusDone, domain.TodoStatusInProgress},
+		{domain.TodoStatusDone, domain.TodoStatusCancelled},
+		{domain.TodoStatusCancelled, domain.TodoStatusDone},
+		{domain.TodoStatusBlocked, domain.TodoStatusDone},
+		{domain.TodoStatusOpen, domain.TodoStatusOpen},
+	}
+
+	for _, tc := range cases {
+		t.Run(string(tc.from)+"->"+string(tc.to), func(t *testing.T) {
+			mockTodoRepo := new(MockTodoRepository)
+			mockCacheRepo := new(MockCacheRepository)
+			uc := NewTodoUseCase(log, mockTodoRepo, new(MockFileRepository), mockCacheRepo, new(MockOutboxRepository))
+
+			id := uuid.NewString()
+			mockTodoRepo.On("GetByID", mock.Anything, id).Return(&domain.TodoItem{UUID: id, Status: string(tc.from)}, nil)
+
+			_, err := uc.ChangeTodoStatus(context.Background(), id, tc.to)
+
+			assert.Equal(t, domain.ErrInvalidStatusTransition, err)
+			mockTodoRepo.AssertNotCalled(t, "BeginTx", mock.Anything)
+			mockTodoRepo.AssertNotCalled(t, "UpdateStatusTx", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestChangeTodoStatusRejectsUnknownStatus(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, new(MockFileRepository), new(MockCacheRepository), new(MockOutboxRepository))
+
+	_, err := uc.ChangeTodoStatus(context.Background(), uuid.NewString(), domain.TodoStatus("archived"))
+
+	assert.Equal(t, domain.ErrInvalidStatus, err)
+	mockTodoRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+}
+
+func TestCreateTodoItemRollsBackWhenOutboxInsertFails(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockFileRepo := new(MockFileRepository)
+	mockCacheRepo := new(MockCacheRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	mockTx := new(MockTx)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo)
+
+	insertErr := errors.New("outbox unavailable")
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("CreateTx", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.Anything).Return(insertErr)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+
+	todo, err := uc.CreateTodoItem(context.Background(), "Test todo", time.Now(), "")
+
+	assert.Equal(t, insertErr, err)
+	assert.Nil(t, todo)
+	mockTx.AssertCalled(t, "Rollback", mock.Anything)
+	mockTx.AssertNotCalled(t, "Commit", mock.Anything)
+	mockCacheRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestCreateTodoItemAttachesItsFileInTheSameTransaction(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockFileRepo := new(MockFileRepository)
+	mockCacheRepo := new(MockCacheRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	mockAttachments := new(MockAttachmentRepository)
+	mockTx := new(MockTx)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo).WithAttachments(mockAttachments)
+
+	mockFileRepo.On("Exists", mock.Anything, "file-1").Return(true, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("CreateTx", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockAttachments.On("AttachTx", mock.Anything, mockTx, mock.MatchedBy(func(a *domain.TodoAttachment) bool {
+		return a.FileID == "file-1" && a.TodoUUID != ""
+	})).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
+
+	todo, err := uc.CreateTodoItem(context.Background(), "Test todo", time.Now(), "file-1")
+
+	assert.NoError(t, err)
+	mockAttachments.AssertCalled(t, "AttachTx", mock.Anything, mockTx, mock.MatchedBy(func(a *domain.TodoAttachment) bool {
+		return a.TodoUUID == todo.UUID
+	}))
+	mockTx.AssertExpectations(t)
+}
+
+func TestUpdateTodoItemRollsBackWhenAttachFails(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockFileRepo := new(MockFileRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	mockAttachments := new(MockAttachmentRepository)
+	mockTx := new(MockTx)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, new(MockCacheRepository), mockOutboxRepo).WithAttachments(mockAttachments)
+
+	fileID := "file-2"
+	todo := &domain.TodoItem{UUID: uuid.NewString(), Description: "Updated", FileID: &fileID}
+	attachErr := errors.New("attachments unavailable")
+	mockFileRepo.On("Exists", mock.Anything, fileID).Return(true, nil)
+	mockTodoRepo.On("GetByID", mock.Anything, todo.UUID).Return(&domain.TodoItem{UUID: todo.UUID}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateTx", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockAttachments.On("AttachTx", mock.Anything, mockTx, mock.Anything).Return(attachErr)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+
+	err := uc.UpdateTodoItem(context.Background(), todo)
+
+	assert.Equal(t, attachErr, err)
+	mockTx.AssertNotCalled(t, "Commit", mock.Anything)
+	mockOutboxRepo.AssertNotCalled(t, "Insert", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpdateTodoItemKeepsAnAlreadyAttachedFile(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockFileRepo := new(MockFileRepository)
+	mockCacheRepo := new(MockCacheRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	mockAttachments := new(MockAttachmentRepository)
+	mockTx := new(MockTx)
+	uc := NewTodoUseCase(log, mockTodoRepo, mockFileRepo, mockCacheRepo, mockOutboxRepo).WithAttachments(mockAttachments)
+
+	fileID := "file-3"
+	todo := &domain.TodoItem{UUID: uuid.NewString(), Description: "Updated", FileID: &fileID}
+	mockFileRepo.On("Exists", mock.Anything, fileID).Return(true, nil)
+	mockTodoRepo.On("GetByID", mock.Anything, todo.UUID).Return(&domain.TodoItem{UUID: todo.UUID, FileID: &fileID}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateTx", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockAttachments.On("AttachTx", mock.Anything, mockTx, mock.Anything).Return(repository.ErrDuplicate)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	mockCacheRepo.On("Delete", mock.Anything, "todo:v1:json:"+todo.UUID).Return(nil)
+	mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
+
+	err := uc.UpdateTodoItem(context.Background(), todo)
+
+	assert.NoError(t, err)
+	mockTx.AssertCalled(t, "Commit", mock.Anything)
+}
+
+// memTx is an in-memory unit of work: staged writes are applied to the store
+// only on Commit, mirroring how the BeeORM flusher behaves.
+type memTx struct {
+	store  *memStore
+	staged []func()
+	done   bool
+}
+
+func (tx *memTx) Commit(ctx context.Context) error {
+	if tx.done {
+		return errors.New("tx done")
+	}
+	tx.done = true
+	for _, apply := range tx.staged {
+		apply()
+	}
+	return nil
+}
+
+func (tx *memTx) Rollback(ctx context.Context) error {
+	tx.done = true
+	tx.staged = nil
+	return nil
+}
+
+type memStore struct {
+	todos  map[string]*domain.TodoItem
+	outbox []repository.OutboxMessage
+}
+
+type memTodoRepo struct {
+	*MockTodoRepository
+	store *memStore
+}
+
+func (r *memTodoRepo) BeginTx(ctx context.Context) (repository.Tx, error) {
+	return &memTx{store: r.store}, nil
+}
+
+func (r *memTodoRepo) CreateTx(ctx context.Context, tx repository.Tx, todo *domain.TodoItem) error {
+	mt := tx.(*memTx)
+	mt.staged = append(mt.staged, func() { r.store.todos[todo.UUID] = todo })
+	return nil
+}
+
+type memOutboxRepo struct {
+	*MockOutboxRepository
+	store *memStore
+	err   error
+}
+
+func (r *memOutboxRepo) Insert(ctx context.Context, tx repository.Tx, msg repository.OutboxMessage) error {
+	if r.err != nil {
+		return r.err
+	}
+	mt := tx.(*memTx)
+	mt.staged = append(mt.staged, func() { r.store.outbox = append(r.store.outbox, msg) })
+	return nil
+}
+
+func TestCreateTodoItemUnitOfWork(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+
+	t.Run("commit persists todo and outbox row together", func(t *testing.T) {
+		store := &memStore{todos: map[string]*domain.TodoItem{}}
+		mockCacheRepo := new(MockCacheRepository)
+		mockCacheRepo.On("InvalidateTags", mock.Anything, []string{"todos"}).Return(nil)
+		uc := NewTodoUseCase(log, &memTodoRepo{store: store}, new(MockFileRepository), mockCacheRepo, &memOutboxRepo{store: store})
+
+		todo, err := uc.CreateTodoItem(context.Background(), "Atomic todo", time.Now(), "")
+
+		assert.NoError(t, err)
+		assert.Contains(t, store.todos, todo.UUID)
+		assert.Len(t, store.outbox, 1)
+		assert.Equal(t, todo.UUID, store.outbox[0].AggregateID)
+	})
+
+	t.Run("failed outbox insert leaves no todo behind", func(t *testing.T) {
+		store := &memStore{todos: map[string]*domain.TodoItem{}}
+		uc := NewTodoUseCase(log, &memTodoRepo{store: store}, new(MockFileRepository), new(MockCacheRepository),
+			&memOutboxRepo{store: store, err: errors.New("boom")})
+
+		_, err := uc.CreateTodoItem(context.Background(), "Doomed todo", time.Now(), "")
+
+		assert.Error(t, err)
+		assert.Empty(t, store.todos)
+		assert.Empty(t, store.outbox)
+	})
+}
+
+// memCache is a byte store backed by a map, so cached values really go
+// through the codec on the way in and out.
+type memCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	tags    map[string][]string
+}
+
+func newMemCache() *memCache {
+	return &memCache{entries: map[string][]byte{}, tags: map[string][]string{}}
+}
+
+func (c *memCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key], nil
+}
+
+func (c *memCache) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = value
+	return nil
+}
+
+func (c *memCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *memCache) Tag(ctx context.Context, key string, expiration time.Duration, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		c.tags[tag] = append(c.tags[tag], key)
+	}
+	return nil
+}
+
+func (c *memCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		for _, key := range c.tags[tag] {
+			delete(c.entries, key)
+		}
+		delete(c.tags, tag)
+	}
+	return nil
+}
+
+func TestCacheHitsSkipTodoRepository(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+
+	for _, codec := range []cache.Codec{cache.JSON, cache.Msgpack} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			mockTodoRepo := new(MockTodoRepository)
+			uc := NewTodoUseCase(log, mockTodoRepo, new(MockFileRepository), newMemCache(), new(MockOutboxRepository)).
+				WithCache(codec, time.Minute, 0)
+
+			due := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+			fileID := "file-1"
+			stored := &domain.TodoItem{
+				ID:          1,
+				UUID:        uuid.NewString(),
+				Description: "Cached todo",
+				DueDate:     &due,
+				FileID:      &fileID,
+				Status:      string(domain.TodoStatusOpen),
+				CreatedAt:   due.Add(-time.Hour),
+				UpdatedAt:   due.Add(-time.Hour),
+			}
+			mockTodoRepo.On("GetByID", mock.Anything, stored.UUID).Return(stored, nil).Once()
+			mockTodoRepo.On("List", mock.Anything).Return([]*domain.TodoItem{stored}, nil).Once()
+
+			for i := 0; i < 3; i++ {
+				todo, err := uc.GetTodoItem(context.Background(), stored.UUID)
+				assert.NoError(t, err)
+				assert.Equal(t, stored.UUID, todo.UUID)
+				assert.Equal(t, stored.Description, todo.Description)
+				assert.True(t, stored.DueDate.Equal(*todo.DueDate))
+				assert.Equal(t, fileID, *todo.FileID)
+
+				todos, err := uc.ListTodoItems(context.Background())
+				assert.NoError(t, err)
+				assert.Len(t, todos, 1)
+				assert.Equal(t, stored.UUID, todos[0].UUID)
+			}
+
+			mockTodoRepo.AssertNumberOfCalls(t, "GetByID", 1)
+			mockTodoRepo.AssertNumberOfCalls(t, "List", 1)
+		})
+	}
+}
+
+func TestPagedListingsAreCachedPerQueryAndEvictedOnDelete(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	mockTx := new(MockTx)
+	store := newMemCache()
+	uc := NewTodoUseCase(log, mockTodoRepo, new(MockFileRepository), store, mockOutboxRepo).
+		WithCache(cache.JSON, time.Minute, 0)
+
+	doomed := &domain.TodoItem{ID: 1, UUID: uuid.NewString(), Description: "Doomed todo"}
+	q := "doomed"
+	byText := domain.TodoFilter{Q: &q}
+	asc := domain.TodoSort{Field: domain.SortCreatedAt, Direction: domain.SortAsc}
+	mockTodoRepo.On("ListPaged", mock.Anything, byText, asc, 10, 0).Return([]*domain.TodoItem{doomed}, int64(1), nil).Once()
+	mockTodoRepo.On("ListPaged", mock.Anything, domain.TodoFilter{}, asc, 10, 0).Return([]*domain.TodoItem{doomed}, int64(1), nil).Once()
+
+	for i := 0; i < 2; i++ {
+		items, total, err := uc.ListTodoItemsPaged(context.Background(), byText, asc, 10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		assert.Equal(t, doomed.UUID, items[0].UUID)
+		_, _, err = uc.ListTodoItemsPaged(context.Background(), domain.TodoFilter{}, asc, 10, 0)
+		assert.NoError(t, err)
+	}
+	mockTodoRepo.AssertNumberOfCalls(t, "ListPaged", 2)
+	assert.Len(t, store.tags["todos"], 2)
+
+	mockTodoRepo.On("GetByID", mock.Anything, doomed.UUID).Return(doomed, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("DeleteTx", mock.Anything, mockTx, doomed.UUID).Return(nil)
+	mockOutboxRepo.On("Insert", mock.Anything, mockTx, mock.Anything).Return(nil)
+	mockTx.On("Commit", mock.Anything).Return(nil)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+	assert.NoError(t, uc.DeleteTodoItem(context.Background(), doomed.UUID))
+
+	assert.Empty(t, store.entries)
+	mockTodoRepo.On("ListPaged", mock.Anything, byText, asc, 10, 0).Return([]*domain.TodoItem{}, int64(0), nil).Once()
+	items, total, err := uc.ListTodoItemsPaged(context.Background(), byText, asc, 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestConcurrentCacheMissesShareOneRepositoryCall(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, new(MockFileRepository), newMemCache(), new(MockOutboxRepository))
+
+	stored := &domain.TodoItem{ID: 1, UUID: uuid.NewString(), Description: "Hot todo"}
+	// The slow lookup keeps every caller waiting on the same load.
+	mockTodoRepo.On("GetByID", mock.Anything, stored.UUID).Return(stored, nil).After(50 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			todo, err := uc.GetTodoItem(context.Background(), stored.UUID)
+			assert.NoError(t, err)
+			assert.Equal(t, stored.UUID, todo.UUID)
+		}()
+	}
+	wg.Wait()
+
+	mockTodoRepo.AssertNumberOfCalls(t, "GetByID", 1)
+}
+
+func TestChangeTodoStatusLosesToAConcurrentChange(t *testing.T) {
+	log := logger.New(logger.Config{
+		Level:      "info",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	mockTodoRepo := new(MockTodoRepository)
+	mockCacheRepo := new(MockCacheRepository)
+	mockOutboxRepo := new(MockOutboxRepository)
+	uc := NewTodoUseCase(log, mockTodoRepo, new(MockFileRepository), mockCacheRepo, mockOutboxRepo)
+
+	// Read as done, but reopened by someone else before the write.
+	id := uuid.NewString()
+	mockTx := new(MockTx)
+	mockTodoRepo.On("GetByID", mock.Anything, id).Return(&domain.TodoItem{UUID: id, Status: string(domain.TodoStatusDone)}, nil)
+	mockTodoRepo.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	mockTodoRepo.On("UpdateStatusTx", mock.Anything, mockTx, mock.Anything, domain.TodoStatusDone).Return(domain.ErrInvalidStatusTransition)
+	mockTx.On("Rollback", mock.Anything).Return(nil)
+
+	_, err := uc.ChangeTodoStatus(context.Background(), id, domain.TodoStatusOpen)
+
+	assert.Equal(t, domain.ErrInvalidStatusTransition, err)
+	mockOutboxRepo.AssertNotCalled(t, "Insert", mock.Anything, mock.Anything, mock.Anything)
+	mockCacheRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
 }
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+// FileCollector is the part of usecase.FileGCUseCase the job drives.
+type FileCollector interface {
+	Collect(ctx context.Context, grace time.Duration, dryRun bool) (*usecase.FileGCReport, error)
+}
+
+// FileGCJob collects unreferenced files on a timer. In dry-run mode it only
+// logs what a real run would delete, so the grace period can be checked
+// against production data before anything is removed.
+type FileGCJob struct {
+	logger    logger.Logger
+	collector FileCollector
+	cfg       config.FileGCConfig
+}
+
+func NewFileGCJob(logger logger.Logger, collector FileCollector, cfg config.FileGCConfig) *FileGCJob {
+	return &FileGCJob{logger: logger, collector: collector, cfg: cfg}
+}
+
+func (j *FileGCJob) Run(ctx context.Context) {
+	if j.cfg.Interval <= 0 {
+		j.logger.Info("File GC disabled; job not started")
+		return
+	}
+
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		j.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *FileGCJob) tick(ctx context.Context) {
+	report, err := j.collector.Collect(ctx, j.cfg.GracePeriod, j.cfg.DryRun)
+	if err != nil {
+		// Collect has logged it; the next tick starts over.
+		return
+	}
+	if report.DryRun {
+		for _, f := range report.Orphans {
+			j.logger.Info("File GC (dry run): would delete %s (%d bytes, modified %s)", f.ID, f.Size, f.ModTime.Format(time.RFC3339))
+		}
+		if report.Unconfirmed > 0 {
+			j.logger.Info("File GC (dry run): would expire %d unconfirmed uploads", report.Unconfirmed)
+		}
+	}
+}
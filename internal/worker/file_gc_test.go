@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCollector struct {
+	graces  []time.Duration
+	dryRuns []bool
+}
+
+func (f *fakeCollector) Collect(ctx context.Context, grace time.Duration, dryRun bool) (*usecase.FileGCReport, error) {
+	f.graces = append(f.graces, grace)
+	f.dryRuns = append(f.dryRuns, dryRun)
+	return &usecase.FileGCReport{DryRun: dryRun}, nil
+}
+
+func TestFileGCJobPassesConfig(t *testing.T) {
+	collector := &fakeCollector{}
+	job := NewFileGCJob(testLogger(), collector, config.FileGCConfig{Interval: time.Hour, GracePeriod: 24 * time.Hour, DryRun: true})
+
+	job.tick(context.Background())
+
+	assert.Equal(t, []time.Duration{24 * time.Hour}, collector.graces)
+	assert.Equal(t, []bool{true}, collector.dryRuns)
+}
+
+func TestFileGCJobDisabled(t *testing.T) {
+	collector := &fakeCollector{}
+	job := NewFileGCJob(testLogger(), collector, config.FileGCConfig{})
+
+	job.Run(context.Background())
+
+	assert.Empty(t, collector.graces)
+}
@@ -4,8 +4,11 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"math"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/delaram/GoTastic/internal/domain"
@@ -15,20 +18,42 @@ import (
 type OutboxDispatcher struct {
 	outbox repository.OutboxRepository
 
-	stream repository.StreamPublisher
+	broker repository.MessageBroker
+	stream string
 
+	// workerID identifies this replica's claims in outbox.LockedBy.
+	workerID       string
 	batchSize      int
 	lockForSeconds int
 	maxAttempts    int
 }
 
-func NewOutboxDispatcher(outbox repository.OutboxRepository, stream repository.StreamPublisher) *OutboxDispatcher {
+// NewOutboxDispatcher publishes claimed outbox rows to stream on broker.
+func NewOutboxDispatcher(outbox repository.OutboxRepository, broker repository.MessageBroker, stream string) *OutboxDispatcher {
 	return &OutboxDispatcher{
-		outbox: outbox, stream: stream,
+		outbox: outbox, broker: broker, stream: stream,
+		workerID:  defaultWorkerID(),
 		batchSize: 100, lockForSeconds: 30, maxAttempts: 10,
 	}
 }
 
+// WithMaxAttempts sets how many failed deliveries a row gets before it is
+// dead-lettered.
+func (d *OutboxDispatcher) WithMaxAttempts(n int) *OutboxDispatcher {
+	if n > 0 {
+		d.maxAttempts = n
+	}
+	return d
+}
+
+// WithWorkerID overrides the hostname-based owner name, e.g. with a pod name.
+func (d *OutboxDispatcher) WithWorkerID(id string) *OutboxDispatcher {
+	if id != "" {
+		d.workerID = id
+	}
+	return d
+}
+
 func (d *OutboxDispatcher) Run(ctx context.Context) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
@@ -43,36 +68,85 @@ func (d *OutboxDispatcher) Run(ctx context.Context) {
 }
 
 func (d *OutboxDispatcher) tick(ctx context.Context) {
-	rows, err := d.outbox.FetchAndLock(ctx, d.batchSize, d.lockForSeconds)
+	rows, err := d.outbox.FetchAndLock(ctx, d.workerID, d.batchSize, d.lockForSeconds)
 	if err != nil {
 		log.Printf("outbox fetch error: %v", err)
 		return
 	}
 	for _, row := range rows {
+		// Once the lease runs out another replica may already own the row;
+		// leave it to them rather than publishing it twice.
+		if time.Now().After(row.LockedUntil) {
+			log.Printf("outbox lease expired before publishing row %d", row.ID)
+			continue
+		}
 		if err := d.handle(ctx, row); err != nil {
+			if row.Attempts+1 >= d.maxAttempts {
+				log.Printf("outbox row %d dead-lettered after %d attempts: %v", row.ID, row.Attempts+1, err)
+				if err := d.outbox.MarkDead(ctx, row.ID, d.workerID, err.Error()); err != nil {
+					log.Printf("outbox mark dead error for row %d: %v", row.ID, err)
+				}
+				continue
+			}
 			// compute backoff and reschedule
 			next := nextBackoff(row.Attempts+1, time.Second, 10*time.Minute)
-			_ = d.outbox.MarkFailed(ctx, row.ID, next.Format("2006-01-02 15:04:05"), err.Error())
+			if err := d.outbox.MarkFailed(ctx, row.ID, d.workerID, next.UTC().Format("2006-01-02 15:04:05"), err.Error()); err != nil {
+				log.Printf("outbox mark failed error for row %d: %v", row.ID, err)
+			}
 			continue
 		}
-		_ = d.outbox.MarkPublished(ctx, row.ID)
+		if err := d.outbox.MarkPublished(ctx, row.ID, d.workerID); err != nil {
+			log.Printf("outbox mark published error for row %d: %v", row.ID, err)
+		}
 	}
 }
 
 func (d *OutboxDispatcher) handle(ctx context.Context, row repository.LockedOutboxRow) error {
 	switch row.EventType {
-	case "todo.created":
+	case repository.EventTodoCreated, repository.EventTodoUpdated, repository.EventTodoDeleted:
 		var todo domain.TodoItem
 		if err := json.Unmarshal(row.Payload, &todo); err != nil {
 			return err
 		}
-		return d.stream.PublishTodoItem(ctx, &todo)
+		ev, err := repository.NewTodoEvent(eventMeta(row), repository.DefaultEventSource, &todo)
+		if err != nil {
+			return err
+		}
+		return d.broker.Publish(ctx, d.stream, ev)
 
-	// add more event types here:
-	// case "file.deleted": ...
 	default:
-		// treat unknown events as success (or fail; your call)
-		return nil
+		// Events without a dedicated shape go out with the row payload as
+		// their data, so new aggregates need no dispatcher change.
+		meta := eventMeta(row)
+		ev := &repository.CloudEvent{
+			SpecVersion:     repository.CloudEventsSpecVersion,
+			ID:              meta.ID,
+			Source:          repository.DefaultEventSource,
+			Type:            meta.Type,
+			Subject:         row.AggregateID,
+			Time:            meta.Time,
+			DataContentType: "application/json",
+			Data:            row.Payload,
+		}
+		for k, v := range meta.Headers {
+			ev.SetExtension(k, v)
+		}
+		return d.broker.Publish(ctx, d.stream, ev)
+	}
+}
+
+// eventMeta gives the published event the outbox row's identity: its ID, so
+// consumers can dedupe redeliveries, and its headers as extensions.
+func eventMeta(row repository.LockedOutboxRow) repository.EventMeta {
+	occurred := row.CreatedAt
+	if occurred.IsZero() {
+		occurred = time.Now().UTC()
+	}
+	return repository.EventMeta{
+		ID:      strconv.FormatUint(row.ID, 10),
+		Type:    row.EventType,
+		Time:    occurred,
+		Headers: row.Headers,
 	}
 }
 
@@ -85,3 +159,11 @@ func nextBackoff(attempt int, base, max time.Duration) time.Time {
 	}
 	return time.Now().Add(d)
 }
+
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "dispatcher"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/domain"
+	"github.com/delaram/GoTastic/internal/infrastructure/broker"
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type failingBroker struct{ err error }
+
+func (b failingBroker) Publish(ctx context.Context, stream string, message interface{}) error {
+	return b.err
+}
+
+func (b failingBroker) Close() error { return nil }
+
+func lockedRow(t *testing.T, id uint64, eventType string, attempts int) repository.LockedOutboxRow {
+	payload, err := json.Marshal(domain.TodoItem{UUID: "todo-uuid", Description: "write tests"})
+	assert.NoError(t, err)
+	return repository.LockedOutboxRow{
+		ID:          id,
+		AggregateID: "todo-uuid",
+		EventType:   eventType,
+		Payload:     payload,
+		Attempts:    attempts,
+		Headers:     map[string]string{"origin": "api"},
+		CreatedAt:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		LockedUntil: time.Now().Add(time.Minute),
+	}
+}
+
+func TestOutboxDispatcherPublishesThroughBroker(t *testing.T) {
+	outbox := new(usecase.MockOutboxRepository)
+	b := broker.NewChannelBroker()
+	defer b.Close()
+	deliveries := b.Subscribe("todos", 2)
+
+	outbox.On("FetchAndLock", mock.Anything, "w1", 100, 30).Return([]repository.LockedOutboxRow{
+		lockedRow(t, 7, repository.EventTodoCreated, 0),
+		lockedRow(t, 8, "todo.archived", 0),
+	}, nil)
+	outbox.On("MarkPublished", mock.Anything, uint64(7), "w1").Return(nil)
+	outbox.On("MarkPublished", mock.Anything, uint64(8), "w1").Return(nil)
+
+	d := NewOutboxDispatcher(outbox, b, "todos").WithWorkerID("w1")
+	d.tick(context.Background())
+
+	first := <-deliveries
+	assert.Equal(t, repository.EventTodoCreated, first.Type)
+	ev := first.Message.(*repository.CloudEvent)
+	assert.Equal(t, "7", ev.ID)
+	assert.Equal(t, "todo-uuid", ev.Subject)
+	assert.Equal(t, "api", ev.Extensions["origin"])
+
+	second := <-deliveries
+	assert.Equal(t, "todo.archived", second.Type)
+	assert.Equal(t, "8", second.Message.(*repository.CloudEvent).ID)
+
+	outbox.AssertExpectations(t)
+}
+
+func TestOutboxDispatcherReschedulesAndDeadLetters(t *testing.T) {
+	outbox := new(usecase.MockOutboxRepository)
+	outbox.On("FetchAndLock", mock.Anything, "w1", 100, 30).Return([]repository.LockedOutboxRow{
+		lockedRow(t, 1, repository.EventTodoUpdated, 0),
+		lockedRow(t, 2, repository.EventTodoUpdated, 2),
+	}, nil)
+	outbox.On("MarkFailed", mock.Anything, uint64(1), "w1", mock.Anything, "nats down").Return(nil)
+	outbox.On("MarkDead", mock.Anything, uint64(2), "w1", "nats down").Return(nil)
+
+	d := NewOutboxDispatcher(outbox, failingBroker{err: errors.New("nats down")}, "todos").
+		WithWorkerID("w1").
+		WithMaxAttempts(3)
+	d.tick(context.Background())
+
+	outbox.AssertExpectations(t)
+	outbox.AssertNotCalled(t, "MarkPublished", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,363 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// EventHandler processes one event. Returning nil acks the message; any other
+// error leaves it pending so it is redelivered, unless it is wrapped with
+// Permanent, in which case the message is parked right away.
+type EventHandler func(ctx context.Context, event repository.CloudEvent) error
+
+// StreamClient is the part of go-redis the consumer uses. *redis.Client and
+// *redis.ClusterClient satisfy it.
+type StreamClient interface {
+	XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd
+	XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd
+	XAutoClaim(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimCmd
+	XPendingExt(ctx context.Context, a *redis.XPendingExtArgs) *redis.XPendingExtCmd
+	XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd
+	XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type StreamConsumerConfig struct {
+	Stream   string
+	Group    string
+	Consumer string
+
+	// StartID is where a newly created group starts reading: "0" replays the
+	// whole stream, "$" only sees new entries. Defaults to "0".
+	StartID string
+
+	BatchSize int64
+	// Block is how long one XREADGROUP waits for new entries.
+	Block time.Duration
+	// MinIdle is how long an entry must sit unacked in another consumer's
+	// pending list before XAUTOCLAIM takes it over.
+	MinIdle       time.Duration
+	ClaimInterval time.Duration
+	// MaxDeliveries is how many times an entry is delivered before it is
+	// parked on DeadStream.
+	MaxDeliveries int64
+	DeadStream    string
+	// DeadRetention bounds DeadStream as entries are parked on it. Parking
+	// is rare, so a StreamTrimJob should trim DeadStream too.
+	DeadRetention  repository.StreamRetention
+	HandlerTimeout time.Duration
+}
+
+// DeadStreamOf is the stream a consumer of stream parks entries on unless
+// DeadStream says otherwise.
+func DeadStreamOf(stream string) string {
+	return stream + ":dead"
+}
+
+func (c *StreamConsumerConfig) setDefaults() {
+	if c.StartID == "" {
+		c.StartID = "0"
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 10
+	}
+	if c.Block <= 0 {
+		c.Block = 5 * time.Second
+	}
+	if c.MinIdle <= 0 {
+		c.MinIdle = time.Minute
+	}
+	if c.ClaimInterval <= 0 {
+		c.ClaimInterval = 30 * time.Second
+	}
+	if c.MaxDeliveries <= 0 {
+		c.MaxDeliveries = 5
+	}
+	if c.DeadStream == "" {
+		c.DeadStream = DeadStreamOf(c.Stream)
+	}
+	if c.HandlerTimeout <= 0 {
+		c.HandlerTimeout = 30 * time.Second
+	}
+	if c.Consumer == "" {
+		c.Consumer = defaultWorkerID()
+	}
+}
+
+// StreamConsumer reads CloudEvents from a Redis stream through a consumer
+// group and dispatches them to handlers registered per event type. Delivery
+// is at-least-once: an entry is acked only after its handler succeeds, and
+// entries left pending by a crashed consumer are reclaimed with XAUTOCLAIM.
+type StreamConsumer struct {
+	logger logger.Logger
+	client StreamClient
+	cfg    StreamConsumerConfig
+
+	mu       sync.RWMutex
+	handlers map[string]EventHandler
+}
+
+func NewStreamConsumer(logger logger.Logger, client StreamClient, cfg StreamConsumerConfig) *StreamConsumer {
+	cfg.setDefaults()
+	return &StreamConsumer{
+		logger:   logger,
+		client:   client,
+		cfg:      cfg,
+		handlers: make(map[string]EventHandler),
+	}
+}
+
+// Handle registers h for events of eventType. Events without a handler are
+// acked and dropped.
+func (c *StreamConsumer) Handle(eventType string, h EventHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[eventType] = h
+}
+
+// Run consumes until ctx is cancelled. The entry being handled when shutdown
+// starts is finished (bounded by HandlerTimeout) before Run returns.
+func (c *StreamConsumer) Run(ctx context.Context) error {
+	if err := c.ensureGroup(ctx); err != nil {
+		return err
+	}
+
+	// Entries delivered to this consumer name before a restart are still in
+	// its pending list; finish those before reading new ones.
+	if err := c.drainOwnPending(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.cfg.ClaimInterval {
+			c.reclaim(ctx)
+			lastClaim = time.Now()
+		}
+
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.cfg.Group,
+			Consumer: c.cfg.Consumer,
+			Streams:  []string{c.cfg.Stream, ">"},
+			Count:    c.cfg.BatchSize,
+			Block:    c.cfg.Block,
+		}).Result()
+		if err != nil {
+			if err == redis.Nil || ctx.Err() != nil {
+				continue
+			}
+			c.logger.Error("Stream consumer: XREADGROUP failed", err)
+			sleepCtx(ctx, time.Second)
+			continue
+		}
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				c.process(ctx, msg)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *StreamConsumer) ensureGroup(ctx context.Context) error {
+	err := c.client.XGroupCreateMkStream(ctx, c.cfg.Stream, c.cfg.Group, c.cfg.StartID).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("create consumer group %s on %s: %w", c.cfg.Group, c.cfg.Stream, err)
+	}
+	return nil
+}
+
+func (c *StreamConsumer) drainOwnPending(ctx context.Context) error {
+	start := "0"
+	for ctx.Err() == nil {
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.cfg.Group,
+			Consumer: c.cfg.Consumer,
+			Streams:  []string{c.cfg.Stream, start},
+			Count:    c.cfg.BatchSize,
+		}).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msgs []redis.XMessage
+		for _, s := range streams {
+			msgs = append(msgs, s.Messages...)
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		c.processWithDeliveryCheck(ctx, msgs)
+		start = msgs[len(msgs)-1].ID
+	}
+	return nil
+}
+
+// reclaim takes over entries other consumers have left idle for MinIdle.
+func (c *StreamConsumer) reclaim(ctx context.Context) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		msgs, next, err := c.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.cfg.Stream,
+			Group:    c.cfg.Group,
+			Consumer: c.cfg.Consumer,
+			MinIdle:  c.cfg.MinIdle,
+			Start:    start,
+			Count:    c.cfg.BatchSize,
+		}).Result()
+		if err != nil {
+			if err != redis.Nil && ctx.Err() == nil {
+				c.logger.Error("Stream consumer: XAUTOCLAIM failed", err)
+			}
+			return
+		}
+		if len(msgs) > 0 {
+			c.logger.Debug("Stream consumer %s reclaimed %d entries", c.cfg.Consumer, len(msgs))
+			c.processWithDeliveryCheck(ctx, msgs)
+		}
+		if next == "" || next == "0-0" {
+			return
+		}
+		start = next
+	}
+}
+
+// processWithDeliveryCheck handles redelivered entries, parking the ones that
+// have already used up MaxDeliveries.
+func (c *StreamConsumer) processWithDeliveryCheck(ctx context.Context, msgs []redis.XMessage) {
+	counts := make(map[string]int64, len(msgs))
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.cfg.Stream,
+		Group:  c.cfg.Group,
+		Start:  msgs[0].ID,
+		End:    msgs[len(msgs)-1].ID,
+		Count:  int64(len(msgs)),
+	}).Result()
+	if err != nil {
+		c.logger.Warn("Stream consumer: XPENDING failed, delivery counts unknown", err)
+	}
+	for _, p := range pending {
+		counts[p.ID] = p.RetryCount
+	}
+
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			return
+		}
+		if n := counts[msg.ID]; n > c.cfg.MaxDeliveries {
+			c.park(ctx, msg, fmt.Sprintf("gave up after %d deliveries", n))
+			continue
+		}
+		c.process(ctx, msg)
+	}
+}
+
+func (c *StreamConsumer) process(ctx context.Context, msg redis.XMessage) {
+	raw, _ := msg.Values[repository.StreamFieldEvent].(string)
+	var event repository.CloudEvent
+	if raw == "" {
+		c.park(ctx, msg, "missing event field")
+		return
+	}
+	if err := json.Unmarshal([]byte(raw), &event); err != nil {
+		c.park(ctx, msg, "malformed event: "+err.Error())
+		return
+	}
+
+	c.mu.RLock()
+	h, ok := c.handlers[event.Type]
+	c.mu.RUnlock()
+	if !ok {
+		c.logger.Debug("Stream consumer: no handler for %s, acking %s", event.Type, msg.ID)
+		c.ack(ctx, msg.ID)
+		return
+	}
+
+	// Handlers run on a context that survives shutdown so the entry in hand
+	// is finished rather than abandoned halfway.
+	hctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.cfg.HandlerTimeout)
+	err := callHandler(hctx, h, event)
+	cancel()
+
+	var perm *permanentError
+	switch {
+	case err == nil:
+		c.ack(ctx, msg.ID)
+	case errors.As(err, &perm):
+		c.park(ctx, msg, err.Error())
+	default:
+		// Left pending; XAUTOCLAIM hands it out again after MinIdle.
+		c.logger.Warn(fmt.Sprintf("Stream consumer: handler for %s failed on %s", event.Type, msg.ID), err)
+	}
+}
+
+func callHandler(ctx context.Context, h EventHandler, event repository.CloudEvent) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("handler panic: %v", rec)
+		}
+	}()
+	return h(ctx, event)
+}
+
+// park copies a poison entry to DeadStream with the reason, then acks it so
+// it stops blocking the group.
+func (c *StreamConsumer) park(ctx context.Context, msg redis.XMessage, reason string) {
+	values := make(map[string]interface{}, len(msg.Values)+4)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values["origin_stream"] = c.cfg.Stream
+	values["origin_id"] = msg.ID
+	values["group"] = c.cfg.Group
+	values["error"] = reason
+
+	args := &redis.XAddArgs{Stream: c.cfg.DeadStream, Values: values}
+	c.cfg.DeadRetention.Limit(args, time.Now())
+	actx := context.WithoutCancel(ctx)
+	if err := c.client.XAdd(actx, args).Err(); err != nil {
+		// Keep it pending rather than lose it.
+		c.logger.Error(fmt.Sprintf("Stream consumer: failed to park %s", msg.ID), err)
+		return
+	}
+	c.logger.Warn(fmt.Sprintf("Stream consumer: parked %s on %s", msg.ID, c.cfg.DeadStream), errors.New(reason))
+	c.ack(actx, msg.ID)
+}
+
+func (c *StreamConsumer) ack(ctx context.Context, id string) {
+	if err := c.client.XAck(context.WithoutCancel(ctx), c.cfg.Stream, c.cfg.Group, id).Err(); err != nil {
+		c.logger.Error(fmt.Sprintf("Stream consumer: XACK %s failed", id), err)
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,238 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStream is an in-memory stand-in for one stream and one consumer group.
+type fakeStream struct {
+	mu       sync.Mutex
+	incoming []redis.XMessage
+	claimed  []redis.XMessage
+	pending  map[string]int64
+	acked    []string
+	parked   []map[string]interface{}
+	parkedTo []string
+	// parkedMaxLen is the MAXLEN each park was XADDed with.
+	parkedMaxLen []int64
+}
+
+func newFakeStream(msgs ...redis.XMessage) *fakeStream {
+	return &fakeStream{incoming: msgs, pending: make(map[string]int64)}
+}
+
+func (f *fakeStream) XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+	return redis.NewStatusResult("", errors.New("BUSYGROUP Consumer Group name already exists"))
+}
+
+func (f *fakeStream) XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if a.Streams[1] != ">" || len(f.incoming) == 0 {
+		return redis.NewXStreamSliceCmdResult(nil, redis.Nil)
+	}
+	msgs := f.incoming
+	f.incoming = nil
+	for _, m := range msgs {
+		f.pending[m.ID]++
+	}
+	return redis.NewXStreamSliceCmdResult([]redis.XStream{{Stream: a.Streams[0], Messages: msgs}}, nil)
+}
+
+func (f *fakeStream) XAutoClaim(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmd := redis.NewXAutoClaimCmd(ctx)
+	msgs := f.claimed
+	f.claimed = nil
+	for _, m := range msgs {
+		f.pending[m.ID]++
+	}
+	cmd.SetVal(msgs, "0-0")
+	return cmd
+}
+
+func (f *fakeStream) XPendingExt(ctx context.Context, a *redis.XPendingExtArgs) *redis.XPendingExtCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmd := redis.NewXPendingExtCmd(ctx)
+	var out []redis.XPendingExt
+	for id, n := range f.pending {
+		out = append(out, redis.XPendingExt{ID: id, RetryCount: n})
+	}
+	cmd.SetVal(out)
+	return cmd
+}
+
+func (f *fakeStream) XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		delete(f.pending, id)
+		f.acked = append(f.acked, id)
+	}
+	return redis.NewIntResult(int64(len(ids)), nil)
+}
+
+func (f *fakeStream) XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.parked = append(f.parked, a.Values.(map[string]interface{}))
+	f.parkedTo = append(f.parkedTo, a.Stream)
+	f.parkedMaxLen = append(f.parkedMaxLen, a.MaxLen)
+	return redis.NewStringResult("1-0", nil)
+}
+
+func (f *fakeStream) snapshot() (acked []string, parked []map[string]interface{}, pending map[string]int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending = make(map[string]int64, len(f.pending))
+	for k, v := range f.pending {
+		pending[k] = v
+	}
+	return append([]string(nil), f.acked...), append([]map[string]interface{}(nil), f.parked...), pending
+}
+
+func eventMessage(t *testing.T, id, eventType string) redis.XMessage {
+	b, err := json.Marshal(repository.CloudEvent{ID: id, Source: "/test", Type: eventType, Data: json.RawMessage(`{}`)})
+	assert.NoError(t, err)
+	return redis.XMessage{ID: id, Values: map[string]interface{}{
+		repository.StreamFieldType:  eventType,
+		repository.StreamFieldEvent: string(b),
+	}}
+}
+
+func newTestConsumer(client StreamClient) *StreamConsumer {
+	log := logger.New(logger.Config{
+		Level:      "error",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+	return NewStreamConsumer(log, client, StreamConsumerConfig{
+		Stream:        "todos",
+		Group:         "test",
+		Consumer:      "c1",
+		Block:         10 * time.Millisecond,
+		ClaimInterval: time.Hour,
+		MaxDeliveries: 2,
+	})
+}
+
+func runFor(c *StreamConsumer, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return c.Run(ctx)
+}
+
+func TestStreamConsumerDispatchesByTypeAndAcks(t *testing.T) {
+	stream := newFakeStream(
+		eventMessage(t, "1-0", repository.EventTodoCreated),
+		eventMessage(t, "2-0", repository.EventTodoDeleted),
+		eventMessage(t, "3-0", "file.uploaded"),
+	)
+	c := newTestConsumer(stream)
+
+	var mu sync.Mutex
+	var seen []string
+	record := func(ctx context.Context, ev repository.CloudEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, ev.Type+"#"+ev.ID)
+		return nil
+	}
+	c.Handle(repository.EventTodoCreated, record)
+	c.Handle(repository.EventTodoDeleted, record)
+
+	assert.NoError(t, runFor(c, 50*time.Millisecond))
+
+	acked, parked, pending := stream.snapshot()
+	assert.Equal(t, []string{"todo.created#1-0", "todo.deleted#2-0"}, seen)
+	assert.ElementsMatch(t, []string{"1-0", "2-0", "3-0"}, acked)
+	assert.Empty(t, parked)
+	assert.Empty(t, pending)
+}
+
+func TestStreamConsumerLeavesFailedEntriesPending(t *testing.T) {
+	stream := newFakeStream(eventMessage(t, "1-0", repository.EventTodoCreated))
+	c := newTestConsumer(stream)
+	c.Handle(repository.EventTodoCreated, func(ctx context.Context, ev repository.CloudEvent) error {
+		return errors.New("downstream unavailable")
+	})
+
+	assert.NoError(t, runFor(c, 50*time.Millisecond))
+
+	acked, parked, pending := stream.snapshot()
+	assert.Empty(t, acked)
+	assert.Empty(t, parked)
+	assert.Equal(t, int64(1), pending["1-0"])
+}
+
+func TestStreamConsumerParksPoisonMessages(t *testing.T) {
+	stream := newFakeStream(
+		redis.XMessage{ID: "1-0", Values: map[string]interface{}{repository.StreamFieldEvent: "not json"}},
+		eventMessage(t, "2-0", repository.EventTodoCreated),
+	)
+	c := newTestConsumer(stream)
+	c.Handle(repository.EventTodoCreated, func(ctx context.Context, ev repository.CloudEvent) error {
+		return Permanent(errors.New("unsupported schema"))
+	})
+
+	assert.NoError(t, runFor(c, 50*time.Millisecond))
+
+	acked, parked, pending := stream.snapshot()
+	assert.ElementsMatch(t, []string{"1-0", "2-0"}, acked)
+	assert.Len(t, parked, 2)
+	assert.Equal(t, "1-0", parked[0]["origin_id"])
+	assert.Contains(t, parked[0]["error"], "malformed event")
+	assert.Equal(t, "unsupported schema", parked[1]["error"])
+	assert.Empty(t, pending)
+}
+
+func TestStreamConsumerParksAfterMaxDeliveries(t *testing.T) {
+	stream := newFakeStream()
+	msg := eventMessage(t, "1-0", repository.EventTodoCreated)
+	stream.claimed = []redis.XMessage{msg}
+	stream.pending["1-0"] = 2 // the reclaim below makes it the third delivery
+
+	c := newTestConsumer(stream)
+	called := false
+	c.Handle(repository.EventTodoCreated, func(ctx context.Context, ev repository.CloudEvent) error {
+		called = true
+		return nil
+	})
+	c.cfg.ClaimInterval = time.Nanosecond
+	c.cfg.DeadRetention = repository.StreamRetention{MaxLen: 1000}
+
+	assert.NoError(t, runFor(c, 50*time.Millisecond))
+
+	acked, parked, _ := stream.snapshot()
+	assert.False(t, called)
+	assert.Equal(t, []string{"1-0"}, acked)
+	assert.Len(t, parked, 1)
+	assert.Equal(t, []string{"todos:dead"}, stream.parkedTo)
+	assert.Equal(t, []int64{1000}, stream.parkedMaxLen, "the dead stream is trimmed as it grows")
+}
+
+func TestStreamConsumerRecoversHandlerPanics(t *testing.T) {
+	stream := newFakeStream(eventMessage(t, "1-0", repository.EventTodoCreated))
+	c := newTestConsumer(stream)
+	c.Handle(repository.EventTodoCreated, func(ctx context.Context, ev repository.CloudEvent) error {
+		panic("boom")
+	})
+
+	assert.NoError(t, runFor(c, 50*time.Millisecond))
+
+	acked, _, pending := stream.snapshot()
+	assert.Empty(t, acked)
+	assert.Contains(t, pending, "1-0")
+}
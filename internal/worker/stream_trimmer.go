@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+// StreamTrimJob enforces stream retention on a timer. Publishers also trim
+// as they write, with XADD ... MAXLEN ~ or MINID ~, but only a running job
+// catches streams that have gone quiet, entries that age out between
+// publishes, and the age limit when both limits are set.
+type StreamTrimJob struct {
+	logger   logger.Logger
+	trimmer  repository.StreamTrimmer
+	streams  []string
+	policy   repository.StreamRetention
+	interval time.Duration
+}
+
+func NewStreamTrimJob(logger logger.Logger, trimmer repository.StreamTrimmer, cfg config.StreamConfig, streams ...string) *StreamTrimJob {
+	if len(streams) == 0 {
+		streams = []string{cfg.Name}
+	}
+	interval := cfg.TrimInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &StreamTrimJob{
+		logger:   logger,
+		trimmer:  trimmer,
+		streams:  streams,
+		policy:   repository.StreamRetention{MaxLen: cfg.MaxLen, MaxAge: cfg.MaxAge},
+		interval: interval,
+	}
+}
+
+func (j *StreamTrimJob) Run(ctx context.Context) {
+	if !j.policy.Enabled() {
+		j.logger.Info("Stream retention disabled; trim job not started")
+		return
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		j.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *StreamTrimJob) tick(ctx context.Context) {
+	for _, stream := range j.streams {
+		trimmed, err := j.trimmer.Trim(ctx, stream, j.policy)
+		if err != nil {
+			j.logger.Error(fmt.Sprintf("Stream retention: trim of %s failed", stream), err)
+			continue
+		}
+		length, err := j.trimmer.Len(ctx, stream)
+		if err != nil {
+			j.logger.Warn(fmt.Sprintf("Stream retention: XLEN %s failed", stream), err)
+			continue
+		}
+		if trimmed > 0 {
+			j.logger.Info("Stream retention: trimmed %d entries from %s, %d left", trimmed, stream, length)
+		} else {
+			j.logger.Debug("Stream retention: %s has %d entries, nothing to trim", stream, length)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/pkg/config"
+	"github.com/delaram/GoTastic/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTrimmer struct {
+	policies []repository.StreamRetention
+	streams  []string
+	err      error
+}
+
+func (f *fakeTrimmer) Trim(ctx context.Context, stream string, policy repository.StreamRetention) (int64, error) {
+	f.streams = append(f.streams, stream)
+	f.policies = append(f.policies, policy)
+	return 3, f.err
+}
+
+func (f *fakeTrimmer) Len(ctx context.Context, stream string) (int64, error) {
+	return 10, nil
+}
+
+func testLogger() logger.Logger {
+	return logger.New(logger.Config{
+		Level:      "error",
+		TimeFormat: time.RFC3339,
+		Pretty:     true,
+	})
+}
+
+func TestStreamTrimJobTrimsConfiguredStreams(t *testing.T) {
+	trimmer := &fakeTrimmer{}
+	job := NewStreamTrimJob(testLogger(), trimmer, config.StreamConfig{
+		Name:   "todos",
+		MaxLen: 500,
+		MaxAge: time.Hour,
+	}, "todos", "todos:dead")
+
+	job.tick(context.Background())
+
+	assert.Equal(t, []string{"todos", "todos:dead"}, trimmer.streams)
+	assert.Equal(t, repository.StreamRetention{MaxLen: 500, MaxAge: time.Hour}, trimmer.policies[0])
+}
+
+func TestStreamTrimJobKeepsGoingAfterError(t *testing.T) {
+	trimmer := &fakeTrimmer{err: errors.New("redis down")}
+	job := NewStreamTrimJob(testLogger(), trimmer, config.StreamConfig{Name: "todos", MaxLen: 1}, "a", "b")
+
+	job.tick(context.Background())
+
+	assert.Equal(t, []string{"a", "b"}, trimmer.streams)
+}
+
+func TestStreamTrimJobDisabledWithoutLimits(t *testing.T) {
+	trimmer := &fakeTrimmer{}
+	job := NewStreamTrimJob(testLogger(), trimmer, config.StreamConfig{Name: "todos"})
+
+	job.Run(context.Background())
+
+	assert.Empty(t, trimmer.streams)
+}
+
+func TestStreamRetentionMinID(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	policy := repository.StreamRetention{MaxAge: time.Minute}
+
+	assert.Equal(t, "1699999940000-0", policy.MinID(now))
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/delaram/GoTastic/pkg/logger"
+)
+
+// ThumbnailGenerator is the part of usecase.ThumbnailUseCase the handler
+// drives.
+type ThumbnailGenerator interface {
+	Generate(ctx context.Context, fileID string) error
+}
+
+// NewThumbnailHandler handles file.uploaded and file.scanned events by
+// generating the image's thumbnails. Images that cannot be thumbnailed are
+// logged and acked: they are the upload's problem, not the stream's. Files
+// still waiting for their malware scan are acked too; their file.scanned
+// event brings them back once they are released.
+func NewThumbnailHandler(logger logger.Logger, generator ThumbnailGenerator) EventHandler {
+	return func(ctx context.Context, event repository.CloudEvent) error {
+		var data repository.FileUploaded
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return Permanent(fmt.Errorf("malformed %s event: %w", event.Type, err))
+		}
+		if data.FileID == "" {
+			return Permanent(fmt.Errorf("%s event %s has no file_id", event.Type, event.ID))
+		}
+
+		err := generator.Generate(ctx, data.FileID)
+		if errors.Is(err, usecase.ErrThumbnailUnsupported) {
+			logger.Warn("Thumbnails: skipping file "+data.FileID, err)
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/delaram/GoTastic/internal/repository"
+	"github.com/delaram/GoTastic/internal/usecase"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeGenerator struct {
+	ids []string
+	err error
+}
+
+func (f *fakeGenerator) Generate(ctx context.Context, fileID string) error {
+	f.ids = append(f.ids, fileID)
+	return f.err
+}
+
+func TestThumbnailHandlerGeneratesUploadedFile(t *testing.T) {
+	generator := &fakeGenerator{}
+	handle := NewThumbnailHandler(testLogger(), generator)
+
+	err := handle(context.Background(), repository.CloudEvent{
+		ID:   "1",
+		Type: repository.EventFileUploaded,
+		Data: []byte(`{"file_id":"photo.png","content_type":"image/png"}`),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"photo.png"}, generator.ids)
+}
+
+func TestThumbnailHandlerErrors(t *testing.T) {
+	var perm *permanentError
+	boom := errors.New("storage down")
+
+	err := NewThumbnailHandler(testLogger(), &fakeGenerator{})(context.Background(), repository.CloudEvent{Type: repository.EventFileUploaded, Data: []byte(`{`)})
+	assert.True(t, errors.As(err, &perm))
+
+	err = NewThumbnailHandler(testLogger(), &fakeGenerator{})(context.Background(), repository.CloudEvent{Type: repository.EventFileUploaded, Data: []byte(`{}`)})
+	assert.True(t, errors.As(err, &perm))
+
+	unsupported := &fakeGenerator{err: fmt.Errorf("%w: bad header", usecase.ErrThumbnailUnsupported)}
+	err = NewThumbnailHandler(testLogger(), unsupported)(context.Background(), repository.CloudEvent{Data: []byte(`{"file_id":"a.png"}`)})
+	assert.NoError(t, err)
+
+	err = NewThumbnailHandler(testLogger(), &fakeGenerator{err: boom})(context.Background(), repository.CloudEvent{Data: []byte(`{"file_id":"a.png"}`)})
+	assert.ErrorIs(t, err, boom)
+	assert.False(t, errors.As(err, &perm))
+}
@@ -0,0 +1,4 @@
+ALTER TABLE TodoItem
+    DROP INDEX idx_status,
+    DROP COLUMN CompletedAt,
+    DROP COLUMN Status;
@@ -0,0 +1,4 @@
+ALTER TABLE TodoItem
+    ADD COLUMN Status      VARCHAR(32) NOT NULL DEFAULT 'open' AFTER FileID,
+    ADD COLUMN CompletedAt DATETIME    NULL AFTER Status,
+    ADD INDEX idx_status (Status);
@@ -0,0 +1,7 @@
+UPDATE Outbox SET Status = 'pending' WHERE Status = 'processing';
+
+ALTER TABLE Outbox
+    DROP INDEX idx_outbox_lease,
+    DROP COLUMN LockedUntil,
+    DROP COLUMN LockedBy,
+    MODIFY COLUMN Status ENUM('pending','published','failed') NOT NULL DEFAULT 'pending';
@@ -0,0 +1,5 @@
+ALTER TABLE Outbox
+    MODIFY COLUMN Status ENUM('pending','processing','published','failed') NOT NULL DEFAULT 'pending',
+    ADD COLUMN LockedBy    VARCHAR(128) NULL AFTER AvailableAt,
+    ADD COLUMN LockedUntil DATETIME(6)  NULL AFTER LockedBy,
+    ADD INDEX idx_outbox_lease (Status, LockedUntil, ID);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS outbox_attempt;
+
+UPDATE Outbox SET Status = 'failed' WHERE Status = 'dead';
+
+ALTER TABLE Outbox
+    DROP INDEX idx_outbox_dead,
+    DROP COLUMN DeadAt,
+    DROP COLUMN LastError,
+    MODIFY COLUMN Status ENUM('pending','processing','published','failed') NOT NULL DEFAULT 'pending';
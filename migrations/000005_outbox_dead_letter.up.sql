@@ -0,0 +1,18 @@
+ALTER TABLE Outbox
+    MODIFY COLUMN Status ENUM('pending','processing','published','failed','dead') NOT NULL DEFAULT 'pending',
+    ADD COLUMN LastError TEXT        NULL AFTER LockedUntil,
+    ADD COLUMN DeadAt    DATETIME(6) NULL AFTER LastError,
+    ADD INDEX idx_outbox_dead (Status, DeadAt, ID);
+
+CREATE TABLE IF NOT EXISTS outbox_attempt (
+    ID       BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    OutboxID BIGINT UNSIGNED NOT NULL,
+    Attempt  INT UNSIGNED    NOT NULL DEFAULT 0,
+    Worker   VARCHAR(128)    NOT NULL DEFAULT '',
+    Error    TEXT            NOT NULL,
+    FailedAt DATETIME(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (ID),
+    KEY idx_outbox_attempt_outbox (OutboxID, Attempt)
+    ) ENGINE=InnoDB
+    DEFAULT CHARSET = utf8mb4
+    COLLATE = utf8mb4_unicode_ci;
@@ -0,0 +1,2 @@
+ALTER TABLE Outbox
+    DROP COLUMN CreatedAt;
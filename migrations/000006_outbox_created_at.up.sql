@@ -0,0 +1,4 @@
+ALTER TABLE Outbox
+    ADD COLUMN CreatedAt DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) AFTER AvailableAt;
+
+UPDATE Outbox SET CreatedAt = AvailableAt;
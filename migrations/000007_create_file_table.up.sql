@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS File (
+    ID          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    FileID      VARCHAR(255)    NOT NULL,
+    Filename    VARCHAR(255)    NOT NULL,
+    ContentType VARCHAR(127)    NOT NULL,
+    Size        BIGINT          NOT NULL DEFAULT 0,
+    SHA256      CHAR(64)        NOT NULL,
+    UploadedBy  VARCHAR(128)    NULL,
+    CreatedAt   DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (ID),
+    UNIQUE KEY idx_file_file_id (FileID),
+    KEY idx_file_content_type (ContentType),
+    KEY idx_file_sha256 (SHA256),
+    KEY idx_file_uploaded_by (UploadedBy, CreatedAt),
+    KEY idx_file_created_at (CreatedAt)
+    ) ENGINE=InnoDB
+    DEFAULT CHARSET = utf8mb4
+    COLLATE = utf8mb4_unicode_ci;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS TodoAttachment (
+    ID        BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    TodoUUID  VARCHAR(36)     NOT NULL,
+    FileID    VARCHAR(255)    NOT NULL,
+    Position  INT             NOT NULL DEFAULT 0,
+    CreatedAt DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (ID),
+    UNIQUE KEY idx_todo_attachment_todo_file (TodoUUID, FileID),
+    KEY idx_todo_attachment_position (TodoUUID, Position),
+    KEY idx_todo_attachment_file (FileID),
+    CONSTRAINT fk_todo_attachment_todo FOREIGN KEY (TodoUUID)
+        REFERENCES TodoItem (UUID) ON DELETE CASCADE
+    ) ENGINE=InnoDB
+    DEFAULT CHARSET = utf8mb4
+    COLLATE = utf8mb4_unicode_ci;
+
+-- Every todo's single FileID becomes its first attachment.
+INSERT INTO TodoAttachment (TodoUUID, FileID, Position, CreatedAt)
+SELECT UUID, FileID, 0, UpdatedAt
+FROM TodoItem
+WHERE FileID IS NOT NULL AND FileID <> '';
@@ -0,0 +1,2 @@
+ALTER TABLE File
+    DROP COLUMN RefCount;
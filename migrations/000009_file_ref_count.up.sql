@@ -0,0 +1,4 @@
+-- Identical uploads share one stored file; RefCount is how many uploads
+-- resolved to it, so deletes only remove the content with the last one.
+ALTER TABLE File
+    ADD COLUMN RefCount INT NOT NULL DEFAULT 1 AFTER SHA256;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS FileThumbnail (
+    ID          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    FileID      VARCHAR(255)    NOT NULL,
+    Size        INT             NOT NULL,
+    ThumbnailID VARCHAR(255)    NOT NULL,
+    ContentType VARCHAR(127)    NOT NULL,
+    Width       INT             NOT NULL,
+    Height      INT             NOT NULL,
+    CreatedAt   DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (ID),
+    UNIQUE KEY idx_file_thumbnail_file_size (FileID, Size),
+    UNIQUE KEY idx_file_thumbnail_thumbnail_id (ThumbnailID),
+    -- Thumbnails go with their file's metadata; the stored copies are then
+    -- unreferenced and left to the file GC.
+    CONSTRAINT fk_file_thumbnail_file FOREIGN KEY (FileID)
+        REFERENCES File (FileID) ON DELETE CASCADE
+    ) ENGINE=InnoDB
+    DEFAULT CHARSET = utf8mb4
+    COLLATE = utf8mb4_unicode_ci;
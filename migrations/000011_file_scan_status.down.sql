@@ -0,0 +1,5 @@
+ALTER TABLE File
+    DROP INDEX idx_file_scan_status,
+    DROP COLUMN ScannedAt,
+    DROP COLUMN ScanSignature,
+    DROP COLUMN ScanStatus;
@@ -0,0 +1,7 @@
+-- Uploads are quarantined as pending until a malware scanner passes them.
+-- Files stored before scanning keep an empty status and stay available.
+ALTER TABLE File
+    ADD COLUMN ScanStatus VARCHAR(16) NOT NULL DEFAULT '' AFTER CreatedAt,
+    ADD COLUMN ScanSignature VARCHAR(255) NULL AFTER ScanStatus,
+    ADD COLUMN ScannedAt DATETIME NULL AFTER ScanSignature,
+    ADD INDEX idx_file_scan_status (ScanStatus);
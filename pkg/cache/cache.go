@@ -0,0 +1,339 @@
+// Package cache is a typed cache on top of a plain byte store such as the
+// Redis CacheRepository. Values are encoded with a pluggable Codec under
+// versioned keys, and hits, misses and errors are counted per cache and
+// totalled per namespace in expvar.
+// Concurrent misses for one key are coalesced into a single load, and with
+// Options.StaleTTL expired entries keep being served while they refresh.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// RefreshTimeout bounds a background stale-while-revalidate load, which
+// outlives the request that triggered it.
+const RefreshTimeout = 30 * time.Second
+
+var timeNow = time.Now
+
+// Store is the byte-level storage a Cache sits on. Get returns nil, nil when
+// the key does not exist.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// TagStore is a Store that can group keys under tags, so every entry derived
+// from the same data can be evicted at once however many keys it spans.
+type TagStore interface {
+	Store
+	// Tag records key under every tag. Tag sets expire after expiration
+	// (refreshed on each call) so they never outlive their entries for long.
+	Tag(ctx context.Context, key string, expiration time.Duration, tags ...string) error
+	// InvalidateTags deletes every key recorded under tags, then the tags.
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+type Options struct {
+	// Namespace prefixes every key, e.g. "todo".
+	Namespace string
+	// Version is part of every key. Bump it when the cached type changes
+	// shape so old entries are ignored instead of decoded wrongly.
+	Version int
+	// Codec defaults to JSON.
+	Codec Codec
+	// TTL applies to every Set; zero means entries never expire.
+	TTL time.Duration
+	// StaleTTL keeps entries in the store for this long after TTL. GetOrLoad
+	// still returns such a stale entry, and reloads it in the background.
+	// Stale entries carry their freshness in the stored bytes, so caches with
+	// and without StaleTTL use different keys. Ignored when TTL is zero.
+	StaleTTL time.Duration
+}
+
+// Stats are the counters of one Cache. Errors counts store failures and
+// entries that could not be decoded; both are also counted as misses. Stale
+// counts stale entries GetOrLoad returned, which are also counted as hits.
+type Stats struct {
+	Hits   int64
+	Misses int64
+	Errors int64
+	Stale  int64
+}
+
+// Cache stores values of type T. The zero value is not usable; use New.
+type Cache[T any] struct {
+	store   Store
+	prefix  string
+	codec   Codec
+	ttl     time.Duration
+	stale   time.Duration
+	metrics *counters
+
+	loads      singleflight.Group
+	refreshing sync.Map // ids with a background refresh in flight
+}
+
+func New[T any](store Store, opts Options) *Cache[T] {
+	if opts.Codec == nil {
+		opts.Codec = JSON
+	}
+	if opts.Version <= 0 {
+		opts.Version = 1
+	}
+	format := opts.Codec.Name()
+	if opts.TTL <= 0 || opts.StaleTTL < 0 {
+		opts.StaleTTL = 0
+	}
+	if opts.StaleTTL > 0 {
+		format += "+swr"
+	}
+	return &Cache[T]{
+		store:   store,
+		prefix:  fmt.Sprintf("%s:v%d:%s:", opts.Namespace, opts.Version, format),
+		codec:   opts.Codec,
+		ttl:     opts.TTL,
+		stale:   opts.StaleTTL,
+		metrics: newCounters(opts.Namespace),
+	}
+}
+
+// Key is the store key id is cached under, e.g. "todo:v1:json:<uuid>".
+func (c *Cache[T]) Key(id string) string {
+	return c.prefix + id
+}
+
+// Get returns the cached value for id and whether there was one. A non-nil
+// error means the store failed or the entry was unreadable; it is reported
+// as a miss so callers can fall back to the source of truth. Stale entries
+// are misses too; only GetOrLoad serves them.
+func (c *Cache[T]) Get(ctx context.Context, id string) (T, bool, error) {
+	v, fresh, ok, err := c.lookup(ctx, id)
+	if !ok || !fresh {
+		c.metrics.misses.Add(1)
+		var zero T
+		return zero, false, err
+	}
+	c.metrics.hits.Add(1)
+	return v, true, nil
+}
+
+// lookup reads and decodes id. fresh is false for an entry past its TTL that
+// is still within StaleTTL.
+func (c *Cache[T]) lookup(ctx context.Context, id string) (v T, fresh, ok bool, err error) {
+	data, err := c.store.Get(ctx, c.Key(id))
+	if err != nil {
+		c.metrics.errors.Add(1)
+		return v, false, false, err
+	}
+	if data == nil {
+		return v, false, false, nil
+	}
+	fresh = true
+	if c.stale > 0 {
+		// Stale-while-revalidate entries start with their fresh-until time.
+		if len(data) < 8 {
+			c.metrics.errors.Add(1)
+			return v, false, false, fmt.Errorf("decode %s: truncated entry", c.Key(id))
+		}
+		fresh = timeNow().UnixNano() < int64(binary.BigEndian.Uint64(data))
+		data = data[8:]
+	}
+	if err := c.codec.Unmarshal(data, &v); err != nil {
+		c.metrics.errors.Add(1)
+		var zero T
+		return zero, false, false, fmt.Errorf("decode %s: %w", c.Key(id), err)
+	}
+	return v, fresh, true, nil
+}
+
+func (c *Cache[T]) Set(ctx context.Context, id string, v T) error {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		c.metrics.errors.Add(1)
+		return fmt.Errorf("encode %s: %w", c.Key(id), err)
+	}
+	if c.stale > 0 {
+		entry := make([]byte, 8, 8+len(data))
+		binary.BigEndian.PutUint64(entry, uint64(timeNow().Add(c.ttl).UnixNano()))
+		data = append(entry, data...)
+	}
+	if err := c.store.Set(ctx, c.Key(id), data, c.expiration()); err != nil {
+		c.metrics.errors.Add(1)
+		return err
+	}
+	return nil
+}
+
+// expiration is how long the store keeps an entry: its TTL plus the window
+// in which it may be served stale.
+func (c *Cache[T]) expiration() time.Duration {
+	return c.ttl + c.stale
+}
+
+// SetTagged stores v like Set and records it under tags, so InvalidateTags
+// evicts it. The store must implement TagStore.
+func (c *Cache[T]) SetTagged(ctx context.Context, id string, v T, tags ...string) error {
+	ts, ok := c.store.(TagStore)
+	if !ok {
+		return errors.New("cache: store does not support tags")
+	}
+	if err := c.Set(ctx, id, v); err != nil {
+		return err
+	}
+	if err := ts.Tag(ctx, c.Key(id), c.expiration(), tags...); err != nil {
+		c.metrics.errors.Add(1)
+		// An untagged entry would survive invalidation; drop it instead.
+		_ = c.store.Delete(ctx, c.Key(id))
+		return err
+	}
+	return nil
+}
+
+func (c *Cache[T]) Delete(ctx context.Context, id string) error {
+	if err := c.store.Delete(ctx, c.Key(id)); err != nil {
+		c.metrics.errors.Add(1)
+		return err
+	}
+	return nil
+}
+
+// GetOrLoad returns the cached value for id, or calls load and caches its
+// result, recorded under tags if any are given. Concurrent misses for the
+// same id share one load call. A stale entry is returned as is while a single
+// background load replaces it. Cache failures never fail the call: they are
+// counted in Stats and load's result is returned as usual. Errors from load
+// are not cached.
+func (c *Cache[T]) GetOrLoad(ctx context.Context, id string, load func(ctx context.Context) (T, error), tags ...string) (T, error) {
+	v, fresh, ok, _ := c.lookup(ctx, id)
+	if ok {
+		c.metrics.hits.Add(1)
+		if !fresh {
+			c.metrics.stale.Add(1)
+			c.revalidate(ctx, id, load, tags)
+		}
+		return v, nil
+	}
+	c.metrics.misses.Add(1)
+
+	res, err, _ := c.loads.Do(id, func() (interface{}, error) {
+		return c.loadAndStore(ctx, id, load, tags)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	v, _ = res.(T)
+	return v, nil
+}
+
+// revalidate reloads a stale id in the background, at most once at a time.
+// The load is detached from ctx's cancellation, since the request that
+// noticed the stale entry is usually done before the reload is.
+func (c *Cache[T]) revalidate(ctx context.Context, id string, load func(ctx context.Context) (T, error), tags []string) {
+	if _, busy := c.refreshing.LoadOrStore(id, struct{}{}); busy {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), RefreshTimeout)
+	go func() {
+		defer cancel()
+		defer c.refreshing.Delete(id)
+		_, _, _ = c.loads.Do(id, func() (interface{}, error) {
+			return c.loadAndStore(ctx, id, load, tags)
+		})
+	}()
+}
+
+func (c *Cache[T]) loadAndStore(ctx context.Context, id string, load func(ctx context.Context) (T, error), tags []string) (T, error) {
+	v, err := load(ctx)
+	if err != nil {
+		return v, err
+	}
+	if len(tags) > 0 {
+		_ = c.SetTagged(ctx, id, v, tags...)
+	} else {
+		_ = c.Set(ctx, id, v)
+	}
+	return v, nil
+}
+
+// Stats reports this cache's own counters. Caches sharing a Namespace add up
+// to its totals in expvar.
+func (c *Cache[T]) Stats() Stats {
+	return Stats{
+		Hits:   c.metrics.hits.Value(),
+		Misses: c.metrics.misses.Value(),
+		Errors: c.metrics.errors.Value(),
+		Stale:  c.metrics.stale.Value(),
+	}
+}
+
+// metrics holds every namespace's totals, published through expvar as
+// cache.<namespace>.hits etc.
+var metrics = expvar.NewMap("cache")
+
+// counters are one cache's counts. Each count is also added to its
+// namespace's total in metrics.
+type counters struct {
+	hits, misses, errors, stale counter
+}
+
+type counter struct {
+	own   atomic.Int64
+	total *expvar.Int
+}
+
+func (c *counter) Add(n int64) {
+	c.own.Add(n)
+	c.total.Add(n)
+}
+
+func (c *counter) Value() int64 {
+	return c.own.Load()
+}
+
+func newCounters(namespace string) *counters {
+	c := &counters{}
+	c.hits.total = total(namespace + ".hits")
+	c.misses.total = total(namespace + ".misses")
+	c.errors.total = total(namespace + ".errors")
+	c.stale.total = total(namespace + ".stale")
+	return c
+}
+
+var totalsMu sync.Mutex
+
+func total(key string) *expvar.Int {
+	totalsMu.Lock()
+	defer totalsMu.Unlock()
+	if v, ok := metrics.Get(key).(*expvar.Int); ok {
+		return v
+	}
+	v := new(expvar.Int)
+	metrics.Set(key, v)
+	return v
+}
+
+// HashID derives a short, stable id from a query description such as a
+// filter, sort order and page, so equal queries share one entry.
+func HashID(query interface{}) (string, error) {
+	data, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16]), nil
+}
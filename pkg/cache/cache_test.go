@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memStore struct {
+	entries map[string][]byte
+	ttls    map[string]time.Duration
+	err     error
+}
+
+func newMemStore() *memStore {
+	return &memStore{entries: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (s *memStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.entries[key], nil
+}
+
+func (s *memStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.entries[key] = value
+	s.ttls[key] = expiration
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	delete(s.entries, key)
+	return nil
+}
+
+// tagStore adds tag bookkeeping to memStore.
+type tagStore struct {
+	*memStore
+	tags map[string][]string
+}
+
+func (s *tagStore) Tag(ctx context.Context, key string, expiration time.Duration, tags ...string) error {
+	for _, tag := range tags {
+		s.tags[tag] = append(s.tags[tag], key)
+	}
+	return nil
+}
+
+func (s *tagStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		for _, key := range s.tags[tag] {
+			delete(s.entries, key)
+		}
+		delete(s.tags, tag)
+	}
+	return nil
+}
+
+type item struct {
+	Name    string
+	Due     *time.Time
+	Tags    []string
+	Created time.Time
+}
+
+func TestCacheRoundTripsWithEveryCodec(t *testing.T) {
+	due := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := &item{Name: "a", Due: &due, Tags: []string{"x", "y"}, Created: due.Add(-time.Hour)}
+
+	for _, codec := range []Codec{JSON, Msgpack} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			c := New[*item](newMemStore(), Options{Namespace: "rt-" + codec.Name(), Codec: codec})
+
+			assert.NoError(t, c.Set(context.Background(), "1", want))
+			got, ok, err := c.Get(context.Background(), "1")
+
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, want.Name, got.Name)
+			assert.True(t, want.Due.Equal(*got.Due))
+			assert.True(t, want.Created.Equal(got.Created))
+			assert.Equal(t, want.Tags, got.Tags)
+		})
+	}
+}
+
+func TestCacheKeysAreVersioned(t *testing.T) {
+	store := newMemStore()
+	v1 := New[string](store, Options{Namespace: "ver", Version: 1, TTL: time.Minute})
+	v2 := New[string](store, Options{Namespace: "ver", Version: 2, Codec: Msgpack})
+
+	assert.Equal(t, "ver:v1:json:42", v1.Key("42"))
+	assert.Equal(t, "ver:v2:msgpack:42", v2.Key("42"))
+
+	assert.NoError(t, v1.Set(context.Background(), "42", "old"))
+	assert.Equal(t, time.Minute, store.ttls["ver:v1:json:42"])
+
+	_, ok, err := v2.Get(context.Background(), "42")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCacheCountsHitsMissesAndErrors(t *testing.T) {
+	store := newMemStore()
+	c := New[int](store, Options{Namespace: "stats"})
+	other := New[int](store, Options{Namespace: "stats"})
+	ctx := context.Background()
+	hitsBefore := metrics.Get("stats.hits").(*expvar.Int).Value()
+
+	_, _, _ = c.Get(ctx, "n")
+	assert.NoError(t, c.Set(ctx, "n", 7))
+	_, _, _ = c.Get(ctx, "n")
+	store.entries[c.Key("bad")] = []byte("{not json")
+	_, ok, err := c.Get(ctx, "bad")
+
+	assert.False(t, ok)
+	assert.Error(t, err)
+	_, _, _ = other.Get(ctx, "n")
+
+	assert.Equal(t, Stats{Hits: 1, Misses: 2, Errors: 1}, c.Stats())
+	assert.Equal(t, Stats{Hits: 1}, other.Stats(), "each cache counts its own calls")
+	assert.Equal(t, hitsBefore+2, metrics.Get("stats.hits").(*expvar.Int).Value(), "expvar totals the namespace")
+}
+
+func TestGetOrLoadFallsBackWhenStoreFails(t *testing.T) {
+	store := newMemStore()
+	c := New[string](store, Options{Namespace: "load"})
+	ctx := context.Background()
+	calls := 0
+	load := func(ctx context.Context) (string, error) {
+		calls++
+		return "fresh", nil
+	}
+
+	v, err := c.GetOrLoad(ctx, "k", load)
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", v)
+	v, err = c.GetOrLoad(ctx, "k", load)
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", v)
+	assert.Equal(t, 1, calls)
+
+	store.err = errors.New("redis down")
+	v, err = c.GetOrLoad(ctx, "k", load)
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", v)
+	assert.Equal(t, 2, calls)
+
+	_, err = c.GetOrLoad(ctx, "other", func(ctx context.Context) (string, error) {
+		return "", errors.New("not found")
+	})
+	assert.EqualError(t, err, "not found")
+}
+
+func TestCodecByName(t *testing.T) {
+	for name, want := range map[string]Codec{"": JSON, "json": JSON, "msgpack": Msgpack} {
+		got, err := CodecByName(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := CodecByName("gob")
+	assert.Error(t, err)
+}
+
+func TestSetTaggedEntriesAreEvictedTogether(t *testing.T) {
+	store := &tagStore{memStore: newMemStore(), tags: map[string][]string{}}
+	pages := New[[]string](store, Options{Namespace: "tagged"})
+	ctx := context.Background()
+
+	first, err := HashID(map[string]interface{}{"q": "milk", "offset": 0})
+	assert.NoError(t, err)
+	same, _ := HashID(map[string]interface{}{"offset": 0, "q": "milk"})
+	second, _ := HashID(map[string]interface{}{"q": "milk", "offset": 10})
+	assert.Equal(t, first, same)
+	assert.NotEqual(t, first, second)
+
+	assert.NoError(t, pages.SetTagged(ctx, first, []string{"a"}, "todos"))
+	assert.NoError(t, pages.SetTagged(ctx, second, []string{"b"}, "todos"))
+	assert.NoError(t, pages.Set(ctx, "untagged", []string{"c"}))
+
+	assert.NoError(t, store.InvalidateTags(ctx, "todos"))
+	_, ok, _ := pages.Get(ctx, first)
+	assert.False(t, ok)
+	_, ok, _ = pages.Get(ctx, second)
+	assert.False(t, ok)
+	_, ok, _ = pages.Get(ctx, "untagged")
+	assert.True(t, ok)
+
+	plain := New[string](newMemStore(), Options{Namespace: "tagged"})
+	assert.Error(t, plain.SetTagged(ctx, "x", "y", "todos"))
+}
+
+func TestGetOrLoadCoalescesConcurrentMisses(t *testing.T) {
+	c := New[string](&lockedStore{memStore: newMemStore()}, Options{Namespace: "coalesce"})
+	release := make(chan struct{})
+	var calls int32
+	load := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "v", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad(context.Background(), "k", load)
+			assert.NoError(t, err)
+			assert.Equal(t, "v", v)
+		}()
+	}
+	// Let every caller reach the in-flight load before it completes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestGetOrLoadServesStaleWhileRevalidating(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	store := &lockedStore{memStore: newMemStore()}
+	c := New[int](store, Options{Namespace: "swr", TTL: time.Minute, StaleTTL: time.Hour})
+	ctx := context.Background()
+	assert.Equal(t, "swr:v1:json+swr:n", c.Key("n"))
+
+	assert.NoError(t, c.Set(ctx, "n", 1))
+	assert.Equal(t, time.Minute+time.Hour, store.ttls[c.Key("n")])
+
+	refreshed := make(chan struct{})
+	load := func(ctx context.Context) (int, error) {
+		defer close(refreshed)
+		return 2, nil
+	}
+	v, err := c.GetOrLoad(ctx, "n", load)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v, "fresh entries are returned without loading")
+
+	now = now.Add(2 * time.Minute)
+	_, ok, _ := c.Get(ctx, "n")
+	assert.False(t, ok, "Get treats stale entries as misses")
+	v, err = c.GetOrLoad(ctx, "n", load)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v, "the stale value is served at once")
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("stale entry was not reloaded")
+	}
+	assert.Eventually(t, func() bool {
+		v, ok, _ := c.Get(ctx, "n")
+		return ok && v == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), c.Stats().Stale)
+}
+
+// lockedStore makes memStore safe for the concurrent tests.
+type lockedStore struct {
+	mu sync.Mutex
+	*memStore
+}
+
+func (s *lockedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memStore.Get(ctx, key)
+}
+
+func (s *lockedStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memStore.Set(ctx, key, value, expiration)
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec turns cached values into bytes and back. The codec name is part of
+// every key, so switching codecs never decodes entries written by the other.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string                               { return "msgpack" }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+var (
+	// JSON is the default codec: readable with redis-cli, a little larger.
+	JSON Codec = jsonCodec{}
+	// Msgpack is smaller and faster to decode than JSON.
+	Msgpack Codec = msgpackCodec{}
+)
+
+// CodecByName resolves a codec from configuration. An empty name means JSON.
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case "", "json":
+		return JSON, nil
+	case "msgpack":
+		return Msgpack, nil
+	default:
+		return nil, fmt.Errorf("unknown cache codec %q", name)
+	}
+}
@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+// Invalidation tells other instances which L1 entries to drop. All asks for
+// the whole L1 to be dropped, e.g. after a subscriber missed messages.
+type Invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	All    bool     `json:"all,omitempty"`
+}
+
+// InvalidationBus broadcasts invalidations between instances, e.g. over
+// Redis pub/sub. Subscribe blocks, calling handle for every invalidation
+// received, until ctx is done.
+type InvalidationBus interface {
+	Publish(ctx context.Context, inv Invalidation) error
+	Subscribe(ctx context.Context, handle func(Invalidation)) error
+}
+
+type TieredOptions struct {
+	// Size is the maximum number of L1 entries. Defaults to 10000.
+	Size int
+	// TTL caps how long an entry stays in L1, and so how stale an instance
+	// can be if it misses a broadcast. Defaults to 30s.
+	TTL time.Duration
+	// Bus is optional; without it only this instance's own writes keep its
+	// L1 in sync.
+	Bus InvalidationBus
+}
+
+// Tiered is a TagStore with a bounded in-process LRU (L1) in front of a
+// shared store such as Redis (L2). Reads are served from L1 when possible.
+// Writes, deletes and tag invalidations go to both levels and are broadcast
+// on the bus so other instances drop their L1 copies; Run applies theirs.
+type Tiered struct {
+	l2      TagStore
+	ttl     time.Duration
+	bus     InvalidationBus
+	origin  string
+	metrics *counters
+
+	mu   sync.Mutex
+	l1   *simplelru.LRU[string, *l1Entry]
+	tags map[string]map[string]struct{} // tag -> L1 keys
+	// untagged holds keys read through from L2, whose tags this instance
+	// never saw; any tag invalidation drops them.
+	untagged map[string]struct{}
+	// gen is bumped by every invalidation, so a read that raced with one
+	// does not put the value it fetched from L2 into L1.
+	gen uint64
+}
+
+type l1Entry struct {
+	data    []byte
+	expires time.Time
+	tags    []string
+	// readThrough is set for entries filled from L2 rather than written here.
+	readThrough bool
+}
+
+func NewTiered(l2 TagStore, opts TieredOptions) (*Tiered, error) {
+	if opts.Size <= 0 {
+		opts.Size = 10000
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 30 * time.Second
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	t := &Tiered{
+		l2:       l2,
+		ttl:      opts.TTL,
+		bus:      opts.Bus,
+		origin:   hex.EncodeToString(id[:]),
+		metrics:  newCounters("l1"),
+		tags:     map[string]map[string]struct{}{},
+		untagged: map[string]struct{}{},
+	}
+	l1, err := simplelru.NewLRU[string, *l1Entry](opts.Size, t.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	t.l1 = l1
+	return t, nil
+}
+
+// Run applies invalidations broadcast by other instances until ctx is done.
+func (t *Tiered) Run(ctx context.Context) error {
+	if t.bus == nil {
+		return errors.New("cache: tiered store has no invalidation bus")
+	}
+	return t.bus.Subscribe(ctx, t.apply)
+}
+
+// Stats reports this store's L1 hits and misses, and the invalidations it
+// failed to broadcast as errors. Every Tiered adds to the "l1" totals in
+// expvar.
+func (t *Tiered) Stats() Stats {
+	return Stats{
+		Hits:   t.metrics.hits.Value(),
+		Misses: t.metrics.misses.Value(),
+		Errors: t.metrics.errors.Value(),
+	}
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, error) {
+	t.mu.Lock()
+	e, ok := t.l1.Get(key)
+	gen := t.gen
+	t.mu.Unlock()
+	if ok && timeNow().Before(e.expires) {
+		t.metrics.hits.Add(1)
+		return e.data, nil
+	}
+	t.metrics.misses.Add(1)
+
+	data, err := t.l2.Get(ctx, key)
+	if err != nil || data == nil {
+		return data, err
+	}
+	t.mu.Lock()
+	if t.gen == gen {
+		t.fill(key, data, t.ttl, true)
+	}
+	t.mu.Unlock()
+	return data, nil
+}
+
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	if err := t.l2.Set(ctx, key, value, expiration); err != nil {
+		return err
+	}
+	ttl := t.ttl
+	if expiration > 0 && expiration < ttl {
+		ttl = expiration
+	}
+	t.mu.Lock()
+	t.gen++
+	t.fill(key, value, ttl, false)
+	t.mu.Unlock()
+	t.broadcast(ctx, Invalidation{Keys: []string{key}})
+	return nil
+}
+
+func (t *Tiered) Delete(ctx context.Context, key string) error {
+	if err := t.l2.Delete(ctx, key); err != nil {
+		return err
+	}
+	t.drop(Invalidation{Keys: []string{key}})
+	t.broadcast(ctx, Invalidation{Keys: []string{key}})
+	return nil
+}
+
+func (t *Tiered) Tag(ctx context.Context, key string, expiration time.Duration, tags ...string) error {
+	if err := t.l2.Tag(ctx, key, expiration, tags...); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.l1.Peek(key)
+	if !ok {
+		return nil
+	}
+	for _, tag := range tags {
+		keys, ok := t.tags[tag]
+		if !ok {
+			keys = map[string]struct{}{}
+			t.tags[tag] = keys
+		}
+		if _, seen := keys[key]; !seen {
+			keys[key] = struct{}{}
+			e.tags = append(e.tags, tag)
+		}
+	}
+	return nil
+}
+
+func (t *Tiered) InvalidateTags(ctx context.Context, tags ...string) error {
+	if err := t.l2.InvalidateTags(ctx, tags...); err != nil {
+		return err
+	}
+	t.drop(Invalidation{Tags: tags})
+	t.broadcast(ctx, Invalidation{Tags: tags})
+	return nil
+}
+
+// fill puts data into L1, replacing any entry for key. Callers hold t.mu.
+func (t *Tiered) fill(key string, data []byte, ttl time.Duration, readThrough bool) {
+	// Remove first so the old entry's tags are unindexed by onEvict.
+	t.l1.Remove(key)
+	t.l1.Add(key, &l1Entry{data: data, expires: timeNow().Add(ttl), readThrough: readThrough})
+	if readThrough {
+		t.untagged[key] = struct{}{}
+	}
+}
+
+// onEvict unindexes an entry leaving L1. It runs under t.mu.
+func (t *Tiered) onEvict(key string, e *l1Entry) {
+	if e.readThrough {
+		delete(t.untagged, key)
+	}
+	for _, tag := range e.tags {
+		delete(t.tags[tag], key)
+		if len(t.tags[tag]) == 0 {
+			delete(t.tags, tag)
+		}
+	}
+}
+
+// drop removes the L1 entries inv names.
+func (t *Tiered) drop(inv Invalidation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gen++
+	if inv.All {
+		t.l1.Purge()
+		return
+	}
+	for _, key := range inv.Keys {
+		t.l1.Remove(key)
+	}
+	for _, tag := range inv.Tags {
+		for key := range t.tags[tag] {
+			t.l1.Remove(key)
+		}
+		delete(t.tags, tag)
+	}
+	if len(inv.Tags) > 0 {
+		for key := range t.untagged {
+			t.l1.Remove(key)
+		}
+	}
+}
+
+func (t *Tiered) apply(inv Invalidation) {
+	if inv.Origin == t.origin {
+		return
+	}
+	t.drop(inv)
+}
+
+// broadcast publishes inv to other instances. A failed publish only leaves
+// their L1 stale until TieredOptions.TTL, so it is counted, not returned.
+func (t *Tiered) broadcast(ctx context.Context, inv Invalidation) {
+	if t.bus == nil {
+		return
+	}
+	inv.Origin = t.origin
+	if err := t.bus.Publish(ctx, inv); err != nil {
+		t.metrics.errors.Add(1)
+	}
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore is a tagged memStore shared by every instance in a test,
+// standing in for Redis. It counts reads so tests can tell L1 hits apart.
+type countingStore struct {
+	mu    sync.Mutex
+	store *tagStore
+	gets  int
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{store: &tagStore{memStore: newMemStore(), tags: map[string][]string{}}}
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	return s.store.Get(ctx, key)
+}
+
+func (s *countingStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Set(ctx, key, value, expiration)
+}
+
+func (s *countingStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Delete(ctx, key)
+}
+
+func (s *countingStore) Tag(ctx context.Context, key string, expiration time.Duration, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Tag(ctx, key, expiration, tags...)
+}
+
+func (s *countingStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.InvalidateTags(ctx, tags...)
+}
+
+// localBus delivers every published invalidation to all subscribers
+// synchronously, including the publisher, like a pub/sub channel would.
+type localBus struct {
+	mu       sync.Mutex
+	handlers []func(Invalidation)
+}
+
+func (b *localBus) Publish(ctx context.Context, inv Invalidation) error {
+	b.mu.Lock()
+	handlers := append([]func(Invalidation){}, b.handlers...)
+	b.mu.Unlock()
+	for _, h := range handlers {
+		h(inv)
+	}
+	return nil
+}
+
+func (b *localBus) Subscribe(ctx context.Context, handle func(Invalidation)) error {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handle)
+	b.mu.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+func (b *localBus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.handlers)
+}
+
+func newReplicas(t *testing.T, l2 TagStore, n int) []*Tiered {
+	t.Helper()
+	bus := &localBus{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	replicas := make([]*Tiered, n)
+	for i := range replicas {
+		tiered, err := NewTiered(l2, TieredOptions{Size: 16, TTL: time.Minute, Bus: bus})
+		assert.NoError(t, err)
+		go func() { _ = tiered.Run(ctx) }()
+		replicas[i] = tiered
+	}
+	assert.Eventually(t, func() bool { return bus.subscribers() == n }, time.Second, time.Millisecond)
+	return replicas
+}
+
+func TestTieredServesRepeatReadsFromL1(t *testing.T) {
+	l2 := newCountingStore()
+	tiered, err := NewTiered(l2, TieredOptions{Size: 2, TTL: time.Minute})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	assert.NoError(t, l2.Set(ctx, "a", []byte("1"), 0))
+	for i := 0; i < 3; i++ {
+		data, err := tiered.Get(ctx, "a")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", string(data))
+	}
+	assert.Equal(t, 1, l2.gets)
+	assert.Equal(t, Stats{Hits: 2, Misses: 1}, tiered.Stats())
+
+	// Filling L1 past Size evicts the least recently used key.
+	assert.NoError(t, tiered.Set(ctx, "b", []byte("2"), 0))
+	assert.NoError(t, tiered.Set(ctx, "c", []byte("3"), 0))
+	_, err = tiered.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, l2.gets)
+
+	// Entries leave L1 after its TTL even without an invalidation.
+	timeNow = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	defer func() { timeNow = time.Now }()
+	_, err = tiered.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, l2.gets)
+}
+
+func TestTieredInvalidatesOtherReplicas(t *testing.T) {
+	l2 := newCountingStore()
+	replicas := newReplicas(t, l2, 2)
+	writer, reader := replicas[0], replicas[1]
+	ctx := context.Background()
+
+	assert.NoError(t, writer.Set(ctx, "todo:1", []byte("old"), 0))
+	data, _ := reader.Get(ctx, "todo:1")
+	assert.Equal(t, "old", string(data))
+
+	assert.NoError(t, writer.Set(ctx, "todo:1", []byte("new"), 0))
+	data, _ = reader.Get(ctx, "todo:1")
+	assert.Equal(t, "new", string(data))
+
+	assert.NoError(t, writer.Delete(ctx, "todo:1"))
+	data, err := reader.Get(ctx, "todo:1")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestTieredInvalidatesTagsEverywhere(t *testing.T) {
+	l2 := newCountingStore()
+	replicas := newReplicas(t, l2, 2)
+	ctx := context.Background()
+
+	pages := New[[]string](replicas[1], Options{Namespace: "tiered_pages"})
+	for _, id := range []string{"p1", "p2"} {
+		assert.NoError(t, pages.SetTagged(ctx, id, []string{id}, "todos"))
+	}
+	assert.NoError(t, pages.Set(ctx, "untagged", []string{"keep"}))
+	// Read through the other replica too, so both L1s hold the pages.
+	other := New[[]string](replicas[0], Options{Namespace: "tiered_pages"})
+	for _, id := range []string{"p1", "p2"} {
+		_, ok, _ := other.Get(ctx, id)
+		assert.True(t, ok)
+	}
+
+	assert.NoError(t, replicas[0].InvalidateTags(ctx, "todos"))
+
+	for _, c := range []*Cache[[]string]{pages, other} {
+		for _, id := range []string{"p1", "p2"} {
+			_, ok, _ := c.Get(ctx, id)
+			assert.False(t, ok, id)
+		}
+		_, ok, _ := c.Get(ctx, "untagged")
+		assert.True(t, ok)
+	}
+}
+
+func TestTieredDropsEverythingOnResubscribe(t *testing.T) {
+	l2 := newCountingStore()
+	tiered, err := NewTiered(l2, TieredOptions{})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	assert.NoError(t, tiered.Set(ctx, "a", []byte("1"), 0))
+	tiered.apply(Invalidation{All: true})
+	_, err = tiered.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, l2.gets)
+
+	// Invalidations this instance published itself are not applied twice.
+	tiered.apply(Invalidation{Origin: tiered.origin, All: true})
+	_, _ = tiered.Get(ctx, "a")
+	assert.Equal(t, 1, l2.gets)
+}
+
+func TestTieredStatsArePerInstance(t *testing.T) {
+	replicas := newReplicas(t, newCountingStore(), 2)
+	ctx := context.Background()
+
+	assert.NoError(t, replicas[0].Set(ctx, "a", []byte("1"), 0))
+	_, err := replicas[0].Get(ctx, "a")
+	assert.NoError(t, err)
+	_, err = replicas[1].Get(ctx, "a")
+	assert.NoError(t, err)
+
+	assert.Equal(t, Stats{Hits: 1}, replicas[0].Stats())
+	assert.Equal(t, Stats{Misses: 1}, replicas[1].Stats())
+}
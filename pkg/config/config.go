@@ -12,10 +12,18 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	S3       S3Config
+	Server     ServerConfig
+	Database   DatabaseConfig
+	Redis      RedisConfig
+	S3         S3Config
+	Storage    StorageConfig
+	Stream     StreamConfig
+	Broker     BrokerConfig
+	Cache      CacheConfig
+	Files      FilesConfig
+	FileGC     FileGCConfig
+	Thumbnails ThumbnailConfig
+	Scanner    ScannerConfig
 }
 
 type ServerConfig struct {
@@ -40,12 +48,145 @@ type RedisConfig struct {
 	DB       int
 }
 
+// StreamConfig controls the Redis stream todo events are published to and
+// how much of it is kept. MaxLen and MaxAge are both optional; zero disables
+// that limit. Trimming is approximate, so the stream may briefly run over.
+type StreamConfig struct {
+	Name         string
+	MaxLen       int64
+	MaxAge       time.Duration
+	TrimInterval time.Duration
+}
+
+// BrokerConfig selects the transport the outbox dispatcher publishes to:
+// "redis" (Redis Streams, the default), "nats" or "memory" (in-process, for
+// tests and local runs).
+type BrokerConfig struct {
+	Driver         string
+	NATSURL        string
+	ConnectTimeout time.Duration
+}
+
+// CacheConfig controls how cached values are encoded ("json" or "msgpack")
+// and how long they live. Entries past TTL are still served for StaleTTL
+// while they are refreshed in the background. L1Size and L1TTL bound the
+// in-process cache in front of Redis; replicas drop each other's L1 entries
+// through InvalidationChannel.
+type CacheConfig struct {
+	Codec               string
+	TTL                 time.Duration
+	StaleTTL            time.Duration
+	L1Size              int
+	L1TTL               time.Duration
+	InvalidationChannel string
+}
+
+// FilesConfig is the upload policy: which file types are accepted and how
+// large they may be. MaxSize caps every upload; a type's own MaxSize, when
+// set, may only lower it.
+type FilesConfig struct {
+	MaxSize int64
+	Types   []FileTypeConfig
+}
+
+// FileGCConfig schedules the removal of stored files no todo refers to.
+// Files younger than GracePeriod are kept, since they may be about to be
+// attached. In DryRun mode the job only reports what it would delete.
+// Interval 0 disables the job.
+type FileGCConfig struct {
+	Interval    time.Duration
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+// ThumbnailConfig controls the thumbnails generated for uploaded images.
+// Each image gets one per size in Sizes, fitted into a Size x Size box.
+// Images over MaxPixels are skipped rather than decoded; JPEGQuality is the
+// quality thumbnails of JPEGs are encoded at.
+type ThumbnailConfig struct {
+	Sizes       []int
+	MaxPixels   int
+	JPEGQuality int
+}
+
+// ScannerConfig points uploads at a clamd daemon for malware scanning:
+// host:port, or the path of its unix socket. An empty ClamAVAddress disables
+// scanning. Timeout bounds each scan.
+type ScannerConfig struct {
+	ClamAVAddress string
+	Timeout       time.Duration
+}
+
+// DefaultThumbnailSizes are generated when the config lists none.
+var DefaultThumbnailSizes = []int{128, 512}
+
+// thumbnailSizes reads thumbnails.sizes, falling back to
+// DefaultThumbnailSizes.
+func thumbnailSizes(v *viper.Viper) []int {
+	sizes := v.GetIntSlice("thumbnails.sizes")
+	if len(sizes) == 0 {
+		return DefaultThumbnailSizes
+	}
+	return sizes
+}
+
+// FileTypeConfig allows one content type, detected from the file's magic
+// bytes, under the listed extensions.
+type FileTypeConfig struct {
+	MIME       string   `mapstructure:"mime"`
+	Extensions []string `mapstructure:"extensions"`
+	MaxSize    int64    `mapstructure:"max_size"`
+}
+
+// DefaultFileTypes are the types accepted when the config lists none.
+var DefaultFileTypes = []FileTypeConfig{
+	{MIME: "image/jpeg", Extensions: []string{".jpg", ".jpeg"}},
+	{MIME: "image/png", Extensions: []string{".png"}},
+	{MIME: "image/gif", Extensions: []string{".gif"}},
+	{MIME: "application/pdf", Extensions: []string{".pdf"}},
+	{MIME: "text/plain", Extensions: []string{".txt"}, MaxSize: 1 << 20},
+	{MIME: "application/msword", Extensions: []string{".doc"}},
+	{MIME: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Extensions: []string{".docx"}},
+}
+
+// fileTypes reads files.types, falling back to DefaultFileTypes.
+func fileTypes(v *viper.Viper) []FileTypeConfig {
+	var types []FileTypeConfig
+	if err := v.UnmarshalKey("files.types", &types); err != nil || len(types) == 0 {
+		return DefaultFileTypes
+	}
+	return types
+}
+
+// S3Config locates the bucket files are stored in. PartSize is the part
+// size of multipart uploads, which bounds the memory an upload holds.
+// Presigned URLs are valid for PresignExpiry and signed for PublicEndpoint,
+// the address browsers reach the bucket at, when it differs from Endpoint.
+// SSE is the server-side encryption S3 applies: "sse-s3", "sse-kms" with
+// SSEKMSKeyID, or "sse-c" with SSECustomerKey, a base64 256-bit key; empty
+// leaves it to the bucket.
 type S3Config struct {
-	Endpoint  string
-	Bucket    string
-	AccessKey string
-	SecretKey string
-	Region    string
+	Endpoint       string
+	PublicEndpoint string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+	Region         string
+	PartSize       int
+	PresignExpiry  time.Duration
+	SSE            string
+	SSEKMSKeyID    string
+	SSECustomerKey string
+}
+
+// StorageConfig selects where file contents live: "s3" (the default) or
+// "local", a directory tree under LocalRoot for laptops and CI. With an
+// EncryptionKey, a base64 256-bit master key, files are encrypted before
+// they reach either.
+type StorageConfig struct {
+	Driver        string
+	LocalRoot     string
+	EncryptionKey string
 }
 
 func Load() (*Config, error) {
@@ -84,11 +225,59 @@ func Load() (*Config, error) {
 			DB:       getInt("REDIS_DB", 0),
 		},
 		S3: S3Config{
-			Endpoint:  getEnv("S3_ENDPOINT", "http://localhost:4566"),
-			Bucket:    getEnv("S3_BUCKET", "todo-files"),
-			AccessKey: getEnv("S3_ACCESS_KEY", "minioadmin"),
-			SecretKey: getEnv("S3_SECRET_KEY", "minioadmin"),
-			Region:    getEnv("S3_REGION", "us-east-1"),
+			Endpoint:       getEnv("S3_ENDPOINT", "http://localhost:4566"),
+			PublicEndpoint: getEnv("S3_PUBLIC_ENDPOINT", ""),
+			Bucket:         getEnv("S3_BUCKET", "todo-files"),
+			AccessKey:      getEnv("S3_ACCESS_KEY", "minioadmin"),
+			SecretKey:      getEnv("S3_SECRET_KEY", "minioadmin"),
+			Region:         getEnv("S3_REGION", "us-east-1"),
+			PartSize:       getInt("S3_PART_SIZE", 8<<20),
+			PresignExpiry:  getDuration("S3_PRESIGN_EXPIRY", 15*time.Minute),
+			SSE:            getEnv("S3_SSE", ""),
+			SSEKMSKeyID:    getEnv("S3_SSE_KMS_KEY_ID", ""),
+			SSECustomerKey: getEnv("S3_SSE_CUSTOMER_KEY", ""),
+		},
+		Storage: StorageConfig{
+			Driver:        getEnv("STORAGE_DRIVER", "s3"),
+			LocalRoot:     getEnv("STORAGE_LOCAL_ROOT", "./data/files"),
+			EncryptionKey: getEnv("STORAGE_ENCRYPTION_KEY", ""),
+		},
+		Stream: StreamConfig{
+			Name:         getEnv("STREAM_NAME", "todos"),
+			MaxLen:       int64(getInt("STREAM_MAX_LEN", 100000)),
+			MaxAge:       getDuration("STREAM_MAX_AGE", 7*24*time.Hour),
+			TrimInterval: getDuration("STREAM_TRIM_INTERVAL", time.Minute),
+		},
+		Broker: BrokerConfig{
+			Driver:         getEnv("BROKER_DRIVER", "redis"),
+			NATSURL:        getEnv("NATS_URL", "nats://localhost:4222"),
+			ConnectTimeout: getDuration("BROKER_CONNECT_TIMEOUT", 5*time.Second),
+		},
+		Cache: CacheConfig{
+			Codec:               getEnv("CACHE_CODEC", "json"),
+			TTL:                 getDuration("CACHE_TTL", time.Hour),
+			StaleTTL:            getDuration("CACHE_STALE_TTL", time.Minute),
+			L1Size:              getInt("CACHE_L1_SIZE", 10000),
+			L1TTL:               getDuration("CACHE_L1_TTL", 30*time.Second),
+			InvalidationChannel: getEnv("CACHE_INVALIDATION_CHANNEL", "cache:invalidate"),
+		},
+		Files: FilesConfig{
+			MaxSize: int64(getInt("FILES_MAX_SIZE", 10<<20)),
+			Types:   fileTypes(viper.GetViper()),
+		},
+		FileGC: FileGCConfig{
+			Interval:    getDuration("FILE_GC_INTERVAL", time.Hour),
+			GracePeriod: getDuration("FILE_GC_GRACE_PERIOD", 24*time.Hour),
+			DryRun:      getBool("FILE_GC_DRY_RUN", true),
+		},
+		Thumbnails: ThumbnailConfig{
+			Sizes:       thumbnailSizes(viper.GetViper()),
+			MaxPixels:   getInt("THUMBNAILS_MAX_PIXELS", 25_000_000),
+			JPEGQuality: getInt("THUMBNAILS_JPEG_QUALITY", 80),
+		},
+		Scanner: ScannerConfig{
+			ClamAVAddress: getEnv("SCANNER_CLAMAV_ADDRESS", ""),
+			Timeout:       getDuration("SCANNER_TIMEOUT", 30*time.Second),
 		},
 	}
 
@@ -113,6 +302,43 @@ func setDefaults() {
 	viper.SetDefault("s3.endpoint", "http://localhost:4566")
 	viper.SetDefault("s3.bucket", "todo-files")
 	viper.SetDefault("s3.region", "us-east-1")
+	viper.SetDefault("s3.part_size", 8<<20)
+	viper.SetDefault("s3.presign_expiry", "15m")
+	viper.SetDefault("s3.sse", "")
+	viper.SetDefault("s3.sse_kms_key_id", "")
+	viper.SetDefault("s3.sse_customer_key", "")
+
+	viper.SetDefault("storage.driver", "s3")
+	viper.SetDefault("storage.local_root", "./data/files")
+	viper.SetDefault("storage.encryption_key", "")
+
+	viper.SetDefault("stream.name", "todos")
+	viper.SetDefault("stream.max_len", 100000)
+	viper.SetDefault("stream.max_age", "168h")
+	viper.SetDefault("stream.trim_interval", "1m")
+
+	viper.SetDefault("broker.driver", "redis")
+	viper.SetDefault("broker.nats_url", "nats://localhost:4222")
+	viper.SetDefault("broker.connect_timeout", "5s")
+
+	viper.SetDefault("cache.codec", "json")
+	viper.SetDefault("cache.ttl", "1h")
+	viper.SetDefault("cache.stale_ttl", "1m")
+	viper.SetDefault("cache.l1_size", 10000)
+	viper.SetDefault("cache.l1_ttl", "30s")
+	viper.SetDefault("cache.invalidation_channel", "cache:invalidate")
+
+	viper.SetDefault("files.max_size", 10<<20)
+
+	viper.SetDefault("file_gc.interval", "1h")
+	viper.SetDefault("file_gc.grace_period", "24h")
+	viper.SetDefault("file_gc.dry_run", true)
+
+	viper.SetDefault("thumbnails.max_pixels", 25_000_000)
+	viper.SetDefault("thumbnails.jpeg_quality", 80)
+
+	viper.SetDefault("scanner.clamav_address", "")
+	viper.SetDefault("scanner.timeout", "30s")
 }
 
 func getEnv(key, defaultValue string) string {
@@ -135,6 +361,18 @@ func getInt(key string, defaultValue int) int {
 	return intValue
 }
 
+func getBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return boolValue
+}
+
 func getDuration(key string, defaultValue time.Duration) time.Duration {
 	value := os.Getenv(key)
 	if value == "" {
@@ -204,8 +442,45 @@ func setDefaultsOn(v *viper.Viper) {
 	v.SetDefault("s3.endpoint", "http://localhost:4566")
 	v.SetDefault("s3.bucket", "todo-files")
 	v.SetDefault("s3.region", "us-east-1")
+	v.SetDefault("s3.part_size", 8<<20)
+	v.SetDefault("s3.presign_expiry", "15m")
 	v.SetDefault("s3.access_key", "minioadmin")
 	v.SetDefault("s3.secret_key", "minioadmin")
+	v.SetDefault("s3.sse", "")
+	v.SetDefault("s3.sse_kms_key_id", "")
+	v.SetDefault("s3.sse_customer_key", "")
+
+	v.SetDefault("storage.driver", "s3")
+	v.SetDefault("storage.local_root", "./data/files")
+	v.SetDefault("storage.encryption_key", "")
+
+	v.SetDefault("stream.name", "todos")
+	v.SetDefault("stream.max_len", 100000)
+	v.SetDefault("stream.max_age", "168h")
+	v.SetDefault("stream.trim_interval", "1m")
+
+	v.SetDefault("broker.driver", "redis")
+	v.SetDefault("broker.nats_url", "nats://localhost:4222")
+	v.SetDefault("broker.connect_timeout", "5s")
+
+	v.SetDefault("cache.codec", "json")
+	v.SetDefault("cache.ttl", "1h")
+	v.SetDefault("cache.stale_ttl", "1m")
+	v.SetDefault("cache.l1_size", 10000)
+	v.SetDefault("cache.l1_ttl", "30s")
+	v.SetDefault("cache.invalidation_channel", "cache:invalidate")
+
+	v.SetDefault("files.max_size", 10<<20)
+
+	v.SetDefault("file_gc.interval", "1h")
+	v.SetDefault("file_gc.grace_period", "24h")
+	v.SetDefault("file_gc.dry_run", true)
+
+	v.SetDefault("thumbnails.max_pixels", 25_000_000)
+	v.SetDefault("thumbnails.jpeg_quality", 80)
+
+	v.SetDefault("scanner.clamav_address", "")
+	v.SetDefault("scanner.timeout", "30s")
 }
 
 // buildFromViper creates the final Config, supporting either:
@@ -260,11 +535,59 @@ func buildFromViper(v *viper.Viper) *Config {
 			DB:       v.GetInt("redis.db"),
 		},
 		S3: S3Config{
-			Endpoint:  v.GetString("s3.endpoint"),
-			Bucket:    v.GetString("s3.bucket"),
-			AccessKey: v.GetString("s3.access_key"),
-			SecretKey: v.GetString("s3.secret_key"),
-			Region:    v.GetString("s3.region"),
+			Endpoint:       v.GetString("s3.endpoint"),
+			PublicEndpoint: v.GetString("s3.public_endpoint"),
+			Bucket:         v.GetString("s3.bucket"),
+			AccessKey:      v.GetString("s3.access_key"),
+			SecretKey:      v.GetString("s3.secret_key"),
+			Region:         v.GetString("s3.region"),
+			PartSize:       v.GetInt("s3.part_size"),
+			PresignExpiry:  v.GetDuration("s3.presign_expiry"),
+			SSE:            v.GetString("s3.sse"),
+			SSEKMSKeyID:    v.GetString("s3.sse_kms_key_id"),
+			SSECustomerKey: v.GetString("s3.sse_customer_key"),
+		},
+		Storage: StorageConfig{
+			Driver:        v.GetString("storage.driver"),
+			LocalRoot:     v.GetString("storage.local_root"),
+			EncryptionKey: v.GetString("storage.encryption_key"),
+		},
+		Stream: StreamConfig{
+			Name:         v.GetString("stream.name"),
+			MaxLen:       v.GetInt64("stream.max_len"),
+			MaxAge:       v.GetDuration("stream.max_age"),
+			TrimInterval: v.GetDuration("stream.trim_interval"),
+		},
+		Broker: BrokerConfig{
+			Driver:         v.GetString("broker.driver"),
+			NATSURL:        v.GetString("broker.nats_url"),
+			ConnectTimeout: v.GetDuration("broker.connect_timeout"),
+		},
+		Cache: CacheConfig{
+			Codec:               v.GetString("cache.codec"),
+			TTL:                 v.GetDuration("cache.ttl"),
+			StaleTTL:            v.GetDuration("cache.stale_ttl"),
+			L1Size:              v.GetInt("cache.l1_size"),
+			L1TTL:               v.GetDuration("cache.l1_ttl"),
+			InvalidationChannel: v.GetString("cache.invalidation_channel"),
+		},
+		Files: FilesConfig{
+			MaxSize: v.GetInt64("files.max_size"),
+			Types:   fileTypes(v),
+		},
+		FileGC: FileGCConfig{
+			Interval:    v.GetDuration("file_gc.interval"),
+			GracePeriod: v.GetDuration("file_gc.grace_period"),
+			DryRun:      v.GetBool("file_gc.dry_run"),
+		},
+		Thumbnails: ThumbnailConfig{
+			Sizes:       thumbnailSizes(v),
+			MaxPixels:   v.GetInt("thumbnails.max_pixels"),
+			JPEGQuality: v.GetInt("thumbnails.jpeg_quality"),
+		},
+		Scanner: ScannerConfig{
+			ClamAVAddress: v.GetString("scanner.clamav_address"),
+			Timeout:       v.GetDuration("scanner.timeout"),
 		},
 	}
 }